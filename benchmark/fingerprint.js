@@ -0,0 +1,32 @@
+// Micro-benchmark for request fingerprints, which run on every request that
+// goes through a caching or idempotency middleware. Run `pnpm build` first.
+const { hashFingerprint, canonicalQuery } = require('../dist');
+
+const ITERATIONS = 200000;
+
+const query = [['utm_source', 'mail'], ['page', '2'], ['q', 'running shoes'], ['sort', 'price'], ['page', '3']];
+const body = { sku: 'A-1', qty: 2, options: { gift: true, note: 'Happy birthday' }, tags: ['a', 'b', 'c'] };
+
+const cases = {
+  'method + path': () => hashFingerprint({ method: 'GET', path: '/products/123' }),
+  'method + path + query': () => hashFingerprint({ method: 'GET', path: '/products', query: canonicalQuery(query) }),
+  'selected query + header': () => hashFingerprint({
+    method: 'GET',
+    path: '/products',
+    query: canonicalQuery(query, ['q', 'page']),
+    headers: [['accept-language', 'en-US']]
+  }),
+  'with body': () => hashFingerprint({ method: 'POST', path: '/orders', query: [], body })
+};
+
+for (const [name, run] of Object.entries(cases)) {
+  // Warm up so the JIT has settled before timing
+  for (let i = 0; i < 10000; i++) run();
+
+  const start = process.hrtime.bigint();
+  for (let i = 0; i < ITERATIONS; i++) run();
+  const elapsed = Number(process.hrtime.bigint() - start);
+
+  const perOp = elapsed / ITERATIONS;
+  console.log(`${name.padEnd(26)} ${perOp.toFixed(0).padStart(6)} ns/op  ${Math.round(1e9 / perOp).toLocaleString()} ops/sec`);
+}
@@ -0,0 +1,43 @@
+// Micro-benchmark for the size metrics: the per-request cost of the metrics()
+// middleware and of the byte counting done on every response write, compared
+// with doing nothing. Run `pnpm build` first.
+const { metrics } = require('../dist');
+const { countWritten } = require('../dist/utils/stream');
+
+const ITERATIONS = 500000;
+
+async function time(name, run) {
+  // Warm up so the JIT has settled before timing
+  for (let i = 0; i < 20000; i++) await run(i);
+
+  const start = process.hrtime.bigint();
+  for (let i = 0; i < ITERATIONS; i++) await run(i);
+  const perOp = Number(process.hrtime.bigint() - start) / ITERATIONS;
+
+  console.log(`${name.padEnd(38)} ${perOp.toFixed(1).padStart(7)} ns/op`);
+  return perOp;
+}
+
+async function main() {
+  const routes = ['/users', '/users/:id', '/orders', '/orders/:id', '/health'];
+  const contexts = routes.map(path => ({ route: { method: 'GET', path }, bytesRead: 120, bytesWritten: 2048 }));
+  const next = () => Promise.resolve();
+
+  const passThrough = async (ctx, next) => next();
+  const traffic = metrics();
+
+  const base = await time('pass-through middleware', i => passThrough(contexts[i % 5], next));
+  const counted = await time('metrics() middleware', i => traffic(contexts[i % 5], next));
+
+  const body = JSON.stringify({ id: 1, name: 'Ada Lovelace', roles: ['admin', 'author'], bio: 'x'.repeat(900) });
+  const buffer = Buffer.from(body);
+  const res = {};
+  const noop = await time('response write, uncounted', () => undefined);
+  const strings = await time('response write, counted (1KB string)', () => countWritten(res, body));
+  const buffers = await time('response write, counted (1KB Buffer)', () => countWritten(res, buffer));
+
+  console.log(`\nmetrics() adds ${(counted - base).toFixed(1)} ns per request`);
+  console.log(`byte counting adds ${(strings - noop).toFixed(1)} ns per string write, ${(buffers - noop).toFixed(1)} ns per Buffer write`);
+}
+
+main();
@@ -0,0 +1,57 @@
+// Micro-benchmark for the plain response helpers: sendString() and
+// sendBytes() against setting the header and calling send(), and against
+// copying the body into a new Buffer first. Each case builds a fresh
+// context, so compare the cases with each other and with the baseline.
+// Run `pnpm build` first.
+const { Qera } = require('../dist');
+
+const ITERATIONS = 300000;
+
+const text = 'Hello, World! '.repeat(64);
+const bytes = new Uint8Array(Buffer.from(text));
+
+// Just enough of uWS's request and response for a context to answer on
+const req = {
+  forEach: (callback) => callback('host', 'localhost'),
+  getUrl: () => '/',
+  getQuery: () => '',
+  getMethod: () => 'get',
+  getHeader: () => ''
+};
+const res = {
+  writeStatus: () => res,
+  writeHeader: () => res,
+  end: () => res,
+  endWithoutBody: () => res,
+  cork: (callback) => { callback(); return res; },
+  onAborted: () => res,
+  getRemoteAddressAsText: () => Buffer.from('127.0.0.1')
+};
+
+const app = new Qera({ logging: { level: 'error' } });
+// A fresh context per op, as for a real request
+const context = () => {
+  res.aborted = undefined;
+  res.abortListeners = undefined;
+  return app.createQeraContext(req, res);
+};
+
+const cases = {
+  'context only (baseline)': () => context(),
+  'header() + send(string)': () => context().header('Content-Type', 'text/plain; charset=utf-8').send(text),
+  'send(Buffer.from(string))': () => context().header('Content-Type', 'text/plain; charset=utf-8').send(Buffer.from(text)),
+  'sendString(string)': () => context().sendString(text),
+  'send(Buffer.from(bytes))': () => context().header('Content-Type', 'application/octet-stream').send(Buffer.from(bytes)),
+  'sendBytes(bytes)': () => context().sendBytes(bytes)
+};
+
+for (const [name, run] of Object.entries(cases)) {
+  // Warm up so the JIT has settled before timing
+  for (let i = 0; i < 20000; i++) run();
+
+  const start = process.hrtime.bigint();
+  for (let i = 0; i < ITERATIONS; i++) run();
+  const perOp = Number(process.hrtime.bigint() - start) / ITERATIONS;
+
+  console.log(`${name.padEnd(28)} ${perOp.toFixed(0).padStart(6)} ns/op  ${Math.round(1e9 / perOp).toLocaleString()} ops/sec`);
+}
@@ -849,7 +849,7 @@ function generateMiddleware(name: string, extension: string) {
 export function ${middlewareName}(options: any = {}): Middleware {
   return async (ctx: QeraContext, next: () => Promise<void>): Promise<void> => {
     // Middleware logic before request
-    console.log(\`[\${middlewareName}] Processing request to \${ctx.req.getUrl()}\`);
+    console.log(\`[\${middlewareName}] Processing request to \${ctx.path()}\`);
     
     // Measure request time
     const startTime = Date.now();
@@ -866,7 +866,7 @@ export function ${middlewareName}(options: any = {}): Middleware {
     : `function ${middlewareName}(options = {}) {
   return async (ctx, next) => {
     // Middleware logic before request
-    console.log(\`[\${middlewareName}] Processing request to \${ctx.req.getUrl()}\`);
+    console.log(\`[\${middlewareName}] Processing request to \${ctx.path()}\`);
     
     // Measure request time
     const startTime = Date.now();
@@ -1,17 +1,171 @@
-import { App, TemplatedApp, HttpRequest, HttpResponse } from 'uWebSockets.js';
-import { RouteHandler, Middleware, QeraContext, QeraConfig, WebSocketHandler } from '../types';
-import { parseBody } from '../utils/bodyParser';
+import {
+  App,
+  SSLApp,
+  TemplatedApp,
+  HttpRequest,
+  HttpResponse,
+  WebSocket,
+  us_listen_socket,
+  us_listen_socket_close
+} from 'uWebSockets.js';
+import {
+  RouteHandler,
+  Middleware,
+  QeraContext,
+  QeraConfig,
+  WebSocketHandler,
+  RouteInfo,
+  RouteMetrics,
+  RouteMetricsEntry,
+  ConnectionStats,
+  RouteOptions,
+  RequestHook,
+  ResponseHook,
+  ErrorHook,
+  WebSocketErrorHook,
+  AutoTLSOptions,
+  ProfilingOptions,
+  RouteDoc,
+  RouteDef,
+  QeraWebSocketContext
+} from '../types';
+import { bindURI, ParamBindError } from '../utils/bindUri';
+import { bindForm } from '../utils/bindForm';
+import { captureCPUProfile, captureHeapProfile, captureHeapSnapshot, ProfilerBusyError, runtimeStats } from '../utils/profiler';
+import {
+  parseBody, PayloadTooLargeError, BindError, BodyDecoder, BodyTimeoutError, RequestAbortedError, CompressionRatioError,
+  UnsupportedEncodingError
+} from '../utils/bodyParser';
 import { parseCookies } from '../utils/cookieParser';
-import { parseUrl } from '../utils/urlParser';
+import { ErrorMap, ErrorTarget } from '../utils/errorMap';
+import { toXML } from '../utils/xml';
+import {
+  parseQueryEntries,
+  matchRoute,
+  routeParams,
+  stripParamPatterns,
+  compileParamPattern,
+  BUILTIN_PARAM_PATTERNS
+} from '../utils/urlParser';
+import { existsSync, mkdirSync, readFileSync, writeFileSync } from 'fs';
+import { join } from 'path';
+import { IncomingMessage, ServerResponse, STATUS_CODES } from 'http';
+import { createSecureContext } from 'tls';
 import { Logger } from '../utils/logger';
-import { QeraSchema } from '../utils/validator';
+import { contentDisposition, csvChunks } from '../utils/attachment';
+import { zipChunks } from '../utils/zip';
+import { compressChunks, compressSync, responseEncoding, ContentEncoding, StreamCompressor } from '../utils/compress';
+import { errorContentType, renderError, RequestFailedError } from '../utils/errorResponse';
+import { framingProblem } from '../utils/framing';
+import { QeraSchema, QeraValidationError } from '../utils/validator';
+import { streamJSONArray, streamBody, pipeUpstream, writeChunk, countWritten, ConnectionClosedError, BodySource } from '../utils/stream';
+import { acceptsType, acceptsCharset, acceptsEncoding, acceptsLanguage } from '../utils/negotiation';
+import { onAborted } from '../utils/abort';
+import { clientIp, isTrustedProxy } from '../utils/ip';
+import { hostPattern, hostname, matchesHost } from '../utils/host';
+import { canonicalQuery, hashFingerprint, FingerprintParts } from '../utils/fingerprint';
+import { parseETags } from '../utils/etag';
+import { formatHTTPDate, notModifiedSince } from '../utils/httpDate';
+import { formatDump } from '../utils/dump';
+import { stringifyJSON } from '../utils/json';
+import { parseDuration, parseSize } from '../utils/config';
+import { streamEvents } from '../utils/sse';
+import { parsePagination, formatPageLinks, pageMeta, defaultPageEnvelope } from '../utils/pagination';
+import { detectContentType } from '../utils/sniff';
+import { diskFileSystem, serveStatic, StaticFileSystem, StaticServeOptions } from '../utils/staticFiles';
+import { RouterGroup, joinPaths } from './group';
+import { compose, runMiddleware, runHandler } from './compose';
+import { createRequest, NodeRouter, RouteRequest } from './nodeServer';
+import { Recorder, ReplayResult, readExchange, replayExchange } from './recorder';
+import { obtainCertificate, certificateNeedsRenewal } from '../utils/acme';
+
+// A registered route handler and its per-route options
+interface RegisteredRoute {
+  path: string;
+  handler: RouteHandler;
+  options: RouteOptions;
+  // Where the route was registered, for conflict errors
+  site: string;
+  // Set for routes created by mount()
+  prefix?: string;
+  // Set for routes registered through host(), e.g. "*.example.com"
+  host?: string;
+  // Shared by every listener the route is served on
+  metrics: RouteMetrics;
+}
+
+// What routing determined about a request before its context is created
+interface RequestMatch {
+  params?: Array<[string, string]>;
+  route?: RouteInfo;
+  metrics?: RouteMetrics;
+  options?: RouteOptions;
+  // Arrived through a TLS listener
+  secure?: boolean;
+}
+
+// An address to serve the app on in addition to the primary port
+interface Listener {
+  port: number;
+  host: string;
+  ssl?: {
+    key_file_name: string;
+    cert_file_name: string;
+  };
+}
 
 export class Qera {
   private app: TemplatedApp;
   private middlewares: Middleware[] = [];
   private config: QeraConfig = {};
-  private routes: Map<string, Map<string, RouteHandler>> = new Map();
-  private wsHandlers: Map<string, WebSocketHandler> = new Map();
+  private routes: Map<string, Map<string, RegisteredRoute>> = new Map();
+  private wsHandlers: Map<string, { handlers: WebSocketHandler; middlewares: Middleware[] }> = new Map();
+  // Groups made with group(), checked by build()
+  private groups: RouterGroup[] = [];
+  // Named param constraints usable in route patterns as ":id{name}"
+  private paramPatterns: Map<string, RegExp> = new Map(
+    Object.entries(BUILTIN_PARAM_PATTERNS).map(([name, pattern]) => [name, compileParamPattern(pattern)])
+  );
+  // Not-found handlers keyed by host pattern ('' for all other hosts), then
+  // by path prefix ('' is the app-level handler)
+  private notFoundHandlers: Map<string, Map<string, RouteHandler>> = new Map([['', new Map()]]);
+  // Host patterns passed to host(); exact ones are matched before wildcards
+  private hostPatterns: Set<string> = new Set();
+  // Domain errors answered with a status, registered with mapError()
+  private errorMap = new ErrorMap();
+  private staticMounts: Array<{ fsys: StaticFileSystem; options: StaticServeOptions }> = [];
+  private listeners: Listener[] = [];
+  // Sent with every response unless the handler sets the same header
+  private defaultHeaders: Array<[string, string]> = [];
+  // Request body decoders for media types beyond JSON, forms and text
+  private bodyDecoders: Record<string, BodyDecoder> = {};
+  private listenSockets: us_listen_socket[] = [];
+  // Listen sockets of TLS listeners, which reloadTLS() swaps for new ones
+  private tlsListeners: Array<{ listener: Listener; socket: us_listen_socket }> = [];
+  private renewalTimers: NodeJS.Timeout[] = [];
+  // Responses still being handled, so shutdown() can wait for (or close) them.
+  // Counted, since a static file miss hands its response on to a route
+  private inFlight: Map<HttpResponse, number> = new Map();
+  private drainWaiters: Array<() => void> = [];
+  // Every uWS app serving this Qera, including those of TLS listeners
+  private uwsApps: TemplatedApp[] = [];
+  // Requests served per connection, for maxRequestsPerConnection and connectionStats()
+  private connectionRequests: Map<string, { count: number; lastSeen: number }> = new Map();
+  private connectionCounts = { opened: 0, reused: 0 };
+  private lastConnectionSweep = Date.now();
+  private nodeRouter?: NodeRouter;
+  private validateResponses: boolean;
+  // maxResponseHeaders and maxResponseHeaderSize, 0 for no limit
+  private headerLimits: { count: number; size: number };
+  private recorder?: Recorder;
+  // Set by stripPrefix()/addPrefix(): how request paths relate to route paths
+  private pathPrefix?: { mode: 'strip' | 'add'; prefix: string };
+  private hooks: {
+    request: RequestHook[];
+    response: ResponseHook[];
+    error: ErrorHook[];
+    webSocketError: WebSocketErrorHook[];
+  } = { request: [], response: [], error: [], webSocketError: [] };
 
   constructor(config: QeraConfig = {}) {
     this.config = {
@@ -24,11 +178,24 @@ export class Qera {
       },
       compression: true,
       bodyLimit: '1mb',
-      ...config
+      ...config,
+      // Also when passed as undefined, since 0 is how they are turned off
+      bodyTimeout: config.bodyTimeout ?? 300000,
+      writeTimeout: config.writeTimeout ?? 30000,
+      maxURILength: config.maxURILength ?? 8192
     };
 
     // Configure the singleton logger
     Logger.configure(this.config.logging);
+
+    this.defaultHeaders = this.buildDefaultHeaders();
+
+    const msgpack = this.config.msgpack;
+    if (msgpack) {
+      const decode: BodyDecoder = (body) => msgpack.decode(body);
+      this.bodyDecoders['application/msgpack'] = decode;
+      this.bodyDecoders['application/x-msgpack'] = decode;
+    }
     
     // Initialize the app with SSL if provided
     if (this.config.ssl) {
@@ -40,6 +207,16 @@ export class Qera {
       this.app = App();
     }
 
+    this.validateResponses = config.validateResponses ?? process.env.NODE_ENV !== 'production';
+    const headerSize = config.maxResponseHeaderSize ?? '64kb';
+    this.headerLimits = {
+      count: config.maxResponseHeaders ?? 100,
+      size: typeof headerSize === 'number' ? headerSize : parseSize(headerSize)
+    };
+    if (config.record) {
+      this.recorder = new Recorder(config.record);
+    }
+
     // Initialize route maps
     ['get', 'post', 'put', 'patch', 'del', 'options', 'head', 'any'].forEach(method => {
       this.routes.set(method, new Map());
@@ -49,6 +226,17 @@ export class Qera {
     this.setupCoreMiddleware();
   }
 
+  private buildDefaultHeaders(): Array<[string, string]> {
+    const headers = new Map<string, [string, string]>([['server', ['Server', 'Qera']]]);
+    for (const [key, value] of Object.entries(this.config.defaultHeaders || {})) {
+      headers.set(key.toLowerCase(), [key, value]);
+    }
+    if (this.config.disableServerHeader) {
+      headers.delete('server');
+    }
+    return [...headers.values()];
+  }
+
   private setupCoreMiddleware() {
     // Add built-in middleware if enabled in config
     if (this.config.compression) {
@@ -74,7 +262,7 @@ export class Qera {
     const cors = this.config.cors || {};
     return async (ctx, next) => {
       // Handle preflight requests
-      if (ctx.req.getMethod() === 'options') {
+      if (ctx.method === 'OPTIONS') {
         const origin = cors.origin === true ? ctx.headers.origin : Array.isArray(cors.origin)
           ? (cors.origin.includes(ctx.headers.origin) ? ctx.headers.origin : cors.origin[0])
           : cors.origin || '*';
@@ -173,115 +361,665 @@ export class Qera {
   }
 
   private setupStaticFiles() {
-    const { root, prefix = '', cacheControl = 'public, max-age=86400' } = this.config.staticFiles!;
-    
-    // uWebSockets.js has a different approach to static files
-    this.app.get(`${prefix}/*`, (res, req) => {
-      const url = req.getUrl();
-      const filePath = url.startsWith(prefix) 
-        ? url.slice(prefix.length) 
-        : url;
-
-      res.writeHeader('Content-Type', this.getMimeType(filePath));
-      res.writeHeader('Cache-Control', cacheControl);
-      
-      res.writeStatus('200 OK');
-      res.end();
-      
-      // In a real implementation, we would use uWS's file streaming APIs
-      // or readFile and stream the content to the response
-    });
+    const {
+      root,
+      prefix = '',
+      cacheControl = 'public, max-age=86400',
+      index = 'index.html',
+      spaFallback,
+      spaExclude = ['/api'],
+      fallThrough
+    } = this.config.staticFiles!;
+
+    this.registerStatic(diskFileSystem(root), { prefix, cacheControl, index, spaFallback, spaExclude, fallThrough });
+  }
+
+  // Static mounts are registered on each uWS app when listening starts
+  private registerStatic(fsys: StaticFileSystem, options: StaticServeOptions) {
+    this.staticMounts.push({ fsys, options });
   }
 
-  private getMimeType(path: string): string {
-    const extension = path.split('.').pop()?.toLowerCase() || '';
-    const mimeTypes: Record<string, string> = {
-      html: 'text/html',
-      css: 'text/css',
-      js: 'application/javascript',
-      json: 'application/json',
-      png: 'image/png',
-      jpg: 'image/jpeg',
-      jpeg: 'image/jpeg',
-      gif: 'image/gif',
-      svg: 'image/svg+xml',
-      ico: 'image/x-icon',
-      txt: 'text/plain',
+  // A miss is answered like an unmatched request (hooks, 405, not-found
+  // handlers); with fallThrough it's offered to the routes first, replayed
+  // through router
+  private mountStatic(app: TemplatedApp, fsys: StaticFileSystem, options: StaticServeOptions, secure: boolean, router?: NodeRouter) {
+    const handler = (res: HttpResponse, req: HttpRequest) => {
+      if (this.refuseRequest(req, res)) return;
+
+      // Registering marks res.aborted on disconnect, which the async file send checks
+      onAborted(res, () => {});
+
+      this.countConnectionRequest(req, res);
+
+      // uWS requests are only valid synchronously, so copy what we need first
+      const headers: Record<string, string> = {};
+      req.forEach((key, value) => {
+        headers[key] = value;
+      });
+      const routeRequest: RouteRequest = { method: req.getMethod(), url: req.getUrl(), query: req.getQuery() || '', headers };
+
+      this.track(res, serveStatic(res, fsys, {
+        url: this.routePath(routeRequest.url) ?? routeRequest.url,
+        method: routeRequest.method,
+        accept: headers.accept || '',
+        ifNoneMatch: headers['if-none-match'] || '',
+        range: headers.range || ''
+      }, {
+        ...options,
+        headers: this.defaultHeaders
+      }).then(served => {
+        if (served || res.aborted) return;
+        // Already counted, see countConnectionRequest()
+        res.fellThrough = true;
+        if (!options.fallThrough || !router?.route(routeRequest, res)) {
+          this.handleUnmatched(createRequest(routeRequest, []), res, secure);
+        }
+      }));
     };
-    
-    return mimeTypes[extension] || 'application/octet-stream';
+
+    const pattern = this.requestPattern(`${options.prefix}/*`);
+    if (pattern !== null) {
+      app.get(pattern, handler);
+    }
+
+    // The SPA entry point must answer client-side routes outside the static prefix too
+    const fallback = this.requestPattern('/*');
+    if (options.spaFallback && options.prefix !== '' && fallback !== null) {
+      app.get(fallback, handler);
+    }
   }
 
-  private createQeraContext(req: HttpRequest, res: HttpResponse): QeraContext {
+  private createQeraContext(
+    req: HttpRequest,
+    res: HttpResponse,
+    match: RequestMatch = {}
+  ): QeraContext {
+    const paramEntries = match.params || NO_PARAMS;
     const headers: Record<string, string> = {};
     req.forEach((key, value) => {
       headers[key] = value;
     });
 
     const cookies = parseCookies(headers.cookie || '');
-    const { query, params } = parseUrl(req.getUrl(), req.getQuery());
+    // For repeated query params the first value wins; queryArray() returns all of them
+    const rawQuery = req.getQuery() || '';
+    const queryEntries = parseQueryEntries(rawQuery);
+    const query: Record<string, string> = {};
+    for (const [key, value] of queryEntries) {
+      if (key !== '__proto__' && !Object.prototype.hasOwnProperty.call(query, key)) {
+        query[key] = value;
+      }
+    }
+
+    // The peer address has to be read before the response can end
+    const remoteAddress = Buffer.from(res.getRemoteAddressAsText()).toString();
+    const ip = clientIp(remoteAddress, headers['x-forwarded-for'], this.config.trustProxy);
+    const fromTrustedProxy = isTrustedProxy(remoteAddress, this.config.trustProxy);
+
+    // First entry of a proxy header, only when the peer is a trusted proxy
+    const forwarded = (name: string) =>
+      fromTrustedProxy && headers[name] ? headers[name].split(',')[0].trim().toLowerCase() : '';
+
+    // A malformed body is only reported once something reads it, so the
+    // error goes through the middleware chain like any other
+    let body: any = {};
+
+    // Route params arrive as ordered entries; the map is built on first use
+    let params: Record<string, string> | undefined;
     
-    // Store status code for tracking
+    // Status and headers are buffered until the body is written, so they can
+    // still change anywhere before that point
     let statusCode = 200;
+    let committed = false;
+    const pendingHeaders: Array<[string, string]> = [...this.defaultHeaders];
+    // Defaults not yet overridden; setting one of these replaces the default
+    const defaultNames = new Set(this.defaultHeaders.map(([key]) => key.toLowerCase()));
+    // The path routes see, i.e. without a stripped or with an added prefix
+    const rawUrl = req.getUrl();
+    const url = this.routePath(rawUrl) ?? rawUrl;
+    const method = req.getMethod().toUpperCase();
+    const jsonOptions = this.config.json;
+    // What qera.json() sends for data: the responseTransformer's result for
+    // successful responses, unless the route opts out. Error bodies, Qera's
+    // own 404/405/500 included, keep their shape
+    const jsonBody = (data: any) => {
+      const transform = this.config.responseTransformer;
+      return transform && statusCode < 400 && ctx.route?.options?.transformResponse !== false ? transform(data, ctx) : data;
+    };
+
+    // Aborted when the client disconnects; created lazily since most handlers never look
+    let abortController: AbortController | undefined;
+    onAborted(res, () => abortController?.abort());
+    // One deadline for the whole request, shared by middleware and handler
+    const budget = this.config.requestTimeout === undefined ? undefined : match.options?.timeout ?? this.config.requestTimeout;
+    const deadline = budget !== undefined && budget > 0 ? Date.now() + budget : undefined;
+
+    // Feature flag decisions, one per flag for the whole request
+    let features: Map<string, Promise<boolean>> | undefined;
+
+    // Set when lastModified() answered with a 304, which makes the handler's
+    // own response expected to be dropped
+    let notModified = false;
+
+    // Returns false (after logging) once the response has been committed
+    const assertWritable = (action: string) => {
+      if (committed) {
+        if (!res.aborted && !notModified) {
+          Logger.warn(`Ignoring ${action} for ${url}: the response has already been sent`);
+        }
+        return false;
+      }
+      return true;
+    };
+
+    const removeHeader = (name: string) => {
+      const lower = name.toLowerCase();
+      defaultNames.delete(lower);
+      for (let i = pendingHeaders.length - 1; i >= 0; i--) {
+        if (pendingHeaders[i][0].toLowerCase() === lower) {
+          pendingHeaders.splice(i, 1);
+        }
+      }
+    };
+
+    // Whether one more header stays within maxResponseHeaders and
+    // maxResponseHeaderSize. Crossing them is usually a loop appending
+    // headers: outside production that throws, in production the header is
+    // dropped with a warning, once per response
+    let headerLimitReported = false;
+    const headerFits = (key: string, value: string) => {
+      const { count, size } = this.headerLimits;
+      let problem: string | undefined;
+      if (count > 0 && pendingHeaders.length >= count) {
+        problem = `more than ${count} headers`;
+      } else if (size > 0) {
+        const total = pendingHeaders.reduce((sum, [name, text]) => sum + name.length + text.length, key.length + value.length);
+        if (total > size) problem = `headers over ${size} bytes`;
+      }
+      if (!problem) return true;
+
+      const message = `Response for ${url} would have ${problem} with ${key}`;
+      if (process.env.NODE_ENV !== 'production') {
+        throw new ResponseHeaderLimitError(message);
+      }
+      if (!headerLimitReported) {
+        headerLimitReported = true;
+        Logger.warn(`${message}; dropping it and any further headers over the limit`);
+      }
+      return false;
+    };
+
+    const addHeader = (key: string, value: string) => {
+      if (defaultNames.has(key.toLowerCase())) {
+        removeHeader(key);
+      }
+      if (headerFits(key, value)) {
+        pendingHeaders.push([key, value]);
+      }
+    };
+
+    // Entries from serverTiming(), sent together as one Server-Timing header
+    const serverTimings: string[] = [];
+
+    // Set by ctx.compressResponse(); nothing is compressed unless asked
+    let compress = false;
+    // Compresses write() chunks once a compressed streamed response has started
+    let streamCompressor: StreamCompressor | undefined;
+
+    const hasHeader = (name: string) => pendingHeaders.some(([key]) => key.toLowerCase() === name);
+
+    // The encoding to compress the body with, if compression was asked for
+    // and the client accepts one. Bodies the handler encoded itself are left alone
+    const compressionEncoding = () =>
+      compress && !hasHeader('content-encoding') ? responseEncoding(ctx.headers['accept-encoding']) : undefined;
+
+    // Headers for a body sent with encoding. A Content-Length set by the
+    // handler describes the uncompressed body, so it goes
+    const compressionHeaders = (encoding: ContentEncoding | undefined) => {
+      if (!compress) return;
+      if (!pendingHeaders.some(([key, value]) => key.toLowerCase() === 'vary' && /accept-encoding|\*/i.test(value))) {
+        addHeader('Vary', 'Accept-Encoding');
+      }
+      if (encoding) {
+        removeHeader('Content-Length');
+        addHeader('Content-Encoding', encoding);
+      }
+    };
+
+    const writeHead = (contentType?: string) => {
+      res.writeStatus(statusCode === 200 ? '200 OK' : statusCode.toString());
+      for (const [key, value] of pendingHeaders) {
+        res.writeHeader(key, value);
+      }
+      if (serverTimings.length > 0) {
+        res.writeHeader('Server-Timing', serverTimings.join(', '));
+      }
+      if (contentType && !pendingHeaders.some(([key]) => key.toLowerCase() === 'content-type')) {
+        res.writeHeader('Content-Type', contentType);
+      }
+    };
+
+    const end = (body?: string | Buffer, contentType?: string) => {
+      committed = true;
+      if (res.aborted) return;
+
+      // 204 and 304 responses never carry a body, nor a Content-Length for one
+      if (BODYLESS_STATUSES.has(statusCode)) {
+        res.cork(() => {
+          writeHead();
+          res.endWithoutBody(undefined, res.closeConnection === true);
+        });
+        return;
+      }
+
+      const encoding = body !== undefined && body.length > 0 ? compressionEncoding() : undefined;
+      compressionHeaders(encoding);
+      const payload = encoding ? compressSync(encoding, body!) : body;
+      countWritten(res, payload);
+      res.cork(() => {
+        writeHead(contentType);
+        res.end(payload, res.closeConnection === true);
+      });
+    };
+
+    // Development aid: warn when a JSON body doesn't match the route's schema for its status
+    const checkResponseSchema = (data: unknown) => {
+      const schema = this.validateResponses ? ctx.route?.options?.responses?.[statusCode] : undefined;
+      if (!schema) return;
+      const result = schema.safeParse(data);
+      if (!result.success) {
+        Logger.warn(`Response ${statusCode} of ${ctx.route!.method} ${ctx.route!.path} doesn't match its schema`, {
+          issues: result.error!.issues.map(issue => `${issue.path.join('.') || '(root)'}: ${issue.message}`)
+        });
+      }
+    };
+
+    // A generated file for ctx.csv() and ctx.zip(). Once the head is out, a
+    // failing source can only cut the connection, so it is logged here
+    // rather than reaching error handlers that would try to respond
+    const download = async (filename: string, contentType: string, source: BodySource) => {
+      ctx.attachment(filename);
+      try {
+        await ctx.stream(contentType, source);
+      } catch (error) {
+        if (!committed) {
+          // The error response isn't the file
+          removeHeader('Content-Disposition');
+          throw error;
+        }
+        if (error instanceof ConnectionClosedError) throw error;
+        Logger.error(`Error streaming ${filename}: ${error}`);
+      }
+    };
+
+    // ctx.cachePublic() and ctx.cachePrivate(); drops the legacy headers
+    // noCache() may have set, which would contradict the new policy
+    const cacheFor = (scope: 'public' | 'private', maxAge: number | string) => {
+      const value = `${scope}, max-age=${maxAgeSeconds(maxAge)}`;
+      if (assertWritable('header Cache-Control')) {
+        removeHeader('Pragma');
+        removeHeader('Expires');
+        ctx.setHeaders({ 'Cache-Control': value });
+      }
+      return ctx;
+    };
+
+    // Set by ctx.abort(); stops the middleware chain
+    let chainAborted = false;
+
+    // Set once write() has sent the head of a streamed response, until end()
+    let streaming = false;
     
     const ctx: QeraContext = {
       req,
       res,
-      params,
       query,
       headers,
       cookies,
-      body: {},
+      get body() {
+        const error = bodyErrors.get(ctx);
+        if (error) {
+          throw error;
+        }
+        return body;
+      },
+
+      set body(value) {
+        bodyErrors.delete(ctx);
+        body = value;
+      },
+
+      peekBody: () => res.rawBody || EMPTY_BODY,
+      // Only set by app.handler(); uWS discards trailers
+      trailer: (name) => res.trailers?.[name.toLowerCase()] || '',
+
+      abort: () => {
+        chainAborted = true;
+      },
+      abortWithStatus: (code) => {
+        chainAborted = true;
+        if (!committed) {
+          ctx.sendStatus(code);
+        }
+      },
+      isAborted: () => chainAborted,
+      // Replaced by the running middleware's next(); outside middleware there's nothing to run
+      next: () => Promise.resolve(),
+
       state: {},
+      ip,
+      route: null,
       
+      get params() {
+        if (!params) {
+          params = Object.fromEntries(paramEntries);
+        }
+        return params;
+      },
+
+      set params(value) {
+        params = value;
+      },
+
+      method,
+      path: () => url,
+      url: () => (rawQuery ? `${url}?${rawQuery}` : url),
+
+      // Common request headers
+      userAgent: () => headers['user-agent'] || '',
+      referer: () => headers.referer || headers.referrer || '',
+      host: () => forwarded('x-forwarded-host') || headers.host || '',
+      protocol: () => {
+        const proto = forwarded('x-forwarded-proto');
+        if (proto === 'http' || proto === 'https') return proto;
+        return match.secure ? 'https' : 'http';
+      },
+      secure: () => ctx.protocol() === 'https',
+
+      ifMatch: () => parseETags(headers['if-match']),
+      ifNoneMatch: () => parseETags(headers['if-none-match']),
+      lastModified: (time) => {
+        if (Number.isNaN(new Date(time).getTime())) {
+          throw new TypeError(`Invalid lastModified time: ${time}`);
+        }
+        if (!assertWritable('Last-Modified')) {
+          return false;
+        }
+        addHeader('Last-Modified', formatHTTPDate(time));
+        // If-None-Match takes precedence, and only GET and HEAD can be answered with 304
+        if ((method === 'GET' || method === 'HEAD') && !headers['if-none-match']
+          && notModifiedSince(headers['if-modified-since'], time)) {
+          statusCode = 304;
+          end();
+          notModified = true;
+          return true;
+        }
+        return false;
+      },
+
+      queryArray: (name) => queryEntries.filter(([key]) => key === name).map(([, value]) => value),
+
+      allParams: () => paramEntries.map(([name, value]) => ({ name, value })),
+
+      paramInt: (name) => {
+        const value = ctx.params[name];
+        return value !== undefined && /^-?\d+$/.test(value) ? parseInt(value, 10) : undefined;
+      },
+
+      paramUUID: (name) => {
+        const value = ctx.params[name] ?? '';
+        if (!UUID.test(value)) {
+          throw new ParamBindError(name, 'path', value, 'a UUID');
+        }
+        return value.toLowerCase();
+      },
+
+      get bytesRead() {
+        return res.bytesRead || 0;
+      },
+      get compressionRatio() {
+        return res.compressionRatio as number | undefined;
+      },
+      get bytesWritten() {
+        return res.bytesWritten || 0;
+      },
+
       // Add statusCode getter property
       get statusCode() {
         return statusCode;
       },
 
+      get committed() {
+        return committed;
+      },
+
+      get signal() {
+        if (!abortController) {
+          const controller = abortController = new AbortController();
+          if (res.aborted) {
+            controller.abort();
+          } else if (deadline !== undefined) {
+            const timer = setTimeout(() => controller.abort(new DeadlineExceededError()), deadline - Date.now());
+            timer.unref?.();
+            deadlineTimers.set(ctx, timer);
+          }
+        }
+        return abortController.signal;
+      },
+      // res.aborted is kept current by the onAborted() listener registered above
+      clientDisconnected: () => res.aborted === true,
+      deadline: () => (deadline === undefined ? undefined : new Date(deadline)),
+      remainingTime: () => (deadline === undefined ? Infinity : Math.max(0, deadline - Date.now())),
+      onFinish: (callback) => {
+        const callbacks = finishCallbacks.get(ctx);
+        if (callbacks) {
+          callbacks.push(callback);
+        } else {
+          finishCallbacks.set(ctx, [callback]);
+        }
+      },
+
       // Response methods
       status: (code) => {
-        statusCode = code;
-        res.writeStatus(code.toString());
+        if (assertWritable(`status ${code}`)) {
+          statusCode = code;
+        }
         return ctx;
       },
       header: (key, value) => {
-        res.writeHeader(key, value);
+        if (assertWritable(`header ${key}`)) {
+          addHeader(key, value);
+        }
+        return ctx;
+      },
+      setHeaders: (values) => {
+        if (assertWritable('headers')) {
+          for (const [key, value] of Object.entries(values)) {
+            removeHeader(key);
+            if (headerFits(key, value)) {
+              pendingHeaders.push([key, value]);
+            }
+          }
+        }
         return ctx;
       },
+      appendHeader: (key, value) => {
+        if (assertWritable(`header ${key}`)) {
+          addHeader(key, value);
+        }
+        return ctx;
+      },
+      deleteHeader: (key) => {
+        if (assertWritable(`header removal ${key}`)) {
+          removeHeader(key);
+        }
+        return ctx;
+      },
+      serverTiming: (metric, duration, description) => {
+        if (!HTTP_TOKEN.test(metric)) {
+          throw new TypeError(`Invalid Server-Timing metric name: ${JSON.stringify(metric)}`);
+        }
+        if (assertWritable(`server timing ${metric}`)) {
+          serverTimings.push(serverTimingEntry(metric, duration, description));
+        }
+        return ctx;
+      },
+      cacheControl: (...directives) => {
+        const value = cacheControlValue(directives);
+        if (assertWritable('header Cache-Control')) {
+          removeHeader('Cache-Control');
+          addHeader('Cache-Control', value);
+        }
+        return ctx;
+      },
+      // Pragma and Expires for HTTP/1.0 caches that ignore Cache-Control
+      noCache: () => ctx.setHeaders({ 'Cache-Control': 'no-cache, no-store, must-revalidate', Pragma: 'no-cache', Expires: '0' }),
+      cachePublic: (maxAge) => cacheFor('public', maxAge),
+      cachePrivate: (maxAge) => cacheFor('private', maxAge),
       json: (data) => {
-        // Use the current status code, default to 200 if still unset
-        if (statusCode === 200) {
-          res.writeStatus('200 OK');
-        } else {
-          res.writeStatus(statusCode.toString());
+        if (assertWritable('json body')) {
+          checkResponseSchema(data);
+          const body = jsonBody(data);
+          end(jsonOptions ? stringifyJSON(body, jsonOptions) : JSON.stringify(body), 'application/json');
+        }
+      },
+      sendStatus: (code) => {
+        if (assertWritable(`status ${code}`)) {
+          statusCode = code;
+          end(STATUS_CODES[code] || String(code), 'text/plain; charset=utf-8');
+        }
+      },
+      jsonWithOptions: (data, options) => {
+        if (assertWritable('json body')) {
+          checkResponseSchema(data);
+          end(stringifyJSON(jsonBody(data), options), 'application/json');
+        }
+      },
+      xml: (data) => {
+        if (assertWritable('xml body')) {
+          end(toXML(data), 'application/xml; charset=utf-8');
+        }
+      },
+      msgpack: (data) => {
+        const codec = this.config.msgpack;
+        if (!codec) {
+          throw new Error('qera.msgpack() needs a codec: set the msgpack config option');
+        }
+        if (assertWritable('msgpack body')) {
+          const encoded = codec.encode(data);
+          end(Buffer.from(encoded.buffer, encoded.byteOffset, encoded.byteLength), 'application/msgpack');
+        }
+      },
+      jsonp: (data, callback = query.callback) => {
+        if (!callback) {
+          return ctx.json(data);
+        }
+        if (!JSONP_CALLBACK.test(callback)) {
+          return ctx.status(400).json({ error: 'Invalid JSONP callback' });
+        }
+        if (assertWritable('jsonp body')) {
+          // U+2028/2029 are valid in JSON but end a line in older JavaScript engines
+          const json = JSON.stringify(data)
+            .replace(/\u2028/g, '\\u2028')
+            .replace(/\u2029/g, '\\u2029');
+          addHeader('X-Content-Type-Options', 'nosniff');
+          // The leading comment stops the response being sniffed as another format
+          end(`/**/ typeof ${callback} === 'function' && ${callback}(${json});`, 'application/javascript');
         }
-        res.writeHeader('Content-Type', 'application/json');
-        res.end(JSON.stringify(data));
       },
       send: (body) => {
-        // Default status code is 200 if not explicitly set
-        if (statusCode === 0) {
-          statusCode = 200;
-          res.writeStatus('200 OK');
+        if (assertWritable('body')) {
+          const data = typeof body === 'string' ? body : Buffer.from(body as ArrayBuffer);
+          // Only used when the handler didn't set a Content-Type
+          const sniff = ctx.route?.options?.sniffContentType ?? this.config.sniffContentType ?? true;
+          end(data, data.length === 0 ? undefined : sniff ? detectContentType(data) : 'application/octet-stream');
         }
-        if (typeof body === 'string') {
-          res.end(body);
-        } else {
-          res.end(Buffer.from(body as ArrayBuffer));
+      },
+      sendString: (text) => {
+        if (assertWritable('body')) {
+          end(text, 'text/plain; charset=utf-8');
+        }
+      },
+      sendBytes: (bytes) => {
+        if (assertWritable('body')) {
+          const data = Buffer.isBuffer(bytes) ? bytes
+            : ArrayBuffer.isView(bytes) ? Buffer.from(bytes.buffer, bytes.byteOffset, bytes.byteLength)
+            : Buffer.from(bytes);
+          end(data, 'application/octet-stream');
+        }
+      },
+      setConnectionClose: () => {
+        // Read by everything that ends the response, including the stream helpers
+        res.closeConnection = true;
+        return ctx;
+      },
+      write: (chunk) => {
+        if (!streaming) {
+          if (!assertWritable('streamed body')) {
+            return Promise.resolve();
+          }
+          committed = true;
+          streaming = true;
+          const encoding = compressionEncoding();
+          compressionHeaders(encoding);
+          if (encoding) {
+            streamCompressor = new StreamCompressor(encoding);
+          }
+          if (!res.aborted) {
+            res.cork(() => writeHead());
+          }
+        }
+        if (streamCompressor) {
+          return streamCompressor.write(chunk).then(output => (output.length > 0 ? writeChunk(res, output) : undefined));
+        }
+        return writeChunk(res, chunk);
+      },
+      end: (chunk) => {
+        if (!streaming) {
+          ctx.send(chunk ?? '');
+          return;
+        }
+        streaming = false;
+        if (streamCompressor) {
+          // The compressor's tail is only known once it has finished
+          streamCompressor.end(chunk).then(output => {
+            if (res.aborted) return;
+            countWritten(res, output);
+            res.cork(() => res.end(output, res.closeConnection === true));
+          }, error => {
+            Logger.error(`Error compressing the response for ${url}: ${error}`);
+            if (!res.aborted) {
+              res.aborted = true;
+              res.close();
+            }
+          });
+          return;
+        }
+        if (!res.aborted) {
+          countWritten(res, chunk);
+          res.cork(() => res.end(chunk, res.closeConnection === true));
         }
       },
+      compressResponse: (enabled = true) => {
+        if (assertWritable('compression choice')) {
+          compress = enabled;
+        }
+        return ctx;
+      },
+      preconditionFailed: () => {
+        ctx.status(412).json({ error: 'Precondition Failed' });
+      },
       redirect: (url, status = 302) => {
-        statusCode = status;
-        res.writeStatus(status.toString());
-        res.writeHeader('Location', url);
-        res.end();
+        if (assertWritable('redirect')) {
+          statusCode = status;
+          addHeader('Location', url);
+          end();
+        }
       },
       cookie: (name, value, options = {}) => {
         const cookie = require('cookie');
         const cookieStr = cookie.serialize(name, value, options);
-        res.writeHeader('Set-Cookie', cookieStr);
-        return ctx;
+        return ctx.appendHeader('Set-Cookie', cookieStr);
       },
       clearCookie: (name, options = {}) => {
         return ctx.cookie(name, '', {
@@ -289,8 +1027,109 @@ export class Qera {
           expires: new Date(0),
         });
       },
+      streamJSONArray: (source) => {
+        if (!assertWritable('streamed body')) {
+          return Promise.resolve();
+        }
+        committed = true;
+        return streamJSONArray(res, statusCode, source, pendingHeaders.splice(0));
+      },
+      stream: (contentType, source) => {
+        if (!assertWritable('streamed body')) {
+          return Promise.resolve();
+        }
+        const encoding = compressionEncoding();
+        // Committed with the first chunk, so a source failing before that still gets a 500
+        return streamBody(res, encoding ? compressChunks(encoding, source) : source, () => {
+          committed = true;
+          compressionHeaders(encoding);
+          writeHead(contentType);
+        });
+      },
+      pipe: (upstream, options = {}) => {
+        if (!assertWritable('piped body')) {
+          return Promise.resolve();
+        }
+        // Not compressed: that would hold back the chunks of an event stream
+        return pipeUpstream(res, upstream, res.rawBody || EMPTY_BODY, options, () => {
+          committed = true;
+          writeHead(options.contentType);
+        });
+      },
+      attachment: (filename) => ctx.header('Content-Disposition', contentDisposition(filename)),
+      csv: (filename, header, rows) => download(filename, 'text/csv; charset=utf-8', csvChunks(header, rows)),
+      zip: (filename, entries) => download(filename, 'application/zip', zipChunks(entries)),
+      sse: (source) => {
+        if (!assertWritable('event stream')) {
+          return Promise.resolve();
+        }
+        committed = true;
+        if (!pendingHeaders.some(([key]) => key.toLowerCase() === 'cache-control')) {
+          addHeader('Cache-Control', 'no-cache');
+        }
+        // Stops nginx from buffering the stream
+        addHeader('X-Accel-Buffering', 'no');
+        return streamEvents(res, source, () => writeHead('text/event-stream'));
+      },
+
+      // Content negotiation
+      accepts: (...types) => acceptsType(ctx.headers.accept, types),
+      acceptsCharsets: (...charsets) => acceptsCharset(ctx.headers['accept-charset'], charsets),
+      acceptsEncodings: (...encodings) => acceptsEncoding(ctx.headers['accept-encoding'], encodings),
+      acceptsLanguages: (...languages) => acceptsLanguage(ctx.headers['accept-language'], languages),
 
       // Utility methods
+      fingerprintParts: (options = {}) => {
+        const parts: FingerprintParts = {};
+        if (options.method !== false) parts.method = method;
+        if (options.path !== false) parts.path = url;
+        if (options.query !== false) {
+          parts.query = canonicalQuery(queryEntries, Array.isArray(options.query) ? options.query : undefined);
+        }
+        if (options.headers) {
+          parts.headers = options.headers.map(name => [name.toLowerCase(), headers[name.toLowerCase()] ?? '']);
+        }
+        if (options.body) parts.body = ctx.body;
+        return parts;
+      },
+      fingerprint: (options) => hashFingerprint(ctx.fingerprintParts(options)),
+      feature: (name) => {
+        const provider = this.config.featureProvider;
+        if (!provider) {
+          throw new Error('qera.feature() needs a provider: set the featureProvider config option');
+        }
+        features ??= new Map();
+        let decision = features.get(name);
+        if (!decision) {
+          decision = Promise.resolve()
+            .then(() => provider(name, ctx))
+            .then(Boolean, (error) => {
+              // Flags fail closed, so an outage of the flag service can't turn features on
+              Logger.warn(`Feature flag ${name} failed, treating it as off: ${error}`);
+              return false;
+            });
+          features.set(name, decision);
+        }
+        return decision;
+      },
+      dump: (options) => formatDump({
+        method,
+        url,
+        query: rawQuery,
+        headers,
+        body,
+        bodyError: bodyErrors.get(ctx)
+      }, options),
+      pagination: (defaults) => parsePagination(query, { ...this.config.pagination, ...defaults }),
+      pageLinks: (page, total) => ctx.header('Link', formatPageLinks(rawUrl, queryEntries, page, total)),
+      paginated: (data, page, total) => {
+        const envelope = this.config.pageEnvelope || defaultPageEnvelope;
+        ctx.pageLinks(page, total);
+        if (total !== undefined) {
+          ctx.header('X-Total-Count', String(total));
+        }
+        ctx.json(envelope(data, pageMeta(page, total)));
+      },
       validate: function<T>(schema: QeraSchema<T>): T {
         const result = schema.safeParse(this.body);
         if (!result.success) {
@@ -299,6 +1138,46 @@ export class Qera {
         }
         return result.data!;
       },
+      bindAndValidate: function<T>(schema: QeraSchema<T> | undefined = this.route?.options?.request): T {
+        if (!schema) {
+          throw new Error('bindAndValidate() needs a schema: pass one or set the route\'s request option');
+        }
+        const data = this.body;
+        if (typeof data !== 'object' || data === null) {
+          throw new BindError('Expected a JSON or form body');
+        }
+        return this.validate(schema);
+      },
+      errorMapping: (error) => this.errorMap.match(error),
+      fail: (status, message) => {
+        if (!Number.isInteger(status) || status < 400 || status > 599) {
+          throw new RangeError(`qera.fail() needs a 4xx or 5xx status, got ${status}`);
+        }
+        // Capturing a stack trace is most of the cost of a throw, and nobody reads this one
+        const stackTraceLimit = Error.stackTraceLimit;
+        Error.stackTraceLimit = 0;
+        const failure = new RequestFailedError(status, message ?? STATUS_CODES[status] ?? String(status));
+        Error.stackTraceLimit = stackTraceLimit;
+        throw failure;
+      },
+      mustBind: <T>(schema?: QeraSchema<T>): T | undefined => {
+        try {
+          return ctx.bindAndValidate(schema);
+        } catch (error) {
+          const response = clientErrorResponse(error);
+          if (!response) throw error;
+          this.sendError(ctx, response.status, response.body);
+          return undefined;
+        }
+      },
+      bindURI: (schema) => bindURI(schema, ctx.params, queryEntries),
+      bindForm: function<T>(schema: QeraSchema<T>): T {
+        const data = this.body;
+        if (typeof data !== 'object' || data === null || Array.isArray(data)) {
+          throw new BindError('Expected a form body');
+        }
+        return bindForm(schema, data);
+      },
       validateQuery: function<T>(schema: QeraSchema<T>): T {
         const result = schema.safeParse(this.query);
         if (!result.success) {
@@ -364,19 +1243,40 @@ export class Qera {
     res: HttpResponse,
     method: string,
     handler: RouteHandler,
-    routeParams?: Record<string, string>
+    match: RequestMatch = {}
   ) {
-    const ctx = this.createQeraContext(req, res);
-    
-    // Inject route params if provided
-    if (routeParams) {
-      ctx.params = {...ctx.params, ...routeParams};
-    }
+    const { route, options = {} } = match;
+    this.recorder?.capture(req, res);
+    const ctx = this.createQeraContext(req, res, match);
+    ctx.route = route || null;
+    // Read by streamed writes waiting on a slow client
+    res.writeTimeout = this.config.writeTimeout;
+
+    this.runHooks(this.hooks.request, ctx);
 
     try {
       // Parse body if needed for this method
       if (['post', 'put', 'patch'].includes(method)) {
-        ctx.body = await parseBody(req, res, this.config.bodyLimit);
+        try {
+          const multipart = { ...this.config.multipart, ...options.multipart };
+          // A route's own body limit beats the global multipart total
+          if (options.maxBodySize !== undefined && options.multipart?.maxTotalSize === undefined) {
+            delete multipart.maxTotalSize;
+          }
+          const decompression = options.decompressRequests ?? this.config.decompressRequests;
+          ctx.body = await parseBody(
+            req,
+            res,
+            options.maxBodySize ?? this.config.bodyLimit,
+            this.bodyDecoders,
+            options.bodyTimeout ?? this.config.bodyTimeout,
+            multipart,
+            decompression === true ? {} : decompression || undefined
+          );
+        } catch (error) {
+          if (!(error instanceof BindError)) throw error;
+          bodyErrors.set(ctx, error);
+        }
       }
 
       // Create middleware chain including the route handler at the end
@@ -386,201 +1286,1226 @@ export class Qera {
       let currentMiddlewareIndex = 0;
       
       const next = async () => {
+        // After ctx.abort() nothing further runs, whoever calls next()
+        if (ctx.isAborted()) return;
         const middleware = middlewareChain[currentMiddlewareIndex];
         currentMiddlewareIndex++;
         
         if (middleware) {
-          await middleware(ctx, next);
+          await runMiddleware(middleware, ctx, next);
         } else {
           // After all middleware, execute the route handler
-          await handler(ctx);
+          await runHandler(handler, ctx);
         }
       };
       
       await next();
     } catch (error) {
+      if (error instanceof ConnectionClosedError || error instanceof RequestAbortedError) {
+        // The client left mid-stream or mid-upload, there's nobody left to answer
+        this.finishRequest(ctx, match);
+        return;
+      }
+
+      const clientError = clientErrorResponse(error);
+      if (clientError) {
+        // A client error, not a failure of the handler
+        if (!res.aborted && !ctx.committed) {
+          this.sendError(ctx, clientError.status, clientError.body);
+        }
+        this.finishRequest(ctx, match);
+        return;
+      }
+
+      const mapped = this.errorMap.match(error);
+      if (mapped && mapped.status < 500) {
+        if (!res.aborted && !ctx.committed) {
+          this.sendError(ctx, mapped.status, { error: mapped.message });
+        }
+        this.finishRequest(ctx, match);
+        return;
+      }
+
       Logger.error(`Error handling request: ${error}`);
+      this.runHooks(this.hooks.error, ctx, error);
       
       // Only send response if it hasn't been sent yet
-      if (!res.aborted) {
-        // Set status code in context too for consistency
-        ctx.status(500);
-        res.writeHeader('Content-Type', 'application/json');
-        res.end(JSON.stringify({ error: 'Internal Server Error' }));
+      if (!res.aborted && !ctx.committed) {
+        this.sendError(ctx, mapped?.status ?? 500, { error: mapped?.message ?? 'Internal Server Error' });
       }
     }
-  }
 
-  // Middleware registration
-  use(middleware: Middleware): this {
-    this.middlewares.push(middleware);
-    return this;
+    this.finishRequest(ctx, match);
   }
 
-  // HTTP methods
-  get(path: string, handler: RouteHandler): this {
-    this.routes.get('get')!.set(path, handler);
-    return this;
+  // Count the response for its route, then tell the response hooks and
+  // ctx.onFinish() callbacks
+  private finishRequest(ctx: QeraContext, match: RequestMatch) {
+    clearTimeout(deadlineTimers.get(ctx));
+    const { metrics } = match;
+    if (metrics) {
+      metrics.requests++;
+      if (ctx.statusCode >= 500) {
+        metrics.errors++;
+      } else if (ctx.statusCode >= 400) {
+        metrics.clientErrors++;
+      }
+    }
+    this.runHooks(this.hooks.response, ctx, match.route || null);
+    this.runHooks(finishCallbacks.get(ctx) || []);
+    finishCallbacks.delete(ctx);
   }
 
-  post(path: string, handler: RouteHandler): this {
-    this.routes.get('post')!.set(path, handler);
-    return this;
-  }
+  /**
+   * Refuse a request before any middleware runs, closing the connection:
+   * 414 when its path and query are longer than maxURILength, and 400 when
+   * its body framing is ambiguous (see framingProblem()). Closing drops
+   * whatever the client sent after it, which could be a smuggled request.
+   * Returns whether the request was refused.
+   */
+  private refuseRequest(req: HttpRequest, res: HttpResponse): boolean {
+    const limit = this.config.maxURILength;
+    if (limit) {
+      const query = req.getQuery() || '';
+      const length = req.getUrl().length + (query === '' ? 0 : query.length + 1);
+      if (length > limit) {
+        Logger.debug(`Refused ${req.getMethod().toUpperCase()} with a ${length}-byte URI, over maxURILength`);
+        this.sendRefusal(req, res, '414 URI Too Long', 'URI Too Long');
+        return true;
+      }
+    }
 
-  put(path: string, handler: RouteHandler): this {
-    this.routes.get('put')!.set(path, handler);
-    return this;
+    const problem = framingProblem(req);
+    if (problem === undefined) {
+      return false;
+    }
+    Logger.debug(`Refused ${req.getMethod().toUpperCase()} ${req.getUrl()}: ${problem}`);
+    this.sendRefusal(req, res, '400 Bad Request', 'Bad Request');
+    return true;
   }
 
-  patch(path: string, handler: RouteHandler): this {
-    this.routes.get('patch')!.set(path, handler);
-    return this;
+  private sendRefusal(req: HttpRequest, res: HttpResponse, status: string, error: string) {
+    const type = errorContentType(req.getHeader('accept'), this.config.errorContentType, this.config.negotiateErrors);
+    const body = { error };
+    const rendered = type === 'json'
+      ? { contentType: 'application/json', body: JSON.stringify(body) }
+      : renderError(type, parseInt(status, 10), body);
+    res.cork(() => {
+      res.writeStatus(status).writeHeader('Content-Type', rendered.contentType).end(rendered.body, true);
+    });
   }
 
-  delete(path: string, handler: RouteHandler): this {
-    this.routes.get('del')!.set(path, handler);
+  // A response Qera sends itself, in the errorContentType config format or,
+  // with negotiateErrors, the one the client's Accept header prefers
+  private sendError(ctx: QeraContext, status: number, body: { error: string; [key: string]: any }) {
+    const type = errorContentType(ctx.headers.accept, this.config.errorContentType, this.config.negotiateErrors);
+    if (type === 'json') {
+      ctx.status(status).json(body);
+      return;
+    }
+    const rendered = renderError(type, status, body);
+    ctx.status(status).setHeaders({ 'Content-Type': rendered.contentType }).send(rendered.body);
+  }
+
+  // Middleware registration
+  use(middleware: Middleware): this {
+    this.middlewares.push(middleware);
+    return this;
+  }
+
+  // Serve static files from any StaticFileSystem, e.g. memoryFileSystem() for bundled assets
+  staticFS(
+    prefix: string,
+    fsys: StaticFileSystem,
+    options: { cacheControl?: string; index?: string; spaFallback?: string; spaExclude?: string[]; fallThrough?: boolean } = {}
+  ): this {
+    this.registerStatic(fsys, {
+      prefix: prefix.replace(/\/$/, ''),
+      cacheControl: options.cacheControl || 'public, max-age=86400',
+      index: options.index || 'index.html',
+      spaFallback: options.spaFallback,
+      spaExclude: options.spaExclude || ['/api'],
+      fallThrough: options.fallThrough
+    });
+    return this;
+  }
+
+  /**
+   * Register a named param constraint for route patterns, e.g.
+   * paramPattern('sku', /[A-Z]{3}-\d{4}/) and then "/items/:id{sku}". The
+   * pattern has to match the whole segment. Register patterns before the
+   * routes that use them.
+   */
+  paramPattern(name: string, pattern: RegExp | string): this {
+    if (!/^\w+$/.test(name)) {
+      throw new Error(`Invalid param pattern name "${name}": use letters, digits and underscores`);
+    }
+    this.paramPatterns.set(name, compileParamPattern(pattern));
+    return this;
+  }
+
+  // Routes sharing a path prefix and middleware
+  group(prefix: string, ...middlewares: Middleware[]): RouterGroup {
+    const group = new RouterGroup(this, prefix, middlewares);
+    this.groups.push(group);
+    return group;
+  }
+
+  /**
+   * Routes for requests to one host, e.g. an API and a web app served by
+   * one process: app.host('api.example.com').get('/users', handler). The
+   * Host header is matched first, then the path: a request for a host with
+   * routes of its own only reaches those, and routes added to the app
+   * directly serve every other host. "*.example.com" matches one label
+   * under example.com; an exact host is matched before a wildcard. Ports
+   * and letter case are ignored.
+   */
+  host(pattern: string, ...middlewares: Middleware[]): RouterGroup {
+    const host = hostPattern(pattern);
+    this.hostPatterns.add(host);
+    if (!this.notFoundHandlers.has(host)) {
+      this.notFoundHandlers.set(host, new Map());
+    }
+
+    const route = (method: string) => (path: string, handler: RouteHandler, options: RouteOptions = {}) =>
+      this.addRoute(method, path, handler, options, undefined, host);
+    const group = new RouterGroup({
+      get: route('get'),
+      post: route('post'),
+      put: route('put'),
+      patch: route('patch'),
+      delete: route('del'),
+      options: route('options'),
+      head: route('head'),
+      any: route('any'),
+      mount: (prefix, handler, options = {}) => this.mountRoutes(prefix, handler, options, host),
+      notFound: (handler, prefix = '') => this.notFoundHandlers.get(host)!.set(prefix.replace(/\/+$/, ''), handler)
+    }, '/', middlewares);
+    this.groups.push(group);
+    return group;
+  }
+
+  // The host pattern a request's Host header falls under, '' for none
+  private hostScope(hostHeader: string): string {
+    if (this.hostPatterns.size === 0) {
+      return '';
+    }
+    const host = hostname(hostHeader);
+    if (this.hostPatterns.has(host)) {
+      return host;
+    }
+    for (const pattern of this.hostPatterns) {
+      if (matchesHost(pattern, host)) {
+        return pattern;
+      }
+    }
+    return '';
+  }
+
+  /**
+   * Handle requests no route matched. With a prefix the handler only covers
+   * paths under it; the longest matching prefix wins, then the app-level
+   * handler, then the default JSON 404. Responses start out as 404.
+   */
+  notFound(handler: RouteHandler, prefix = ''): this {
+    this.notFoundHandlers.get('')!.set(prefix.replace(/\/+$/, ''), handler);
+    return this;
+  }
+
+  // Lifecycle hooks: run for every request (including 404/405) and can't alter the response
+  onRequest(hook: RequestHook): this {
+    this.hooks.request.push(hook);
+    return this;
+  }
+
+  onResponse(hook: ResponseHook): this {
+    this.hooks.response.push(hook);
+    return this;
+  }
+
+  /**
+   * Answer errors matching target with status and message (default the
+   * status's reason phrase) wherever they are thrown, e.g.
+   * app.mapError(NotFoundError, 404). target is an error class, matched by
+   * instanceof, or one error object. Errors wrapped with { cause } match
+   * too; see ErrorMap for the order mappings are tried in. 5xx responses
+   * are logged and reach onError hooks like other failures.
+   */
+  mapError(target: ErrorTarget, status: number, message?: string): this {
+    this.errorMap.add(target, status, message);
+    return this;
+  }
+
+  onError(hook: ErrorHook): this {
+    this.hooks.error.push(hook);
+    return this;
+  }
+
+  // Called when a WebSocket handler throws; the connection is closed, others keep running
+  onWebSocketError(hook: WebSocketErrorHook): this {
+    this.hooks.webSocketError.push(hook);
+    return this;
+  }
+
+  // HTTP methods
+  get(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('get', path, handler, options);
+  }
+
+  post(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('post', path, handler, options);
+  }
+
+  put(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('put', path, handler, options);
+  }
+
+  patch(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('patch', path, handler, options);
+  }
+
+  delete(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('del', path, handler, options);
+  }
+
+  options(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('options', path, handler, options);
+  }
+
+  head(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('head', path, handler, options);
+  }
+
+  any(path: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.addRoute('any', path, handler, options);
+  }
+
+  /**
+   * Register routes declared as data, e.g. lists exported by feature
+   * modules. The whole list is checked first: when any entry is invalid
+   * nothing is registered, and a RouteConfigError lists every problem
+   * (unknown methods, missing handlers, malformed patterns, conflicts and
+   * names used twice, within the list or with routes already registered).
+   */
+  addRoutes(routes: RouteDef[]): this {
+    const problems: string[] = [];
+    const added: Array<{ method: string; path: string }> = [];
+    const names = new Map<string, string>();
+    for (const registered of this.routes.values()) {
+      for (const { options } of registered.values()) {
+        if (options.name !== undefined) names.set(options.name, 'a registered route');
+      }
+    }
+
+    routes.forEach((def, i) => {
+      const method = ROUTE_METHODS[String(def.method).toUpperCase()];
+      const label = `Route #${i + 1} (${def.method} ${def.path})`;
+      if (method === undefined) {
+        problems.push(`${label}: unknown method "${def.method}"`);
+      }
+      if (typeof def.handler !== 'function') {
+        problems.push(`${label}: the handler is not a function`);
+      }
+      if (typeof def.path !== 'string') {
+        problems.push(`${label}: the path is not a string`);
+        return;
+      }
+      const problem = routePatternProblem(def.path);
+      if (problem) {
+        problems.push(`${label}: ${problem}`);
+      }
+      for (const { pattern } of routeParams(def.path)) {
+        if (pattern !== undefined && !this.paramPatterns.has(pattern)) {
+          problems.push(`${label}: unknown param pattern "${pattern}"`);
+        }
+      }
+      if (method !== undefined) {
+        const shape = routeShape(def.path);
+        const existing = this.conflictingRoute(method, def.path);
+        const earlier = added.find(route => route.method === method && routeShape(route.path) === shape);
+        if (existing || earlier) {
+          problems.push(`${label}: conflicts with ${routeLabel(method, existing ? existing.path : earlier!.path)}`);
+        }
+        added.push({ method, path: def.path });
+      }
+      const name = def.name ?? def.options?.name;
+      if (name !== undefined) {
+        if (names.has(name)) {
+          problems.push(`${label}: the name "${name}" is already used by ${names.get(name)}`);
+        } else {
+          names.set(name, `route #${i + 1}`);
+        }
+      }
+    });
+
+    if (problems.length > 0) {
+      throw new RouteConfigError(problems);
+    }
+    for (const def of routes) {
+      const options = def.name === undefined ? def.options || {} : { ...def.options, name: def.name };
+      this.addRoute(ROUTE_METHODS[def.method.toUpperCase()], def.path, compose(def.middleware || [], def.handler), options);
+    }
+    return this;
+  }
+
+  /**
+   * Register a route, refusing a second handler for the same method and
+   * pattern. Patterns that only differ in parameter names (/users/:id and
+   * /users/:name) conflict too, since the first would always win.
+   */
+  private addRoute(
+    method: string,
+    path: string,
+    handler: RouteHandler,
+    options: RouteOptions,
+    prefix?: string,
+    host?: string
+  ): this {
+    const routes = this.routes.get(method)!;
+    const site = registrationSite();
+
+    for (const { pattern } of routeParams(path)) {
+      if (pattern !== undefined && !this.paramPatterns.has(pattern)) {
+        throw new Error(`Unknown param pattern "${pattern}" in route ${routeLabel(method, path, host)}`);
+      }
+    }
+
+    const existing = this.conflictingRoute(method, path, host);
+    if (existing) {
+      throw new Error(
+        `Route conflict: ${routeLabel(method, path, host)} conflicts with ${routeLabel(method, existing.path, host)}\n` +
+        `  registered at ${site}\n` +
+        `  previously registered at ${existing.site}`
+      );
+    }
+
+    routes.set(host === undefined ? path : `${host}${path}`, {
+      path, handler, options, site, prefix, host, metrics: { requests: 0, clientErrors: 0, errors: 0 }
+    });
+    return this;
+  }
+
+  // The registered route a new one for method, path and host would conflict
+  // with; routes for different hosts never compete for a request
+  private conflictingRoute(method: string, path: string, host?: string): RegisteredRoute | undefined {
+    const shape = routeShape(path);
+    for (const existing of this.routes.get(method)!.values()) {
+      if (existing.host === host && routeShape(existing.path) === shape) {
+        return existing;
+      }
+    }
+    return undefined;
+  }
+
+  /**
+   * Serve the app below prefix without changing route registrations, e.g.
+   * behind a load balancer that forwards /service-a/* unchanged: a request
+   * for /service-a/users is handled by the /users route, and requests
+   * outside the prefix get a plain 404. Handlers see the path without the
+   * prefix in ctx.path(); ctx.req.getUrl() still returns it as sent.
+   *
+   * Routing happens before any middleware runs, so this is an app setting
+   * rather than middleware. Call it before listen().
+   */
+  stripPrefix(prefix: string): this {
+    this.pathPrefix = { mode: 'strip', prefix: joinPaths('/', prefix) };
     return this;
   }
 
-  options(path: string, handler: RouteHandler): this {
-    this.routes.get('options')!.set(path, handler);
+  /**
+   * The reverse of stripPrefix(), for a load balancer that removes a prefix
+   * the routes were registered with: a request for /users is handled by the
+   * /service-a/users route, and ctx.path() includes the prefix. Routes
+   * outside the prefix can't be reached and are skipped with a warning.
+   */
+  addPrefix(prefix: string): this {
+    this.pathPrefix = { mode: 'add', prefix: joinPaths('/', prefix) };
+    return this;
+  }
+
+  // The uWS pattern requests must match to reach a route pattern; null when
+  // no request can reach it
+  private requestPattern(pattern: string): string | null {
+    if (!this.pathPrefix || this.pathPrefix.prefix === '/') {
+      return pattern;
+    }
+    const { mode, prefix } = this.pathPrefix;
+    if (mode === 'strip') {
+      return joinPaths(prefix, pattern);
+    }
+    if (pattern === prefix) {
+      return '/';
+    }
+    return pattern.startsWith(`${prefix}/`) ? pattern.slice(prefix.length) : null;
+  }
+
+  // The path routes see for a request path; null when it is outside a stripped prefix
+  private routePath(url: string): string | null {
+    if (!this.pathPrefix || this.pathPrefix.prefix === '/') {
+      return url;
+    }
+    const { mode, prefix } = this.pathPrefix;
+    if (mode === 'add') {
+      return url === '/' ? prefix : `${prefix}${url}`;
+    }
+    if (url === prefix) {
+      return '/';
+    }
+    return url.startsWith(`${prefix}/`) ? url.slice(prefix.length) : null;
+  }
+
+  /**
+   * Send every request for prefix or any path below it to handler, whatever
+   * the method, e.g. a sub-router or legacy code being migrated. Exact and
+   * parameter routes under the prefix still win; the mount gets whatever they
+   * don't match, including what would otherwise be a 404 or 405.
+   * ctx.route.prefix holds the prefix and ctx.path() the full path.
+   */
+  mount(prefix: string, handler: RouteHandler, options: RouteOptions = {}): this {
+    return this.mountRoutes(prefix, handler, options);
+  }
+
+  private mountRoutes(prefix: string, handler: RouteHandler, options: RouteOptions, host?: string): this {
+    const base = joinPaths('/', prefix);
+    // uWS wildcards need a segment before them, except for "/*" which matches "/" too
+    if (base !== '/') {
+      this.addRoute('any', base, handler, options, base, host);
+    }
+    return this.addRoute('any', joinPaths(base, '*'), handler, options, base, host);
+  }
+
+  /**
+   * Serve V8 profiles under prefix for profiling a live process. Opt in
+   * only, and keep it behind auth: profiles expose source and heap
+   * contents, and captures slow the process down while they run.
+   *
+   *   GET prefix/cpu?seconds=30          .cpuprofile (Chrome DevTools, VS Code)
+   *   GET prefix/allocations?seconds=30  .heapprofile of sampled allocations
+   *   GET prefix/heap                    .heapsnapshot, pausing the process
+   *   GET prefix/stats                   memory and event loop delay as JSON
+   *
+   * One capture runs at a time; others get a 409.
+   */
+  enableProfiling(prefix = '/debug/profile', options: ProfilingOptions = {}): this {
+    const maxSeconds = options.maxSeconds ?? 60;
+    const group = this.group(prefix, ...(options.auth ? [options.auth] : []));
+    if (!options.auth) {
+      Logger.warn(`Profiling endpoints under ${group.prefix} have no auth: anyone who can reach them can profile the process`);
+    }
+
+    // seconds from the query, 30 by default; a disconnecting client ends the capture early
+    const duration = (ctx: QeraContext): number | undefined => {
+      const seconds = ctx.query.seconds === undefined ? Math.min(30, maxSeconds) : Number(ctx.query.seconds);
+      if (!(seconds > 0 && seconds <= maxSeconds)) {
+        ctx.status(400).json({ error: `seconds must be between 0 and ${maxSeconds}` });
+        return undefined;
+      }
+      return seconds;
+    };
+
+    // Saved under a name DevTools recognises by its extension
+    const download = (ctx: QeraContext, extension: string) => ctx
+      .header('Content-Type', 'application/json')
+      .header('Content-Disposition', `attachment; filename="profile-${Date.now()}.${extension}"`);
+
+    const capture = (run: (ctx: QeraContext) => Promise<void>): RouteHandler => async (ctx) => {
+      try {
+        await run(ctx);
+      } catch (error) {
+        if (!(error instanceof ProfilerBusyError)) throw error;
+        ctx.status(409).json({ error: error.message });
+      }
+    };
+
+    group.get('/', (ctx) => {
+      ctx.json({ endpoints: ['cpu', 'allocations', 'heap', 'stats'].map(name => joinPaths(group.prefix, name)) });
+    });
+    group.get('/cpu', capture(async (ctx) => {
+      const seconds = duration(ctx);
+      if (seconds === undefined) return;
+      const profile = await captureCPUProfile(seconds, ctx.signal);
+      download(ctx, 'cpuprofile').send(profile);
+    }));
+    group.get('/allocations', capture(async (ctx) => {
+      const seconds = duration(ctx);
+      if (seconds === undefined) return;
+      const profile = await captureHeapProfile(seconds, ctx.signal);
+      download(ctx, 'heapprofile').send(profile);
+    }));
+    group.get('/heap', capture(async (ctx) => {
+      const chunks = await captureHeapSnapshot();
+      download(ctx, 'heapsnapshot');
+      await ctx.stream('application/json', chunks);
+    }));
+    group.get('/stats', async (ctx) => ctx.json(await runtimeStats()));
     return this;
   }
 
-  head(path: string, handler: RouteHandler): this {
-    this.routes.get('head')!.set(path, handler);
+  /**
+   * Every route with the request and response schemas declared in its
+   * options, converted to JSON Schema, e.g. to build OpenAPI documents.
+   */
+  routeDocs(): RouteDoc[] {
+    const docs: RouteDoc[] = [];
+    for (const [method, routes] of this.routes) {
+      for (const { path, options, host } of routes.values()) {
+        const doc: RouteDoc = { method: routeMethodName(method), path };
+        if (host !== undefined) {
+          doc.host = host;
+        }
+        if (options.request) {
+          doc.request = options.request.toJSONSchema();
+        }
+        if (options.responses) {
+          doc.responses = {};
+          for (const [status, schema] of Object.entries(options.responses)) {
+            doc.responses[Number(status)] = schema.toJSONSchema();
+          }
+        }
+        docs.push(doc);
+      }
+    }
+    return docs;
+  }
+
+  /**
+   * Every route's request and error counts, e.g. for a /stats endpoint.
+   * Counting is always on and costs a few increments per request; unmatched
+   * requests aren't counted. qera.route.metrics() reads one route's counts.
+   */
+  routeMetrics(): RouteMetricsEntry[] {
+    const entries: RouteMetricsEntry[] = [];
+    for (const [method, routes] of this.routes) {
+      for (const { path, host, metrics } of routes.values()) {
+        const entry: RouteMetricsEntry = { method: routeMethodName(method), path, ...metrics };
+        if (host !== undefined) {
+          entry.host = host;
+        }
+        entries.push(entry);
+      }
+    }
+    return entries;
+  }
+
+  // Start every route's counts from zero again
+  resetRouteMetrics(): this {
+    for (const routes of this.routes.values()) {
+      for (const { metrics } of routes.values()) {
+        metrics.requests = 0;
+        metrics.clientErrors = 0;
+        metrics.errors = 0;
+      }
+    }
     return this;
   }
 
-  any(path: string, handler: RouteHandler): this {
-    this.routes.get('any')!.set(path, handler);
+  /**
+   * How many requests came on new and on kept-alive connections, and how
+   * many connections are open, to see whether clients reuse connections
+   * under load. uWS.js reports no connection events, so connections are told
+   * apart by client address and port, and one counts as open until it has
+   * been idle for the keep-alive timeout or closed after a response.
+   */
+  connectionStats(): ConnectionStats {
+    const now = Date.now();
+    let open = 0;
+    for (const { lastSeen } of this.connectionRequests.values()) {
+      if (now - lastSeen < KEEP_ALIVE_TIMEOUT_MS) open++;
+    }
+    return { ...this.connectionCounts, open };
+  }
+
+  /**
+   * Serve WebSocket connections on path. The upgrade request passes through
+   * the app middleware, then the middleware given here, like a GET route:
+   * middleware that answers it (e.g. a 401) refuses the connection.
+   * qera.state and qera.user as they stand at the upgrade are handed to the
+   * socket handlers as ctx.state and ctx.user.
+   */
+  ws(path: string, handlers: WebSocketHandler, ...middlewares: Middleware[]): this {
+    this.wsHandlers.set(path, { handlers, middlewares });
     return this;
   }
 
-  // WebSocket support
-  ws(path: string, handlers: WebSocketHandler): this {
-    this.wsHandlers.set(path, handlers);
+  /**
+   * Serve the app on another address as well, e.g. an internal port next to
+   * the public one. All listeners share routes and middleware; listeners with
+   * their own ssl certificate get a separate uWS app, so WebSocket publish()
+   * only reaches clients connected through the same kind of listener.
+   */
+  addListener(
+    port: number,
+    host = 'localhost',
+    ssl?: { key_file_name: string; cert_file_name: string }
+  ): this {
+    this.listeners.push({ port, host, ssl });
     return this;
   }
 
-  // Start the server
+  /**
+   * Check the route setup and throw a RouteConfigError listing every
+   * problem, rather than serving a tree that can't work: malformed
+   * patterns (no leading slash, unnamed or repeated params, a wildcard
+   * before the last segment), route names used twice and group middleware
+   * no route runs. Conflicting routes and unknown param patterns already
+   * throw when registered.
+   *
+   * listen(), listenAutoTLS() and handler() call this first; call it in a
+   * test to catch misconfiguration in CI without starting a server.
+   */
+  build(): void {
+    const problems: string[] = [];
+
+    for (const [method, routes] of this.routes) {
+      for (const { path, host } of routes.values()) {
+        const problem = routePatternProblem(path);
+        if (problem) {
+          problems.push(`Route ${routeLabel(method, path, host)}: ${problem}`);
+        }
+      }
+    }
+
+    const named = new Map<string, string>();
+    for (const [method, routes] of this.routes) {
+      for (const { path, options, host } of routes.values()) {
+        if (options.name === undefined) continue;
+        const route = routeLabel(method, path, host);
+        const existing = named.get(options.name);
+        if (existing) {
+          problems.push(`Route name "${options.name}" is used by both ${existing} and ${route}`);
+        } else {
+          named.set(options.name, route);
+        }
+      }
+    }
+
+    for (const path of this.wsHandlers.keys()) {
+      const problem = routePatternProblem(path);
+      if (problem) {
+        problems.push(`WebSocket route ${path}: ${problem}`);
+      }
+    }
+
+    for (const { prefix, middlewares } of this.groups.flatMap(group => group.danglingMiddleware())) {
+      const names = middlewares.map(middleware => middleware.name || 'anonymous').join(', ');
+      problems.push(`Group ${prefix}: middleware ${names} is added after all of its routes, or the group has none, so it never runs`);
+    }
+
+    if (problems.length > 0) {
+      throw new RouteConfigError(problems);
+    }
+  }
+
+  // Start the server on the primary address and every added listener
   listen(port?: number, host?: string): void {
+    this.build();
     port = port || this.config.port || 3000;
     host = host || this.config.host || 'localhost';
 
-    // Register all routes
-    this.registerRoutes();
-    
-    // Register all WebSocket handlers
-    this.registerWebSocketHandlers();
+    this.mountApp(this.app, !!this.config.ssl);
+    this.startListener(this.app, { port, host, ssl: this.config.ssl });
 
-    this.app.listen(host, port, (listenSocket) => {
-      if (listenSocket) {
-        Logger.info(`Server listening on http://${host}:${port}`);
+    for (const listener of this.listeners) {
+      if (listener.ssl) {
+        const app = SSLApp(listener.ssl);
+        this.mountApp(app, true);
+        this.startListener(app, listener);
       } else {
-        Logger.error(`Failed to listen on port ${port}`);
+        this.startListener(this.app, listener);
       }
-    });
+    }
+  }
+
+  /**
+   * A Node http request listener serving this app, for running Qera inside
+   * an existing Node server (http, https, http2 in compatibility mode) or
+   * handing it to test tools that take one:
+   *
+   *   http.createServer(app.handler()).listen(8080);
+   *
+   * Routes, middleware, params and bodies work as with listen(); register
+   * routes first, as routes added after the first call are not served.
+   * WebSockets are not served through the handler. The handler is built
+   * once, so calling this again returns an equivalent listener.
+   */
+  handler(): (req: IncomingMessage, res: ServerResponse) => void {
+    if (!this.nodeRouter) {
+      this.build();
+      this.nodeRouter = new NodeRouter();
+      this.mountApp(this.nodeRouter.app, false);
+    }
+    const router = this.nodeRouter;
+    return (req, res) => router.dispatch(req, res);
   }
 
-  private registerRoutes() {
-    // Import the matchRoute function from urlParser
-    const { matchRoute } = require('../utils/urlParser');
+  /**
+   * Feed a fixture written in record mode back through the app, middleware
+   * and routing included, and resolve with the response next to the
+   * recorded one. headers are added to the recorded request, e.g. to put
+   * back credentials that were redacted.
+   */
+  async replay(file: string, headers: Record<string, string> = {}): Promise<ReplayResult> {
+    return replayExchange(await readExchange(file), this.handler(), headers);
+  }
 
-    // Register GET routes
-    for (const [routePath, handler] of this.routes.get('get')!) {
-      this.app.get(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        // Pass params to handleRequest
-        this.handleRequest(req, res, 'get', handler, params);
-      });
+  /**
+   * Switch TLS listeners to a new certificate without a restart, e.g. after
+   * a Let's Encrypt renewal. The pair is checked first and an invalid one
+   * throws, leaving the old certificate in place. New connections are then
+   * accepted by a fresh uWS app bound to the same addresses (uWS binds with
+   * SO_REUSEPORT), while connections made before the reload finish on the
+   * old one. As with addListener(), WebSocket publish() only reaches clients
+   * connected to the same uWS app, i.e. since the same reload.
+   */
+  reloadTLS(certFile: string, keyFile: string): void {
+    if (this.tlsListeners.length === 0) {
+      throw new Error('reloadTLS: no TLS listener is running');
     }
 
-    // Register POST routes
-    for (const [routePath, handler] of this.routes.get('post')!) {
-      this.app.post(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        this.handleRequest(req, res, 'post', handler, params);
-      });
+    try {
+      createSecureContext({ cert: readFileSync(certFile), key: readFileSync(keyFile) });
+    } catch (error) {
+      throw new Error(`reloadTLS: invalid certificate or key: ${(error as Error).message}`);
     }
 
-    // Register PUT routes
-    for (const [routePath, handler] of this.routes.get('put')!) {
-      this.app.put(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        this.handleRequest(req, res, 'put', handler, params);
-      });
+    this.rebindTLS(this.tlsListeners, { key_file_name: keyFile, cert_file_name: certFile });
+  }
+
+  /**
+   * Serve the app over HTTPS with certificates obtained and renewed
+   * automatically from Let's Encrypt (or another ACME CA). Port httpPort
+   * answers HTTP-01 challenges and redirects everything else to HTTPS.
+   * Certificates are cached in cacheDir, so restarts reuse them instead of
+   * hitting the CA's rate limits. Resolves once the HTTPS listener is up and
+   * rejects when no certificate could be obtained.
+   */
+  async listenAutoTLS(options: AutoTLSOptions): Promise<void> {
+    if (!options.acceptTerms) {
+      throw new Error('listenAutoTLS: set acceptTerms to agree to the certificate authority\'s terms of service');
+    }
+    if (options.domains.length === 0) {
+      throw new Error('listenAutoTLS: at least one domain is required');
     }
+    this.build();
+
+    const { domains, cacheDir } = options;
+    const host = options.host || '0.0.0.0';
+    const port = options.port ?? 443;
+    const renewBefore = options.renewBefore ?? 30 * 24 * 60 * 60 * 1000;
+    const certFile = join(cacheDir, `${domains[0]}.crt`);
+    const keyFile = join(cacheDir, `${domains[0]}.key`);
+    const accountFile = join(cacheDir, 'acme-account.key');
+    mkdirSync(cacheDir, { recursive: true, mode: 0o700 });
+
+    const challenges = new Map<string, string>();
+    const httpApp = App();
+    httpApp.get('/.well-known/acme-challenge/:token', (res, req) => {
+      const keyAuthorization = challenges.get(req.getParameter(0) || '');
+      if (keyAuthorization) {
+        res.writeHeader('Content-Type', 'text/plain').end(keyAuthorization);
+      } else {
+        res.writeStatus('404 Not Found').end();
+      }
+    });
+    httpApp.any('/*', (res, req) => {
+      const method = req.getMethod().toUpperCase();
+      if (method !== 'GET' && method !== 'HEAD') {
+        res.writeStatus('400 Bad Request').end('Use HTTPS');
+        return;
+      }
+      const hostname = req.getHeader('host').replace(/:\d+$/, '') || domains[0];
+      const query = req.getQuery();
+      const location = `https://${hostname}${port === 443 ? '' : `:${port}`}${req.getUrl()}${query ? `?${query}` : ''}`;
+      res.writeStatus('301 Moved Permanently').writeHeader('Location', location).end();
+    });
+    this.uwsApps.push(httpApp);
+    this.startListener(httpApp, { port: options.httpPort ?? 80, host });
+
+    // Fetch a certificate unless the cached one is still good; true when renewed
+    const renew = async () => {
+      const cached = existsSync(certFile) ? readFileSync(certFile, 'utf8') : '';
+      if (!certificateNeedsRenewal(cached, domains, renewBefore)) {
+        return false;
+      }
+      Logger.info(`Requesting a certificate for ${domains.join(', ')}`);
+      const issued = await obtainCertificate(domains, {
+        directoryUrl: options.directoryUrl,
+        email: options.email,
+        accountKey: existsSync(accountFile) ? readFileSync(accountFile, 'utf8') : undefined,
+        publish: (token, keyAuthorization) => challenges.set(token, keyAuthorization),
+        unpublish: (token) => challenges.delete(token)
+      });
+      writeFileSync(accountFile, issued.accountKey, { mode: 0o600 });
+      writeFileSync(keyFile, issued.key, { mode: 0o600 });
+      writeFileSync(certFile, issued.cert);
+      return true;
+    };
 
-    // Register PATCH routes
-    for (const [routePath, handler] of this.routes.get('patch')!) {
-      this.app.patch(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        this.handleRequest(req, res, 'patch', handler, params);
+    await renew();
+
+    const listener: Listener = { port, host, ssl: { key_file_name: keyFile, cert_file_name: certFile } };
+    const app = SSLApp(listener.ssl!);
+    this.mountApp(app, true);
+    this.startListener(app, listener);
+
+    let renewing = false;
+    const timer = setInterval(async () => {
+      if (renewing) return;
+      renewing = true;
+      try {
+        if (await renew()) {
+          this.rebindTLS(this.tlsListeners.filter(entry => entry.listener === listener), listener.ssl!);
+        }
+      } catch (error) {
+        // The current certificate keeps serving; the next check tries again
+        Logger.error(`Certificate renewal for ${domains.join(', ')} failed: ${(error as Error).message}`);
+      } finally {
+        renewing = false;
+      }
+    }, AUTO_TLS_CHECK_INTERVAL_MS);
+    timer.unref();
+    this.renewalTimers.push(timer);
+  }
+
+  // Bind a new uWS app with the certificate to the addresses of the entries,
+  // closing only their old listen sockets
+  private rebindTLS(entries: Array<{ listener: Listener; socket: us_listen_socket }>, ssl: { key_file_name: string; cert_file_name: string }) {
+    const app = SSLApp(ssl);
+    this.mountApp(app, true);
+
+    const failed: string[] = [];
+    for (const entry of entries) {
+      const { host, port } = entry.listener;
+      app.listen(host, port, (listenSocket) => {
+        if (!listenSocket) {
+          failed.push(`${host}:${port}`);
+          return;
+        }
+        // Only the old listen socket closes; its open connections carry on
+        us_listen_socket_close(entry.socket);
+        this.listenSockets[this.listenSockets.indexOf(entry.socket)] = listenSocket;
+        entry.socket = listenSocket;
+        entry.listener.ssl = ssl;
       });
     }
 
-    // Register DELETE routes
-    for (const [routePath, handler] of this.routes.get('del')!) {
-      this.app.del(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        this.handleRequest(req, res, 'del', handler, params);
-      });
+    if (failed.length > 0) {
+      throw new Error(`reloadTLS: could not bind ${failed.join(', ')}; those listeners keep the old certificate`);
     }
+    Logger.info(`Reloaded TLS certificate from ${ssl.cert_file_name}`);
+  }
 
-    // Register OPTIONS routes
-    for (const [routePath, handler] of this.routes.get('options')!) {
-      this.app.options(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        this.handleRequest(req, res, 'options', handler, params);
-      });
+  /**
+   * Stop accepting connections on all listeners and resolve once requests
+   * already in flight have finished. With a timeout (ms), requests still
+   * running at the deadline are cut off: their connections and any other
+   * open connections (keep-alive, WebSockets) are closed, and the promise
+   * rejects with a ShutdownTimeoutError saying how many were force-closed.
+   */
+  async shutdown(options: { timeout?: number } = {}): Promise<void> {
+    const startedAt = Date.now();
+
+    for (const socket of this.listenSockets) {
+      us_listen_socket_close(socket);
     }
+    this.listenSockets = [];
+    this.tlsListeners = [];
+    this.renewalTimers.splice(0).forEach(timer => clearInterval(timer));
+
+    if (this.inFlight.size > 0) {
+      let timer: NodeJS.Timeout | undefined;
+      const drained = new Promise<boolean>(resolve => this.drainWaiters.push(() => resolve(true)));
+      const deadline = options.timeout === undefined
+        ? new Promise<boolean>(() => undefined)
+        : new Promise<boolean>(resolve => {
+          timer = setTimeout(() => resolve(false), options.timeout);
+        });
 
-    // Register HEAD routes
-    for (const [routePath, handler] of this.routes.get('head')!) {
-      this.app.head(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        this.handleRequest(req, res, 'head', handler, params);
-      });
+      const clean = await Promise.race([drained, deadline]);
+      clearTimeout(timer);
+
+      if (!clean) {
+        const forceClosed = this.forceClose();
+        Logger.warn(`Server shut down after force-closing ${forceClosed} in-flight requests`, {
+          clean: false,
+          forceClosed,
+          durationMs: Date.now() - startedAt
+        });
+        throw new ShutdownTimeoutError(options.timeout!, forceClosed);
+      }
     }
 
-    // Register ANY routes
-    for (const [routePath, handler] of this.routes.get('any')!) {
-      this.app.any(routePath, (res, req) => {
-        // Extract params from URL
-        const url = req.getUrl();
-        const { match, params } = matchRoute(routePath, url);
-        
-        this.handleRequest(req, res, req.getMethod().toLowerCase(), handler, params);
-      });
+    Logger.info('Server shut down', { clean: true, forceClosed: 0, durationMs: Date.now() - startedAt });
+  }
+
+  // Close every connection that is still open; returns how many requests were cut off
+  private forceClose(): number {
+    const responses = [...this.inFlight.keys()].filter(res => !res.aborted);
+    for (const res of responses) {
+      res.close();
+    }
+    for (const app of this.uwsApps) {
+      app.close();
+    }
+    return responses.length;
+  }
+
+  // Register static files, routes and WebSocket handlers on a uWS app
+  private mountApp(app: TemplatedApp, secure: boolean) {
+    this.uwsApps.push(app);
+    // Routes are recorded as well as registered when a static mount falls through to them
+    const router = this.staticMounts.some(({ options }) => options.fallThrough) ? new NodeRouter(app) : undefined;
+    for (const { fsys, options } of this.staticMounts) {
+      this.mountStatic(app, fsys, options, secure, router);
+    }
+    this.registerRoutes(router?.app ?? app, secure);
+    this.registerWebSocketHandlers(app, secure);
+  }
+
+  private startListener(app: TemplatedApp, listener: Listener) {
+    const address = `${listener.ssl ? 'https' : 'http'}://${listener.host}:${listener.port}`;
+
+    app.listen(listener.host, listener.port, (listenSocket) => {
+      if (listenSocket) {
+        this.listenSockets.push(listenSocket);
+        if (listener.ssl) {
+          this.tlsListeners.push({ listener, socket: listenSocket });
+        }
+        Logger.info(`Server listening on ${address}`);
+      } else {
+        Logger.error(`Failed to listen on ${address}: address in use or not permitted`);
+      }
+    });
+  }
+
+  // Count a request as in flight until its handling settles
+  private track(res: HttpResponse, work: Promise<void>) {
+    this.inFlight.set(res, (this.inFlight.get(res) ?? 0) + 1);
+    work.finally(() => {
+      const left = this.inFlight.get(res)! - 1;
+      if (left > 0) {
+        this.inFlight.set(res, left);
+        return;
+      }
+      this.inFlight.delete(res);
+      if (this.inFlight.size === 0) {
+        this.drainWaiters.splice(0).forEach(resolve => resolve());
+      }
+    });
+  }
+
+  /**
+   * Count a request on its connection, for connectionStats(), and ask uWS to
+   * close the connection after the response that completes
+   * maxRequestsPerConnection requests on it. Must run before the response
+   * ends. uWS.js has no connection identity, so connections are told apart
+   * by remote address and port; an entry idle for longer than the keep-alive
+   * timeout belongs to a connection uWS has already closed.
+   */
+  private countConnectionRequest(req: HttpRequest, res: HttpResponse) {
+    // Counted already by the static mount that passed the request on
+    if (res.fellThrough) return;
+
+    const now = Date.now();
+    const key = `${Buffer.from(res.getRemoteAddressAsText()).toString()}:${res.getRemotePort()}`;
+    const entry = this.connectionRequests.get(key);
+    const reused = entry !== undefined && now - entry.lastSeen < KEEP_ALIVE_TIMEOUT_MS;
+    const count = reused ? entry.count + 1 : 1;
+    if (reused) {
+      this.connectionCounts.reused++;
+    } else {
+      this.connectionCounts.opened++;
+    }
+
+    const max = this.config.maxRequestsPerConnection;
+    if (max && count >= max) {
+      this.connectionRequests.delete(key);
+      res.closeConnection = true;
+    } else if (/\bclose\b/i.test(req.getHeader('connection'))) {
+      // The client hangs up after this response
+      this.connectionRequests.delete(key);
+    } else {
+      this.connectionRequests.set(key, { count, lastSeen: now });
+    }
+
+    // Connections that went away without reaching the limit leave entries behind
+    if (now - this.lastConnectionSweep >= KEEP_ALIVE_TIMEOUT_MS) {
+      this.lastConnectionSweep = now;
+      for (const [stale, { lastSeen }] of this.connectionRequests) {
+        if (now - lastSeen >= KEEP_ALIVE_TIMEOUT_MS) {
+          this.connectionRequests.delete(stale);
+        }
+      }
     }
   }
 
-  private registerWebSocketHandlers() {
-    for (const [path, handler] of this.wsHandlers.entries()) {
-      this.app.ws(path, {
+  private registerRoutes(app: TemplatedApp, secure: boolean) {
+    const unescape = this.config.unescapePath === false ? (value: string) => value : unescapePathSegment;
+
+    for (const [method, routes] of this.routes) {
+      // uWS tries routes with the same pattern in registration order, so
+      // constrained routes go first and yield when their constraint fails.
+      // Routes yield requests for other hosts too
+      const ordered = [...routes.values()].sort((a, b) =>
+        Number(stripParamPatterns(b.path) !== b.path) - Number(stripParamPatterns(a.path) !== a.path));
+
+      for (const { path: routePath, handler, options, prefix, host, metrics } of ordered) {
+        const route: RouteInfo = {
+          method: routeMethodName(method),
+          path: routePath,
+          methods: this.routeMethods(routePath, host),
+          options,
+          metrics: () => ({ ...metrics })
+        };
+        if (options.name !== undefined) {
+          route.name = options.name;
+        }
+        if (prefix !== undefined) {
+          route.prefix = prefix;
+        }
+        if (host !== undefined) {
+          route.host = host;
+        }
+        const scope = host ?? '';
+        const declared = routeParams(routePath);
+        const paramNames = declared.map(param => param.name);
+        const constraints = declared.map(param =>
+          param.pattern === undefined ? undefined : this.paramPatterns.get(param.pattern));
+
+        const pattern = this.requestPattern(stripParamPatterns(routePath));
+        if (pattern === null) {
+          Logger.warn(`Route ${routeMethodName(method)} ${routePath} is outside the added prefix ${this.pathPrefix!.prefix} and can't be reached`);
+          continue;
+        }
+
+        (app as any)[method](pattern, (res: HttpResponse, req: HttpRequest) => {
+          if (this.hostScope(req.getHeader('host')) !== scope) {
+            req.setYield(true);
+            return;
+          }
+
+          // uWS hands out params by position, valid only until the first await.
+          // It matches the raw path, so "%2F" never splits a segment
+          const params = paramNames.length === 0
+            ? NO_PARAMS
+            : paramNames.map((name, i): [string, string] => [name, unescape(req.getParameter(i))]);
+
+          if (constraints.some((constraint, i) => constraint && !constraint.test(params[i][1]))) {
+            req.setYield(true);
+            return;
+          }
+
+          if (this.refuseRequest(req, res)) return;
+
+          const requestMethod = method === 'any' ? req.getMethod().toLowerCase() : method;
+
+          this.countConnectionRequest(req, res);
+          this.track(res, this.handleRequest(req, res, requestMethod, handler, { params, route, metrics, options, secure }));
+        });
+      }
+    }
+
+    // Anything uWS couldn't route ends up here as a 404 or 405
+    app.any('/*', (res, req) => {
+      this.handleUnmatched(req, res, secure);
+    });
+  }
+
+  private handleUnmatched(req: HttpRequest, res: HttpResponse, secure: boolean) {
+    if (this.refuseRequest(req, res)) return;
+
+    // Requests outside a stripped prefix match nothing, not even not-found handlers
+    const url = this.routePath(req.getUrl());
+    const scope = this.hostScope(req.getHeader('host'));
+    const allowed = url === null ? [] : this.allowedMethods(url, scope);
+    const notFound = allowed.length === 0 && url !== null ? this.findNotFoundHandler(url, scope) : undefined;
+
+    this.countConnectionRequest(req, res);
+
+    // Unmatched requests still pass through global middleware (CORS, favicon, ...)
+    this.track(res, this.handleRequest(req, res, req.getMethod().toLowerCase(), async (ctx) => {
+      if (allowed.length > 0) {
+        ctx.header('Allow', allowed.join(', '));
+        this.sendError(ctx, 405, { error: 'Method Not Allowed' });
+      } else if (notFound) {
+        ctx.status(404);
+        await notFound(ctx);
+      } else {
+        this.sendError(ctx, 404, { error: 'Not Found' });
+      }
+    }, { secure }));
+  }
+
+
+  // The not-found handler registered for the longest prefix of url, among
+  // those of the request's host; the app-level one covers every host
+  private findNotFoundHandler(url: string, scope: string): RouteHandler | undefined {
+    const handlers = this.notFoundHandlers.get(scope)!;
+    let best: string | undefined;
+
+    for (const prefix of handlers.keys()) {
+      const covers = prefix === '' || url === prefix || url.startsWith(`${prefix}/`);
+      if (covers && (best === undefined || prefix.length > best.length)) {
+        best = prefix;
+      }
+    }
+
+    return best === undefined ? this.notFoundHandlers.get('')!.get('') : handlers.get(best);
+  }
+
+  // Methods with a route registered for exactly this pattern and host
+  private routeMethods(pattern: string, host?: string): string[] {
+    const key = host === undefined ? pattern : `${host}${pattern}`;
+    return [...this.routes]
+      .filter(([, routes]) => routes.has(key))
+      .map(([method]) => routeMethodName(method));
+  }
+
+  // Methods that have a route matching the given path for the request's host
+  private allowedMethods(url: string, scope: string): string[] {
+    const allowed: string[] = [];
+
+    for (const [method, routes] of this.routes) {
+      if (method === 'any') continue;
+
+      for (const { path: routePath, host } of routes.values()) {
+        if ((host ?? '') === scope && matchRoute(routePath, url, this.paramPatterns).match) {
+          allowed.push(routeMethodName(method));
+          break;
+        }
+      }
+    }
+
+    return allowed;
+  }
+
+  // Lifecycle hooks are observers: failures are logged but never affect the response
+  private runHooks<A extends any[]>(hooks: Array<(...args: A) => void | Promise<void>>, ...args: A) {
+    for (const hook of hooks) {
+      try {
+        const result = hook(...args);
+        if (result instanceof Promise) {
+          result.catch(error => Logger.error(`Error in lifecycle hook: ${error}`));
+        }
+      } catch (error) {
+        Logger.error(`Error in lifecycle hook: ${error}`);
+      }
+    }
+  }
+
+  private registerWebSocketHandlers(app: TemplatedApp, secure: boolean) {
+    const unescape = this.config.unescapePath === false ? (value: string) => value : unescapePathSegment;
+
+    for (const [path, { handlers: handler, middlewares }] of this.wsHandlers.entries()) {
+      const paramNames = routeParams(path).map(param => param.name);
+
+      const pattern = this.requestPattern(stripParamPatterns(path));
+      if (pattern === null) {
+        Logger.warn(`WebSocket route ${path} is outside the added prefix ${this.pathPrefix!.prefix} and can't be reached`);
+        continue;
+      }
+
+      app.ws(pattern, {
         // Compression
         compression: 1,
         // Maximum message size
@@ -589,146 +2514,338 @@ export class Qera {
         maxBackpressure: 1024 * 1024,
         // Whether to automatically close on error
         closeOnBackpressureLimit: true,
-        
+
+        upgrade: (res, req, context) => {
+          if (this.refuseRequest(req, res)) return;
+
+          // uWS invalidates req at the first await, so the handshake is read first
+          const key = req.getHeader('sec-websocket-key');
+          const protocol = req.getHeader('sec-websocket-protocol');
+          const extensions = req.getHeader('sec-websocket-extensions');
+          const params = paramNames.map((name, i): [string, string] => [name, unescape(req.getParameter(i))]);
+
+          const accept = (ctx: QeraContext) => {
+            if (res.aborted) return;
+            const data: WebSocketData = { params: ctx.params, query: ctx.query, state: { ...ctx.state }, user: ctx.user };
+            // Seen by response hooks; uWS writes the handshake response itself
+            ctx.status(101);
+            res.cork(() => res.upgrade(data, key, protocol, extensions, context));
+          };
+          this.track(res, this.handleRequest(req, res, 'get', compose(middlewares, accept), { params, secure }));
+        },
+
         open: (ws) => {
-          const url = new URL(`ws://localhost${path}`);
-          const query = Object.fromEntries(url.searchParams);
-          
-        interface WebSocketContext {
-            ws: any;
-            params: Record<string, any>;
-            query: Record<string, any>;
-            send: (message: string | ArrayBuffer | ArrayBufferView) => boolean;
-            close: (code?: number, reason?: string) => void;
-            subscribe: (topic: string) => void;
-            unsubscribe: (topic: string) => void;
-            publish: (topic: string, message: string | ArrayBuffer | ArrayBufferView) => void;
-            authenticate: (authHandler: (token: string) => Promise<boolean>) => Promise<boolean>;
-        }
-
-        const ctx: WebSocketContext = {
-            ws,
-            params: {},
-            query,
-            send: (message: string | ArrayBuffer | ArrayBufferView) => {
-                if (typeof message === 'string' || message instanceof ArrayBuffer) {
-                    return ws.send(message) !== 0;
-                } else if (ArrayBuffer.isView(message)) {
-                    return ws.send(Buffer.from(message.buffer, message.byteOffset, message.byteLength)) !== 0;
-                } else {
-                    throw new Error('Unsupported message type for ws.send');
-                }
-            },
-            close: (code?: number, reason?: string) => ws.end(code, reason),
-            subscribe: (topic: string) => ws.subscribe(topic),
-            unsubscribe: (topic: string) => ws.unsubscribe(topic),
-            publish: (topic: string, message: string | ArrayBuffer | ArrayBufferView) => {
-                if (typeof message === 'string' || message instanceof ArrayBuffer) {
-                    ws.publish(topic, message);
-                } else if (ArrayBuffer.isView(message)) {
-                    ws.publish(topic, Buffer.from(message.buffer, message.byteOffset, message.byteLength));
-                } else {
-                    throw new Error('Unsupported message type for ws.publish');
-                }
-            },
-            authenticate: async (authHandler: (token: string) => Promise<boolean>) => {
-                const token = query.token || '';
-                return await authHandler(token);
-            }
-        };
-          
-          if (handler.open) {
-            handler.open(ctx);
-          }
+          if (!handler.open) return;
+
+          const ctx = this.createWebSocketContext(ws);
+          this.runWebSocketHandler(ctx, true, () => handler.open!(ctx));
         },
         
         message: (ws, message, isBinary) => {
           if (!handler.message) return;
-          
-          const url = new URL(`ws://localhost${path}`);
-          const query = Object.fromEntries(url.searchParams);
-          
-          interface WebSocketContext {
-            ws: any;
-            params: Record<string, any>;
-            query: Record<string, any>;
-            send: (message: string | ArrayBuffer | ArrayBufferView) => boolean;
-            close: (code?: number, reason?: string) => void;
-            subscribe: (topic: string) => void;
-            unsubscribe: (topic: string) => void;
-            publish: (topic: string, message: string | ArrayBuffer | ArrayBufferView) => void;
-            authenticate: (authHandler: (token: string) => Promise<boolean>) => Promise<boolean>;
-          }
 
-          const ctx: WebSocketContext = {
-            ws,
-            params: {},
-            query,
-            send: (message: string | ArrayBuffer | ArrayBufferView): boolean => {
-              if (typeof message === 'string' || message instanceof ArrayBuffer) {
-                return ws.send(message) !== 0;
-              } else if (ArrayBuffer.isView(message)) {
-                return ws.send(Buffer.from(message.buffer, message.byteOffset, message.byteLength)) !== 0;
-              } else {
-                throw new Error('Unsupported message type for ws.send');
-              }
-            },
-            close: (code?: number, reason?: string): void => ws.end(code, reason),
-            subscribe: (topic: string) => ws.subscribe(topic),
-            unsubscribe: (topic: string): void => { ws.unsubscribe(topic); },
-            publish: (topic: string, message: string | ArrayBuffer | ArrayBufferView): void => {
-              if (typeof message === 'string' || message instanceof ArrayBuffer) {
-                ws.publish(topic, message);
-              } else if (ArrayBuffer.isView(message)) {
-                ws.publish(topic, Buffer.from(message.buffer, message.byteOffset, message.byteLength));
-              } else {
-                throw new Error('Unsupported message type for ws.publish');
-              }
-            },
-            authenticate: async (authHandler: (token: string) => Promise<boolean>): Promise<boolean> => {
-              const token = query.token || '';
-              return await authHandler(token);
-            }
-          };
-          
-          handler.message(ctx, message, isBinary);
+          const ctx = this.createWebSocketContext(ws);
+          this.runWebSocketHandler(ctx, true, () => handler.message!(ctx, message, isBinary));
         },
         
         close: (ws, code, message) => {
           if (!handler.close) return;
-          
-          const url = new URL(`ws://localhost${path}`);
-          const query = Object.fromEntries(url.searchParams);
-          
-          interface WebSocketContext {
-            ws: any;
-            params: Record<string, any>;
-            query: Record<string, any>;
-            send: (message: string | ArrayBuffer | ArrayBufferView) => boolean;
-            close: (code?: number, reason?: string) => void;
-            subscribe: (topic: string) => void;
-            unsubscribe: (topic: string) => void;
-            publish: (topic: string, message: string | ArrayBuffer | ArrayBufferView) => void;
-            authenticate: (authHandler: (token: string) => Promise<boolean>) => Promise<boolean>;
-          }
 
-          const ctx: WebSocketContext = {
+          // The socket is gone, so the context only carries data
+          const { params, query, state, user } = ws.getUserData() as WebSocketData;
+          const ctx: QeraWebSocketContext = {
             ws,
-            params: {},
+            params,
             query,
-            send: (message: string | ArrayBuffer | ArrayBufferView): boolean => false, // will fail as socket is closing
-            close: (code?: number, reason?: string): void => {},
-            subscribe: (topic: string): void => {},
-            unsubscribe: (topic: string): void => {},
-            publish: (topic: string, message: string | ArrayBuffer | ArrayBufferView): void => {},
-            authenticate: async (authHandler: (token: string) => Promise<boolean>): Promise<boolean> => false
+            state,
+            user,
+            send: () => false,
+            close: () => {},
+            subscribe: () => {},
+            unsubscribe: () => {},
+            publish: () => {},
+            authenticate: async () => false
           };
-          
-          handler.close(ctx, code, message);
+          this.runWebSocketHandler(ctx, false, () => handler.close!(ctx, code, message));
         },
       });
     }
   }
+
+  private createWebSocketContext(ws: WebSocket<any>): QeraWebSocketContext {
+    const { params, query, state, user } = ws.getUserData() as WebSocketData;
+    const toSendable = (message: string | ArrayBuffer | ArrayBufferView, action: string) => {
+      if (typeof message === 'string' || message instanceof ArrayBuffer) {
+        return message;
+      } else if (ArrayBuffer.isView(message)) {
+        return Buffer.from(message.buffer, message.byteOffset, message.byteLength);
+      }
+      throw new Error(`Unsupported message type for ws.${action}`);
+    };
+
+    return {
+      ws,
+      params,
+      query,
+      state,
+      user,
+      send: (message) => ws.send(toSendable(message, 'send')) !== 0,
+      close: (code?: number, reason?: string) => ws.end(code, reason),
+      subscribe: (topic) => { ws.subscribe(topic); },
+      unsubscribe: (topic) => { ws.unsubscribe(topic); },
+      publish: (topic, message) => { ws.publish(topic, toSendable(message, 'publish')); },
+      authenticate: async (authHandler) => {
+        const token = query.token || '';
+        return await authHandler(token);
+      }
+    };
+  }
+
+  /**
+   * Run a WebSocket handler so that an error (thrown or rejected) only
+   * affects its own connection: it's logged, reported to onWebSocketError
+   * hooks and, while the socket is open, the connection is closed with 1011.
+   */
+  private runWebSocketHandler(
+    ctx: QeraWebSocketContext,
+    closeOnError: boolean,
+    run: () => void | Promise<void>
+  ) {
+    const fail = (error: unknown) => {
+      Logger.error(`Error in WebSocket handler: ${error}`);
+      this.runHooks(this.hooks.webSocketError, ctx, error);
+
+      if (closeOnError) {
+        try {
+          ctx.ws.end(1011, 'Internal error');
+        } catch {
+          // Already closed
+        }
+      }
+    };
+
+    try {
+      const result = run();
+      if (result instanceof Promise) {
+        result.catch(fail);
+      }
+    } catch (error) {
+      fail(error);
+    }
+  }
+}
+
+const NO_PARAMS: Array<[string, string]> = [];
+
+// peekBody() for requests without a body
+const EMPTY_BODY = Buffer.alloc(0);
+
+// Any version, written out with hyphens, as ctx.paramUUID() accepts it
+const UUID = /^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$/i;
+
+// Statuses whose responses must not have a body
+const BODYLESS_STATUSES = new Set([204, 304]);
+
+// uWS closes HTTP connections that stay idle this long (HTTP_IDLE_TIMEOUT_S)
+const KEEP_ALIVE_TIMEOUT_MS = 10000;
+
+// What a WebSocket keeps from its upgrade request, as uWS user data
+interface WebSocketData {
+  params: Record<string, string>;
+  query: Record<string, string>;
+  state: Record<string, any>;
+  user?: any;
+}
+
+// Body parse failures, kept aside until the handler reads ctx.body
+const bodyErrors = new WeakMap<QeraContext, Error>();
+
+// Timers aborting ctx.signal at the request deadline, cleared once the request is done
+const deadlineTimers = new WeakMap<QeraContext, NodeJS.Timeout>();
+
+// ctx.onFinish() callbacks, run once the request is done
+const finishCallbacks = new WeakMap<QeraContext, Array<() => void | Promise<void>>>();
+
+// Default responses for errors caused by the request rather than the handler
+function clientErrorResponse(error: unknown): { status: number; body: Record<string, any> } | null {
+  if (error instanceof PayloadTooLargeError) {
+    return { status: 413, body: { error: 'Payload Too Large' } };
+  }
+  if (error instanceof BindError) {
+    return { status: 400, body: { error: error.message } };
+  }
+  if (error instanceof BodyTimeoutError) {
+    return { status: 408, body: { error: 'Request Timeout' } };
+  }
+  if (error instanceof RequestFailedError) {
+    return { status: error.statusCode, body: { error: error.message } };
+  }
+  if (error instanceof CompressionRatioError) {
+    return { status: 400, body: { error: error.message } };
+  }
+  if (error instanceof UnsupportedEncodingError) {
+    return { status: 415, body: { error: 'Unsupported Media Type' } };
+  }
+  if (error instanceof QeraValidationError) {
+    return { status: 422, body: { error: error.message, details: error.format() } };
+  }
+  return null;
+}
+
+// A pattern with parameter names erased, so /users/:id and /users/:name compare
+// equal. Constraints are kept: /items/:id{int} and /items/:id{uuid} can coexist
+function routeShape(path: string): string {
+  return path.replace(/:[^/{]+/g, ':');
+}
+
+// What makes a route pattern unusable, if anything. uWS only supports a
+// wildcard as the whole last segment
+function routePatternProblem(path: string): string | undefined {
+  if (!path.startsWith('/')) {
+    return 'the pattern must start with "/"';
+  }
+  const segments = path.split('/');
+  if (segments.slice(0, -1).some(segment => segment.includes('*')) || /.\*|\*./.test(segments[segments.length - 1])) {
+    return 'a wildcard can only be the whole last segment';
+  }
+  const names = routeParams(path).map(param => param.name);
+  const invalid = names.find(name => !/^\w+$/.test(name));
+  if (invalid !== undefined) {
+    return invalid === '' ? 'a parameter has no name' : `invalid parameter name "${invalid}": use letters, digits and underscores`;
+  }
+  const repeated = names.find((name, i) => names.indexOf(name) !== i);
+  if (repeated !== undefined) {
+    return `parameter "${repeated}" is used twice`;
+  }
+  return undefined;
+}
+
+// The caller's file and line, skipping frames inside the router itself
+function registrationSite(): string {
+  const frames = (new Error().stack || '').split('\n').slice(1);
+  const caller = frames.find(frame => !/[\\/]core[\\/](app|group)\.[jt]s/.test(frame));
+  return caller ? caller.trim().replace(/^at /, '') : 'unknown location';
+}
+
+// Map internal route keys to HTTP method names
+function routeMethodName(method: string): string {
+  if (method === 'del') return 'DELETE';
+  return method.toUpperCase();
+}
+
+// RouteDef methods and the route keys they map to
+const ROUTE_METHODS: Record<string, string> = {
+  GET: 'get',
+  POST: 'post',
+  PUT: 'put',
+  PATCH: 'patch',
+  DELETE: 'del',
+  OPTIONS: 'options',
+  HEAD: 'head',
+  ANY: 'any'
+};
+
+// A route as errors name it, e.g. "GET /users" or "GET api.example.com/users"
+function routeLabel(method: string, path: string, host?: string): string {
+  return `${routeMethodName(method)} ${host ?? ''}${path}`;
+}
+
+// How often listenAutoTLS() checks whether its certificate is due for renewal
+const AUTO_TLS_CHECK_INTERVAL_MS = 12 * 60 * 60 * 1000;
+
+// Rejected by shutdown() when requests outlived the timeout and were cut off
+export class ShutdownTimeoutError extends Error {
+  forceClosed: number;
+
+  constructor(timeout: number, forceClosed: number) {
+    super(`Shutdown timed out after ${timeout}ms: force-closed ${forceClosed} in-flight request${forceClosed === 1 ? '' : 's'}`);
+    this.forceClosed = forceClosed;
+    this.name = 'ShutdownTimeoutError';
+  }
+}
+
+// The reason ctx.signal aborts with when the request's time budget runs out
+export class DeadlineExceededError extends Error {
+  constructor() {
+    super('Request deadline exceeded');
+    this.name = 'DeadlineExceededError';
+  }
+}
+
+// Thrown outside production when a response crosses maxResponseHeaders or
+// maxResponseHeaderSize
+export class ResponseHeaderLimitError extends Error {
+  constructor(message: string) {
+    super(message);
+    this.name = 'ResponseHeaderLimitError';
+  }
+}
+
+// Thrown by build() with everything wrong with the route setup
+export class RouteConfigError extends Error {
+  problems: string[];
+
+  constructor(problems: string[]) {
+    super(`Invalid route configuration:\n${problems.map(problem => `  ${problem}`).join('\n')}`);
+    this.problems = problems;
+    this.name = 'RouteConfigError';
+  }
+}
+
+// Callback names JSONP will echo back: identifiers, optionally dotted (e.g. "app.cb")
+const JSONP_CALLBACK = /^[A-Za-z_$][\w$]*(?:\.[A-Za-z_$][\w$]*)*$/;
+
+// Metric names must be HTTP tokens to be valid in Server-Timing
+const HTTP_TOKEN = /^[!#$%&'*+\-.^_`|~0-9A-Za-z]+$/;
+
+// One Server-Timing entry: name;dur=<ms>;desc="<text>"
+function serverTimingEntry(metric: string, duration?: number, description?: string): string {
+  let entry = metric;
+  if (duration !== undefined && Number.isFinite(duration)) {
+    // Milliseconds, at most microsecond precision to keep the header short
+    entry += `;dur=${Math.round(duration * 1000) / 1000}`;
+  }
+  if (description) {
+    entry += `;desc="${description.replace(/[\\"]/g, '\\$&')}"`;
+  }
+  return entry;
+}
+
+// A percent-decoded path segment. "+" stays a plus sign, as it only means a
+// space in query strings; malformed escapes leave the segment as sent
+function unescapePathSegment(segment: string): string {
+  if (!segment.includes('%')) return segment;
+  try {
+    return decodeURIComponent(segment);
+  } catch {
+    return segment;
+  }
+}
+
+// Directives must be tokens, optionally with a token or quoted value, e.g.
+// "max-age=60" or 'no-cache="Set-Cookie"'
+const CACHE_DIRECTIVE = /^[!#$%&'*+\-.^_`|~0-9A-Za-z]+(?:=(?:[!#$%&'*+\-.^_`|~0-9A-Za-z]+|"[^"\\\r\n]*"))?$/;
+
+function cacheControlValue(directives: string[]): string {
+  const trimmed = directives.map(directive => directive.trim());
+  if (trimmed.length === 0) {
+    throw new TypeError('cacheControl() needs at least one directive');
+  }
+  for (const directive of trimmed) {
+    if (!CACHE_DIRECTIVE.test(directive)) {
+      throw new TypeError(`Invalid Cache-Control directive: ${JSON.stringify(directive)}`);
+    }
+  }
+  return trimmed.join(', ');
+}
+
+// max-age in whole seconds, from seconds or a duration string such as "1h"
+function maxAgeSeconds(maxAge: number | string): number {
+  const seconds = typeof maxAge === 'string' ? parseDuration(maxAge) / 1000 : maxAge;
+  if (!Number.isFinite(seconds) || seconds < 0) {
+    throw new TypeError(`Invalid max-age: ${JSON.stringify(maxAge)}`);
+  }
+  return Math.floor(seconds);
 }
 
 // Factory function
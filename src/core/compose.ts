@@ -0,0 +1,89 @@
+import { Middleware, QeraContext, RouteHandler } from '../types';
+
+/**
+ * Run one middleware with next, which is also ctx.next() while it runs, so
+ * middleware can use either. ctx.next() goes back to the outer middleware's
+ * next once this one returns.
+ */
+export async function runMiddleware(middleware: Middleware, ctx: QeraContext, next: () => Promise<void>): Promise<void> {
+  const outer = ctx.next;
+  ctx.next = next;
+  try {
+    await middleware(ctx, next);
+  } finally {
+    ctx.next = outer;
+  }
+}
+
+const nothingNext = () => Promise.resolve();
+
+// Run the handler at the end of a chain; ctx.next() has nothing left to run there
+export async function runHandler(handler: RouteHandler, ctx: QeraContext): Promise<void> {
+  const outer = ctx.next;
+  ctx.next = nothingNext;
+  try {
+    await handler(ctx);
+  } finally {
+    ctx.next = outer;
+  }
+}
+
+/**
+ * Wrap a handler with middleware, producing a single handler that runs the
+ * middleware in order and the handler last. A middleware that doesn't call
+ * next() or calls ctx.abort() ends the chain.
+ */
+export function compose(middlewares: Middleware[], handler: RouteHandler): RouteHandler {
+  if (middlewares.length === 0) {
+    return handler;
+  }
+
+  return async (ctx: QeraContext) => {
+    let index = 0;
+
+    const next = async (): Promise<void> => {
+      // Contexts made by hand, e.g. in unit tests, may lack isAborted
+      if (ctx.isAborted?.()) return;
+      const middleware = middlewares[index++];
+
+      if (middleware) {
+        await runMiddleware(middleware, ctx, next);
+      } else {
+        await runHandler(handler, ctx);
+      }
+    };
+
+    await next();
+  };
+}
+
+// A reusable middleware stack that can be used wherever a middleware can
+export interface MiddlewareChain extends Middleware {
+  readonly middlewares: readonly Middleware[];
+  // A new chain with more middleware at the end; this one is left as is
+  append(...middlewares: Middleware[]): MiddlewareChain;
+  // Route handler running the chain and then handler
+  handle(handler: RouteHandler): RouteHandler;
+}
+
+/**
+ * Combine middleware into one, e.g. an "authenticated" stack applied to
+ * several groups or routes. Running the chain is the same as registering its
+ * middleware one after another; a middleware that doesn't call next() stops
+ * the rest of the chain and everything after it.
+ *
+ * The handler builder is called handle() rather than then() so a chain isn't
+ * mistaken for a promise when it's returned from an async function.
+ */
+export function chain(...middlewares: Middleware[]): MiddlewareChain {
+  const stack = [...middlewares];
+
+  const run = ((ctx: QeraContext, next: () => Promise<void>) =>
+    compose(stack, () => next())(ctx)) as MiddlewareChain;
+
+  return Object.assign(run, {
+    middlewares: stack,
+    append: (...more: Middleware[]) => chain(...stack, ...more),
+    handle: (handler: RouteHandler) => compose([...stack], handler)
+  });
+}
@@ -0,0 +1,129 @@
+import { Middleware, RouteHandler, RouteOptions } from '../types';
+import { compose } from './compose';
+
+// The parts of Qera a group registers into
+export interface GroupHost {
+  get(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  post(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  put(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  patch(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  delete(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  options(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  head(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  any(path: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  mount(prefix: string, handler: RouteHandler, options?: RouteOptions): unknown;
+  notFound(handler: RouteHandler, prefix?: string): unknown;
+}
+
+export function joinPaths(prefix: string, path: string): string {
+  const joined = `${prefix.replace(/\/+$/, '')}/${path.replace(/^\/+/, '')}`;
+  return joined.length > 1 ? joined.replace(/\/+$/, '') : joined;
+}
+
+/**
+ * Routes sharing a path prefix and middleware. Group middleware runs after
+ * the app-level middleware and only for routes registered on the group (or
+ * its subgroups).
+ */
+export class RouterGroup {
+  readonly prefix: string;
+  private host: GroupHost;
+  private middlewares: Middleware[];
+  private parent?: RouterGroup;
+  private subgroups: RouterGroup[] = [];
+  // Middleware added to this group that no route has picked up yet
+  private unused: Middleware[];
+
+  constructor(host: GroupHost, prefix: string, middlewares: Middleware[] = []) {
+    this.host = host;
+    this.prefix = joinPaths('/', prefix);
+    this.middlewares = [...middlewares];
+    this.unused = [...middlewares];
+  }
+
+  use(middleware: Middleware): this {
+    this.middlewares.push(middleware);
+    this.unused.push(middleware);
+    return this;
+  }
+
+  // Nested group inheriting this group's prefix and middleware
+  group(prefix: string, ...middlewares: Middleware[]): RouterGroup {
+    const child = new RouterGroup(this.host, joinPaths(this.prefix, prefix), [...this.middlewares, ...middlewares]);
+    // Only the middleware added here belongs to the child; the rest is reported on this group
+    child.unused = [...middlewares];
+    child.parent = this;
+    this.subgroups.push(child);
+    return child;
+  }
+
+  /**
+   * Groups, this one and its subgroups, holding middleware that no route
+   * runs: the group has no routes, or the middleware was added after all of
+   * them. Qera.build() reports these.
+   */
+  danglingMiddleware(): Array<{ prefix: string; middlewares: Middleware[] }> {
+    const own = this.unused.length > 0 ? [{ prefix: this.prefix, middlewares: [...this.unused] }] : [];
+    return [...own, ...this.subgroups.flatMap(group => group.danglingMiddleware())];
+  }
+
+  get(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.get(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  post(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.post(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  put(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.put(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  patch(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.patch(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  delete(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.delete(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  options(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.options(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  head(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.head(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  any(path: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.any(joinPaths(this.prefix, path), this.wrap(handler), options);
+    return this;
+  }
+
+  // Everything under prefix (relative to the group) that no route matches
+  mount(prefix: string, handler: RouteHandler, options?: RouteOptions): this {
+    this.host.mount(joinPaths(this.prefix, prefix), this.wrap(handler), options);
+    return this;
+  }
+
+  // Handle 404s for paths under this group's prefix
+  notFound(handler: RouteHandler): this {
+    this.host.notFound(this.wrap(handler), this.prefix);
+    return this;
+  }
+
+  private wrap(handler: RouteHandler): RouteHandler {
+    for (let group: RouterGroup | undefined = this; group; group = group.parent) {
+      group.unused = group.unused.filter(middleware => !this.middlewares.includes(middleware));
+    }
+    // Snapshot so middleware added later only affects routes added later
+    return compose([...this.middlewares], handler);
+  }
+}
@@ -0,0 +1,254 @@
+import { EventEmitter } from 'events';
+import { IncomingMessage, OutgoingHttpHeaders, ServerResponse } from 'http';
+import { Socket } from 'net';
+import { Middleware, QeraContext, RouteHandler } from '../types';
+
+// A Node http request listener, as used by plain http.createServer handlers
+export type NodeHandler = (req: IncomingMessage, res: ServerResponse) => unknown;
+
+// Connect/Express-style middleware; next(error) reports a failure
+export type NodeMiddleware = (req: IncomingMessage, res: ServerResponse, next: (error?: unknown) => void) => unknown;
+
+// An IncomingMessage carrying the request the context describes, body included
+function createNodeRequest(ctx: QeraContext): IncomingMessage {
+  const socket = new Socket();
+  Object.defineProperty(socket, 'remoteAddress', { value: ctx.ip });
+  Object.defineProperty(socket, 'encrypted', { value: ctx.secure() || undefined });
+
+  const req = new IncomingMessage(socket);
+  req.method = ctx.method;
+  req.url = ctx.url();
+  req.httpVersion = '1.1';
+  req.httpVersionMajor = 1;
+  req.httpVersionMinor = 1;
+  req.headers = { ...ctx.headers };
+  req.rawHeaders = Object.entries(ctx.headers).flat();
+
+  const rawBody = ctx.peekBody();
+  if (rawBody.length > 0) {
+    req.push(rawBody);
+  }
+  req.push(null);
+  return req;
+}
+
+/**
+ * The parts of ServerResponse that handlers use, writing through the
+ * context. Status and headers are buffered until the first write, like Node.
+ * A response ended without earlier writes is sent in one piece; otherwise it
+ * is streamed with ctx.write(), and write() returns false until each chunk
+ * is accepted so pipe() sees backpressure.
+ */
+class NodeResponse extends EventEmitter {
+  statusCode = 200;
+  statusMessage = '';
+  headersSent = false;
+  writableEnded = false;
+  writableFinished = false;
+  readonly req: IncomingMessage;
+
+  private ctx: QeraContext;
+  private headers = new Map<string, { name: string; value: number | string | string[] }>();
+  private pending: Promise<void> = Promise.resolve();
+  private queued = 0;
+  private failed = false;
+
+  constructor(ctx: QeraContext, req: IncomingMessage) {
+    super();
+    this.ctx = ctx;
+    this.req = req;
+    this.statusCode = ctx.statusCode;
+  }
+
+  setHeader(name: string, value: number | string | readonly string[]): this {
+    this.headers.set(name.toLowerCase(), { name, value: Array.isArray(value) ? [...value] : value as number | string });
+    return this;
+  }
+
+  getHeader(name: string) {
+    return this.headers.get(name.toLowerCase())?.value;
+  }
+
+  getHeaders(): OutgoingHttpHeaders {
+    const headers: OutgoingHttpHeaders = {};
+    for (const [key, { value }] of this.headers) {
+      headers[key] = value;
+    }
+    return headers;
+  }
+
+  getHeaderNames(): string[] {
+    return [...this.headers.keys()];
+  }
+
+  hasHeader(name: string): boolean {
+    return this.headers.has(name.toLowerCase());
+  }
+
+  removeHeader(name: string): void {
+    this.headers.delete(name.toLowerCase());
+  }
+
+  writeHead(statusCode: number, message?: string | OutgoingHttpHeaders, headers?: OutgoingHttpHeaders): this {
+    this.statusCode = statusCode;
+    if (typeof message === 'string') {
+      this.statusMessage = message;
+    } else {
+      headers = message;
+    }
+    for (const [name, value] of Object.entries(headers || {})) {
+      if (value !== undefined) {
+        this.setHeader(name, value);
+      }
+    }
+    return this;
+  }
+
+  write(chunk: string | Uint8Array, encoding?: BufferEncoding | ((error?: Error | null) => void), callback?: (error?: Error | null) => void): boolean {
+    if (typeof encoding === 'function') {
+      callback = encoding;
+      encoding = undefined;
+    }
+    this.flushHead();
+    const data = typeof chunk === 'string' ? Buffer.from(chunk, encoding) : Buffer.from(chunk);
+
+    this.queued++;
+    this.enqueue(async () => {
+      await this.ctx.write(data);
+      callback?.();
+      if (--this.queued === 0) {
+        this.emit('drain');
+      }
+    });
+    return false;
+  }
+
+  end(chunk?: string | Uint8Array | (() => void), encoding?: BufferEncoding | (() => void), callback?: () => void): this {
+    if (typeof chunk === 'function') {
+      callback = chunk;
+      chunk = undefined;
+    } else if (typeof encoding === 'function') {
+      callback = encoding;
+      encoding = undefined;
+    }
+    if (this.writableEnded) {
+      return this;
+    }
+    this.writableEnded = true;
+
+    const data = chunk === undefined ? undefined : typeof chunk === 'string' ? Buffer.from(chunk, encoding) : Buffer.from(chunk);
+    const streamed = this.headersSent;
+    this.flushHead();
+
+    this.enqueue(async () => {
+      if (streamed) {
+        this.ctx.end(data);
+      } else {
+        this.ctx.send(data ?? '');
+      }
+      this.writableFinished = true;
+      callback?.();
+      this.emit('finish');
+      this.emit('close');
+    });
+    return this;
+  }
+
+  // Copy status and headers to the context before the first body bytes
+  flushHead() {
+    if (this.headersSent) return;
+    this.headersSent = true;
+
+    if (this.statusCode !== this.ctx.statusCode) {
+      this.ctx.status(this.statusCode);
+    }
+    for (const { name, value } of this.headers.values()) {
+      if (Array.isArray(value)) {
+        this.ctx.deleteHeader(name);
+        value.forEach(item => this.ctx.appendHeader(name, item));
+      } else {
+        this.ctx.setHeaders({ [name]: String(value) });
+      }
+    }
+  }
+
+  // Writes run in order; a failed write (the client left) fails the response
+  private enqueue(step: () => Promise<void>) {
+    this.pending = this.pending
+      .then(() => (this.failed ? undefined : step()))
+      .catch(error => {
+        this.failed = true;
+        this.emit('error', error);
+      });
+  }
+}
+
+// Run a Node handler against the context. Settles once the response is
+// finished, or when the handler calls next() if onNext is given
+function runNodeHandler(ctx: QeraContext, handler: NodeMiddleware, onNext?: () => void): Promise<void> {
+  return new Promise<void>((resolve, reject) => {
+    const req = createNodeRequest(ctx);
+    const res = new NodeResponse(ctx, req);
+
+    res.once('finish', () => resolve());
+    res.on('error', reject);
+    ctx.signal.addEventListener('abort', () => {
+      // The signal also aborts at the requestTimeout deadline, while the
+      // client is still waiting for the answer
+      if (!ctx.clientDisconnected()) return;
+      req.emit('aborted');
+      res.emit('close');
+    }, { once: true });
+
+    const next = (error?: unknown) => {
+      if (error) {
+        reject(error);
+      } else if (onNext) {
+        // Headers set before next() belong to the response the chain sends
+        res.flushHead();
+        onNext();
+        resolve();
+      }
+    };
+
+    try {
+      Promise.resolve(handler(req, res as unknown as ServerResponse, next)).catch(reject);
+    } catch (error) {
+      reject(error);
+    }
+  });
+}
+
+/**
+ * Use a Node http handler, (req, res) => void, as a route handler. It gets
+ * an IncomingMessage with the method, URL, headers and body of the request
+ * and a response object with the usual ServerResponse methods (setHeader,
+ * writeHead, write, end, and it can be a pipe() target), written through the
+ * context.
+ *
+ * Adapting allocates a request stream, a socket object and a response per
+ * request, so keep it for code that can't be ported. The body has already
+ * been read (and bodyLimit applied) by Qera and is replayed to the handler.
+ * Responses written with write() are streamed with chunked encoding.
+ */
+export function fromNodeHandler(handler: NodeHandler): RouteHandler {
+  return (ctx) => runNodeHandler(ctx, handler);
+}
+
+/**
+ * Use Connect/Express-style middleware, (req, res, next) => void, as Qera
+ * middleware: next() continues the Qera chain with any status and headers
+ * the middleware set, next(error) throws error into the chain, and ending
+ * the response stops it.
+ */
+export function fromNodeMiddleware(middleware: NodeMiddleware): Middleware {
+  return async (ctx, next) => {
+    let continued = false;
+    await runNodeHandler(ctx, middleware, () => {
+      continued = true;
+    });
+    if (continued) {
+      await next();
+    }
+  };
+}
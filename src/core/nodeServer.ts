@@ -0,0 +1,259 @@
+import { IncomingMessage, ServerResponse } from 'http';
+import { HttpRequest, HttpResponse, TemplatedApp } from 'uWebSockets.js';
+import { Logger } from '../utils/logger';
+
+type UwsHandler = (res: HttpResponse, req: HttpRequest) => void;
+
+interface NodeRoute {
+  method: string;
+  pattern: string;
+  segments: string[];
+  handler: UwsHandler;
+}
+
+// uWS tries static segments before parameters before wildcards, segment by segment
+function segmentRank(segment: string | undefined): number {
+  if (segment === undefined) return -1;
+  if (segment === '*') return 0;
+  return segment.startsWith(':') ? 1 : 2;
+}
+
+function compareRoutes(a: NodeRoute, b: NodeRoute): number {
+  for (let i = 0; i < Math.max(a.segments.length, b.segments.length); i++) {
+    const diff = segmentRank(b.segments[i]) - segmentRank(a.segments[i]);
+    if (diff !== 0) return diff;
+  }
+  // Method routes before any() routes of the same shape, then registration order
+  return Number(a.method === 'any') - Number(b.method === 'any');
+}
+
+// Positional params when the route matches the path, null otherwise
+function matchSegments(segments: string[], path: string[]): string[] | null {
+  const params: string[] = [];
+  for (let i = 0; i < segments.length; i++) {
+    const segment = segments[i];
+    if (i >= path.length) return null;
+    if (segment === '*') return params;
+    if (segment.startsWith(':')) {
+      params.push(path[i]);
+    } else if (segment !== path[i]) {
+      return null;
+    }
+  }
+  return segments.length === path.length ? params : null;
+}
+
+// Request data a route needs, copied off the original request
+export interface RouteRequest {
+  method: string; // lower case, as uWS reports it
+  url: string;
+  query: string;
+  headers: Record<string, string>; // lower-case names
+}
+
+// The uWS HttpRequest surface Qera reads, backed by copied request data
+export function createRequest(request: RouteRequest, params: string[]): HttpRequest & { yielded: boolean } {
+  const { method, url, query, headers } = request;
+  const uwsRequest = {
+    yielded: false,
+    getMethod: () => method,
+    getCaseSensitiveMethod: () => method.toUpperCase(),
+    getUrl: () => url,
+    getQuery: (key?: string) => (key === undefined ? query : new URLSearchParams(query).get(key) ?? undefined),
+    getHeader: (key: string) => headers[key.toLowerCase()] || '',
+    getParameter: (index: number) => params[index],
+    forEach: (callback: (key: string, value: string) => void) => {
+      for (const [key, value] of Object.entries(headers)) {
+        callback(key, value);
+      }
+    },
+    setYield: (yielded: boolean) => {
+      uwsRequest.yielded = yielded;
+      return uwsRequest;
+    }
+  };
+  return uwsRequest as unknown as HttpRequest & { yielded: boolean };
+}
+
+// The uWS HttpResponse surface Qera writes to, backed by a Node response
+function createResponse(req: IncomingMessage, res: ServerResponse): HttpResponse {
+  let status = 200;
+  let statusMessage: string | undefined;
+  let headers: Array<[string, string]> = [];
+  let ended = false;
+  let written = 0;
+
+  const sendHead = () => {
+    if (res.headersSent) return;
+    const grouped = new Map<string, string[]>();
+    for (const [key, value] of headers) {
+      const values = grouped.get(key.toLowerCase()) || [];
+      values.push(value);
+      grouped.set(key.toLowerCase(), values);
+    }
+    for (const [key, values] of grouped) {
+      res.setHeader(key, values.length === 1 ? values[0] : values);
+    }
+    res.writeHead(status, statusMessage);
+    headers = [];
+  };
+
+  const toBuffer = (chunk: any) =>
+    typeof chunk === 'string' ? Buffer.from(chunk) : Buffer.from(chunk instanceof ArrayBuffer ? new Uint8Array(chunk) : chunk);
+
+  const response: any = {
+    writeStatus(line: string) {
+      const [code, ...message] = String(line).split(' ');
+      status = parseInt(code, 10);
+      statusMessage = message.length > 0 ? message.join(' ') : undefined;
+      return response;
+    },
+    writeHeader(key: string, value: string) {
+      headers.push([String(key), String(value)]);
+      return response;
+    },
+    write(chunk: any) {
+      sendHead();
+      const data = toBuffer(chunk);
+      written += data.length;
+      return res.write(data);
+    },
+    end(chunk?: any, closeConnection?: boolean) {
+      ended = true;
+      if (closeConnection) {
+        headers.push(['Connection', 'close']);
+      }
+      sendHead();
+      if (chunk !== undefined && chunk !== null) {
+        written += toBuffer(chunk).length;
+        res.end(toBuffer(chunk));
+      } else {
+        res.end();
+      }
+      return response;
+    },
+    endWithoutBody(reportedContentLength?: number, closeConnection?: boolean) {
+      return response.end(undefined, closeConnection);
+    },
+    tryEnd(chunk: any) {
+      response.end(chunk);
+      return [true, true];
+    },
+    close() {
+      ended = true;
+      res.destroy();
+      return response;
+    },
+    cork(callback: () => void) {
+      callback();
+      return response;
+    },
+    onAborted(handler: () => void) {
+      res.on('close', () => {
+        if (!ended && !res.writableFinished) handler();
+      });
+      return response;
+    },
+    onData(handler: (chunk: ArrayBuffer, isLast: boolean) => void) {
+      const deliver = (data: Buffer, isLast: boolean) =>
+        handler(data.buffer.slice(data.byteOffset, data.byteOffset + data.length) as ArrayBuffer, isLast);
+      req.on('data', (chunk: Buffer) => deliver(chunk, false));
+      req.on('end', () => {
+        // Node has the trailers once the body has ended; ctx.trailer() reads them
+        response.trailers = req.trailers;
+        deliver(Buffer.alloc(0), true);
+      });
+      return response;
+    },
+    onWritable(handler: (offset: number) => boolean) {
+      res.once('drain', () => handler(written));
+      return response;
+    },
+    getWriteOffset: () => written,
+    getRemoteAddressAsText: () => Buffer.from(req.socket.remoteAddress || ''),
+    getRemotePort: () => req.socket.remotePort || 0,
+    getProxiedRemoteAddressAsText: () => Buffer.from('')
+  };
+  return response as HttpResponse;
+}
+
+/**
+ * Routes registered the way they would be on a uWS app, served to Node
+ * http requests instead. Matching follows uWS: static segments beat
+ * parameters beat wildcards, and a handler that sets yield passes the
+ * request on to the next matching route. Given a uWS app, registrations are
+ * forwarded to it too, so the router can replay a request uWS has routed.
+ */
+export class NodeRouter {
+  private routes: NodeRoute[] = [];
+  private sorted = true;
+
+  // Stands in for the uWS app Qera registers its routes on
+  readonly app: TemplatedApp;
+
+  constructor(forward?: TemplatedApp) {
+    const register = (method: string) => (pattern: string, handler: UwsHandler) => {
+      this.routes.push({ method, pattern, segments: pattern.split('/'), handler });
+      this.sorted = false;
+      (forward as any)?.[method === 'delete' ? 'del' : method](pattern, handler);
+      return this.app;
+    };
+
+    this.app = {
+      get: register('get'),
+      post: register('post'),
+      put: register('put'),
+      patch: register('patch'),
+      del: register('delete'),
+      options: register('options'),
+      head: register('head'),
+      any: register('any'),
+      ws: (pattern: string, behavior: unknown) => {
+        if (forward) {
+          forward.ws(pattern, behavior as any);
+        } else {
+          Logger.warn(`WebSocket route ${pattern} is not served through app.handler(); use listen() for WebSockets`);
+        }
+        return this.app;
+      },
+      close: () => this.app
+    } as unknown as TemplatedApp;
+  }
+
+  dispatch(req: IncomingMessage, res: ServerResponse): void {
+    const [url, query = ''] = (req.url || '/').split(/\?(.*)/s);
+    const headers: Record<string, string> = {};
+    for (const [key, value] of Object.entries(req.headers)) {
+      if (value !== undefined) {
+        headers[key] = Array.isArray(value) ? value.join(', ') : value;
+      }
+    }
+
+    const request = { method: (req.method || 'GET').toLowerCase(), url, query, headers };
+    if (!this.route(request, createResponse(req, res))) {
+      res.writeHead(404).end();
+    }
+  }
+
+  // Offer a request to the matching routes in order until one keeps it;
+  // false when there was none or they all yielded
+  route(request: RouteRequest, res: HttpResponse): boolean {
+    if (!this.sorted) {
+      // Array.prototype.sort is stable, so equal routes keep registration order
+      this.routes.sort(compareRoutes);
+      this.sorted = true;
+    }
+
+    const path = request.url.split('/');
+    for (const route of this.routes) {
+      if (route.method !== request.method && route.method !== 'any') continue;
+      const params = matchSegments(route.segments, path);
+      if (!params) continue;
+
+      const uwsRequest = createRequest(request, params);
+      route.handler(res, uwsRequest);
+      if (!uwsRequest.yielded) return true;
+    }
+    return false;
+  }
+}
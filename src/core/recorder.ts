@@ -0,0 +1,259 @@
+import { EventEmitter } from 'events';
+import { promises as fs } from 'fs';
+import { IncomingMessage, ServerResponse } from 'http';
+import { Socket } from 'net';
+import { join } from 'path';
+import { HttpRequest, HttpResponse } from 'uWebSockets.js';
+import { RecordOptions } from '../types';
+import { DEFAULT_REDACTED_HEADERS } from '../utils/dump';
+import { Logger } from '../utils/logger';
+
+// One request and its response as written to disk by record mode
+export interface RecordedExchange {
+  recordedAt: string;
+  request: {
+    method: string;
+    url: string;
+    query: string;
+    headers: Record<string, string>;
+    body: string;
+    bodyEncoding?: 'base64';
+  };
+  response: {
+    status: number;
+    headers: Array<[string, string]>;
+    body: string;
+    bodyEncoding?: 'base64';
+    // Set when the body was cut at maxBodySize
+    truncated?: boolean;
+  };
+}
+
+export interface ReplayResult {
+  status: number;
+  headers: Array<[string, string]>;
+  body: Buffer;
+  // The response as it was recorded, to compare against
+  recorded: RecordedExchange['response'];
+}
+
+export const REDACTED = '[redacted]';
+
+// Text bodies are stored as-is so fixtures stay readable and editable
+function encodeBody(body: Buffer): { body: string; bodyEncoding?: 'base64' } {
+  const text = body.toString('utf8');
+  return Buffer.from(text).equals(body)
+    ? { body: text }
+    : { body: body.toString('base64'), bodyEncoding: 'base64' };
+}
+
+function decodeBody(body: string, encoding?: 'base64'): Buffer {
+  return Buffer.from(body, encoding === 'base64' ? 'base64' : 'utf8');
+}
+
+const toBuffer = (chunk: any): Buffer =>
+  typeof chunk === 'string' ? Buffer.from(chunk) : Buffer.from(chunk instanceof ArrayBuffer ? new Uint8Array(chunk) : chunk);
+
+/**
+ * Writes routed requests and their responses to options.dir as JSON
+ * fixtures that app.replay() can feed back through the app. Headers named
+ * in options.redact are replaced before anything reaches the disk. At most
+ * maxRequests fixtures are written per process, and requests with bodies
+ * over maxBodySize are skipped since they couldn't be replayed faithfully;
+ * larger response bodies are truncated.
+ */
+export class Recorder {
+  private readonly redact: Set<string>;
+  private readonly maxRequests: number;
+  private readonly maxBodySize: number;
+  private recorded = 0;
+  private ready?: Promise<unknown>;
+
+  constructor(private readonly options: RecordOptions) {
+    this.redact = new Set((options.redact || [...DEFAULT_REDACTED_HEADERS, 'set-cookie']).map(name => name.toLowerCase()));
+    this.maxRequests = options.maxRequests ?? 1000;
+    this.maxBodySize = options.maxBodySize ?? 64 * 1024;
+  }
+
+  /**
+   * Start recording an exchange: the request is copied now, while req is
+   * valid, and res is wrapped so that everything written to it is captured.
+   * The fixture is written once the response ends.
+   */
+  capture(req: HttpRequest, res: HttpResponse): void {
+    if (this.recorded >= this.maxRequests) {
+      return;
+    }
+    const sequence = ++this.recorded;
+
+    const headers: Record<string, string> = {};
+    req.forEach((key, value) => {
+      headers[key] = this.redact.has(key) ? REDACTED : value;
+    });
+    const request = { method: req.getMethod().toUpperCase(), url: req.getUrl(), query: req.getQuery() || '', headers };
+
+    let status = 200;
+    const responseHeaders: Array<[string, string]> = [];
+    const chunks: Buffer[] = [];
+    let size = 0;
+    const collect = (chunk: any) => {
+      if (chunk === undefined || chunk === null || size > this.maxBodySize) return;
+      const data = toBuffer(chunk);
+      chunks.push(data);
+      size += data.length;
+    };
+
+    const finish = () => {
+      const requestBody: Buffer = res.rawBody || Buffer.alloc(0);
+      if (requestBody.length > this.maxBodySize) {
+        Logger.debug(`Not recording ${request.method} ${request.url}: the body is over ${this.maxBodySize} bytes`);
+        return;
+      }
+      const body = Buffer.concat(chunks);
+      const exchange: RecordedExchange = {
+        recordedAt: new Date().toISOString(),
+        request: { ...request, ...encodeBody(requestBody) },
+        response: {
+          status,
+          headers: responseHeaders,
+          ...encodeBody(body.subarray(0, this.maxBodySize)),
+          ...(body.length > this.maxBodySize ? { truncated: true } : {})
+        }
+      };
+      this.write(exchange, sequence);
+    };
+
+    const { writeStatus, writeHeader, write, end, endWithoutBody, tryEnd } = res;
+    res.writeStatus = (line: string) => {
+      status = parseInt(String(line), 10);
+      return writeStatus.call(res, line);
+    };
+    res.writeHeader = (key: string, value: string) => {
+      const name = String(key).toLowerCase();
+      responseHeaders.push([name, this.redact.has(name) ? REDACTED : String(value)]);
+      return writeHeader.call(res, key, value);
+    };
+    res.write = (chunk: any) => {
+      collect(chunk);
+      return write.call(res, chunk);
+    };
+    res.end = (chunk?: any, closeConnection?: boolean) => {
+      collect(chunk);
+      finish();
+      return end.call(res, chunk, closeConnection);
+    };
+    res.endWithoutBody = (reportedContentLength?: number, closeConnection?: boolean) => {
+      finish();
+      return endWithoutBody.call(res, reportedContentLength, closeConnection);
+    };
+    res.tryEnd = (chunk: any, totalSize: number) => {
+      const result = tryEnd.call(res, chunk, totalSize);
+      collect(chunk);
+      if (result[1]) finish();
+      return result;
+    };
+  }
+
+  private write(exchange: RecordedExchange, sequence: number) {
+    const { method, url } = exchange.request;
+    const slug = url.replace(/[^A-Za-z0-9]+/g, '-').replace(/^-|-$/g, '').slice(0, 60) || 'root';
+    const file = join(this.options.dir, `${Date.now()}-${sequence}-${method.toLowerCase()}-${slug}.json`);
+
+    this.ready ??= fs.mkdir(this.options.dir, { recursive: true });
+    this.ready
+      // Renamed into place so nothing ever sees a half-written fixture
+      .then(() => fs.writeFile(`${file}.tmp`, JSON.stringify(exchange, null, 2)))
+      .then(() => fs.rename(`${file}.tmp`, file))
+      .catch(error => Logger.warn(`Could not record ${method} ${url} to ${file}: ${error}`));
+  }
+}
+
+// Collects what the app writes for a replayed request
+class ReplayResponse extends EventEmitter {
+  statusCode = 200;
+  headersSent = false;
+  writableFinished = false;
+  readonly headers: Array<[string, string]> = [];
+  private chunks: Buffer[] = [];
+
+  constructor(private readonly done: (response: ReplayResponse, body: Buffer) => void) {
+    super();
+  }
+
+  setHeader(name: string, value: string | string[]) {
+    for (const item of Array.isArray(value) ? value : [value]) {
+      this.headers.push([name.toLowerCase(), String(item)]);
+    }
+    return this;
+  }
+
+  writeHead(status: number) {
+    this.statusCode = status;
+    this.headersSent = true;
+    return this;
+  }
+
+  write(chunk: any) {
+    this.headersSent = true;
+    this.chunks.push(toBuffer(chunk));
+    return true;
+  }
+
+  end(chunk?: any) {
+    if (chunk !== undefined && chunk !== null) {
+      this.write(chunk);
+    }
+    this.headersSent = true;
+    this.writableFinished = true;
+    this.done(this, Buffer.concat(this.chunks));
+    this.emit('close');
+    return this;
+  }
+
+  destroy() {
+    this.end();
+    return this;
+  }
+}
+
+/**
+ * Send a recorded request to handler, a Node request listener such as
+ * app.handler(), and collect the response. headers are added to the
+ * recorded ones, e.g. to fill in redacted credentials.
+ */
+export function replayExchange(
+  exchange: RecordedExchange,
+  handler: (req: IncomingMessage, res: ServerResponse) => void,
+  headers: Record<string, string> = {}
+): Promise<ReplayResult> {
+  const { request } = exchange;
+  const socket = new Socket();
+  Object.defineProperty(socket, 'remoteAddress', { value: '127.0.0.1' });
+
+  const req = new IncomingMessage(socket);
+  req.method = request.method;
+  req.url = request.query ? `${request.url}?${request.query}` : request.url;
+  req.headers = { ...request.headers };
+  for (const [key, value] of Object.entries(headers)) {
+    req.headers[key.toLowerCase()] = value;
+  }
+  const body = decodeBody(request.body, request.bodyEncoding);
+  if (body.length > 0) {
+    req.push(body);
+  }
+  req.push(null);
+
+  return new Promise(resolve => {
+    const res = new ReplayResponse((response, responseBody) => resolve({
+      status: response.statusCode,
+      headers: response.headers,
+      body: responseBody,
+      recorded: exchange.response
+    }));
+    handler(req, res as unknown as ServerResponse);
+  });
+}
+
+export async function readExchange(file: string): Promise<RecordedExchange> {
+  return JSON.parse(await fs.readFile(file, 'utf8'));
+}
@@ -1,10 +1,53 @@
-import createApp, { Qera } from './core/app';
+import createApp, { Qera, RouteConfigError, ShutdownTimeoutError, DeadlineExceededError, ResponseHeaderLimitError } from './core/app';
 import * as middlewares from './middlewares';
 import { Logger } from './utils/logger';
 import v, { QeraSchema, QeraValidationError, infer as InferType } from './utils/validator';
 
 // Export types
 export * from './types';
+export type { JSONArraySource, BodySource, PipeOptions } from './utils/stream';
+export { ConnectionClosedError } from './utils/stream';
+export { contentDisposition, formatCSVRow } from './utils/attachment';
+export type { ErrorContentType } from './utils/errorResponse';
+export { RequestFailedError } from './utils/errorResponse';
+export type { ErrorTarget, MappedError } from './utils/errorMap';
+export { parseXML, toXML, XMLSyntaxError } from './utils/xml';
+export { zipChunks, crc32, ZipTooLargeError } from './utils/zip';
+export type { ZipEntry, ZipSource } from './utils/zip';
+export { diskFileSystem, memoryFileSystem } from './utils/staticFiles';
+export type { StaticFileSystem, StaticFileStat } from './utils/staticFiles';
+export { RouterGroup } from './core/group';
+export { chain } from './core/compose';
+export { fromNodeHandler, fromNodeMiddleware } from './core/nodeAdapter';
+export type { NodeHandler, NodeMiddleware } from './core/nodeAdapter';
+export type { MiddlewareChain } from './core/compose';
+export { WebSocketHub } from './utils/wsHub';
+export type { HubOptions, HubMessage } from './utils/wsHub';
+export { EventBroker, Subscription } from './utils/eventBroker';
+export type { BrokerOptions } from './utils/eventBroker';
+export { formatEvent } from './utils/sse';
+export type { SSEEvent, SSESource } from './utils/sse';
+export { configFromEnv, parseSize, parseDuration } from './utils/config';
+export {
+  BindError, PayloadTooLargeError, BodyTimeoutError, RequestAbortedError, CompressionRatioError, UnsupportedEncodingError
+} from './utils/bodyParser';
+export type { DecompressionLimits, MultipartLimits } from './utils/bodyParser';
+export { ParamBindError } from './utils/bindUri';
+export { FormBindError } from './utils/bindForm';
+export { hashFingerprint, canonicalQuery } from './utils/fingerprint';
+export type { FingerprintOptions, FingerprintParts } from './utils/fingerprint';
+export { trimFrameworkFrames } from './utils/stack';
+export { parseETags, etagMatches } from './utils/etag';
+export { formatHTTPDate, parseHTTPDate, notModifiedSince } from './utils/httpDate';
+export type { DumpOptions } from './utils/dump';
+export type { SlowRequest } from './middlewares/slowLog';
+export { stringifyJSON, toSnakeCase, toCamelCase } from './utils/json';
+export type { JSONOptions } from './utils/json';
+export { defaultPageEnvelope } from './utils/pagination';
+export type { Page, PageDefaults, PageMeta, PageEnvelope } from './utils/pagination';
+export type { RecordedExchange, ReplayResult } from './core/recorder';
+export { obtainCertificate, AcmeError } from './utils/acme';
+export type { AcmeOptions, AcmeCertificate } from './utils/acme';
 
 // Export middleware functions
 export const {
@@ -13,15 +56,30 @@ export const {
   compression,
   requestLogger,
   errorHandler,
-  HttpError
+  safe,
+  HttpError,
+  otel,
+  connLimit,
+  favicon,
+  timeout,
+  circuitBreaker,
+  metrics,
+  dumper,
+  apiKey,
+  workerPool,
+  requireHeaders,
+  subdomain,
+  requireJSON,
+  slowLog
 } = middlewares;
 
 // Export core components
-export { Qera, Logger };
+export { Qera, Logger, RouteConfigError, ShutdownTimeoutError, DeadlineExceededError, ResponseHeaderLimitError };
 
 // Export validator
 export { v, QeraSchema, QeraValidationError };
 export type { InferType };
+export type { JSONSchema } from './utils/validator';
 
 // Default export (factory function)
 export default createApp;
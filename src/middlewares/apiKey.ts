@@ -0,0 +1,92 @@
+import { createHash, timingSafeEqual } from 'crypto';
+import { Middleware, QeraContext } from '../types';
+
+// Where to find the key: a request header, a query param, or an
+// "Authorization: Bearer" token
+export type APIKeyLookup = `header:${string}` | `query:${string}` | 'bearer';
+
+export interface APIKeyOptions {
+  // Places to look, in priority order; the first one present is used
+  // (default ['header:X-API-Key'])
+  lookup?: APIKeyLookup[];
+  // Static keys, compared in constant time. With an object, the value of
+  // the matching key is the identity; with an array it's the key itself
+  keys?: string[] | Record<string, unknown>;
+  // Look a key up elsewhere, e.g. in a database: resolve to the identity,
+  // or to null/undefined for an unknown key. Errors it throws propagate
+  validate?: (key: string, ctx: QeraContext) => unknown | Promise<unknown>;
+}
+
+// Digests have equal length, so timingSafeEqual works for keys of any length
+const digest = (value: string) => createHash('sha256').update(value).digest();
+
+function findKey(ctx: QeraContext, lookup: APIKeyLookup[]): string | undefined {
+  for (const place of lookup) {
+    let key: string | undefined;
+    if (place === 'bearer') {
+      const match = /^Bearer[ \t]+(\S+)\s*$/i.exec(ctx.headers.authorization || '');
+      key = match?.[1];
+    } else if (place.startsWith('header:')) {
+      key = ctx.headers[place.slice(7).toLowerCase()];
+    } else if (place.startsWith('query:')) {
+      key = ctx.query[place.slice(6)];
+    }
+    if (key) return key;
+  }
+  return undefined;
+}
+
+/**
+ * Authenticate requests by API key. The key is checked against the static
+ * keys, then passed to validate; the identity found is stored in ctx.user.
+ * Requests without a key, or with one neither accepts, get a 401. Static
+ * keys are all compared on every request, without stopping at a match, so
+ * response times don't reveal how much of a key was right.
+ */
+export function apiKey(options: APIKeyOptions): Middleware {
+  if (!options.keys && !options.validate) {
+    throw new Error('apiKey() needs keys or a validate function');
+  }
+  const lookup = options.lookup || ['header:X-API-Key'];
+  for (const place of lookup) {
+    if (place !== 'bearer' && !/^(header|query):./.test(place)) {
+      throw new Error(`Invalid API key lookup "${place}": use "header:<name>", "query:<name>" or "bearer"`);
+    }
+  }
+
+  const keys = options.keys || [];
+  const staticKeys: Array<[Buffer, unknown]> = Array.isArray(keys)
+    ? keys.map(key => [digest(key), key])
+    : Object.entries(keys).map(([key, identity]) => [digest(key), identity]);
+
+  const challenge = (ctx: QeraContext, error: string) => {
+    if (lookup.includes('bearer')) {
+      ctx.header('WWW-Authenticate', 'Bearer');
+    }
+    ctx.status(401).json({ error });
+  };
+
+  return async (ctx, next) => {
+    const key = findKey(ctx, lookup);
+    if (!key) {
+      return challenge(ctx, 'API key required');
+    }
+
+    const presented = digest(key);
+    let identity: unknown;
+    for (const [candidate, candidateIdentity] of staticKeys) {
+      if (timingSafeEqual(candidate, presented) && identity === undefined) {
+        identity = candidateIdentity;
+      }
+    }
+    if (identity === undefined && options.validate) {
+      identity = await options.validate(key, ctx);
+    }
+
+    if (identity === undefined || identity === null || identity === false) {
+      return challenge(ctx, 'Invalid API key');
+    }
+    ctx.user = identity;
+    await next();
+  };
+}
@@ -0,0 +1,131 @@
+import { Middleware, QeraContext } from '../types';
+
+export type CircuitState = 'closed' | 'open' | 'half-open';
+
+export interface CircuitBreakerOptions {
+  // Share of failed requests in a window that opens the circuit, default 0.5
+  failureRatio?: number;
+  // Requests a window needs before the ratio counts, default 10
+  minRequests?: number;
+  // Length of the counting window in ms, default 10s
+  windowMs?: number;
+  // How long the circuit stays open before trial requests are let through, default 30s
+  openMs?: number;
+  // Trial requests allowed at once while half-open, default 1
+  halfOpenRequests?: number;
+  // Defaults to a 5xx status or a thrown error that isn't a 4xx client error
+  isFailure?: (ctx: QeraContext, error?: unknown) => boolean;
+  // Called on every transition, e.g. to export the state as a metric
+  onStateChange?: (state: CircuitState, previous: CircuitState) => void;
+  // Writes the response for rejected requests, defaults to a 503 JSON error
+  response?: (ctx: QeraContext) => void;
+}
+
+const defaultIsFailure = (ctx: QeraContext, error?: unknown) => {
+  if (error === undefined) {
+    return ctx.statusCode >= 500;
+  }
+  // Client errors (malformed or invalid input) say nothing about the upstream
+  const status = (error as { statusCode?: unknown } | null)?.statusCode;
+  return !(typeof status === 'number' && status < 500);
+};
+
+const defaultResponse = (ctx: QeraContext) => {
+  ctx.status(503).json({ error: 'Service Unavailable' });
+};
+
+/**
+ * Circuit breaker for routes backed by an upstream that can degrade. While
+ * closed, requests pass and outcomes are counted per window; once the failure
+ * ratio is reached the circuit opens and requests are rejected with a 503
+ * (and Retry-After) without running the handler. After openMs it half-opens:
+ * a few trial requests go through, and the first outcome either closes the
+ * circuit again or re-opens it.
+ *
+ * Each call creates an independent breaker, so create one per upstream and
+ * share it between the routes that use it.
+ */
+export function circuitBreaker(options: CircuitBreakerOptions = {}): Middleware {
+  const failureRatio = options.failureRatio ?? 0.5;
+  const minRequests = options.minRequests ?? 10;
+  const windowMs = options.windowMs ?? 10000;
+  const openMs = options.openMs ?? 30000;
+  const halfOpenRequests = options.halfOpenRequests ?? 1;
+  const isFailure = options.isFailure || defaultIsFailure;
+  const respond = options.response || defaultResponse;
+
+  let state: CircuitState = 'closed';
+  let openedAt = 0;
+  let trials = 0;
+  let windowStart = Date.now();
+  let total = 0;
+  let failures = 0;
+
+  const transition = (next: CircuitState) => {
+    const previous = state;
+    state = next;
+    if (next === 'open') {
+      openedAt = Date.now();
+    }
+    if (next === 'closed') {
+      windowStart = Date.now();
+      total = 0;
+      failures = 0;
+    }
+    options.onStateChange?.(next, previous);
+  };
+
+  const record = (failed: boolean, trial: boolean) => {
+    if (trial) {
+      trials--;
+      // A trial that finishes after another one decided the outcome doesn't count
+      if (state === 'half-open') {
+        transition(failed ? 'open' : 'closed');
+      }
+      return;
+    }
+
+    if (state !== 'closed') return;
+
+    const now = Date.now();
+    if (now - windowStart >= windowMs) {
+      windowStart = now;
+      total = 0;
+      failures = 0;
+    }
+
+    total++;
+    if (failed) failures++;
+
+    if (total >= minRequests && failures / total >= failureRatio) {
+      transition('open');
+    }
+  };
+
+  return async (ctx, next) => {
+    if (state === 'open' && Date.now() - openedAt >= openMs) {
+      transition('half-open');
+    }
+
+    const trial = state === 'half-open' && trials < halfOpenRequests;
+
+    if (state === 'open' || (state === 'half-open' && !trial)) {
+      const retryAfter = Math.max(Math.ceil((openedAt + openMs - Date.now()) / 1000), 1);
+      ctx.header('Retry-After', String(retryAfter));
+      respond(ctx);
+      return;
+    }
+
+    if (trial) {
+      trials++;
+    }
+
+    try {
+      await next();
+    } catch (error) {
+      record(isFailure(ctx, error), trial);
+      throw error;
+    }
+    record(isFailure(ctx), trial);
+  };
+}
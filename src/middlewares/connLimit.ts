@@ -0,0 +1,40 @@
+import { Middleware, QeraContext } from '../types';
+
+export interface ConnLimitOptions {
+  // Identifies a client, defaults to the (trusted-proxy aware) client IP
+  keyGenerator?: (ctx: QeraContext) => string;
+  message?: string;
+}
+
+/**
+ * Cap the number of requests a single client can have in flight at once.
+ * Requests beyond the limit get a 429 straight away; a slot is released when
+ * the request finishes, including when the handler throws.
+ */
+export function connLimit(perIp: number, options: ConnLimitOptions = {}): Middleware {
+  const keyGenerator = options.keyGenerator || ((ctx: QeraContext) => ctx.ip);
+  const message = options.message || 'Too many concurrent requests';
+  const active = new Map<string, number>();
+
+  return async (ctx, next) => {
+    const key = keyGenerator(ctx);
+    const count = (active.get(key) || 0) + 1;
+
+    if (count > perIp) {
+      ctx.status(429).json({ error: message });
+      return;
+    }
+
+    active.set(key, count);
+    try {
+      await next();
+    } finally {
+      const remaining = active.get(key)! - 1;
+      if (remaining === 0) {
+        active.delete(key);
+      } else {
+        active.set(key, remaining);
+      }
+    }
+  };
+}
@@ -0,0 +1,20 @@
+import { Middleware } from '../types';
+import { DumpOptions } from '../utils/dump';
+import { Logger } from '../utils/logger';
+
+/**
+ * Log a dump of every request that matched a route (see ctx.dump()) before
+ * it is handled, so the dump is there even if the handler crashes. Output
+ * goes to Logger.info unless a writer is given, e.g. a file stream's write.
+ * Meant for diagnosing misbehaving clients, not for production traffic.
+ */
+export function dumper(write?: (dump: string) => void, options: DumpOptions = {}): Middleware {
+  const output = write || ((dump: string) => Logger.info(`Request dump\n${dump}`));
+
+  return async (ctx, next) => {
+    if (ctx.route) {
+      output(ctx.dump(options));
+    }
+    await next();
+  };
+}
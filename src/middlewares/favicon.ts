@@ -0,0 +1,50 @@
+import * as crypto from 'crypto';
+import * as fs from 'fs';
+import { Middleware } from '../types';
+import { getMimeType } from '../utils/staticFiles';
+
+export interface FaviconOptions {
+  // Cache lifetime in seconds, default one year
+  maxAge?: number;
+  // Content type, defaults to one derived from the file extension (or image/x-icon)
+  type?: string;
+}
+
+/**
+ * Answer /favicon.ico before any other middleware or route runs. The icon
+ * is read once when the middleware is created (a missing file fails at
+ * startup, not per request). Without an icon, requests get a bodyless 404.
+ */
+export function favicon(icon?: string | Buffer, options: FaviconOptions = {}): Middleware {
+  const data = typeof icon === 'string' ? fs.readFileSync(icon) : icon;
+  const type = options.type || (typeof icon === 'string' ? getMimeType(icon) : 'image/x-icon');
+  const maxAge = options.maxAge ?? 365 * 24 * 60 * 60;
+  const etag = data ? `"${crypto.createHash('sha1').update(data).digest('base64')}"` : '';
+
+  return async (ctx, next) => {
+    if (ctx.path() !== '/favicon.ico') {
+      await next();
+      return;
+    }
+
+    if (!data) {
+      ctx.status(404).send('');
+      return;
+    }
+
+    const { method } = ctx;
+    if (method !== 'GET' && method !== 'HEAD') {
+      ctx.status(405).header('Allow', 'GET, HEAD').send('');
+      return;
+    }
+
+    ctx.header('Cache-Control', `public, max-age=${maxAge}`).header('ETag', etag);
+
+    if (ctx.headers['if-none-match'] === etag) {
+      ctx.status(304).send('');
+      return;
+    }
+
+    ctx.header('Content-Type', type).send(method === 'HEAD' ? '' : data);
+  };
+}
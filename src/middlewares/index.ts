@@ -1,4 +1,23 @@
-import { QeraContext, Middleware } from '../types';
+import { QeraContext, Middleware, RouteHandler } from '../types';
+import { Logger } from '../utils/logger';
+import { BindError } from '../utils/bodyParser';
+import { QeraValidationError } from '../utils/validator';
+import { trimFrameworkFrames } from '../utils/stack';
+import { RequestFailedError } from '../utils/errorResponse';
+
+export * from './otel';
+export * from './connLimit';
+export * from './favicon';
+export * from './timeout';
+export * from './circuitBreaker';
+export * from './metrics';
+export * from './dumper';
+export * from './apiKey';
+export * from './workerPool';
+export * from './requireHeaders';
+export * from './subdomain';
+export * from './requireJSON';
+export * from './slowLog';
 
 // Extend HttpRequest type to include optional 'log' property
 declare module 'uWebSockets.js' {
@@ -165,10 +184,10 @@ export function requestLogger(): Middleware {
     try {
       // Log request start
       if (ctx.req.log && typeof ctx.req.log.info === 'function') {
-        ctx.req.log.info(`Request started: ${ctx.req.getMethod()} ${ctx.req.getUrl()}`, {
+        ctx.req.log.info(`Request started: ${ctx.method} ${ctx.path()}`, {
           requestId,
-          method: ctx.req.getMethod(),
-          url: ctx.req.getUrl(),
+          method: ctx.method,
+          url: ctx.path(),
           query: ctx.query,
           ip: ctx.headers['x-forwarded-for'] || 'unknown',
           userAgent: ctx.headers['user-agent']
@@ -180,7 +199,7 @@ export function requestLogger(): Middleware {
     } catch (error) {
       // Log error if one occurred
       if (ctx.req.log && typeof ctx.req.log.error === 'function') {
-        ctx.req.log.error(`Request error: ${ctx.req.getMethod()} ${ctx.req.getUrl()}`, {
+        ctx.req.log.error(`Request error: ${ctx.method} ${ctx.path()}`, {
           requestId,
           error: error instanceof Error ? error.message : 'Unknown error',
           stack: error instanceof Error ? error.stack : undefined
@@ -195,11 +214,11 @@ export function requestLogger(): Middleware {
       
       // Log request completion
       if (ctx.req.log && typeof ctx.req.log.info === 'function') {
-        ctx.req.log.info(`Request completed: ${ctx.req.getMethod()} ${ctx.req.getUrl()}`, {
+        ctx.req.log.info(`Request completed: ${ctx.method} ${ctx.path()}`, {
           requestId,
           duration,
-          method: ctx.req.getMethod(),
-          url: ctx.req.getUrl(),
+          method: ctx.method,
+          url: ctx.path(),
           status: ctx.res.statusCode
         });
       }
@@ -207,42 +226,78 @@ export function requestLogger(): Middleware {
   };
 }
 
-// Error handling middleware
-export function errorHandler(options: {
+export interface ErrorHandlerOptions {
   log?: boolean;
   includeErrorDetails?: boolean;
-}): Middleware {
+  // Formats stacks for the log and the error details, e.g. to cap the depth
+  // or emit JSON. Defaults to trimFrameworkFrames, which drops Qera's frames
+  stackFormatter?: (stack: string, error: Error) => string;
+}
+
+// Log an error and answer with its status, shared by errorHandler() and safe()
+function respondWithError(ctx: QeraContext, error: unknown, options: ErrorHandlerOptions) {
+  const formatStack = options.stackFormatter || trimFrameworkFrames;
+
+  // Default error code; errors registered with app.mapError() get theirs
+  const known = error instanceof HttpError || error instanceof BindError || error instanceof QeraValidationError
+    || error instanceof RequestFailedError;
+  const mapped = known ? undefined : ctx.errorMapping?.(error);
+  const statusCode = known ? error.statusCode : mapped?.status ?? 500;
+
+  const stack = error instanceof Error && error.stack ? formatStack(error.stack, error) : undefined;
+  
+  // Log error if enabled; qera.fail() is a deliberate answer, not an error
+  if (options.log !== false && !(error instanceof RequestFailedError)) {
+    if (ctx.req.log && typeof ctx.req.log.error === 'function') {
+      ctx.req.log.error('Error in request:', {
+        error: error instanceof Error ? error.message : 'Unknown error',
+        status: statusCode,
+        url: ctx.path(),
+        stack
+      });
+    }
+  }
+  
+  // Prepare response
+  const response: Record<string, any> = {
+    error: mapped ? mapped.message : error instanceof Error ? error.message : 'Internal Server Error'
+  };
+  
+  // Include additional error details if enabled and in development
+  if (options.includeErrorDetails && process.env.NODE_ENV !== 'production' && error instanceof Error) {
+    response.stack = stack;
+    response.details = error instanceof HttpError ? error.details : undefined;
+  }
+  
+  ctx.status(statusCode).json(response);
+}
+
+// Error handling middleware
+export function errorHandler(options: ErrorHandlerOptions): Middleware {
   return async (ctx, next) => {
     try {
       await next();
     } catch (error) {
-      // Default error code
-      const statusCode = error instanceof HttpError ? error.statusCode : 500;
-      
-      // Log error if enabled
-      if (options.log !== false) {
-        if (ctx.req.log && typeof ctx.req.log.error === 'function') {
-          ctx.req.log.error('Error in request:', {
-            error: error instanceof Error ? error.message : 'Unknown error',
-            status: statusCode,
-            url: ctx.req.getUrl(),
-            stack: error instanceof Error ? error.stack : undefined
-          });
-        }
-      }
-      
-      // Prepare response
-      const response: Record<string, any> = {
-        error: error instanceof Error ? error.message : 'Internal Server Error'
-      };
-      
-      // Include additional error details if enabled and in development
-      if (options.includeErrorDetails && process.env.NODE_ENV !== 'production' && error instanceof Error) {
-        response.stack = error.stack;
-        response.details = error instanceof HttpError ? error.details : undefined;
+      respondWithError(ctx, error, options);
+    }
+  };
+}
+
+/**
+ * Catch errors from one handler the way errorHandler() would, with the same
+ * options, for a risky route in an app without a global errorHandler().
+ * Errors thrown after the response was sent are only logged.
+ */
+export function safe(handler: RouteHandler, options: ErrorHandlerOptions = {}): RouteHandler {
+  return async (ctx) => {
+    try {
+      await handler(ctx);
+    } catch (error) {
+      if (ctx.committed) {
+        Logger.error(`Error handling ${ctx.path()} after the response was sent: ${error}`);
+        return;
       }
-      
-      ctx.status(statusCode).json(response);
+      respondWithError(ctx, error, options);
     }
   };
 }
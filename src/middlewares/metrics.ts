@@ -0,0 +1,90 @@
+import { Middleware, QeraContext, RouteHandler } from '../types';
+
+// Traffic counted for one route
+export interface RouteTraffic {
+  requests: number;
+  requestBytes: number;  // request body bytes read
+  responseBytes: number; // response body bytes written, headers excluded
+}
+
+export interface MetricsMiddleware extends Middleware {
+  // Copy of the counters keyed by "METHOD /pattern"; requests that matched no
+  // route are counted under "unmatched"
+  snapshot(): Record<string, RouteTraffic>;
+  reset(): void;
+  // Route handler serving the counters in the Prometheus text format
+  handler: RouteHandler;
+}
+
+interface RouteCounters extends RouteTraffic {
+  method: string;
+  route: string;
+}
+
+const routeKey = (ctx: QeraContext) => (ctx.route ? `${ctx.route.method} ${ctx.route.path}` : 'unmatched');
+
+// Label values are quoted; backslashes, quotes and newlines must be escaped
+const labelValue = (value: string) => value.replace(/[\\"]/g, '\\$&').replace(/\n/g, '\\n');
+
+/**
+ * Request and response size counters per route, to find bandwidth-heavy
+ * endpoints. The framework counts body bytes as it reads and writes them
+ * (ctx.bytesRead and ctx.bytesWritten); this middleware only adds them up
+ * once the rest of the chain has finished, so the per-request cost is a map
+ * lookup. Register it first so it sees every response.
+ *
+ * Errors answered by the framework after the chain unwinds (an unhandled
+ * throw without errorHandler) are counted as requests without their body.
+ */
+export function metrics(): MetricsMiddleware {
+  let routes = new Map<string, RouteCounters>();
+
+  const middleware = (async (ctx, next) => {
+    try {
+      await next();
+    } finally {
+      const key = routeKey(ctx);
+      let traffic = routes.get(key);
+      if (!traffic) {
+        const { method = '', path = 'unmatched' } = ctx.route || {};
+        traffic = { method, route: path, requests: 0, requestBytes: 0, responseBytes: 0 };
+        routes.set(key, traffic);
+      }
+      traffic.requests++;
+      traffic.requestBytes += ctx.bytesRead;
+      traffic.responseBytes += ctx.bytesWritten;
+    }
+  }) as MetricsMiddleware;
+
+  middleware.snapshot = () => {
+    const copy: Record<string, RouteTraffic> = {};
+    for (const [key, { requests, requestBytes, responseBytes }] of routes) {
+      copy[key] = { requests, requestBytes, responseBytes };
+    }
+    return copy;
+  };
+
+  middleware.reset = () => {
+    routes = new Map();
+  };
+
+  middleware.handler = (ctx) => {
+    const series: Array<[keyof RouteTraffic, string, string]> = [
+      ['requests', 'qera_http_requests_total', 'Requests handled, by route.'],
+      ['requestBytes', 'qera_http_request_bytes_total', 'Request body bytes read, by route.'],
+      ['responseBytes', 'qera_http_response_bytes_total', 'Response body bytes written, by route.']
+    ];
+
+    const lines: string[] = [];
+    for (const [field, name, help] of series) {
+      lines.push(`# HELP ${name} ${help}`, `# TYPE ${name} counter`);
+      for (const traffic of routes.values()) {
+        lines.push(`${name}{method="${labelValue(traffic.method)}",route="${labelValue(traffic.route)}"} ${traffic[field]}`);
+      }
+    }
+
+    ctx.header('Content-Type', 'text/plain; version=0.0.4').send(`${lines.join('\n')}\n`);
+  };
+
+  return middleware;
+}
@@ -0,0 +1,144 @@
+import { Middleware, QeraContext } from '../types';
+
+// Structural subset of the @opentelemetry/api types used here, so Qera
+// doesn't need a hard dependency on the OpenTelemetry packages
+export interface OtelSpan {
+  setAttribute(key: string, value: string | number | boolean): any;
+  setStatus(status: { code: number; message?: string }): any;
+  recordException(exception: any): void;
+  end(): void;
+}
+
+export interface OtelTracer {
+  startSpan(name: string, options?: Record<string, any>, context?: unknown): OtelSpan;
+}
+
+export interface OtelTracerProvider {
+  getTracer(name: string, version?: string): OtelTracer;
+}
+
+export interface OtelPropagator {
+  extract(context: unknown, carrier: Record<string, string>, getter?: unknown): unknown;
+}
+
+export interface OtelOptions {
+  tracerProvider?: OtelTracerProvider;
+  tracerName?: string;
+  // Defaults to W3C Trace Context (traceparent) extraction
+  propagator?: OtelPropagator;
+  spanName?: (ctx: QeraContext, method: string) => string;
+}
+
+// OpenTelemetry span status and kind values
+const SPAN_KIND_SERVER = 1;
+const STATUS_ERROR = 2;
+
+const TRACEPARENT = /^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$/;
+
+/**
+ * Parse a W3C traceparent header. Returns null for malformed values and for
+ * the all-zero trace/span ids the spec declares invalid.
+ */
+export function parseTraceparent(header: string | undefined): {
+  traceId: string;
+  spanId: string;
+  traceFlags: number;
+} | null {
+  const match = header ? TRACEPARENT.exec(header.trim().toLowerCase()) : null;
+  if (!match || match[1] === 'ff') {
+    return null;
+  }
+
+  const [, , traceId, spanId, flags] = match;
+  if (/^0+$/.test(traceId) || /^0+$/.test(spanId)) {
+    return null;
+  }
+
+  return { traceId, spanId, traceFlags: parseInt(flags, 16) };
+}
+
+/**
+ * OpenTelemetry tracing middleware. Starts a server span per request, named
+ * after the matched route pattern, continues incoming trace context and
+ * records the response status and any thrown error. The span is available as
+ * ctx.state.span and the active context as ctx.state.traceContext.
+ *
+ * The span ends once the request is finished (see ctx.onFinish()), with
+ * the status that was sent, and is marked as failed only for a 5xx. A
+ * thrown error is recorded either way.
+ *
+ * Without a tracerProvider the middleware does nothing, so it can be wired
+ * up unconditionally and enabled through configuration.
+ */
+export function otel(options: OtelOptions = {}): Middleware {
+  if (!options.tracerProvider) {
+    return async (ctx, next) => {
+      await next();
+    };
+  }
+
+  const api = require('@opentelemetry/api');
+  const tracer = options.tracerProvider.getTracer(options.tracerName || 'qera');
+
+  const extract = (headers: Record<string, string>) => {
+    const active = api.context.active();
+    if (options.propagator) {
+      return options.propagator.extract(active, headers);
+    }
+
+    const parent = parseTraceparent(headers.traceparent);
+    if (!parent) {
+      return active;
+    }
+
+    return api.trace.setSpanContext(active, { ...parent, isRemote: true });
+  };
+
+  return async (ctx, next) => {
+    const { method } = ctx;
+    const route = ctx.route?.path;
+
+    const name = options.spanName
+      ? options.spanName(ctx, method)
+      : route ? `${method} ${route}` : method;
+
+    const parentContext = extract(ctx.headers);
+    const span = tracer.startSpan(name, {
+      kind: SPAN_KIND_SERVER,
+      attributes: {
+        'http.request.method': method,
+        'url.path': ctx.path(),
+        ...(route ? { 'http.route': route } : {}),
+        ...(ctx.headers['user-agent'] ? { 'user_agent.original': ctx.headers['user-agent'] } : {})
+      }
+    }, parentContext);
+
+    const traceContext = api.trace.setSpan(parentContext, span);
+    ctx.state.span = span;
+    ctx.state.traceContext = traceContext;
+    let failure: unknown;
+    let failed = false;
+
+    // The status is final once the request is done, also when the chain
+    // throws and the error is answered afterwards
+    ctx.onFinish(() => {
+      const status = ctx.statusCode;
+      span.setAttribute('http.response.status_code', status);
+      if (status >= 500) {
+        span.setStatus(failed
+          ? { code: STATUS_ERROR, message: failure instanceof Error ? failure.message : String(failure) }
+          : { code: STATUS_ERROR });
+      }
+      span.end();
+    });
+
+    try {
+      await api.context.with(traceContext, next);
+    } catch (error) {
+      failed = true;
+      failure = error;
+      span.recordException(error);
+      throw error;
+    }
+  };
+}
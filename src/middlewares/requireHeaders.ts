@@ -0,0 +1,49 @@
+import { Middleware } from '../types';
+
+// Checks a header value: a pattern it must match in full, or a function
+// returning whether the value is acceptable
+export type HeaderCheck = RegExp | ((value: string) => boolean);
+
+/**
+ * Answer 400 when a required header is missing or empty, naming the first
+ * one that is. Headers are given by name, or as an object mapping names to
+ * a check of their value; a value that fails its check gets a 400 naming
+ * the header too. Checks run in the order the headers are given.
+ */
+export function requireHeaders(...headers: Array<string | Record<string, HeaderCheck>>): Middleware {
+  const required: Array<[string, HeaderCheck | undefined]> = [];
+  for (const entry of headers) {
+    if (typeof entry === 'string') {
+      required.push([entry, undefined]);
+    } else {
+      required.push(...Object.entries(entry));
+    }
+  }
+  if (required.length === 0) {
+    throw new Error('requireHeaders() needs at least one header name');
+  }
+
+  // Patterns must match the whole value, not just part of it
+  const checks = required.map(([name, check]): [string, string, ((value: string) => boolean) | undefined] => {
+    if (check instanceof RegExp) {
+      const whole = new RegExp(`^(?:${check.source})$`, check.flags.replace(/[gy]/g, ''));
+      return [name, name.toLowerCase(), value => whole.test(value)];
+    }
+    return [name, name.toLowerCase(), check];
+  });
+
+  return async (ctx, next) => {
+    for (const [name, key, check] of checks) {
+      const value = ctx.headers[key];
+      if (!value) {
+        ctx.status(400).json({ error: `Missing required header ${name}` });
+        return;
+      }
+      if (check && !check(value)) {
+        ctx.status(400).json({ error: `Invalid value for header ${name}` });
+        return;
+      }
+    }
+    await next();
+  };
+}
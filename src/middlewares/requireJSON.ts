@@ -0,0 +1,56 @@
+import { Middleware } from '../types';
+
+export interface RequireJSONOptions {
+  // Methods whose requests must carry JSON, default POST, PUT and PATCH
+  methods?: string[];
+  // Paths left alone, such as file uploads: a string matches that path and
+  // everything below it ('/uploads' matches '/uploads/avatar' but not
+  // '/uploads-old'), a pattern is tested against the path
+  exclude?: Array<string | RegExp>;
+  // Let requests without a body through, whatever their Content-Type
+  allowEmpty?: boolean;
+}
+
+// application/json, or a JSON-based type such as application/merge-patch+json
+const JSON_TYPE = /^application\/(?:[\w.-]+\+)?json$/;
+
+/**
+ * Answer 415 when a request to one of the methods doesn't declare a JSON
+ * Content-Type (parameters such as charset are fine), and 400 when its body
+ * is empty. Malformed JSON is left to the body binding, which answers 400
+ * when the handler reads it.
+ */
+export function requireJSON(options: RequireJSONOptions = {}): Middleware {
+  const methods = new Set((options.methods ?? ['POST', 'PUT', 'PATCH']).map(method => method.toUpperCase()));
+  if (methods.size === 0) {
+    throw new Error('requireJSON() needs at least one method');
+  }
+  const exclude = options.exclude ?? [];
+  const excluded = (path: string) => exclude.some(entry => typeof entry === 'string'
+    ? path === entry || path.startsWith(entry.endsWith('/') ? entry : `${entry}/`)
+    : entry.test(path));
+
+  return async (ctx, next) => {
+    if (!methods.has(ctx.method) || excluded(ctx.path())) {
+      await next();
+      return;
+    }
+
+    const empty = ctx.peekBody().length === 0;
+    if (empty && options.allowEmpty) {
+      await next();
+      return;
+    }
+
+    const type = (ctx.headers['content-type'] || '').split(';')[0].trim().toLowerCase();
+    if (!JSON_TYPE.test(type)) {
+      ctx.status(415).json({ error: 'Content-Type must be application/json' });
+      return;
+    }
+    if (empty) {
+      ctx.status(400).json({ error: 'Request body must not be empty' });
+      return;
+    }
+    await next();
+  };
+}
@@ -0,0 +1,56 @@
+import { Middleware } from '../types';
+import { Logger } from '../utils/logger';
+
+// One request that took longer than the threshold
+export interface SlowRequest {
+  time: string; // when the request reached the middleware, ISO 8601
+  method: string;
+  path: string;
+  route: string | null; // the matched route pattern, e.g. "/reports/:id"
+  status: number;
+  latencyMs: number;
+}
+
+/**
+ * Log requests that take longer than threshold ms, from reaching this
+ * middleware until the rest of the chain has finished, and nothing else.
+ * Each slow request is passed to write as a JSON line (newline included)
+ * and as an entry, so write can be a file stream's write; without one they
+ * go to Logger.warn. Register it first so the latency covers every
+ * middleware.
+ *
+ * Entries are written once the request is finished (see ctx.onFinish()),
+ * so a request whose chain throws is logged with the status the error was
+ * answered with.
+ */
+export function slowLog(threshold: number, write?: (line: string, entry: SlowRequest) => void): Middleware {
+  if (!Number.isFinite(threshold) || threshold < 0) {
+    throw new Error(`slowLog() needs a threshold of 0 ms or more, got ${threshold}`);
+  }
+  const output = write || ((_line: string, entry: SlowRequest) =>
+    Logger.warn(`Slow request: ${entry.method} ${entry.path} took ${entry.latencyMs}ms`, { ...entry }));
+
+  return async (ctx, next) => {
+    const startedAt = Date.now();
+
+    try {
+      await next();
+    } finally {
+      const latencyMs = Date.now() - startedAt;
+      if (latencyMs > threshold) {
+        // Logged once the response is done, so a thrown error has its answered status
+        ctx.onFinish(() => {
+          const entry: SlowRequest = {
+            time: new Date(startedAt).toISOString(),
+            method: ctx.method,
+            path: ctx.path(),
+            route: ctx.route ? ctx.route.path : null,
+            status: ctx.statusCode,
+            latencyMs
+          };
+          output(`${JSON.stringify(entry)}\n`, entry);
+        });
+      }
+    }
+  };
+}
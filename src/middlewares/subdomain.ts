@@ -0,0 +1,68 @@
+import { Middleware, QeraContext } from '../types';
+import { HOST_LABEL, hostname } from '../utils/host';
+
+export interface SubdomainOptions {
+  // Domain tenants live under, e.g. "example.com" for "acme.example.com";
+  // several for apps served under more than one domain
+  baseDomain: string | string[];
+  // Look up the tenant for a subdomain, e.g. in a database: resolve to the
+  // tenant, or to null/undefined for an unknown one, which gets a 404. Without
+  // it, the subdomain itself is the tenant
+  resolve?: (subdomain: string, ctx: QeraContext) => unknown | Promise<unknown>;
+  // Subdomains that aren't tenants (default ['www'])
+  ignore?: string[];
+  // Where the tenant goes in ctx.state (default 'tenant')
+  key?: string;
+}
+
+/**
+ * Tag requests with the tenant named by the Host header's subdomain, so
+ * "acme.example.com" stores "acme" (or what resolve returns for it) in
+ * ctx.state.tenant. Requests for a base domain itself, or for an ignored
+ * subdomain such as "www", carry no tenant. With resolve, unknown tenants,
+ * nested subdomains, invalid labels and hosts outside the base domains get
+ * a 404; without it they also carry no tenant. The Host comes from
+ * ctx.host(), so X-Forwarded-Host counts only from a trusted proxy.
+ */
+export function subdomain(options: SubdomainOptions): Middleware {
+  const bases = (Array.isArray(options.baseDomain) ? options.baseDomain : [options.baseDomain])
+    .map(base => base.toLowerCase().replace(/^\.|\.$/g, ''));
+  if (bases.length === 0 || bases.some(base => base === '')) {
+    throw new Error('subdomain() needs a base domain, e.g. "example.com"');
+  }
+  const ignore = new Set((options.ignore || ['www']).map(name => name.toLowerCase()));
+  const key = options.key || 'tenant';
+
+  // The tenant label of host, '' when there is none, undefined when invalid
+  const label = (host: string): string | undefined => {
+    for (const base of bases) {
+      if (host === base) return '';
+      if (host.endsWith(`.${base}`)) {
+        const name = host.slice(0, -base.length - 1);
+        if (ignore.has(name)) return '';
+        return HOST_LABEL.test(name) ? name : undefined;
+      }
+    }
+    return undefined;
+  };
+
+  return async (ctx, next) => {
+    const name = label(hostname(ctx.host()));
+
+    if (name === '' || (name === undefined && !options.resolve)) {
+      return next();
+    }
+
+    // Invalid hosts only get here with a resolver, and are unknown tenants
+    let tenant: unknown = name;
+    if (name !== undefined && options.resolve) {
+      tenant = await options.resolve(name, ctx);
+    }
+    if (tenant === undefined || tenant === null) {
+      ctx.status(404).json({ error: 'Not Found' });
+      return;
+    }
+    ctx.state[key] = tenant;
+    await next();
+  };
+}
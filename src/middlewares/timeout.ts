@@ -0,0 +1,70 @@
+import { Middleware, QeraContext } from '../types';
+import { Logger } from '../utils/logger';
+
+export interface TimeoutOptions {
+  // Writes the response sent when the deadline passes, defaults to a 503 JSON error
+  response?: (ctx: QeraContext) => void;
+}
+
+const defaultResponse = (ctx: QeraContext) => {
+  ctx.status(503).json({ error: 'Request timed out' });
+};
+
+/**
+ * Answer requests that take longer than ms with a timeout response. Routes
+ * can override the limit with the timeout route option (0 disables it).
+ * With the requestTimeout config, the response goes out at the request's
+ * deadline if that comes first; without ms, the deadline is the only limit.
+ *
+ * Exactly one of the handler and the timeout writes the response: whichever
+ * commits first wins, and later writes from the other side are ignored. The
+ * handler keeps running after the deadline; it can watch ctx.committed to
+ * stop early.
+ */
+export function timeout(ms?: number, options: TimeoutOptions = {}): Middleware {
+  const respond = options.response || defaultResponse;
+
+  return async (ctx, next) => {
+    const own = ctx.route?.options?.timeout ?? ms;
+    if (own !== undefined && !(own > 0)) {
+      await next();
+      return;
+    }
+    // The shared deadline also counts the time spent before this middleware
+    const limit = Math.min(own ?? Infinity, ctx.remainingTime());
+    if (limit === Infinity) {
+      await next();
+      return;
+    }
+    if (limit === 0) {
+      respond(ctx);
+      return;
+    }
+
+    let timedOut = false;
+    let timer: NodeJS.Timeout | undefined;
+    const expired = new Promise<void>(resolve => {
+      timer = setTimeout(() => {
+        timedOut = true;
+        resolve();
+      }, limit);
+    });
+
+    const work = next();
+    // Once the deadline has passed nothing awaits the handler any more
+    work.catch(error => {
+      if (timedOut) {
+        Logger.warn(`Request handler failed after timing out: ${error}`);
+      }
+    });
+
+    try {
+      await Promise.race([work, expired]);
+      if (timedOut && !ctx.committed) {
+        respond(ctx);
+      }
+    } finally {
+      clearTimeout(timer);
+    }
+  };
+}
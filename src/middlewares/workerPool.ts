@@ -0,0 +1,140 @@
+import { Middleware, QeraContext, RouteHandler } from '../types';
+
+export interface WorkerPoolOptions {
+  // Requests allowed to run at once
+  workers: number;
+  // Requests allowed to wait for a worker; any more get a 503 (default workers * 10)
+  maxQueue?: number;
+  // Longest a request may wait for a worker in ms before it gets a 503
+  // (default 0, no limit)
+  maxWait?: number;
+  message?: string;
+}
+
+export interface WorkerPoolStats {
+  workers: number;
+  active: number;     // requests running now
+  queued: number;     // requests waiting now
+  admitted: number;   // requests that got a worker, right away or after waiting
+  rejected: number;   // requests answered 503 because the queue was full or too slow
+  totalWait: number;  // ms spent waiting by admitted requests
+  maxWait: number;    // longest wait of an admitted request, in ms
+}
+
+export interface WorkerPoolMiddleware extends Middleware {
+  stats(): WorkerPoolStats;
+  // Route handler serving the stats in the Prometheus text format
+  handler: RouteHandler;
+}
+
+/**
+ * Run at most `workers` requests at once and queue the rest, instead of
+ * rejecting them the way connLimit does. A burst then costs the requests at
+ * the back some waiting rather than failing them, as long as it fits in
+ * the queue. Requests are admitted in arrival order. A request whose client
+ * disconnects while queued leaves the queue without running; one that
+ * reaches its requestTimeout deadline while queued gets the 503.
+ */
+export function workerPool(options: WorkerPoolOptions): WorkerPoolMiddleware {
+  const { workers, maxQueue = workers * 10, maxWait = 0 } = options;
+  if (!Number.isInteger(workers) || workers < 1) {
+    throw new Error(`workerPool() needs at least one worker, got ${workers}`);
+  }
+  const message = options.message || 'Server busy, try again later';
+
+  // Admit callbacks of waiting requests, oldest first
+  const queue: Array<() => void> = [];
+  let active = 0;
+  const stats = { admitted: 0, rejected: 0, totalWait: 0, maxWait: 0 };
+
+  const release = () => {
+    const admit = queue.shift();
+    if (admit) {
+      // The worker passes straight to the next request
+      admit();
+    } else {
+      active--;
+    }
+  };
+
+  const recordWait = (ms: number) => {
+    stats.admitted++;
+    stats.totalWait += ms;
+    stats.maxWait = Math.max(stats.maxWait, ms);
+  };
+
+  // Resolves true once a worker is free, false when the request gave up
+  const acquire = (signal: AbortSignal): Promise<boolean> => new Promise(resolve => {
+    let timer: NodeJS.Timeout | undefined;
+    const done = (admitted: boolean) => {
+      clearTimeout(timer);
+      signal.removeEventListener('abort', leave);
+      resolve(admitted);
+    };
+    const admit = () => done(true);
+    const leave = () => {
+      queue.splice(queue.indexOf(admit), 1);
+      done(false);
+    };
+    queue.push(admit);
+    signal.addEventListener('abort', leave);
+    if (maxWait > 0) {
+      timer = setTimeout(leave, maxWait);
+    }
+  });
+
+  const busy = (ctx: QeraContext) => {
+    stats.rejected++;
+    ctx.header('Retry-After', '1').status(503).json({ error: message });
+  };
+
+  const middleware = (async (ctx, next) => {
+    if (active < workers) {
+      active++;
+      recordWait(0);
+    } else {
+      if (queue.length >= maxQueue) {
+        return busy(ctx);
+      }
+      const queuedAt = performance.now();
+      if (!await acquire(ctx.signal)) {
+        // The signal also aborts at the requestTimeout deadline, and that
+        // client is still waiting for an answer
+        return ctx.clientDisconnected() ? undefined : busy(ctx);
+      }
+      recordWait(performance.now() - queuedAt);
+    }
+
+    try {
+      await next();
+    } finally {
+      release();
+    }
+  }) as WorkerPoolMiddleware;
+
+  middleware.stats = () => ({ workers, active, queued: queue.length, ...stats });
+
+  middleware.handler = (ctx) => {
+    const lines = [
+      '# HELP qera_pool_workers Requests allowed to run at once.',
+      '# TYPE qera_pool_workers gauge',
+      `qera_pool_workers ${workers}`,
+      '# HELP qera_pool_active Requests running now.',
+      '# TYPE qera_pool_active gauge',
+      `qera_pool_active ${active}`,
+      '# HELP qera_pool_queue_depth Requests waiting for a worker.',
+      '# TYPE qera_pool_queue_depth gauge',
+      `qera_pool_queue_depth ${queue.length}`,
+      '# HELP qera_pool_rejected_total Requests answered 503 because the queue was full or the wait too long.',
+      '# TYPE qera_pool_rejected_total counter',
+      `qera_pool_rejected_total ${stats.rejected}`,
+      '# HELP qera_pool_wait_seconds Time admitted requests waited for a worker.',
+      '# TYPE qera_pool_wait_seconds summary',
+      `qera_pool_wait_seconds_sum ${stats.totalWait / 1000}`,
+      `qera_pool_wait_seconds_count ${stats.admitted}`
+    ];
+    ctx.header('Content-Type', 'text/plain; version=0.0.4').send(`${lines.join('\n')}\n`);
+  };
+
+  return middleware;
+}
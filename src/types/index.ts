@@ -1,34 +1,258 @@
+import { Duplex } from "stream";
 import { HttpRequest, HttpResponse, WebSocket } from "uWebSockets.js";
-import { QeraSchema } from "../utils/validator";
+import { QeraSchema, JSONSchema } from "../utils/validator";
+import { JSONArraySource, BodySource, PipeOptions } from "../utils/stream";
+import { FingerprintOptions, FingerprintParts } from "../utils/fingerprint";
+import { DumpOptions } from "../utils/dump";
+import { JSONOptions } from "../utils/json";
+import { SSESource } from "../utils/sse";
+import { Page, PageDefaults, PageEnvelope } from "../utils/pagination";
+import { DecompressionLimits, MultipartLimits } from "../utils/bodyParser";
+import { ZipSource } from "../utils/zip";
+import { ErrorContentType } from "../utils/errorResponse";
+import { MappedError } from "../utils/errorMap";
 
 // Core request context types
 export interface QeraContext {
   req: HttpRequest;
   res: HttpResponse;
+  // Path parameters by name, percent-decoded (see unescapePath); built on first access
   params: Record<string, string>;
-  query: Record<string, string | string[]>;
+  // Query parameters; when a name repeats (?a=1&a=2) the first value wins
+  query: Record<string, string>;
   body: any;
+  // Stop the middleware chain: nothing after the current middleware runs,
+  // including the handler, and next() does nothing. abortWithStatus() also
+  // answers with sendStatus(code) unless a response was sent already
+  abort(): void;
+  abortWithStatus(code: number): void;
+  isAborted(): boolean;
+  // The next() of the middleware currently running: runs the rest of the
+  // chain and the handler, and rejects with whatever they throw. Code after
+  // it runs once the handler is done; not calling it ends the chain
+  next(): Promise<void>;
+  // The request body bytes as received, also when the body didn't parse; any
+  // number of middleware and handlers can read them. Empty without a body
+  peekBody(): Buffer;
+  // A trailer field sent after a chunked request body, '' when there is none.
+  // Under uWebSockets.js it is always '', since uWS drops trailers while
+  // decoding the body; app.handler() on a Node http server has them once the
+  // body has been read, which is before middleware runs
+  trailer(name: string): string;
   headers: Record<string, string>;
   cookies: Record<string, string>;
   session?: Record<string, any>;
   user?: any;
   state: Record<string, any>;
+
+  // Request method in upper case, e.g. "POST". Unlike ctx.req, which uWS
+  // invalidates once the body has been read, it stays valid
+  readonly method: string;
+
+  // Request path without the query string, and with it as requested
+  path(): string;
+  url(): string;
+
+  // Client address; X-Forwarded-For is only used when the peer is a trusted proxy
+  readonly ip: string;
+
+  // Route that matched this request, null when nothing matched
+  route: RouteInfo | null;
   
-  // Status code accessor
+  // Request header shortcuts ('' when absent). host() and protocol() honour
+  // X-Forwarded-Host/-Proto only when the peer is a trusted proxy
+  userAgent(): string;
+  referer(): string;
+  host(): string;
+  protocol(): 'http' | 'https';
+  secure(): boolean;
+
+  // Entity tags from If-Match / If-None-Match as sent, e.g. ['"v3"'] or ['*'];
+  // [] when absent. Compare them with etagMatches()
+  ifMatch(): string[];
+  ifNoneMatch(): string[];
+  // Set Last-Modified, and answer a GET or HEAD with 304 right away when the
+  // client's If-Modified-Since is not older (to the second). Returns true
+  // then; the handler's own response is dropped without a warning
+  lastModified(time: Date | number): boolean;
+
+  // Every value of a query parameter, in request order ([] when absent)
+  queryArray(name: string): string[];
+
+  // Path parameters in the order the route declares them
+  allParams(): Array<{ name: string; value: string }>;
+  // A path parameter as an integer, undefined when missing or not an integer
+  paramInt(name: string): number | undefined;
+  // A path parameter as a UUID in canonical lower-case form; throws a
+  // ParamBindError (400) when it is missing or not a UUID
+  paramUUID(name: string): string;
+
+  // Status that will be (or was) sent
   readonly statusCode: number;
+
+  // Request body bytes received and response body bytes sent so far
+  readonly bytesRead: number;
+  readonly bytesWritten: number;
+  // Inflated bytes per byte sent for a compressed request body, e.g. 4.2;
+  // undefined when the body wasn't decompressed
+  readonly compressionRatio: number | undefined;
+
+  // True once status and headers have been sent; after that status(),
+  // header() and further body writes are ignored with a warning
+  readonly committed: boolean;
+
+  // Aborted when the client disconnects or, with requestTimeout, at the
+  // deadline; pass it to fetch() and other cancellable work. Tell the two
+  // apart with clientDisconnected() or signal.reason
+  readonly signal: AbortSignal;
+  // Whether the client has gone; a cheap check for loops that can't take a
+  // signal. Only disconnects the socket has noticed are seen
+  clientDisconnected(): boolean;
+  // When the requestTimeout budget runs out (undefined without one), and the
+  // ms left until then (Infinity without one, 0 once passed). signal aborts
+  // at the deadline with a DeadlineExceededError
+  deadline(): Date | undefined;
+  remainingTime(): number;
+  // Run callback once the request is finished, after the response hooks.
+  // statusCode is final by then, also for errors Qera answered after the
+  // chain threw. Errors thrown by the callback are logged and ignored
+  onFinish(callback: () => void | Promise<void>): void;
   
   // Response methods
   status(code: number): QeraContext;
+  // Adds the header; calling it again for the same name sends a second line
   header(key: string, value: string): QeraContext;
+  // Replace every existing value of each header
+  setHeaders(headers: Record<string, string>): QeraContext;
+  // Add another line for the header, e.g. Set-Cookie or Link, never comma-joined
+  appendHeader(key: string, value: string): QeraContext;
+  deleteHeader(key: string): QeraContext;
+  // Close the connection once this response is sent (uWS adds Connection: close)
+  setConnectionClose(): QeraContext;
+  // Add a Server-Timing entry (duration in ms) shown in browser devtools;
+  // every call adds one, all sent in a single header with the response
+  serverTiming(metric: string, duration?: number, description?: string): QeraContext;
+  // Replace Cache-Control with the directives, e.g. ('public', 'max-age=60', 'immutable')
+  cacheControl(...directives: string[]): QeraContext;
+  // Never store or reuse the response; also sets Pragma and Expires for old caches
+  noCache(): QeraContext;
+  // Cacheable by browsers and shared caches such as CDNs for maxAge, in
+  // seconds or a duration string like "1h"
+  cachePublic(maxAge: number | string): QeraContext;
+  // Cacheable by the browser only, e.g. for per-user pages; maxAge as for cachePublic()
+  cachePrivate(maxAge: number | string): QeraContext;
   json(data: any): void;
+  // JSON with explicit nulls or renamed keys, e.g. { nulls: 'emit', keys: 'snake_case' }
+  jsonWithOptions(data: any, options: JSONOptions): void;
+  // JSON wrapped in a callback named by the callback query param (or the
+  // given name). Falls back to plain JSON without one; invalid names get a 400
+  jsonp(data: any, callback?: string): void;
+  // XML with a declaration, from an object whose one key is the root element;
+  // "@name" keys are attributes and "#text" is text, as XML bodies are parsed
+  xml(data: Record<string, any>): void;
+  // Encode with the configured msgpack codec, sent as application/msgpack
+  msgpack(data: any): void;
+  // Without a Content-Type header, the type is detected from the first 512
+  // bytes (see sniffContentType)
   send(body: string | Buffer | ArrayBuffer): void;
+  // Plain text (text/plain; charset=utf-8) without sniffing. The string goes
+  // to the socket as it is, encoded once there rather than copied to a Buffer first
+  sendString(text: string): void;
+  // Raw bytes (application/octet-stream) without sniffing. A Uint8Array or
+  // ArrayBuffer is wrapped rather than copied, so don't change it until the call returns
+  sendBytes(bytes: Buffer | Uint8Array | ArrayBuffer): void;
+  // Set the status and send its reason phrase as the body, e.g. "Not Found";
+  // 204 and 304 are sent without a body
+  sendStatus(code: number): void;
+  // Streamed responses: the first write() sends the status and headers, end()
+  // finishes. write() waits out backpressure and rejects with
+  // ConnectionClosedError once the client has disconnected
+  write(chunk: string | Buffer): Promise<void>;
+  end(chunk?: string | Buffer): void;
+  // Compress the body this response sends (br, gzip or deflate, as the
+  // client accepts), or not with false. Whole bodies are compressed at once;
+  // write() and stream() compress as they go, without a Content-Length
+  compressResponse(enabled?: boolean): QeraContext;
   redirect(url: string, status?: number): void;
+  // 412 for a conditional request whose If-Match didn't match
+  preconditionFailed(): void;
   cookie(name: string, value: string, options?: CookieOptions): QeraContext;
   clearCookie(name: string, options?: CookieOptions): QeraContext;
+  streamJSONArray<T = any>(source: JSONArraySource<T>): Promise<void>;
+  // Copy a Node/web stream or (async) iterable of chunks to the response.
+  // Rejects with the source's error or ConnectionClosedError on disconnect
+  stream(contentType: string, source: BodySource): Promise<void>;
+  // Write the request body to a duplex upstream such as a net.Socket and
+  // stream its output back as the response, e.g. for event streams and long
+  // polls. Rejects with the upstream's error, or ConnectionClosedError on
+  // disconnect, which also destroys the upstream
+  pipe(upstream: Duplex, options?: PipeOptions): Promise<void>;
+  // Ask the browser to save the response, as filename if given
+  attachment(filename?: string): QeraContext;
+  // Stream a CSV download built from rows as they are read. Once output has
+  // started a failing source is logged and the connection cut, since the
+  // status is already out; before that it rejects as usual
+  csv(filename: string, header: string[] | null, rows: JSONArraySource<unknown[]>): Promise<void>;
+  // Stream a ZIP download, compressing each entry as it is read; errors as for csv()
+  zip(filename: string, entries: ZipSource): Promise<void>;
+  // Server-sent events from a source such as an EventBroker subscription,
+  // until it ends or the client disconnects (ConnectionClosedError)
+  sse(source: SSESource): Promise<void>;
+
+  // Content negotiation: each returns the preferred offer, or '' if none is acceptable
+  accepts(...types: string[]): string;
+  acceptsCharsets(...charsets: string[]): string;
+  acceptsEncodings(...encodings: string[]): string;
+  acceptsLanguages(...languages: string[]): string;
   
   // Utility methods
+  // Stable SHA-256 key for caching, idempotency or single-flight: method, path
+  // and query sorted by name by default; options add headers or the body
+  fingerprint(options?: FingerprintOptions): string;
+  // The values fingerprint() hashes, to adjust before calling hashFingerprint()
+  fingerprintParts(options?: FingerprintOptions): FingerprintParts;
+  // Readable request dump for debug logs: request line, headers (credentials
+  // redacted) and the body, truncated
+  dump(options?: DumpOptions): string;
+  // Whether a feature flag is on for this request, asked of the app's
+  // featureProvider once per flag and request; a failing provider counts as off
+  feature(name: string): Promise<boolean>;
+  // page/size or limit/offset from the query, clamped; defaults override the
+  // app's pagination config
+  pagination(defaults?: PageDefaults): Page;
+  // Set a Link header with first/prev/next/last links for page; last (and
+  // the end of next links) needs the total item count
+  pageLinks(page: Page, total?: number): QeraContext;
+  // Send one page of a list as JSON in the app's pageEnvelope, by default
+  // { data, meta: { total, page, size, totalPages } }, with pageLinks() and,
+  // when total is known, X-Total-Count
+  paginated(data: unknown, page: Page, total?: number): void;
   validate<T>(schema: QeraSchema<T>): T;
   validateQuery<T>(schema: QeraSchema<T>): T;
+  // Path and query params in one object, converted to the schema's types; a
+  // path param wins over a query param of the same name. Throws
+  // ParamBindError (400) for values that don't convert, QeraValidationError
+  // (422) for invalid ones
+  bindURI<T>(schema: QeraSchema<T>): T;
+  // The urlencoded or multipart body as the object schema describes, with
+  // nested ("address.city", "address[city]") and list ("tags[]",
+  // "items[0][name]") field names. Throws FormBindError (400) listing names
+  // that can't be placed, ParamBindError (400) for values that don't convert
+  // and QeraValidationError (422) for invalid ones
+  bindForm<T>(schema: QeraSchema<T>): T;
+  // Throws BindError (400) for malformed or non-object bodies, QeraValidationError (422) for invalid ones.
+  // Without a schema, the route's request schema is used
+  bindAndValidate<T>(schema?: QeraSchema<T>): T;
+  // bindAndValidate() that answers a bad body itself, with the 400 or 422 Qera
+  // sends for the error, and returns undefined; the handler then just returns
+  mustBind<T>(schema?: QeraSchema<T>): T | undefined;
+  // The status and message app.mapError() registered for error, if any;
+  // errorHandler() and safe() answer with it
+  errorMapping(error: unknown): MappedError | undefined;
+  // End the request from anywhere in the handler's call tree: throws a
+  // RequestFailedError, which Qera answers with status and message (default
+  // the reason phrase) without logging it. Never returns
+  fail(status: number, message?: string): never;
   encrypt(data: string): string;
   decrypt(data: string): string;
   signJwt(payload: any, options?: JwtOptions): string;
@@ -58,11 +282,103 @@ export type RouteHandler = (context: QeraContext) => void | Promise<void>;
 // Middleware type
 export type Middleware = (context: QeraContext, next: () => Promise<void>) => void | Promise<void>;
 
+// Route that handled a request, identified by its registered pattern. Label
+// metrics, logs and traces with path rather than the request path, which
+// has a value per user, order, etc.
+export interface RouteInfo {
+  method: string; // e.g. "GET", or "ANY" for app.any() routes
+  path: string;   // the pattern, e.g. "/users/:id"
+  methods: string[]; // every method registered for the pattern, e.g. ["GET", "PUT"]
+  name?: string; // the route's name option
+  options?: RouteOptions; // what the route was registered with
+  prefix?: string; // for app.mount() routes, the mounted prefix
+  host?: string; // for app.host() routes, the host pattern, e.g. "*.example.com"
+  metrics(): RouteMetrics; // the route's counts so far
+}
+
+// Responses a route has finished since the app started or
+// app.resetRouteMetrics(), read as a snapshot
+export interface RouteMetrics {
+  requests: number; // every response, whatever its status
+  clientErrors: number; // 4xx responses
+  errors: number; // 5xx responses, failed handlers included
+}
+
+// One route's counts as app.routeMetrics() lists them
+export interface RouteMetricsEntry extends RouteMetrics {
+  method: string;
+  path: string;
+  host?: string;
+}
+
+// HTTP connections since the app started, as app.connectionStats() reads them
+export interface ConnectionStats {
+  opened: number; // requests that came on a new connection
+  reused: number; // requests that came on a kept-alive connection
+  open: number; // connections open now, as far as Qera can tell
+}
+
+// One route for app.addRoutes(), e.g. from a module's exported list
+export interface RouteDef {
+  method: string; // "GET", "POST", ... or "ANY", in any case
+  path: string;
+  handler: RouteHandler;
+  name?: string; // the name option, e.g. "users.show"
+  middleware?: Middleware[]; // runs after the app middleware, before the handler
+  options?: RouteOptions;
+}
+
+// Per-route settings, passed after the handler: app.post(path, handler, options)
+export interface RouteOptions {
+  // Identifies the route in qera.route.name, e.g. "users.show"; must be unique
+  name?: string;
+  // Overrides the global bodyLimit for this route, e.g. "50mb" or bytes
+  maxBodySize?: string | number;
+  // Overrides the timeout() middleware's limit for this route, in ms (0 disables it)
+  timeout?: number;
+  // Overrides the global bodyTimeout for this route, in ms (0 disables it)
+  bodyTimeout?: number;
+  // Merged over the global multipart limits; maxBodySize here also beats the
+  // global maxTotalSize
+  multipart?: MultipartLimits;
+  // Overrides the global decompressRequests for this route; false leaves bodies compressed
+  decompressRequests?: boolean | DecompressionLimits;
+  // Overrides the global sniffContentType for qera.send() on this route
+  sniffContentType?: boolean;
+  // false sends qera.json() bodies on this route without the responseTransformer
+  transformResponse?: boolean;
+  // Documented request body, and the default schema for ctx.bindAndValidate()
+  request?: QeraSchema;
+  // Documented response bodies by status; JSON responses are checked against
+  // them unless validateResponses is off
+  responses?: Record<number, QeraSchema>;
+}
+
+// A route as listed by app.routeDocs(), schemas converted to JSON Schema
+export interface RouteDoc {
+  method: string;
+  path: string;
+  host?: string;
+  request?: JSONSchema;
+  responses?: Record<number, JSONSchema>;
+}
+
+// Lifecycle hooks (observers only, they can't change the response)
+export type RequestHook = (context: QeraContext) => void | Promise<void>;
+export type ResponseHook = (context: QeraContext, route: RouteInfo | null) => void | Promise<void>;
+export type ErrorHook = (context: QeraContext, error: unknown) => void | Promise<void>;
+export type WebSocketErrorHook = (context: QeraWebSocketContext, error: unknown) => void | Promise<void>;
+
 // WebSocket interface
 export interface QeraWebSocketContext {
   ws: WebSocket<any>;
   params: Record<string, string>;
   query: Record<string, string | string[]>;
+  // qera.state as middleware left it before the upgrade, kept for the whole
+  // connection, so handlers can store their own values in it too
+  state: Record<string, any>;
+  // qera.user as authentication middleware set it before the upgrade
+  user?: any;
   send(message: string | ArrayBuffer | Buffer): boolean;
   close(code?: number, message?: string): void;
   subscribe(topic: string): void;
@@ -80,7 +396,17 @@ export type WebSocketHandler = {
   drain?: (ctx: QeraWebSocketContext) => void | Promise<void>;
 };
 
+// Decides feature flags for qera.feature(), e.g. by wrapping a flag SDK's
+// client; the context gives user-based flags the user, headers and the like
+export type FeatureProvider = (name: string, ctx: QeraContext) => boolean | Promise<boolean>;
+
 // Configuration types
+// A msgpack implementation, e.g. { encode, decode } from @msgpack/msgpack
+export interface MsgPackCodec {
+  encode(value: any): Uint8Array;
+  decode(data: Uint8Array): any;
+}
+
 export interface QeraConfig {
   port?: number;
   host?: string;
@@ -103,6 +429,30 @@ export interface QeraConfig {
   };
   compression?: boolean;
   bodyLimit?: string | number; // e.g., "1mb" or bytes
+  bodyTimeout?: number; // ms to receive and parse a request body, answered with 408 when exceeded (default 300000)
+  writeTimeout?: number; // ms a streamed response waits for a client that reads nothing, then the connection closes (default 30000)
+  requestTimeout?: number; // ms budget per request, from its start; the timeout route option overrides it
+  multipart?: MultipartLimits; // parts, file and total size limits for form uploads, answered with 413
+  decompressRequests?: boolean | DecompressionLimits; // inflate gzip, deflate and br bodies; maxRatio defaults to 100, answered with 400
+  trustProxy?: boolean | string[]; // peers allowed to set X-Forwarded-* headers
+  unescapePath?: boolean; // percent-decode route params, e.g. "john%20doe" to "john doe" (default true)
+  defaultHeaders?: Record<string, string>; // sent with every response, handlers can override them
+  disableServerHeader?: boolean; // omit the default "Server: Qera" header
+  msgpack?: MsgPackCodec; // enables msgpack request bodies and qera.msgpack()
+  featureProvider?: FeatureProvider; // decides flags for qera.feature()
+  maxRequestsPerConnection?: number; // close keep-alive connections after this many requests
+  maxURILength?: number; // bytes of path and query string, answered with 414 when exceeded (default 8192, 0 for no limit)
+  maxResponseHeaders?: number; // headers one response may carry, default 100 (0 for no limit); throws outside production, else logs and drops the rest
+  maxResponseHeaderSize?: string | number; // bytes of header names and values in one response, default "64kb"; handled as maxResponseHeaders
+  json?: JSONOptions; // applied by qera.json() to every response
+  responseTransformer?: (data: any, ctx: QeraContext) => any; // replaces qera.json() bodies of responses below 400, e.g. to wrap them in an envelope
+  validateResponses?: boolean; // warn about JSON responses not matching route schemas; default off in production
+  errorContentType?: ErrorContentType; // format of the default 404/405/500 and other error responses, default 'json'
+  negotiateErrors?: boolean; // let the Accept header pick the error format, falling back to errorContentType
+  sniffContentType?: boolean; // qera.send() without a Content-Type detects one (default), or sends application/octet-stream
+  pagination?: PageDefaults; // defaults for qera.pagination(): page size and its limits
+  pageEnvelope?: PageEnvelope; // shapes qera.paginated() bodies from the data and page meta
+  record?: RecordOptions; // write requests and responses to disk as fixtures for app.replay()
   session?: {
     secret: string;
     name?: string;
@@ -119,6 +469,10 @@ export interface QeraConfig {
     root: string;
     prefix?: string;
     cacheControl?: string;
+    index?: string; // file served for directory requests, default "index.html"
+    spaFallback?: string; // e.g. "index.html", served for unknown browser navigations
+    spaExclude?: string[]; // path prefixes that never fall back, default ["/api"]
+    fallThrough?: boolean; // missing files go on to the routes instead of a 404
   };
   rateLimit?: {
     max: number;
@@ -133,3 +487,38 @@ export interface QeraConfig {
     algorithm?: string;
   };
 }
+
+// Options for app.listenAutoTLS()
+// Record mode, see app.replay()
+export interface RecordOptions {
+  // Directory the fixtures are written to, created when missing
+  dir: string;
+  // Header names whose values are replaced with "[redacted]", replacing the
+  // default list (authorization, proxy-authorization, cookie, set-cookie)
+  redact?: string[];
+  // Stop recording after this many requests, default 1000
+  maxRequests?: number;
+  // Skip requests with larger bodies and truncate larger responses, default 64kb
+  maxBodySize?: number;
+}
+
+export interface ProfilingOptions {
+  // Runs before every profiling endpoint, e.g. jwtAuth() or an IP check
+  auth?: Middleware;
+  // Longest capture a request may ask for, in seconds (default 60)
+  maxSeconds?: number;
+}
+
+export interface AutoTLSOptions {
+  domains: string[];
+  // Must be true: agrees to the CA's terms of service (Let's Encrypt's by default)
+  acceptTerms: boolean;
+  // Where certificates and the ACME account key are kept between restarts
+  cacheDir: string;
+  email?: string; // contact for expiry notices from the CA
+  port?: number; // HTTPS port, default 443
+  httpPort?: number; // HTTP-01 challenges and redirects, default 80
+  host?: string; // default "0.0.0.0"
+  directoryUrl?: string; // ACME directory, default Let's Encrypt production
+  renewBefore?: number; // ms before expiry to renew, default 30 days
+}
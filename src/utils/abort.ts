@@ -0,0 +1,40 @@
+import { HttpResponse } from 'uWebSockets.js';
+
+/**
+ * Register a callback for when the client disconnects before the response is
+ * complete. uWS only keeps the last onAborted handler, so everything that
+ * needs to know about aborts goes through here instead of calling
+ * res.onAborted directly. Also sets res.aborted, which response helpers check
+ * before writing.
+ *
+ * Returns a function that removes the callback again, for listeners that
+ * only matter while something is pending, such as a wait for the socket to
+ * drain.
+ */
+export function onAborted(res: HttpResponse, listener: () => void): () => void {
+  if (res.aborted) {
+    listener();
+    return () => undefined;
+  }
+
+  if (!res.abortListeners) {
+    const listeners: Array<() => void> = [];
+    res.abortListeners = listeners;
+    res.onAborted(() => {
+      res.aborted = true;
+      // A copy, since listeners may remove themselves as they run
+      for (const abortListener of [...listeners]) {
+        abortListener();
+      }
+    });
+  }
+
+  const listeners: Array<() => void> = res.abortListeners;
+  listeners.push(listener);
+  return () => {
+    const index = listeners.indexOf(listener);
+    if (index !== -1) {
+      listeners.splice(index, 1);
+    }
+  };
+}
@@ -0,0 +1,293 @@
+import { createHash, createPrivateKey, createPublicKey, generateKeyPairSync, KeyObject, sign, X509Certificate } from 'crypto';
+import http from 'http';
+import https from 'https';
+
+export const LETS_ENCRYPT_DIRECTORY = 'https://acme-v02.api.letsencrypt.org/directory';
+
+export interface AcmeOptions {
+  // ACME directory URL, Let's Encrypt production by default
+  directoryUrl?: string;
+  // Contact address for expiry notices from the CA
+  email?: string;
+  // PEM account key; a new one is generated when missing
+  accountKey?: string;
+  // Answers HTTP-01 challenges: publish(token, keyAuthorization) is called
+  // before the CA is asked to check, unpublish(token) once it is done
+  publish: (token: string, keyAuthorization: string) => void;
+  unpublish: (token: string) => void;
+  // Poll interval while the CA validates and issues, default 2000ms
+  pollInterval?: number;
+  // Give up after this many polls, default 30
+  maxPolls?: number;
+}
+
+export interface AcmeCertificate {
+  // PEM chain, leaf first
+  cert: string;
+  // PEM private key of the certificate
+  key: string;
+  // PEM account key, to reuse for renewals
+  accountKey: string;
+}
+
+interface AcmeResponse {
+  status: number;
+  headers: http.IncomingHttpHeaders;
+  body: string;
+}
+
+export class AcmeError extends Error {
+  constructor(message: string, public readonly status?: number) {
+    super(message);
+    this.name = 'AcmeError';
+  }
+}
+
+const base64url = (data: Buffer | string) => Buffer.from(data).toString('base64url');
+
+function acmeRequest(method: string, url: string, body?: string): Promise<AcmeResponse> {
+  const target = new URL(url);
+  const transport = target.protocol === 'http:' ? http : https;
+  return new Promise((resolve, reject) => {
+    const req = transport.request(target, {
+      method,
+      headers: body === undefined ? {} : { 'Content-Type': 'application/jose+json' }
+    }, (res) => {
+      const chunks: Buffer[] = [];
+      res.on('data', chunk => chunks.push(chunk));
+      res.on('end', () => resolve({ status: res.statusCode || 0, headers: res.headers, body: Buffer.concat(chunks).toString() }));
+      res.on('error', reject);
+    });
+    req.on('error', reject);
+    req.end(body);
+  });
+}
+
+// DER encoding, only the types a certificate signing request needs
+function der(tag: number, content: Buffer): Buffer {
+  const length = content.length;
+  let header: Buffer;
+  if (length < 0x80) {
+    header = Buffer.from([tag, length]);
+  } else {
+    const bytes: number[] = [];
+    for (let rest = length; rest > 0; rest >>= 8) {
+      bytes.unshift(rest & 0xff);
+    }
+    header = Buffer.from([tag, 0x80 | bytes.length, ...bytes]);
+  }
+  return Buffer.concat([header, content]);
+}
+
+const sequence = (...items: Buffer[]) => der(0x30, Buffer.concat(items));
+const set = (...items: Buffer[]) => der(0x31, Buffer.concat(items));
+
+function oid(dotted: string): Buffer {
+  const [first, second, ...rest] = dotted.split('.').map(Number);
+  const bytes = [first * 40 + second];
+  for (const part of rest) {
+    const encoded = [part & 0x7f];
+    for (let value = part >> 7; value > 0; value >>= 7) {
+      encoded.unshift(0x80 | (value & 0x7f));
+    }
+    bytes.push(...encoded);
+  }
+  return der(0x06, Buffer.from(bytes));
+}
+
+/**
+ * A PKCS#10 certificate signing request for the domains, signed with key
+ * (EC P-256). The first domain is the common name; all of them go into the
+ * subjectAltName extension, which is what CAs actually check.
+ */
+export function createCSR(domains: string[], key: KeyObject): Buffer {
+  const subject = sequence(set(sequence(oid('2.5.4.3'), der(0x0c, Buffer.from(domains[0])))));
+  const publicKey = createPublicKey(key).export({ format: 'der', type: 'spki' });
+  const altNames = sequence(...domains.map(domain => der(0x82, Buffer.from(domain))));
+  const extensions = der(0xa0, sequence(
+    oid('1.2.840.113549.1.9.14'),
+    set(sequence(sequence(oid('2.5.29.17'), der(0x04, altNames))))
+  ));
+
+  const info = sequence(der(0x02, Buffer.from([0])), subject, publicKey, extensions);
+  const signature = sign('sha256', info, key);
+  // ecdsa-with-SHA256; the leading zero byte counts unused bits of the BIT STRING
+  return sequence(info, sequence(oid('1.2.840.10045.4.3.2')), der(0x03, Buffer.concat([Buffer.from([0]), signature])));
+}
+
+/**
+ * The RFC 7638 thumbprint of the account key, which every HTTP-01 key
+ * authorization ends with.
+ */
+export function jwkThumbprint(key: KeyObject): string {
+  const { crv, kty, x, y } = key.export({ format: 'jwk' });
+  return base64url(createHash('sha256').update(JSON.stringify({ crv, kty, x, y })).digest());
+}
+
+/**
+ * A minimal ACME (RFC 8555) client: registers or finds the account, orders
+ * a certificate for the domains, answers HTTP-01 challenges and downloads
+ * the issued chain. Requests are sent one at a time, each with a fresh nonce.
+ */
+class AcmeClient {
+  private directory: Record<string, string> = {};
+  private nonce?: string;
+  private kid?: string;
+  private readonly key: KeyObject;
+
+  constructor(private readonly options: AcmeOptions, key: KeyObject) {
+    this.key = key;
+  }
+
+  async obtain(domains: string[]): Promise<{ cert: string; key: string }> {
+    const directoryUrl = this.options.directoryUrl || LETS_ENCRYPT_DIRECTORY;
+    const directory = await acmeRequest('GET', directoryUrl);
+    if (directory.status !== 200) {
+      throw new AcmeError(`ACME directory ${directoryUrl} answered ${directory.status}`, directory.status);
+    }
+    this.directory = JSON.parse(directory.body);
+
+    const account = await this.post(this.directory.newAccount, {
+      termsOfServiceAgreed: true,
+      ...(this.options.email ? { contact: [`mailto:${this.options.email}`] } : {})
+    });
+    this.kid = account.headers.location as string;
+
+    const order = await this.post(this.directory.newOrder, {
+      identifiers: domains.map(value => ({ type: 'dns', value }))
+    });
+    const orderUrl = order.headers.location as string;
+    const { authorizations, finalize } = JSON.parse(order.body);
+
+    for (const url of authorizations as string[]) {
+      await this.authorize(url);
+    }
+
+    const certKey = generateKeyPairSync('ec', { namedCurve: 'P-256' }).privateKey;
+    await this.post(finalize, { csr: base64url(createCSR(domains, certKey)) });
+
+    const issued = await this.poll(orderUrl, 'valid');
+    const cert = await this.post(issued.certificate, undefined);
+    return { cert: cert.body, key: certKey.export({ format: 'pem', type: 'pkcs8' }) as string };
+  }
+
+  private async authorize(url: string) {
+    const authorization = JSON.parse((await this.post(url, undefined)).body);
+    if (authorization.status === 'valid') {
+      return;
+    }
+    const challenge = (authorization.challenges || []).find((item: any) => item.type === 'http-01');
+    if (!challenge) {
+      throw new AcmeError(`No http-01 challenge offered for ${authorization.identifier?.value}`);
+    }
+
+    const keyAuthorization = `${challenge.token}.${jwkThumbprint(this.key)}`;
+    this.options.publish(challenge.token, keyAuthorization);
+    try {
+      await this.post(challenge.url, {});
+      await this.poll(url, 'valid');
+    } finally {
+      this.options.unpublish(challenge.token);
+    }
+  }
+
+  // POST-as-GET a resource until it reaches status; "invalid" fails at once
+  private async poll(url: string, status: string): Promise<any> {
+    const maxPolls = this.options.maxPolls ?? 30;
+    for (let attempt = 0; attempt < maxPolls; attempt++) {
+      const resource = JSON.parse((await this.post(url, undefined)).body);
+      if (resource.status === status) {
+        return resource;
+      }
+      if (resource.status === 'invalid') {
+        const detail = resource.error?.detail
+          || resource.challenges?.find((item: any) => item.error)?.error?.detail
+          || 'validation failed';
+        throw new AcmeError(`ACME ${url} became invalid: ${detail}`);
+      }
+      await new Promise(resolve => setTimeout(resolve, this.options.pollInterval ?? 2000));
+    }
+    throw new AcmeError(`ACME ${url} did not become ${status} after ${maxPolls} polls`);
+  }
+
+  // A JWS-signed POST; payload undefined sends POST-as-GET
+  private async post(url: string, payload: unknown, retried = false): Promise<AcmeResponse> {
+    if (!this.nonce) {
+      this.nonce = (await acmeRequest('HEAD', this.directory.newNonce)).headers['replay-nonce'] as string;
+    }
+
+    const header: Record<string, unknown> = { alg: 'ES256', nonce: this.nonce, url };
+    if (this.kid) {
+      header.kid = this.kid;
+    } else {
+      const { crv, kty, x, y } = this.key.export({ format: 'jwk' });
+      header.jwk = { crv, kty, x, y };
+    }
+    const protectedHeader = base64url(JSON.stringify(header));
+    const encodedPayload = payload === undefined ? '' : base64url(JSON.stringify(payload));
+    const signature = sign('sha256', Buffer.from(`${protectedHeader}.${encodedPayload}`), {
+      key: this.key,
+      dsaEncoding: 'ieee-p1363'
+    });
+
+    const res = await acmeRequest('POST', url, JSON.stringify({
+      protected: protectedHeader,
+      payload: encodedPayload,
+      signature: base64url(signature)
+    }));
+    this.nonce = res.headers['replay-nonce'] as string | undefined;
+
+    if (res.status >= 400) {
+      const problem = safeParse(res.body);
+      // A stale nonce is expected now and then; the server sent a fresh one
+      if (problem?.type === 'urn:ietf:params:acme:error:badNonce' && !retried) {
+        return this.post(url, payload, true);
+      }
+      throw new AcmeError(`ACME ${url} answered ${res.status}: ${problem?.detail || res.body}`, res.status);
+    }
+    return res;
+  }
+}
+
+function safeParse(body: string): any {
+  try {
+    return JSON.parse(body);
+  } catch {
+    return undefined;
+  }
+}
+
+/**
+ * Obtain a certificate for domains from an ACME CA such as Let's Encrypt,
+ * proving control of them with HTTP-01 challenges. Passing options.accountKey
+ * reuses an existing account. By calling this you agree to the CA's terms of
+ * service.
+ */
+export async function obtainCertificate(domains: string[], options: AcmeOptions): Promise<AcmeCertificate> {
+  if (domains.length === 0) {
+    throw new AcmeError('At least one domain is required');
+  }
+  const key = options.accountKey
+    ? createPrivateKey(options.accountKey)
+    : generateKeyPairSync('ec', { namedCurve: 'P-256' }).privateKey;
+
+  const issued = await new AcmeClient(options, key).obtain(domains);
+  return { ...issued, accountKey: key.export({ format: 'pem', type: 'pkcs8' }) as string };
+}
+
+/**
+ * Whether a PEM certificate should be replaced: it is unreadable, doesn't
+ * cover every domain, or expires within renewBefore ms.
+ */
+export function certificateNeedsRenewal(pem: string, domains: string[], renewBefore: number, now = Date.now()): boolean {
+  try {
+    const cert = new X509Certificate(pem);
+    const names = (cert.subjectAltName || '').split(',').map(name => name.trim());
+    if (!domains.every(domain => names.includes(`DNS:${domain}`))) {
+      return true;
+    }
+    return new Date(cert.validTo).getTime() - now < renewBefore;
+  } catch {
+    return true;
+  }
+}
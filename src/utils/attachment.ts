@@ -0,0 +1,60 @@
+import { JSONArraySource, toIterator } from './stream';
+
+/**
+ * A Content-Disposition value telling browsers to save the response as
+ * filename. Names outside printable ASCII are sent in filename* (RFC 6266)
+ * with an ASCII fallback for old clients.
+ */
+export function contentDisposition(filename?: string): string {
+  if (!filename) return 'attachment';
+  const name = filename.replace(/^.*[\\/]/, '');
+  const fallback = name.replace(/[^\x20-\x7e]/g, '_').replace(/["\\]/g, '\\$&');
+  if (/^[\x20-\x7e]*$/.test(name)) {
+    return `attachment; filename="${fallback}"`;
+  }
+  const encoded = encodeURIComponent(name).replace(/['()*]/g, char => `%${char.charCodeAt(0).toString(16).toUpperCase()}`);
+  return `attachment; filename="${fallback}"; filename*=UTF-8''${encoded}`;
+}
+
+// Flush CSV output once it grows past this many characters
+const CSV_FLUSH_THRESHOLD = 16 * 1024;
+
+function csvField(value: unknown): string {
+  if (value === null || value === undefined) return '';
+  const text = value instanceof Date ? value.toISOString() : String(value);
+  return /[",\r\n]/.test(text) ? `"${text.replace(/"/g, '""')}"` : text;
+}
+
+// One RFC 4180 record: fields quoted only when they need it, CRLF-terminated
+export function formatCSVRow(values: unknown[]): string {
+  return `${values.map(csvField).join(',')}\r\n`;
+}
+
+/**
+ * CSV text for header (if any) and rows, in chunks of about 16KB so rows
+ * are never all held at once. The rows source is only read as the chunks
+ * are consumed.
+ */
+export async function* csvChunks(header: string[] | null, rows: JSONArraySource<unknown[]>): AsyncGenerator<string> {
+  const iterator = toIterator(rows);
+  let buffer = header ? formatCSVRow(header) : '';
+  let finished = false;
+
+  try {
+    for (;;) {
+      const result = await iterator.next();
+      if (result.done) break;
+      buffer += formatCSVRow(result.value);
+      if (buffer.length >= CSV_FLUSH_THRESHOLD) {
+        yield buffer;
+        buffer = '';
+      }
+    }
+    finished = true;
+    if (buffer) yield buffer;
+  } finally {
+    if (!finished) {
+      await Promise.resolve(iterator.return?.()).catch(() => undefined);
+    }
+  }
+}
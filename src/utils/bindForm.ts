@@ -0,0 +1,161 @@
+import { BindError } from './bodyParser';
+import { convertParam, expectedType } from './bindUri';
+import { JSONSchema, QeraSchema, QeraValidationError } from './validator';
+
+// Rejected by ctx.bindForm() when field names can't be placed in the bound
+// object, e.g. "address.city" next to a plain "address" field
+export class FormBindError extends BindError {
+  // Each unmappable field name with the reason, e.g. 'age.years: "age" is not an object'
+  fields: string[];
+
+  constructor(fields: string[]) {
+    super(`Unmappable form fields: ${fields.join('; ')}`);
+    this.fields = fields;
+    this.name = 'FormBindError';
+  }
+}
+
+// Highest array index a field name may use, so "tags[99999999]" can't
+// allocate a huge array
+const MAX_INDEX = 1000;
+
+// Names that would reach Object.prototype instead of a field
+const FORBIDDEN_KEYS = new Set(['__proto__', 'constructor', 'prototype']);
+
+const FIELD_NAME = /^([^.[\]]+)((?:\.[^.[\]]+|\[[^[\]]*\])*)$/;
+
+// "items[0].name" or "items[0][name]" as ['items', '0', 'name']; "tags[]"
+// ends in '' (append). null for malformed names
+function fieldPath(name: string): string[] | null {
+  const match = FIELD_NAME.exec(name);
+  if (!match) return null;
+  const path = [match[1]];
+  for (const [, dotted, bracketed] of match[2].matchAll(/\.([^.[\]]+)|\[([^[\]]*)\]/g)) {
+    path.push(dotted ?? bracketed);
+  }
+  return path;
+}
+
+// The branch of a nullable or union schema that describes a value
+function branch(schema: JSONSchema | undefined): JSONSchema | undefined {
+  if (!schema || typeof schema.type === 'string') return schema;
+  const type = expectedType(schema);
+  return (schema.anyOf as JSONSchema[] | undefined)?.find(option => expectedType(option) === type);
+}
+
+const isIndex = (key: string) => /^\d+$/.test(key);
+
+// Put one form field into target. Returns why it doesn't fit, or undefined
+function place(target: Record<string, unknown>, name: string, values: unknown[], schema: JSONSchema | undefined): string | undefined {
+  const path = fieldPath(name);
+  if (!path) return 'malformed name';
+  if (path.slice(0, -1).includes('')) return '"[]" can only end a name';
+
+  // The first n segments of the name, for messages
+  const prefix = (n: number) => `"${path.slice(0, n).join('.')}"`;
+
+  let node: any = target;
+  let nodeSchema = schema;
+  for (let i = 0; i < path.length; i++) {
+    const key = path[i];
+    const last = i === path.length - 1;
+    let childSchema: JSONSchema | undefined;
+
+    if (Array.isArray(node)) {
+      if (key !== '' && !isIndex(key)) return `${prefix(i)} is a list, "${key}" is not an index`;
+      if (key !== '' && Number(key) > MAX_INDEX) return `index ${key} is over ${MAX_INDEX}`;
+      childSchema = branch(nodeSchema?.items);
+    } else {
+      if (key === '') return `${prefix(i)} is not a list`;
+      if (FORBIDDEN_KEYS.has(key)) return `"${key}" is not allowed`;
+      const properties: Record<string, JSONSchema> | undefined = nodeSchema?.properties;
+      // Undeclared fields, such as a CSRF token or the submit button, are left out
+      if (properties && !Object.prototype.hasOwnProperty.call(properties, key)) return undefined;
+      childSchema = branch(properties?.[key]);
+    }
+    const index = key === '' ? node.length : key;
+    const type = expectedType(childSchema);
+
+    if (last) {
+      if (key === '') {
+        for (const value of values) {
+          node.push(convert(name, value, childSchema));
+        }
+      } else if (node[index] !== undefined) {
+        return `conflicts with another field for ${prefix(i + 1)}`;
+      } else if (type === 'array') {
+        const items = branch(childSchema!.items);
+        node[index] = values.map(value => convert(name, value, items));
+      } else if (type === 'object') {
+        return `${prefix(i + 1)} is an object, name its fields`;
+      } else {
+        node[index] = convert(name, values[0], childSchema);
+      }
+      return undefined;
+    }
+
+    // The container this field continues into
+    const next = path[i + 1];
+    const wantsList = type === 'array' || (type === undefined && (next === '' || isIndex(next)));
+    if (type !== undefined && type !== 'array' && type !== 'object') {
+      return `${prefix(i + 1)} is not an object`;
+    }
+    if (node[index] === undefined) {
+      node[index] = wantsList ? [] : {};
+    } else if (typeof node[index] !== 'object' || node[index] === null || Array.isArray(node[index]) !== wantsList) {
+      return `conflicts with another field for ${prefix(i + 1)}`;
+    }
+    node = node[index];
+    nodeSchema = childSchema;
+  }
+  return undefined;
+}
+
+// Form values are strings; uploaded files and JSON values pass through
+function convert(field: string, value: unknown, schema: JSONSchema | undefined): unknown {
+  return typeof value === 'string' ? convertParam(field, 'form', value, schema) : value;
+}
+
+// Arrays filled by index can have holes, e.g. items[0] and items[2]
+function compact(value: unknown): unknown {
+  if (Array.isArray(value)) {
+    return value.filter(() => true).map(compact);
+  }
+  if (typeof value === 'object' && value !== null && Object.getPrototypeOf(value) === Object.prototype) {
+    for (const [key, item] of Object.entries(value)) {
+      (value as Record<string, unknown>)[key] = compact(item);
+    }
+  }
+  return value;
+}
+
+/**
+ * Build the object schema describes from flat form fields, validate it and
+ * return it. Names nest with dots or brackets ("address.city",
+ * "address[city]"), index lists ("items[0][name]") or append to them
+ * ("tags[]"), and repeated names fill array properties. Values are
+ * converted to the declared types. Fields the schema doesn't declare are
+ * left out; names that can't be placed are all reported in one
+ * FormBindError.
+ */
+export function bindForm<T>(schema: QeraSchema<T>, form: Record<string, unknown>): T {
+  const root = branch(schema.toJSONSchema());
+  const data: Record<string, unknown> = {};
+  const problems: string[] = [];
+
+  for (const [name, value] of Object.entries(form)) {
+    const problem = place(data, name, Array.isArray(value) ? value : [value], root);
+    if (problem) {
+      problems.push(`${name}: ${problem}`);
+    }
+  }
+  if (problems.length > 0) {
+    throw new FormBindError(problems);
+  }
+
+  const result = schema.safeParse(compact(data));
+  if (!result.success) {
+    throw new QeraValidationError(result.error!.issues);
+  }
+  return result.data!;
+}
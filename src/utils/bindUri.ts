@@ -0,0 +1,90 @@
+import { BindError } from './bodyParser';
+import { JSONSchema, QeraSchema, QeraValidationError } from './validator';
+
+export type ParamSource = 'path' | 'query' | 'form';
+
+// Rejected by ctx.bindURI() and ctx.bindForm() when a value can't be
+// converted to the type its schema expects, e.g. "?page=two" for an integer
+export class ParamBindError extends BindError {
+  field: string;
+  source: ParamSource;
+  value: string;
+  expected: string;
+
+  constructor(field: string, source: ParamSource, value: string, expected: string) {
+    super(`Invalid ${source === 'form' ? 'form field' : `${source} parameter`} "${field}": expected ${expected}, received "${value}"`);
+    this.field = field;
+    this.source = source;
+    this.value = value;
+    this.expected = expected;
+    this.name = 'ParamBindError';
+  }
+}
+
+// The JSON type a property expects, looking through nullable and union wrappers
+export function expectedType(schema: JSONSchema | undefined): string | undefined {
+  if (!schema) return undefined;
+  if (typeof schema.type === 'string') return schema.type;
+  const options: JSONSchema[] = schema.anyOf || [];
+  return options.map(expectedType).find(type => type !== undefined && type !== 'null');
+}
+
+// A param as the type its schema expects; strings for anything but numbers and booleans
+export function convertParam(field: string, source: ParamSource, value: string, schema: JSONSchema | undefined): unknown {
+  switch (expectedType(schema)) {
+    case 'integer':
+      if (!/^[+-]?\d{1,15}$/.test(value.trim())) throw new ParamBindError(field, source, value, 'an integer');
+      return parseInt(value, 10);
+    case 'number': {
+      const number = Number(value);
+      if (value.trim() === '' || !Number.isFinite(number)) throw new ParamBindError(field, source, value, 'a number');
+      return number;
+    }
+    case 'boolean':
+      if (value === 'true' || value === '1') return true;
+      if (value === 'false' || value === '0') return false;
+      throw new ParamBindError(field, source, value, 'a boolean');
+    default:
+      return value;
+  }
+}
+
+/**
+ * Read the properties schema declares from path params and query params,
+ * convert them to the declared types and validate. A path param wins over
+ * a query param of the same name. Array properties collect every value of
+ * a repeated query param; undeclared query params are left out so they
+ * don't trip strict schemas.
+ */
+export function bindURI<T>(
+  schema: QeraSchema<T>,
+  params: Record<string, string>,
+  query: Array<[string, string]>
+): T {
+  const properties: Record<string, JSONSchema> | undefined = schema.toJSONSchema().properties;
+  const fields = properties ? Object.keys(properties) : [...new Set([...query.map(([key]) => key), ...Object.keys(params)])];
+  const data: Record<string, unknown> = {};
+
+  for (const field of fields) {
+    const property = properties?.[field];
+    const isArray = expectedType(property) === 'array';
+
+    if (Object.prototype.hasOwnProperty.call(params, field)) {
+      const value = convertParam(field, 'path', params[field], isArray ? property!.items : property);
+      data[field] = isArray ? [value] : value;
+      continue;
+    }
+
+    const values = query.filter(([key]) => key === field).map(([, value]) => value);
+    if (values.length === 0) continue;
+    data[field] = isArray
+      ? values.map(value => convertParam(field, 'query', value, property!.items))
+      : convertParam(field, 'query', values[0], property);
+  }
+
+  const result = schema.safeParse(data);
+  if (!result.success) {
+    throw new QeraValidationError(result.error!.issues);
+  }
+  return result.data!;
+}
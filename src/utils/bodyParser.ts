@@ -1,22 +1,258 @@
 import { HttpRequest, HttpResponse } from 'uWebSockets.js';
+import * as zlib from 'zlib';
+import { onAborted } from './abort';
+import { parseSize } from './config';
+import { parseXML } from './xml';
 
-export async function parseBody(req: HttpRequest, res: HttpResponse, limit?: string | number): Promise<any> {
+// Rejected when a request body exceeds the route's (or the global) limit
+export class PayloadTooLargeError extends Error {
+  statusCode = 413;
+  limit: number;
+
+  constructor(limit: number, message = 'Request body too large') {
+    super(message);
+    this.limit = limit;
+    this.name = 'PayloadTooLargeError';
+  }
+}
+
+// Rejected when a request body can't be parsed as its declared content type
+export class BindError extends Error {
+  statusCode = 400;
+
+  constructor(message: string) {
+    super(message);
+    this.name = 'BindError';
+  }
+}
+
+// Rejected when the body isn't read and parsed within the route's bodyTimeout
+export class BodyTimeoutError extends Error {
+  statusCode = 408;
+  timeout: number;
+
+  constructor(timeout: number) {
+    super(`Request body not received within ${timeout}ms`);
+    this.timeout = timeout;
+    this.name = 'BodyTimeoutError';
+  }
+}
+
+// Rejected when the client disconnects before its body has been read
+export class RequestAbortedError extends Error {
+  constructor() {
+    super('Request aborted');
+    this.name = 'RequestAbortedError';
+  }
+}
+
+// Rejected when a compressed request body inflates to more than maxRatio
+// times its size, as a zip bomb would
+export class CompressionRatioError extends Error {
+  statusCode = 400;
+  maxRatio: number;
+
+  constructor(maxRatio: number) {
+    super(`Request body expands more than ${maxRatio} times when decompressed`);
+    this.maxRatio = maxRatio;
+    this.name = 'CompressionRatioError';
+  }
+}
+
+// Rejected when a request body's Content-Encoding can't be decoded
+export class UnsupportedEncodingError extends Error {
+  statusCode = 415;
+  encoding: string;
+
+  constructor(encoding: string) {
+    super(`Unsupported Content-Encoding: ${encoding}`);
+    this.encoding = encoding;
+    this.name = 'UnsupportedEncodingError';
+  }
+}
+
+// Decodes a raw body of a media type the parser doesn't handle itself
+export type BodyDecoder = (body: Buffer) => any;
+
+export interface MultipartLimits {
+  // Parts in one form, fields and files together (default 1000)
+  maxParts?: number;
+  // Bytes in one file part, i.e. one with a filename (default unlimited)
+  maxFileSize?: string | number;
+  // Bytes in the whole form; replaces the global bodyLimit for multipart
+  // bodies, while a route's maxBodySize still wins (default bodyLimit)
+  maxTotalSize?: string | number;
+}
+
+export interface DecompressionLimits {
+  // Most bytes a compressed body may inflate to per byte sent (default 100)
+  maxRatio?: number;
+}
+
+const CRLF_CRLF = Buffer.from('\r\n\r\n');
+
+const INFLATE: Record<string, (buffer: Buffer, options: zlib.ZlibOptions & zlib.BrotliOptions) => Buffer> = {
+  gzip: zlib.gunzipSync,
+  'x-gzip': zlib.gunzipSync,
+  deflate: zlib.inflateSync,
+  br: zlib.brotliDecompressSync
+};
+
+/**
+ * Checks multipart limits on the body as it arrives, so a form with too
+ * many parts or an oversized file is refused without buffering the rest.
+ * Parts are found by their "\r\n--boundary" delimiters; the bytes
+ * between a part's headers and the next delimiter are its size. Search
+ * state carries over between chunks, so delimiters may be split anywhere.
+ */
+class MultipartLimiter {
+  private delimiter: Buffer;
+  // Unscanned bytes that may be the start of a delimiter or header end;
+  // the body is treated as starting with CRLF so the first delimiter matches
+  private pending = Buffer.from('\r\n');
+  private state: 'body' | 'headers' = 'body';
+  private headers = '';
+  private isFile = false;
+  private partSize = 0;
+  private parts = 0;
+
+  constructor(boundary: string, private maxParts: number, private maxFileSize: number) {
+    this.delimiter = Buffer.from(`\r\n--${boundary}`);
+  }
+
+  // Throws PayloadTooLargeError once a limit is exceeded
+  write(chunk: Buffer): void {
+    let data = this.pending.length > 0 ? Buffer.concat([this.pending, chunk]) : chunk;
+
+    for (;;) {
+      if (this.state === 'headers') {
+        const end = data.indexOf(CRLF_CRLF);
+        if (end === -1) {
+          this.headers += data.subarray(0, Math.max(0, data.length - 3)).toString('latin1');
+          this.pending = data.subarray(Math.max(0, data.length - 3));
+          return;
+        }
+        this.headers += data.subarray(0, end).toString('latin1');
+        if (++this.parts > this.maxParts) {
+          throw new PayloadTooLargeError(this.maxParts, `Too many multipart parts (limit ${this.maxParts})`);
+        }
+        this.isFile = /filename\*?=/i.test(this.headers);
+        this.headers = '';
+        this.partSize = 0;
+        this.state = 'body';
+        data = data.subarray(end + CRLF_CRLF.length);
+        continue;
+      }
+
+      const end = data.indexOf(this.delimiter);
+      const scanned = end === -1 ? Math.max(0, data.length - (this.delimiter.length - 1)) : end;
+      this.partSize += scanned;
+      if (this.isFile && this.partSize > this.maxFileSize) {
+        throw new PayloadTooLargeError(this.maxFileSize, `Multipart file larger than ${this.maxFileSize} bytes`);
+      }
+      if (end === -1) {
+        this.pending = data.subarray(scanned);
+        return;
+      }
+      // The closing delimiter is followed by "--" and an epilogue without headers
+      this.isFile = false;
+      this.state = 'headers';
+      data = data.subarray(end + this.delimiter.length);
+    }
+  }
+}
+
+/**
+ * Read and parse a request body. decoders maps media types (without
+ * parameters, e.g. "application/msgpack") to decoders that take precedence
+ * over the built-in JSON, form and text handling.
+ *
+ * Reading stops as soon as the client disconnects (RequestAbortedError) or
+ * timeout ms pass (BodyTimeoutError); the deadline also covers parsing, which
+ * checks it between multipart parts. Multipart bodies are held to their
+ * limits while they arrive, maxTotalSize in place of limit.
+ *
+ * With decompression, gzip, deflate and br bodies are inflated before they
+ * are parsed. limit then applies to the body both as sent and as inflated,
+ * and inflating stops as soon as either it or maxRatio is crossed, so a
+ * small zip bomb never gets to fill memory. The observed ratio is kept as
+ * res.compressionRatio.
+ */
+export async function parseBody(
+  req: HttpRequest,
+  res: HttpResponse,
+  limit?: string | number,
+  decoders: Record<string, BodyDecoder> = {},
+  timeout?: number,
+  multipart: MultipartLimits = {},
+  decompression?: DecompressionLimits
+): Promise<any> {
   const contentType = req.getHeader('content-type');
-  const bufferLimit = parseLimit(limit || '1mb');
+  const contentLength = req.getHeader('content-length');
+  const contentEncoding = decompression ? req.getHeader('content-encoding').trim().toLowerCase() : '';
+  const compressed = contentEncoding !== '' && contentEncoding !== 'identity';
+  const boundary = multipartBoundary(contentType);
+  const bufferLimit = parseLimit((boundary !== undefined && multipart.maxTotalSize) || limit || '1mb');
+  const limiter = boundary === undefined ? undefined : new MultipartLimiter(
+    boundary,
+    multipart.maxParts ?? 1000,
+    multipart.maxFileSize === undefined ? Infinity : parseLimit(multipart.maxFileSize)
+  );
+  const deadline = timeout ? Date.now() + timeout : undefined;
+
+  const checkDeadline = () => {
+    if (deadline !== undefined && Date.now() > deadline) {
+      throw new BodyTimeoutError(timeout!);
+    }
+  };
 
   return new Promise((resolve, reject) => {
     let buffer: Buffer;
     let offset = 0;
     let aborted = false;
+    let timer: NodeJS.Timeout | undefined;
 
-    res.onAborted(() => {
+    const fail = (error: Error) => {
       aborted = true;
-      reject(new Error('Request aborted'));
-    });
+      clearTimeout(timer);
+      reject(error);
+    };
+
+    onAborted(res, () => fail(new RequestAbortedError()));
+
+    if (compressed && !Object.prototype.hasOwnProperty.call(INFLATE, contentEncoding)) {
+      fail(new UnsupportedEncodingError(contentEncoding));
+      return;
+    }
+
+    // Refuse declared oversized bodies without reading them
+    if (contentLength && parseInt(contentLength, 10) > bufferLimit) {
+      fail(new PayloadTooLargeError(bufferLimit));
+      return;
+    }
+
+    if (timeout) {
+      timer = setTimeout(() => fail(new BodyTimeoutError(timeout)), timeout);
+    }
 
     res.onData((chunk, isLast) => {
       if (aborted) return;
       const chunkBuffer = Buffer.from(chunk);
+      // Read back as ctx.bytesRead
+      res.bytesRead = (res.bytesRead || 0) + chunkBuffer.length;
+
+      // Chunked bodies don't declare a length, so count as they arrive
+      if (offset + chunkBuffer.length > bufferLimit) {
+        fail(new PayloadTooLargeError(bufferLimit));
+        return;
+      }
+      // A compressed form is checked once it has been inflated
+      try {
+        if (!compressed) limiter?.write(chunkBuffer);
+      } catch (error) {
+        fail(error as Error);
+        return;
+      }
 
       // Initialize or expand the buffer
       if (!buffer) {
@@ -24,13 +260,6 @@ export async function parseBody(req: HttpRequest, res: HttpResponse, limit?: str
         chunkBuffer.copy(buffer);
         offset = chunkBuffer.length;
       } else {
-        // Check size limit
-        if (offset + chunkBuffer.length > bufferLimit) {
-          aborted = true;
-          reject(new Error('Request body too large'));
-          return;
-        }
-
         // Expand buffer to fit new chunk
         const newBuffer = Buffer.allocUnsafe(offset + chunkBuffer.length);
         buffer.copy(newBuffer, 0, 0, offset);
@@ -41,38 +270,83 @@ export async function parseBody(req: HttpRequest, res: HttpResponse, limit?: str
 
       // If this is the last chunk, parse and resolve
       if (isLast) {
+        clearTimeout(timer);
+        // Kept for handlers that need the bytes as sent, e.g. fromNodeHandler()
+        res.rawBody = buffer.subarray(0, offset);
+        let decoded: Buffer = res.rawBody;
+        if (compressed && offset > 0) {
+          try {
+            decoded = inflate(res.rawBody, contentEncoding, bufferLimit, decompression!.maxRatio ?? 100);
+            res.compressionRatio = decoded.length / offset;
+            limiter?.write(decoded);
+          } catch (error) {
+            reject(error instanceof CompressionRatioError || error instanceof PayloadTooLargeError
+              ? error
+              : new BindError(`Malformed request body: invalid ${contentEncoding} data`));
+            return;
+          }
+        }
         try {
-          const body = parseBufferByContentType(buffer.slice(0, offset), contentType);
+          const body = parseBufferByContentType(decoded, contentType, decoders, checkDeadline);
           resolve(body);
         } catch (error) {
-          reject(error);
+          reject(error instanceof BodyTimeoutError
+            ? error
+            : new BindError(`Malformed request body: ${error instanceof Error ? error.message : error}`));
         }
       }
     });
   });
 }
 
-function parseBufferByContentType(buffer: Buffer, contentType: string): any {
+// Inflate a body, stopping at whichever of limit and maxRatio comes first
+function inflate(body: Buffer, encoding: string, limit: number, maxRatio: number): Buffer {
+  const ratioLimit = Math.floor(body.length * maxRatio);
+  try {
+    return INFLATE[encoding](body, { maxOutputLength: Math.max(1, Math.min(limit, ratioLimit)) });
+  } catch (error) {
+    if ((error as NodeJS.ErrnoException).code !== 'ERR_BUFFER_TOO_LARGE') throw error;
+    throw ratioLimit < limit ? new CompressionRatioError(maxRatio) : new PayloadTooLargeError(limit);
+  }
+}
+
+function parseBufferByContentType(
+  buffer: Buffer,
+  contentType: string,
+  decoders: Record<string, BodyDecoder>,
+  checkDeadline: () => void = () => undefined
+): any {
   if (buffer.length === 0) {
     return {};
   }
   
-  const type = contentType.split(';')[0].toLowerCase();
+  const type = contentType.split(';')[0].trim().toLowerCase();
   
-  if (type === 'application/json') {
+  if (Object.prototype.hasOwnProperty.call(decoders, type)) {
+    return decoders[type](buffer);
+  } else if (type === 'application/json') {
     return JSON.parse(buffer.toString());
+  } else if (type === 'application/xml' || type === 'text/xml' || type.endsWith('+xml')) {
+    return parseXML(buffer.toString());
   } else if (type === 'application/x-www-form-urlencoded') {
     return parseUrlEncoded(buffer.toString());
   } else if (type.startsWith('multipart/form-data')) {
     // Basic multipart form handling - in a real implementation this would be more robust
     const boundary = contentType.split('boundary=')[1];
-    return parseMultipart(buffer, boundary);
+    return parseMultipart(buffer, boundary, checkDeadline);
   } else {
     // For plain text and other formats, just return the string
     return buffer.toString();
   }
 }
 
+// The boundary of a multipart/form-data body, quoted or not
+function multipartBoundary(contentType: string): string | undefined {
+  if (!/^\s*multipart\/form-data\s*;/i.test(contentType)) return undefined;
+  const match = /;\s*boundary=(?:"([^"]+)"|([^;\s]+))/i.exec(contentType);
+  return match ? match[1] ?? match[2] : undefined;
+}
+
 function parseUrlEncoded(str: string): Record<string, string | string[]> {
   const result: Record<string, string | string[]> = {};
   
@@ -97,7 +371,7 @@ function parseUrlEncoded(str: string): Record<string, string | string[]> {
   return result;
 }
 
-function parseMultipart(buffer: Buffer, boundary: string): Record<string, any> {
+function parseMultipart(buffer: Buffer, boundary: string, checkDeadline: () => void): Record<string, any> {
   // This is a simplified implementation
   // A real implementation would need to handle file uploads properly
   const result: Record<string, any> = {};
@@ -108,6 +382,8 @@ function parseMultipart(buffer: Buffer, boundary: string): Record<string, any> {
   
   // Process each part
   for (let i = 1; i < parts.length - 1; i++) {
+    // Forms with many parts can take a while; stop once the deadline has passed
+    checkDeadline();
     const part = parts[i];
     const headerBodySplit = part.indexOf('\r\n\r\n');
     
@@ -127,25 +403,16 @@ function parseMultipart(buffer: Buffer, boundary: string): Record<string, any> {
   return result;
 }
 
+// Sizes are read like configFromEnv() reads them, but a malformed limit
+// falls back to 1MB instead of failing every request
 function parseLimit(limit: string | number): number {
   if (typeof limit === 'number') {
     return limit;
   }
-  
-  const units: Record<string, number> = {
-    b: 1,
-    kb: 1024,
-    mb: 1024 * 1024,
-    gb: 1024 * 1024 * 1024
-  };
-  
-  const match = limit.toLowerCase().match(/^(\d+(?:\.\d+)?)([a-z]{1,2})$/);
-  if (!match) {
-    return 1048576; // Default to 1MB
+
+  try {
+    return parseSize(limit);
+  } catch {
+    return 1048576;
   }
-  
-  const size = parseFloat(match[1]);
-  const unit = match[2];
-  
-  return size * (units[unit] || units.b);
 }
@@ -0,0 +1,115 @@
+import { Readable, Transform, pipeline } from 'stream';
+import {
+  brotliCompressSync,
+  constants,
+  createBrotliCompress,
+  createDeflate,
+  createGzip,
+  deflateSync,
+  gzipSync
+} from 'zlib';
+import { acceptsEncoding } from './negotiation';
+import { BodySource, closeSource } from './stream';
+
+export type ContentEncoding = 'br' | 'gzip' | 'deflate';
+
+// Brotli's default quality (11) is meant for precompressing files; 4 is
+// about as fast as gzip and still compresses better
+const BROTLI_OPTIONS = { params: { [constants.BROTLI_PARAM_QUALITY]: 4 } };
+
+/**
+ * The encoding to compress a response with for this Accept-Encoding header,
+ * or undefined to send it as is. Without the header nothing is compressed,
+ * as clients that don't send it rarely expect compressed bodies.
+ */
+export function responseEncoding(acceptEncoding: string | undefined): ContentEncoding | undefined {
+  if (!acceptEncoding) return undefined;
+  const encoding = acceptsEncoding(acceptEncoding, ['br', 'gzip', 'deflate', 'identity']);
+  return encoding === 'identity' || encoding === '' ? undefined : encoding as ContentEncoding;
+}
+
+// A whole body, compressed in one go
+export function compressSync(encoding: ContentEncoding, body: string | Buffer): Buffer {
+  switch (encoding) {
+    case 'br':
+      return brotliCompressSync(body, BROTLI_OPTIONS);
+    case 'gzip':
+      return gzipSync(body);
+    case 'deflate':
+      return deflateSync(body);
+  }
+}
+
+export function createCompressor(encoding: ContentEncoding): Transform {
+  switch (encoding) {
+    case 'br':
+      return createBrotliCompress(BROTLI_OPTIONS);
+    case 'gzip':
+      return createGzip();
+    case 'deflate':
+      return createDeflate();
+  }
+}
+
+// A body source compressed as it is read; a failing source fails the output
+export async function* compressChunks(encoding: ContentEncoding, source: BodySource): AsyncGenerator<Buffer> {
+  const compressor = createCompressor(encoding);
+  // Errors reach the loop below, which reads from the destroyed compressor
+  pipeline(Readable.from(source), compressor, () => undefined);
+  try {
+    for await (const chunk of compressor) {
+      yield chunk;
+    }
+  } finally {
+    compressor.destroy();
+    closeSource(source);
+  }
+}
+
+/**
+ * Compression for a response written piece by piece. Each write() is
+ * flushed right away, so the client can decode everything written so far,
+ * which streaming endpoints rely on. Flushing costs some compression, so
+ * fewer, larger writes compress better.
+ */
+export class StreamCompressor {
+  private compressor: Transform;
+  private output: Buffer[] = [];
+  private failure: Error | undefined;
+
+  constructor(encoding: ContentEncoding) {
+    this.compressor = createCompressor(encoding);
+    this.compressor.on('data', (chunk: Buffer) => this.output.push(chunk));
+    this.compressor.on('error', (error) => {
+      this.failure = error;
+    });
+  }
+
+  // The compressed form of chunk, including everything still held back
+  async write(chunk: string | Buffer): Promise<Buffer> {
+    await new Promise<void>(resolve => {
+      this.compressor.write(chunk);
+      this.compressor.flush(() => resolve());
+    });
+    return this.take();
+  }
+
+  // The rest of the compressed body, ending with chunk
+  async end(chunk?: string | Buffer): Promise<Buffer> {
+    await new Promise<void>(resolve => {
+      this.compressor.once('end', resolve);
+      this.compressor.once('error', () => resolve());
+      this.compressor.end(chunk);
+    });
+    return this.take();
+  }
+
+  private async take(): Promise<Buffer> {
+    // Output pushed while flushing can be delivered on the next tick
+    await new Promise(resolve => setImmediate(resolve));
+    if (this.failure) throw this.failure;
+    const output = Buffer.concat(this.output);
+    this.output = [];
+    return output;
+  }
+}
@@ -0,0 +1,117 @@
+import { QeraConfig } from '../types';
+
+const SIZE_UNITS: Record<string, number> = {
+  b: 1,
+  kb: 1024,
+  mb: 1024 * 1024,
+  gb: 1024 * 1024 * 1024
+};
+
+const DURATION_UNITS: Record<string, number> = {
+  ms: 1,
+  s: 1000,
+  m: 60 * 1000,
+  h: 60 * 60 * 1000
+};
+
+/**
+ * Parse a size such as "10MB", "512kb" or "2048" (bytes). Unlike bodyLimit,
+ * which quietly falls back to 1MB, malformed values throw.
+ */
+export function parseSize(value: string): number {
+  const match = /^(\d+(?:\.\d+)?)\s*([a-z]*)$/i.exec(value.trim());
+  const unit = match ? (match[2].toLowerCase() || 'b') : '';
+  if (!match || !(unit in SIZE_UNITS)) {
+    throw new Error(`"${value}" is not a valid size (e.g. 512kb, 10MB)`);
+  }
+  return Math.floor(parseFloat(match[1]) * SIZE_UNITS[unit]);
+}
+
+// Parse a duration such as "5s", "250ms", "1m" or "1500" (milliseconds)
+export function parseDuration(value: string): number {
+  const match = /^(\d+(?:\.\d+)?)\s*([a-z]*)$/i.exec(value.trim());
+  const unit = match ? (match[2].toLowerCase() || 'ms') : '';
+  if (!match || !(unit in DURATION_UNITS)) {
+    throw new Error(`"${value}" is not a valid duration (e.g. 250ms, 5s, 1m)`);
+  }
+  return Math.floor(parseFloat(match[1]) * DURATION_UNITS[unit]);
+}
+
+function parseBoolean(value: string): boolean {
+  const normalized = value.trim().toLowerCase();
+  if (['true', '1', 'yes', 'on'].includes(normalized)) return true;
+  if (['false', '0', 'no', 'off'].includes(normalized)) return false;
+  throw new Error(`"${value}" is not a valid boolean (true/false)`);
+}
+
+function parsePort(value: string): number {
+  const port = Number(value);
+  if (!Number.isInteger(port) || port < 0 || port > 65535) {
+    throw new Error(`"${value}" is not a valid port`);
+  }
+  return port;
+}
+
+function parseLogLevel(value: string): 'debug' | 'info' | 'warn' | 'error' {
+  const level = value.trim().toLowerCase();
+  if (level !== 'debug' && level !== 'info' && level !== 'warn' && level !== 'error') {
+    throw new Error(`"${value}" is not a valid log level (debug, info, warn, error)`);
+  }
+  return level;
+}
+
+/**
+ * Read server settings from environment variables named PREFIX_SETTING, e.g.
+ * QERA_PORT=8080. Only variables that are set end up in the result, so it can
+ * be spread over code defaults:
+ *
+ *   new Qera({ bodyLimit: '1mb', ...configFromEnv('QERA') })
+ *
+ * Throws a single error listing every malformed variable.
+ */
+export function configFromEnv(prefix = 'QERA', env: Record<string, string | undefined> = process.env): QeraConfig {
+  const config: QeraConfig = {};
+  const errors: string[] = [];
+
+  const read = <T>(name: string, parse: (value: string) => T, apply: (value: T) => void) => {
+    const key = `${prefix}_${name}`;
+    const raw = env[key];
+    if (raw === undefined || raw === '') return;
+
+    try {
+      apply(parse(raw));
+    } catch (error) {
+      errors.push(`${key}: ${(error as Error).message}`);
+    }
+  };
+
+  read('PORT', parsePort, value => { config.port = value; });
+  read('HOST', value => value.trim(), value => { config.host = value; });
+  read('BODY_LIMIT', parseSize, value => { config.bodyLimit = value; });
+  read('BODY_TIMEOUT', parseDuration, value => { config.bodyTimeout = value; });
+  read('WRITE_TIMEOUT', parseDuration, value => { config.writeTimeout = value; });
+  read('COMPRESSION', parseBoolean, value => { config.compression = value; });
+  read('LOG_LEVEL', parseLogLevel, value => { config.logging = { ...config.logging, level: value }; });
+  read('TRUST_PROXY', value => {
+    // "true"/"false" or a comma separated list of proxy addresses
+    try {
+      return parseBoolean(value);
+    } catch {
+      return value.split(',').map(address => address.trim()).filter(Boolean);
+    }
+  }, value => { config.trustProxy = value; });
+  read('RATE_LIMIT_MAX', value => {
+    const max = Number(value);
+    if (!Number.isInteger(max) || max <= 0) {
+      throw new Error(`"${value}" is not a positive integer`);
+    }
+    return max;
+  }, value => { config.rateLimit = { windowMs: 60000, ...config.rateLimit, max: value }; });
+  read('RATE_LIMIT_WINDOW', parseDuration, value => { config.rateLimit = { max: 100, ...config.rateLimit, windowMs: value }; });
+
+  if (errors.length > 0) {
+    throw new Error(`Invalid environment configuration:\n  ${errors.join('\n  ')}`);
+  }
+
+  return config;
+}
@@ -0,0 +1,72 @@
+export interface DumpOptions {
+  // Body characters kept before truncating, default 1024
+  maxBodyLength?: number;
+  // Header names whose values are hidden, replacing the default list
+  redact?: string[];
+}
+
+// What a request dump shows, copied out of the request while it is valid
+export interface DumpParts {
+  method: string;
+  url: string;
+  query: string;
+  headers: Record<string, string>;
+  // Parsed body, or the error that reading it raised
+  body: unknown;
+  bodyError?: Error;
+}
+
+export const DEFAULT_REDACTED_HEADERS = ['authorization', 'proxy-authorization', 'cookie'];
+
+function formatBody(body: unknown): string {
+  if (typeof body === 'string') {
+    return body;
+  }
+  if (Buffer.isBuffer(body)) {
+    return `<${body.length} bytes binary>`;
+  }
+  try {
+    return JSON.stringify(body) ?? String(body);
+  } catch {
+    return String(body);
+  }
+}
+
+/**
+ * A human-readable, multi-line dump of a request for debug logging:
+ *
+ *   POST /users?invite=1
+ *   authorization: [redacted]
+ *   content-type: application/json
+ *
+ *   {"name":"Ada"}
+ *
+ * Headers are sorted by name. The body is omitted when empty and truncated
+ * past maxBodyLength, with the number of characters left out.
+ */
+export function formatDump(parts: DumpParts, options: DumpOptions = {}): string {
+  const maxBodyLength = options.maxBodyLength ?? 1024;
+  const redact = new Set((options.redact || DEFAULT_REDACTED_HEADERS).map(name => name.toLowerCase()));
+
+  const lines = [`${parts.method} ${parts.url}${parts.query ? `?${parts.query}` : ''}`];
+  for (const name of Object.keys(parts.headers).sort()) {
+    lines.push(`${name}: ${redact.has(name) ? '[redacted]' : parts.headers[name]}`);
+  }
+
+  let body = parts.bodyError ? '' : formatBody(parts.body);
+  if (body === '{}') {
+    // The parser's value for requests without a body
+    body = '';
+  }
+  if (body.length > maxBodyLength) {
+    body = `${body.slice(0, maxBodyLength)}... (${body.length - maxBodyLength} more characters)`;
+  }
+  if (parts.bodyError) {
+    body = `<unreadable body: ${parts.bodyError.message}>`;
+  }
+  if (body) {
+    lines.push('', body);
+  }
+
+  return lines.join('\n');
+}
@@ -0,0 +1,60 @@
+import { STATUS_CODES } from 'http';
+
+// What app.mapError() maps: one error object (a sentinel such as
+// NotFound = new Error('not found')) or every instance of an error class
+export type ErrorTarget = Error | (abstract new (...args: any[]) => Error);
+
+export interface MappedError {
+  status: number;
+  message: string;
+}
+
+/**
+ * Error-to-response mappings, looked up like Go's errors.Is: the thrown
+ * error is checked first, then each error in its cause chain, and the first
+ * one with a mapping decides. For one error, a mapped sentinel beats a
+ * mapped class, and a subclass beats its parent classes, whatever order they
+ * were registered in.
+ */
+export class ErrorMap {
+  private sentinels = new Map<unknown, MappedError>();
+  private classes: Array<[abstract new (...args: any[]) => Error, MappedError]> = [];
+
+  add(target: ErrorTarget, status: number, message?: string): void {
+    if (!Number.isInteger(status) || status < 400 || status > 599) {
+      throw new RangeError(`mapError() needs a 4xx or 5xx status, got ${status}`);
+    }
+    const mapped = { status, message: message ?? STATUS_CODES[status] ?? String(status) };
+    if (typeof target === 'function') {
+      this.classes = this.classes.filter(([type]) => type !== target);
+      this.classes.push([target, mapped]);
+    } else {
+      this.sentinels.set(target, mapped);
+    }
+  }
+
+  match(error: unknown): MappedError | undefined {
+    if (this.sentinels.size === 0 && this.classes.length === 0) return undefined;
+    // A cycle of causes ends the walk instead of looping forever
+    const seen = new Set<unknown>();
+    let current = error;
+    while (current !== undefined && current !== null && !seen.has(current)) {
+      seen.add(current);
+      const mapped = this.sentinels.get(current) ?? this.classMapping(current);
+      if (mapped) return mapped;
+      current = (current as { cause?: unknown }).cause;
+    }
+    return undefined;
+  }
+
+  // The mapping of the most derived registered class error is an instance of
+  private classMapping(error: unknown): MappedError | undefined {
+    let best: [abstract new (...args: any[]) => Error, MappedError] | undefined;
+    for (const entry of this.classes) {
+      if (error instanceof entry[0] && (!best || entry[0].prototype instanceof best[0])) {
+        best = entry;
+      }
+    }
+    return best?.[1];
+  }
+}
@@ -0,0 +1,61 @@
+import { acceptsType } from './negotiation';
+
+// Formats for the responses Qera sends itself (404, 405, 500, ...)
+export type ErrorContentType = 'json' | 'text' | 'html';
+
+const MEDIA_TYPES: Record<ErrorContentType, string> = {
+  json: 'application/json',
+  text: 'text/plain',
+  html: 'text/html'
+};
+
+/**
+ * Thrown by ctx.fail() to end a request with status and message from any
+ * depth of helper calls. The framework answers it like a client error,
+ * without logging it or running error hooks, and errorHandler() and safe()
+ * answer it the same way. It carries no stack trace, which keeps throwing
+ * it cheap.
+ */
+export class RequestFailedError extends Error {
+  statusCode: number;
+
+  constructor(statusCode: number, message: string) {
+    super(message);
+    this.statusCode = statusCode;
+    this.name = 'RequestFailedError';
+  }
+}
+
+const escapeHTML = (text: string) => text.replace(/[&<>"']/g, char => `&#${char.charCodeAt(0)};`);
+
+/**
+ * The format for a default error response: fallback, or with negotiate
+ * whichever of the three the Accept header prefers. Ties, wildcards and
+ * headers accepting none of them get the fallback.
+ */
+export function errorContentType(accept: string | undefined, fallback: ErrorContentType = 'json', negotiate = false): ErrorContentType {
+  if (!negotiate || !accept) return fallback;
+  const offers = [fallback, ...(Object.keys(MEDIA_TYPES) as ErrorContentType[]).filter(type => type !== fallback)];
+  const preferred = acceptsType(accept, offers.map(type => MEDIA_TYPES[type]));
+  return offers.find(type => MEDIA_TYPES[type] === preferred) ?? fallback;
+}
+
+/**
+ * A default error response body. JSON sends body as it is; text and HTML
+ * only carry its error message, the HTML as a minimal page titled with the
+ * status.
+ */
+export function renderError(type: ErrorContentType, status: number, body: { error: string; [key: string]: any }): { contentType: string; body: string } {
+  if (type === 'text') {
+    return { contentType: 'text/plain; charset=utf-8', body: body.error };
+  }
+  if (type === 'html') {
+    const message = escapeHTML(body.error);
+    return {
+      contentType: 'text/html; charset=utf-8',
+      body: `<!DOCTYPE html>\n<html><head><meta charset="utf-8"><title>${status} ${message}</title></head>` +
+        `<body><h1>${status} ${message}</h1></body></html>\n`
+    };
+  }
+  return { contentType: 'application/json', body: JSON.stringify(body) };
+}
@@ -0,0 +1,40 @@
+/**
+ * Parse an If-Match or If-None-Match header into its entity tags, as sent
+ * (quotes and W/ prefix included), e.g. ['"v3"', 'W/"a1"']. "*" comes back as
+ * ['*']. Tags may contain commas, so the header is scanned rather than split;
+ * a malformed header yields the tags before the first error.
+ */
+export function parseETags(header: string | undefined): string[] {
+  const tags: string[] = [];
+  if (!header) {
+    return tags;
+  }
+  if (header.trim() === '*') {
+    return ['*'];
+  }
+
+  const tag = /\s*((?:W\/)?"[^"]*")\s*(?:,|$)/y;
+  let match: RegExpExecArray | null;
+  while (tag.lastIndex < header.length && (match = tag.exec(header))) {
+    tags.push(match[1]);
+  }
+  return tags;
+}
+
+const opaque = (tag: string) => tag.replace(/^W\//, '');
+
+/**
+ * Whether a current entity tag satisfies a list from parseETags. If-Match
+ * uses strong comparison: weak tags on either side never match. If-None-Match
+ * uses weak comparison (weak = true), which ignores the W/ prefix. "*" matches
+ * any current representation.
+ */
+export function etagMatches(tags: string[], etag: string, weak = false): boolean {
+  if (tags.includes('*')) {
+    return true;
+  }
+  if (weak) {
+    return tags.some(tag => opaque(tag) === opaque(etag));
+  }
+  return !etag.startsWith('W/') && tags.includes(etag);
+}
@@ -0,0 +1,113 @@
+import { SSEEvent } from './sse';
+
+export interface BrokerOptions {
+  // Events a subscriber may have waiting before new ones are dropped for it (default 100)
+  bufferSize?: number;
+}
+
+/**
+ * One subscriber's view of a broker: an async iterator over the events
+ * published since it subscribed. Ending it, by return() (which for await
+ * and qera.sse() call when they stop early) or close(), unsubscribes.
+ */
+export class Subscription<T = SSEEvent> implements AsyncIterableIterator<T> {
+  // Events skipped because this subscriber fell behind
+  dropped = 0;
+  private queue: T[] = [];
+  private waiting?: (result: IteratorResult<T>) => void;
+  private closed = false;
+
+  constructor(private readonly bufferSize: number, private readonly onClose: (subscription: Subscription<T>) => void) {}
+
+  // Queued events, not yet taken by the consumer
+  get pending(): number {
+    return this.queue.length;
+  }
+
+  push(event: T): void {
+    if (this.closed) return;
+    if (this.waiting) {
+      const waiting = this.waiting;
+      this.waiting = undefined;
+      waiting({ value: event, done: false });
+    } else if (this.queue.length < this.bufferSize) {
+      this.queue.push(event);
+    } else {
+      this.dropped++;
+    }
+  }
+
+  next(): Promise<IteratorResult<T>> {
+    if (this.queue.length > 0) {
+      return Promise.resolve({ value: this.queue.shift()!, done: false });
+    }
+    if (this.closed) {
+      return Promise.resolve({ value: undefined, done: true });
+    }
+    return new Promise(resolve => {
+      this.waiting = resolve;
+    });
+  }
+
+  return(): Promise<IteratorResult<T>> {
+    this.close();
+    return Promise.resolve({ value: undefined, done: true });
+  }
+
+  // Stop receiving events; a consumer waiting for one is released
+  close(): void {
+    if (this.closed) return;
+    this.closed = true;
+    this.queue = [];
+    this.onClose(this);
+    if (this.waiting) {
+      const waiting = this.waiting;
+      this.waiting = undefined;
+      waiting({ value: undefined, done: true });
+    }
+  }
+
+  [Symbol.asyncIterator](): this {
+    return this;
+  }
+}
+
+/**
+ * In-memory fan-out of events to any number of subscribers, e.g. several
+ * qera.sse() streams sharing one feed. publish() never waits: each
+ * subscriber has its own bounded queue, and a subscriber that falls
+ * bufferSize events behind misses new events until it catches up, so one
+ * slow client can't hold up the others or grow memory without bound.
+ */
+export class EventBroker<T = SSEEvent> {
+  private subscribers = new Set<Subscription<T>>();
+  private bufferSize: number;
+
+  constructor(options: BrokerOptions = {}) {
+    this.bufferSize = options.bufferSize ?? 100;
+  }
+
+  // Number of active subscribers
+  get size(): number {
+    return this.subscribers.size;
+  }
+
+  subscribe(): Subscription<T> {
+    const subscription = new Subscription<T>(this.bufferSize, closed => this.subscribers.delete(closed));
+    this.subscribers.add(subscription);
+    return subscription;
+  }
+
+  publish(event: T): void {
+    for (const subscription of this.subscribers) {
+      subscription.push(event);
+    }
+  }
+
+  // End every subscription, e.g. on shutdown so event streams finish
+  close(): void {
+    for (const subscription of [...this.subscribers]) {
+      subscription.close();
+    }
+  }
+}
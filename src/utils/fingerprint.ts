@@ -0,0 +1,72 @@
+import { createHash } from 'crypto';
+
+// What goes into a request fingerprint. Every field is optional so callers
+// can build parts by hand, e.g. to key on a normalised path
+export interface FingerprintParts {
+  method?: string;
+  path?: string;
+  query?: Array<[string, string]>;
+  headers?: Array<[string, string]>;
+  body?: unknown;
+}
+
+export interface FingerprintOptions {
+  // Include the method and path, default true
+  method?: boolean;
+  path?: boolean;
+  // true for every query param (default), false for none, or the names to keep
+  query?: boolean | string[];
+  // Request headers to include, by name; none by default
+  headers?: string[];
+  // Include the parsed body, default false
+  body?: boolean;
+}
+
+/**
+ * Sort query entries by name so ?a=1&b=2 and ?b=2&a=1 produce the same key.
+ * Values of a repeated name keep their request order, since that order can
+ * carry meaning. Filtering by name happens here too, to avoid a second pass.
+ */
+export function canonicalQuery(
+  entries: Array<[string, string]>,
+  names?: string[]
+): Array<[string, string]> {
+  const kept = names ? entries.filter(([key]) => names.includes(key)) : entries.slice();
+  // Array.prototype.sort is stable, which keeps repeated values in order
+  return kept.sort((a, b) => (a[0] < b[0] ? -1 : a[0] > b[0] ? 1 : 0));
+}
+
+// JSON with object keys sorted at every level, so equal bodies serialise equally
+function stableStringify(value: unknown): string {
+  if (value === undefined) {
+    return 'null';
+  }
+  if (Buffer.isBuffer(value)) {
+    return JSON.stringify(value.toString('base64'));
+  }
+  if (Array.isArray(value)) {
+    return `[${value.map(stableStringify).join(',')}]`;
+  }
+  if (value !== null && typeof value === 'object' && !(value instanceof Date)) {
+    const keys = Object.keys(value).sort();
+    const record = value as Record<string, unknown>;
+    return `{${keys.map(key => `${JSON.stringify(key)}:${stableStringify(record[key])}`).join(',')}}`;
+  }
+  return JSON.stringify(value);
+}
+
+/**
+ * Hash fingerprint parts into a stable hex key (SHA-256). Parts are encoded
+ * as a JSON array before hashing, so no combination of values can collide by
+ * shifting characters between fields.
+ */
+export function hashFingerprint(parts: FingerprintParts): string {
+  const encoded = stableStringify([
+    parts.method ?? null,
+    parts.path ?? null,
+    parts.query ?? null,
+    parts.headers ?? null,
+    parts.body ?? null
+  ]);
+  return createHash('sha256').update(encoded).digest('hex');
+}
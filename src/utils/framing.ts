@@ -0,0 +1,55 @@
+import { HttpRequest } from 'uWebSockets.js';
+
+/**
+ * What makes a request's body framing ambiguous, if anything. Servers and
+ * proxies that disagree on where a body ends can be made to read part of
+ * it as another request (request smuggling), so such requests are refused
+ * rather than guessed at, as RFC 9112 asks:
+ *
+ * - Content-Length together with Transfer-Encoding
+ * - Content-Length sent twice or as a list, even with equal values, or not
+ *   a plain number
+ * - Transfer-Encoding whose last coding isn't chunked, or with chunked twice
+ * - a header continued on the next line (obsolete line folding)
+ */
+export function framingProblem(req: HttpRequest): string | undefined {
+  const contentLength: string[] = [];
+  const transferEncoding: string[] = [];
+  let folded = false;
+
+  req.forEach((key, value) => {
+    if (key === 'content-length') {
+      contentLength.push(value);
+    } else if (key === 'transfer-encoding') {
+      transferEncoding.push(value);
+    }
+    // A folded line reaches us as a name starting with whitespace, or as a
+    // value with the line break still in it
+    if (/^[ \t]/.test(key) || /[\r\n]/.test(value)) {
+      folded = true;
+    }
+  });
+
+  if (folded) {
+    return 'obsolete line folding';
+  }
+  if (contentLength.length > 0 && transferEncoding.length > 0) {
+    return 'both Content-Length and Transfer-Encoding';
+  }
+  if (contentLength.length > 1 || contentLength.some(value => value.includes(','))) {
+    return 'more than one Content-Length';
+  }
+  if (contentLength.length === 1 && !/^\d+$/.test(contentLength[0].trim())) {
+    return 'invalid Content-Length';
+  }
+  if (transferEncoding.length > 0) {
+    const codings = transferEncoding.join(',').split(',').map(coding => coding.trim().toLowerCase());
+    if (codings[codings.length - 1] !== 'chunked') {
+      return 'Transfer-Encoding not ending in chunked';
+    }
+    if (codings.filter(coding => coding === 'chunked').length > 1) {
+      return 'chunked applied more than once';
+    }
+  }
+  return undefined;
+}
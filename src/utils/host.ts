@@ -0,0 +1,33 @@
+// A single DNS label: letters, digits and inner hyphens, at most 63 characters
+export const HOST_LABEL = /^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$/;
+
+// The host without port and trailing dot, lowercased; [::1]:3000 keeps its brackets
+export function hostname(host: string): string {
+  const name = host.startsWith('[') ? host.slice(0, host.indexOf(']') + 1) : host.split(':')[0];
+  return name.toLowerCase().replace(/\.$/, '');
+}
+
+/**
+ * A host pattern in the form routes are matched with: lowercased, without
+ * trailing dot. "api.example.com" is one host; "*.example.com" is any
+ * single label under example.com, but not example.com itself or
+ * "a.b.example.com". Throws for anything else.
+ */
+export function hostPattern(pattern: string): string {
+  const normalized = pattern.toLowerCase().replace(/\.$/, '');
+  const labels = normalized.split('.');
+  const named = labels[0] === '*' ? labels.slice(1) : labels;
+  if (named.length === 0 || !named.every(label => HOST_LABEL.test(label))) {
+    throw new Error(`Invalid host pattern "${pattern}": use a host name such as "api.example.com" or "*.example.com"`);
+  }
+  return normalized;
+}
+
+// Whether a hostname() matches a hostPattern()
+export function matchesHost(pattern: string, host: string): boolean {
+  if (!pattern.startsWith('*.')) {
+    return host === pattern;
+  }
+  const suffix = pattern.slice(1);
+  return host.endsWith(suffix) && HOST_LABEL.test(host.slice(0, -suffix.length));
+}
@@ -0,0 +1,57 @@
+const MONTHS = ['Jan', 'Feb', 'Mar', 'Apr', 'May', 'Jun', 'Jul', 'Aug', 'Sep', 'Oct', 'Nov', 'Dec'];
+
+// Sun, 06 Nov 1994 08:49:37 GMT
+const IMF_FIXDATE = /^[A-Z][a-z]{2}, (\d{2}) ([A-Z][a-z]{2}) (\d{4}) (\d{2}):(\d{2}):(\d{2}) GMT$/;
+// Sunday, 06-Nov-94 08:49:37 GMT
+const RFC_850 = /^[A-Z][a-z]+, (\d{2})-([A-Z][a-z]{2})-(\d{2}) (\d{2}):(\d{2}):(\d{2}) GMT$/;
+// Sun Nov  6 08:49:37 1994
+const ASCTIME = /^[A-Z][a-z]{2} ([A-Z][a-z]{2}) ([ \d]\d) (\d{2}):(\d{2}):(\d{2}) (\d{4})$/;
+
+// An HTTP-date (IMF-fixdate), e.g. for Last-Modified
+export function formatHTTPDate(date: Date | number): string {
+  return new Date(date).toUTCString();
+}
+
+/**
+ * Parse an HTTP-date in any of the three formats HTTP allows, always as
+ * UTC. Returns the time in ms, or undefined for anything else; Date.parse
+ * is not used since it accepts far more and reads asctime dates as local time.
+ */
+export function parseHTTPDate(value: string | undefined): number | undefined {
+  if (!value) return undefined;
+  const text = value.trim();
+
+  let parts: [string, string, string, string, string, string] | undefined;
+  let match: RegExpExecArray | null;
+  if ((match = IMF_FIXDATE.exec(text))) {
+    parts = [match[3], match[2], match[1], match[4], match[5], match[6]];
+  } else if ((match = RFC_850.exec(text))) {
+    // Two-digit years that would be more than 50 years ahead are in the past century
+    const year = 2000 + parseInt(match[3], 10);
+    const fullYear = year > new Date().getUTCFullYear() + 50 ? year - 100 : year;
+    parts = [String(fullYear), match[2], match[1], match[4], match[5], match[6]];
+  } else if ((match = ASCTIME.exec(text))) {
+    parts = [match[6], match[1], match[2].trim(), match[3], match[4], match[5]];
+  }
+  if (!parts) return undefined;
+
+  const [year, monthName, day, hours, minutes, seconds] = parts;
+  const month = MONTHS.indexOf(monthName);
+  if (month === -1) return undefined;
+
+  const time = Date.UTC(+year, month, +day, +hours, +minutes, +seconds);
+  // Reject dates that rolled over, e.g. 31 Feb
+  return new Date(time).getUTCDate() === +day ? time : undefined;
+}
+
+/**
+ * Whether an If-Modified-Since header lets the response be a 304: the
+ * resource, at HTTP-date (second) precision, is not newer than the date.
+ * An invalid or missing header never matches.
+ */
+export function notModifiedSince(ifModifiedSince: string | undefined, lastModified: Date | number): boolean {
+  const since = parseHTTPDate(ifModifiedSince);
+  if (since === undefined) return false;
+  const modified = Math.floor(new Date(lastModified).getTime() / 1000) * 1000;
+  return modified <= since;
+}
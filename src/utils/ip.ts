@@ -0,0 +1,41 @@
+// Which peers may set X-Forwarded-* headers: true trusts every peer, a list
+// trusts those addresses only
+export type TrustProxy = boolean | string[];
+
+// Strip the IPv4-mapped IPv6 prefix so "::ffff:10.0.0.1" matches "10.0.0.1"
+export function normalizeAddress(address: string): string {
+  const trimmed = address.trim();
+  return trimmed.toLowerCase().startsWith('::ffff:') && trimmed.includes('.')
+    ? trimmed.slice(7)
+    : trimmed;
+}
+
+export function isTrustedProxy(address: string, trustProxy: TrustProxy | undefined): boolean {
+  if (trustProxy === true) return true;
+  if (!trustProxy) return false;
+  return trustProxy.map(normalizeAddress).includes(normalizeAddress(address));
+}
+
+/**
+ * Determine the client address. X-Forwarded-For is only honoured when the
+ * direct peer is a trusted proxy; the chain is then walked from the right,
+ * skipping trusted proxies, so clients can't spoof their address by sending
+ * their own header.
+ */
+export function clientIp(remoteAddress: string, forwardedFor: string | undefined, trustProxy: TrustProxy | undefined): string {
+  let ip = normalizeAddress(remoteAddress);
+
+  if (!forwardedFor || !isTrustedProxy(ip, trustProxy)) {
+    return ip;
+  }
+
+  const chain = forwardedFor.split(',').map(normalizeAddress).filter(Boolean);
+  for (let i = chain.length - 1; i >= 0; i--) {
+    ip = chain[i];
+    if (!isTrustedProxy(ip, trustProxy)) {
+      break;
+    }
+  }
+
+  return ip;
+}
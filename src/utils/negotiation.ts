@@ -0,0 +1,163 @@
+// A single entry from an Accept-style header, e.g. "text/html;level=1;q=0.8"
+export interface QualityValue {
+  value: string;
+  q: number;
+  params: Record<string, string>;
+  index: number;
+}
+
+// Shorthands accepted by accepts(), mirroring the common Express usage
+const typeShorthands: Record<string, string> = {
+  json: 'application/json',
+  html: 'text/html',
+  text: 'text/plain',
+  txt: 'text/plain',
+  xml: 'application/xml',
+  form: 'application/x-www-form-urlencoded',
+  urlencoded: 'application/x-www-form-urlencoded',
+  multipart: 'multipart/form-data',
+  js: 'application/javascript',
+  css: 'text/css',
+  png: 'image/png',
+  jpg: 'image/jpeg',
+  jpeg: 'image/jpeg',
+  gif: 'image/gif',
+  svg: 'image/svg+xml',
+  bin: 'application/octet-stream'
+};
+
+/**
+ * Parse a header made of comma separated values with optional quality
+ * parameters (Accept, Accept-Charset, Accept-Encoding, Accept-Language).
+ * Entries are returned ordered by preference: highest q first, ties keep the
+ * order in which the client sent them. Entries with q=0 are kept so callers
+ * can tell "explicitly refused" apart from "not mentioned".
+ */
+export function parseQualityValues(header: string): QualityValue[] {
+  const entries: QualityValue[] = [];
+
+  if (!header) {
+    return entries;
+  }
+
+  const parts = header.split(',');
+
+  for (let i = 0; i < parts.length; i++) {
+    const [rawValue, ...rawParams] = parts[i].split(';');
+    const value = rawValue.trim().toLowerCase();
+    if (!value) continue;
+
+    let q = 1;
+    const params: Record<string, string> = {};
+
+    for (const rawParam of rawParams) {
+      const eq = rawParam.indexOf('=');
+      if (eq === -1) continue;
+      const key = rawParam.slice(0, eq).trim().toLowerCase();
+      const paramValue = rawParam.slice(eq + 1).trim().replace(/^"(.*)"$/, '$1');
+
+      if (key === 'q') {
+        const parsed = parseFloat(paramValue);
+        q = isNaN(parsed) ? 0 : Math.min(Math.max(parsed, 0), 1);
+      } else {
+        params[key] = paramValue;
+      }
+    }
+
+    entries.push({ value, q, params, index: i });
+  }
+
+  return entries.sort((a, b) => b.q - a.q || a.index - b.index);
+}
+
+// Returns a specificity score (higher is more specific) or -1 if no match
+type Matcher = (range: QualityValue, offer: string) => number;
+
+function negotiate(header: string | undefined, offers: string[], matcher: Matcher, implicit?: string): string {
+  if (offers.length === 0) {
+    return '';
+  }
+
+  // A missing header means the client accepts anything
+  if (header === undefined || header === '') {
+    return offers[0];
+  }
+
+  const ranges = parseQualityValues(header);
+  let best = '';
+  let bestQ = 0;
+
+  for (const offer of offers) {
+    let q = -1;
+    let specificity = -1;
+
+    for (const range of ranges) {
+      const s = matcher(range, offer);
+      if (s > specificity) {
+        specificity = s;
+        q = range.q;
+      }
+    }
+
+    if (q === -1 && implicit !== undefined && offer.toLowerCase() === implicit) {
+      q = 1;
+    }
+
+    if (q > bestQ) {
+      best = offer;
+      bestQ = q;
+    }
+  }
+
+  return best;
+}
+
+function normalizeType(type: string): string {
+  const lower = type.toLowerCase();
+  return typeShorthands[lower] || (lower.includes('/') ? lower : `application/${lower}`);
+}
+
+function matchMediaType(range: QualityValue, offer: string): number {
+  const [rangeType, rangeSubtype] = range.value.split('/');
+  const [offerType, offerSubtype] = normalizeType(offer).split('/');
+
+  if (rangeType === '*' && rangeSubtype === '*') return 0;
+  if (rangeType !== offerType) return -1;
+  if (rangeSubtype === '*') return 1;
+  return rangeSubtype === offerSubtype ? 2 : -1;
+}
+
+function matchToken(range: QualityValue, offer: string): number {
+  if (range.value === '*') return 0;
+  return range.value === offer.toLowerCase() ? 1 : -1;
+}
+
+function matchLanguage(range: QualityValue, offer: string): number {
+  const tag = offer.toLowerCase();
+  if (range.value === '*') return 0;
+  if (range.value === tag) return 2;
+  // Basic filtering (RFC 4647): "en" matches "en-US"
+  return tag.startsWith(`${range.value}-`) ? 1 : -1;
+}
+
+/**
+ * Return the offered media type the client prefers according to its Accept
+ * header, or an empty string if none is acceptable. Offers may be full types
+ * ("application/json") or shorthands ("json", "html").
+ */
+export function acceptsType(header: string | undefined, offers: string[]): string {
+  return negotiate(header, offers, matchMediaType);
+}
+
+export function acceptsCharset(header: string | undefined, offers: string[]): string {
+  return negotiate(header, offers, matchToken);
+}
+
+// "identity" is acceptable unless the client explicitly refuses it
+export function acceptsEncoding(header: string | undefined, offers: string[]): string {
+  return negotiate(header, offers, matchToken, 'identity');
+}
+
+export function acceptsLanguage(header: string | undefined, offers: string[]): string {
+  return negotiate(header, offers, matchLanguage);
+}
@@ -0,0 +1,109 @@
+export interface PageDefaults {
+  // Page size when the request doesn't ask for one, default 20
+  size?: number;
+  // Smallest and largest page size a request may ask for, default 1 and 100
+  minSize?: number;
+  maxSize?: number;
+}
+
+export interface Page {
+  // 1-based page number
+  page: number;
+  size: number;
+  // Items to skip, i.e. (page - 1) * size unless the request sent an offset
+  offset: number;
+  // Same as size, for queries that read better with limit/offset
+  limit: number;
+  // Which params the request used, so links keep the same style
+  style: 'page' | 'offset';
+}
+
+// What qera.paginated() reports about a page; total and totalPages only
+// when the total item count is known
+export interface PageMeta {
+  total?: number;
+  page: number;
+  size: number;
+  totalPages?: number;
+}
+
+// Shapes qera.paginated() bodies, e.g. to match an existing API's conventions
+export type PageEnvelope = (data: unknown, meta: PageMeta) => unknown;
+
+// The default envelope: { data, meta: { total, page, size, totalPages } }
+export const defaultPageEnvelope: PageEnvelope = (data, meta) => ({ data, meta });
+
+export function pageMeta(page: Page, total?: number): PageMeta {
+  if (total === undefined) {
+    return { page: page.page, size: page.size };
+  }
+  return { total, page: page.page, size: page.size, totalPages: Math.ceil(total / page.size) };
+}
+
+// Plain integers only; "2.5", "1e3" and "abc" fall back to the defaults
+function parseInteger(value: string | undefined): number | undefined {
+  return value !== undefined && /^-?\d{1,15}$/.test(value.trim()) ? parseInt(value, 10) : undefined;
+}
+
+const clamp = (value: number, min: number, max: number) => Math.min(Math.max(value, min), max);
+
+/**
+ * Read page/size or limit/offset query params. page/size win when both
+ * styles are sent. Missing or malformed values use the defaults, sizes are
+ * clamped to [minSize, maxSize] and pages and offsets below the first are
+ * moved up to it, so the result is always safe to put in a query.
+ */
+export function parsePagination(query: Record<string, string>, defaults: PageDefaults = {}): Page {
+  const minSize = Math.max(1, defaults.minSize ?? 1);
+  const maxSize = Math.max(minSize, defaults.maxSize ?? 100);
+  const defaultSize = clamp(defaults.size ?? 20, minSize, maxSize);
+
+  const pageStyle = query.page !== undefined || query.size !== undefined
+    || (query.offset === undefined && query.limit === undefined);
+  const size = clamp(parseInteger(pageStyle ? query.size : query.limit) ?? defaultSize, minSize, maxSize);
+  // Offsets stay below MAX_SAFE_INTEGER however large the page number
+  const maxOffset = Number.MAX_SAFE_INTEGER - size;
+
+  if (pageStyle) {
+    const page = clamp(parseInteger(query.page) ?? 1, 1, Math.floor(maxOffset / size) + 1);
+    return { page, size, offset: (page - 1) * size, limit: size, style: 'page' };
+  }
+
+  const offset = clamp(parseInteger(query.offset) ?? 0, 0, maxOffset);
+  return { page: Math.floor(offset / size) + 1, size, offset, limit: size, style: 'offset' };
+}
+
+/**
+ * An RFC 8288 Link header value with first, prev, next and last links for
+ * page, on path with the other query params kept. Without total the last
+ * page is unknown, so next is always included and last never is.
+ */
+export function formatPageLinks(path: string, query: Array<[string, string]>, page: Page, total?: number): string {
+  const names = page.style === 'page' ? ['page', 'size'] : ['offset', 'limit'];
+  const kept = query.filter(([key]) => !names.includes(key));
+
+  const link = (target: number, rel: string) => {
+    const params = new URLSearchParams(kept);
+    if (page.style === 'page') {
+      params.set('page', String(target));
+      params.set('size', String(page.size));
+    } else {
+      params.set('offset', String((target - 1) * page.size));
+      params.set('limit', String(page.size));
+    }
+    return `<${path}?${params}>; rel="${rel}"`;
+  };
+
+  const last = total === undefined ? undefined : Math.max(1, Math.ceil(total / page.size));
+  const links = [link(1, 'first')];
+  if (page.page > 1) {
+    links.push(link(last === undefined ? page.page - 1 : Math.min(page.page - 1, last), 'prev'));
+  }
+  if (last === undefined || page.page < last) {
+    links.push(link(page.page + 1, 'next'));
+  }
+  if (last !== undefined) {
+    links.push(link(last, 'last'));
+  }
+  return links.join(', ');
+}
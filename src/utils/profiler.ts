@@ -0,0 +1,117 @@
+import { Session } from 'inspector';
+import { getHeapStatistics } from 'v8';
+import { monitorEventLoopDelay } from 'perf_hooks';
+
+// Rejected when a capture is requested while another one is running; the
+// inspector can only run one profiler of each kind per process
+export class ProfilerBusyError extends Error {
+  statusCode = 409;
+
+  constructor() {
+    super('A profile is already being captured');
+    this.name = 'ProfilerBusyError';
+  }
+}
+
+let busy = false;
+
+function post<T = any>(session: Session, method: string, params?: object): Promise<T> {
+  return new Promise((resolve, reject) => {
+    session.post(method, params, (error, result) => (error ? reject(error) : resolve(result as T)));
+  });
+}
+
+// Wait ms, or less when signal aborts first
+function sleep(ms: number, signal?: AbortSignal): Promise<void> {
+  return new Promise(resolve => {
+    const timer = setTimeout(done, ms);
+    function done() {
+      clearTimeout(timer);
+      signal?.removeEventListener('abort', done);
+      resolve();
+    }
+    signal?.addEventListener('abort', done);
+  });
+}
+
+// Run capture on a fresh inspector session, one capture at a time
+async function withSession<T>(capture: (session: Session) => Promise<T>): Promise<T> {
+  if (busy) {
+    throw new ProfilerBusyError();
+  }
+  busy = true;
+  const session = new Session();
+  session.connect();
+  try {
+    return await capture(session);
+  } finally {
+    session.disconnect();
+    busy = false;
+  }
+}
+
+/**
+ * Sample the CPU for seconds (less if signal aborts) and return the profile
+ * as .cpuprofile JSON, which Chrome DevTools and VS Code open.
+ */
+export function captureCPUProfile(seconds: number, signal?: AbortSignal): Promise<string> {
+  return withSession(async session => {
+    await post(session, 'Profiler.enable');
+    await post(session, 'Profiler.start');
+    await sleep(seconds * 1000, signal);
+    const { profile } = await post(session, 'Profiler.stop');
+    return JSON.stringify(profile);
+  });
+}
+
+/**
+ * Record where memory is allocated for seconds and return a .heapprofile
+ * (sampling heap profile) for DevTools' memory panel.
+ */
+export function captureHeapProfile(seconds: number, signal?: AbortSignal): Promise<string> {
+  return withSession(async session => {
+    await post(session, 'HeapProfiler.enable');
+    await post(session, 'HeapProfiler.startSampling');
+    await sleep(seconds * 1000, signal);
+    const { profile } = await post(session, 'HeapProfiler.stopSampling');
+    return JSON.stringify(profile);
+  });
+}
+
+/**
+ * Take a .heapsnapshot of the whole heap. The process stops while V8
+ * writes it, and the snapshot is held in memory until sent, so expect a
+ * pause and a memory spike roughly the size of the heap.
+ */
+export function captureHeapSnapshot(): Promise<string[]> {
+  return withSession(async session => {
+    const chunks: string[] = [];
+    session.on('HeapProfiler.addHeapSnapshotChunk', ({ params }) => chunks.push(params.chunk));
+    await post(session, 'HeapProfiler.takeHeapSnapshot', { reportProgress: false });
+    return chunks;
+  });
+}
+
+/**
+ * Memory and event loop figures worth a look before taking a profile.
+ * Event loop delay is sampled for sampleMs, in ms.
+ */
+export async function runtimeStats(sampleMs = 100): Promise<Record<string, any>> {
+  const histogram = monitorEventLoopDelay({ resolution: 10 });
+  histogram.enable();
+  await sleep(sampleMs);
+  histogram.disable();
+
+  const ms = (ns: number) => Math.round(ns / 1e4) / 100;
+  return {
+    uptime: process.uptime(),
+    memory: process.memoryUsage(),
+    heap: getHeapStatistics(),
+    eventLoopDelay: {
+      min: ms(histogram.min),
+      mean: ms(histogram.mean),
+      p99: ms(histogram.percentile(99)),
+      max: ms(histogram.max)
+    }
+  };
+}
@@ -0,0 +1,109 @@
+// Content sniffing after the WHATWG MIME Sniffing Standard, with the same
+// signatures and results as Go's http.DetectContentType
+
+// Bytes that never appear in text (section 5, "binary data byte")
+const isBinaryByte = (byte: number) =>
+  byte <= 0x08 || byte === 0x0b || (byte >= 0x0e && byte <= 0x1a) || (byte >= 0x1c && byte <= 0x1f);
+
+const isWhitespace = (byte: number) =>
+  byte === 0x09 || byte === 0x0a || byte === 0x0c || byte === 0x0d || byte === 0x20;
+
+type Matcher = (data: Buffer, firstNonWhitespace: number) => string | undefined;
+
+const bytes = (text: string) => Buffer.from(text, 'latin1');
+
+// An exact prefix, or a prefix with "." bytes that match anything
+function prefix(signature: string, type: string): Matcher {
+  const expected = bytes(signature);
+  return (data) => {
+    if (data.length < expected.length) return undefined;
+    for (let i = 0; i < expected.length; i++) {
+      if (signature[i] !== '.' && data[i] !== expected[i]) return undefined;
+    }
+    return type;
+  };
+}
+
+// A case-insensitive tag after leading whitespace, ended by a space or ">"
+function htmlTag(tag: string): Matcher {
+  const expected = bytes(tag.toUpperCase());
+  return (data, start) => {
+    if (data.length < start + expected.length + 1) return undefined;
+    for (let i = 0; i < expected.length; i++) {
+      let byte = data[start + i];
+      if (byte >= 0x61 && byte <= 0x7a) byte -= 0x20;
+      if (byte !== expected[i]) return undefined;
+    }
+    const next = data[start + expected.length];
+    return next === 0x20 || next === 0x3e ? 'text/html; charset=utf-8' : undefined;
+  };
+}
+
+// ISO base media files: a ftyp box naming an mp4 brand
+const mp4: Matcher = (data) => {
+  if (data.length < 12) return undefined;
+  const boxSize = data.readUInt32BE(0);
+  if (boxSize % 4 !== 0 || data.length < boxSize || data.toString('latin1', 4, 8) !== 'ftyp') return undefined;
+  for (let offset = 8; offset + 3 <= boxSize; offset += 4) {
+    // Bytes 12-15 hold the minor version, not a brand
+    if (offset === 12) continue;
+    if (data.toString('latin1', offset, offset + 3) === 'mp4') return 'video/mp4';
+  }
+  return undefined;
+};
+
+const signatures: Matcher[] = [
+  ...['<!DOCTYPE HTML', '<HTML', '<HEAD', '<SCRIPT', '<IFRAME', '<H1', '<DIV', '<FONT', '<TABLE', '<A',
+    '<STYLE', '<TITLE', '<B', '<BODY', '<BR', '<P', '<!--'].map(htmlTag),
+  (data, start) => (data.toString('latin1', start, start + 5) === '<?xml' ? 'text/xml; charset=utf-8' : undefined),
+  prefix('%PDF-', 'application/pdf'),
+  prefix('%!PS-Adobe-', 'application/postscript'),
+  prefix('\xFE\xFF', 'text/plain; charset=utf-16be'),
+  prefix('\xFF\xFE', 'text/plain; charset=utf-16le'),
+  prefix('\xEF\xBB\xBF', 'text/plain; charset=utf-8'),
+  prefix('\x00\x00\x01\x00', 'image/x-icon'),
+  prefix('\x00\x00\x02\x00', 'image/x-icon'),
+  prefix('BM', 'image/bmp'),
+  prefix('GIF87a', 'image/gif'),
+  prefix('GIF89a', 'image/gif'),
+  prefix('RIFF....WEBPVP', 'image/webp'),
+  prefix('\x89PNG\x0D\x0A\x1A\x0A', 'image/png'),
+  prefix('\xFF\xD8\xFF', 'image/jpeg'),
+  prefix('FORM....AIFF', 'audio/aiff'),
+  prefix('ID3', 'audio/mpeg'),
+  prefix('OggS\x00', 'application/ogg'),
+  prefix('MThd\x00\x00\x00\x06', 'audio/midi'),
+  prefix('RIFF....AVI ', 'video/avi'),
+  prefix('RIFF....WAVE', 'audio/wave'),
+  mp4,
+  prefix('\x1A\x45\xDF\xA3', 'video/webm'),
+  prefix('wOFF', 'font/woff'),
+  prefix('wOF2', 'font/woff2'),
+  prefix('\x00\x01\x00\x00', 'font/ttf'),
+  prefix('OTTO', 'font/otf'),
+  prefix('ttcf', 'font/collection'),
+  prefix('\x1F\x8B\x08', 'application/x-gzip'),
+  prefix('PK\x03\x04', 'application/zip'),
+  prefix('Rar!\x1A\x07\x00', 'application/x-rar-compressed'),
+  prefix('Rar!\x1A\x07\x01\x00', 'application/x-rar-compressed'),
+  prefix('\x00asm', 'application/wasm')
+];
+
+/**
+ * The content type of data judging by its first 512 bytes. Always returns
+ * a type: text without binary bytes is "text/plain; charset=utf-8" and
+ * anything unrecognised is "application/octet-stream".
+ */
+export function detectContentType(data: Buffer | string): string {
+  const head = typeof data === 'string' ? Buffer.from(data.slice(0, 512)).subarray(0, 512) : data.subarray(0, 512);
+
+  let start = 0;
+  while (start < head.length && isWhitespace(head[start])) start++;
+
+  for (const match of signatures) {
+    const type = match(head, start);
+    if (type) return type;
+  }
+
+  return head.some(isBinaryByte) ? 'application/octet-stream' : 'text/plain; charset=utf-8';
+}
@@ -0,0 +1,98 @@
+import { HttpResponse } from 'uWebSockets.js';
+import { onAborted } from './abort';
+import { ConnectionClosedError, countWritten, writeChunk } from './stream';
+
+export interface SSEEvent {
+  // Sent as the event id, which browsers echo in Last-Event-ID on reconnect
+  id?: string | number;
+  // Event type, dispatched to addEventListener(event) instead of onmessage
+  event?: string;
+  // Strings are sent as-is, anything else as JSON
+  data: unknown;
+  // Reconnection delay the client should use, in ms
+  retry?: number;
+}
+
+// Events to stream; plain strings are sent as data-only events
+export type SSESource = AsyncIterable<SSEEvent | string> | Iterable<SSEEvent | string>;
+
+const DISCONNECTED = Symbol('disconnected');
+
+// Field values can't contain line breaks; they would start a new field
+const singleLine = (value: string | number) => String(value).replace(/[\r\n]/g, '');
+
+/**
+ * One event in text/event-stream format. Multi-line data is split over
+ * several data: fields, which clients join back with newlines.
+ */
+export function formatEvent(event: SSEEvent | string): string {
+  const { id, event: type, data, retry } = typeof event === 'string' ? { data: event } as SSEEvent : event;
+  let output = '';
+  if (id !== undefined) output += `id: ${singleLine(id)}\n`;
+  if (type !== undefined) output += `event: ${singleLine(type)}\n`;
+  if (retry !== undefined) output += `retry: ${Math.floor(retry)}\n`;
+  const text = typeof data === 'string' ? data : JSON.stringify(data) ?? '';
+  for (const line of text.split(/\r\n|\r|\n/)) {
+    output += `data: ${line}\n`;
+  }
+  return `${output}\n`;
+}
+
+/**
+ * Send events from source until it ends or the client disconnects. The
+ * head goes out right away, followed by a comment, so clients see the
+ * stream open before the first event. A disconnect is noticed even while
+ * waiting for the next event: the source is closed (ending a broker
+ * subscription) and ConnectionClosedError is thrown.
+ */
+export async function streamEvents(res: HttpResponse, source: SSESource, writeHead: () => void): Promise<void> {
+  const iterator: Iterator<SSEEvent | string> | AsyncIterator<SSEEvent | string> =
+    Symbol.asyncIterator in source
+      ? (source as AsyncIterable<SSEEvent | string>)[Symbol.asyncIterator]()
+      : (source as Iterable<SSEEvent | string>)[Symbol.iterator]();
+
+  let disconnect!: () => void;
+  const disconnected = new Promise<typeof DISCONNECTED>(resolve => {
+    disconnect = () => resolve(DISCONNECTED);
+  });
+  onAborted(res, disconnect);
+
+  let finished = false;
+  try {
+    if (!res.aborted) {
+      const opened = ': connected\n\n';
+      countWritten(res, opened);
+      res.cork(() => {
+        writeHead();
+        res.write(opened);
+      });
+    }
+
+    while (!res.aborted) {
+      const result = await Promise.race([iterator.next(), disconnected]);
+      if (result === DISCONNECTED) break;
+      if (result.done) {
+        finished = true;
+        break;
+      }
+      await writeChunk(res, formatEvent(result.value));
+    }
+
+    if (res.aborted) {
+      throw new ConnectionClosedError();
+    }
+    res.cork(() => res.end(undefined, res.closeConnection === true));
+  } catch (error) {
+    // The status went out with the head, so a failing source can only cut the stream
+    if (!res.aborted && !(error instanceof ConnectionClosedError)) {
+      res.aborted = true;
+      res.close();
+    }
+    throw error;
+  } finally {
+    if (!finished) {
+      // Not awaited: a source waiting for its next event may only settle later
+      Promise.resolve(iterator.return?.()).catch(() => undefined);
+    }
+  }
+}
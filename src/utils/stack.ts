@@ -0,0 +1,30 @@
+import * as path from 'path';
+
+// The compiled framework root (src/ or dist/), whose core and middleware
+// frames sit between every handler and the server
+const frameworkRoot = path.resolve(__dirname, '..');
+const frameworkDirs = ['core', 'middlewares'].map(dir => path.join(frameworkRoot, dir) + path.sep);
+
+const isFrame = (line: string) => line.trimStart().startsWith('at ');
+
+// Whether a stack line is a call inside Qera's request pipeline
+export function isFrameworkFrame(line: string): boolean {
+  return isFrame(line) && frameworkDirs.some(dir => line.includes(dir));
+}
+
+/**
+ * Drop Qera's own frames (route dispatch, the middleware chain, built-in
+ * middleware) from a stack, so what remains starts at the code that threw
+ * and reads as the application's call path. The message lines are kept. A
+ * stack made only of framework frames, i.e. a bug in Qera itself, is
+ * returned unchanged rather than emptied.
+ */
+export function trimFrameworkFrames(stack: string): string {
+  const lines = stack.split('\n');
+  const kept = lines.filter(line => !isFrameworkFrame(line));
+
+  if (!kept.some(isFrame)) {
+    return stack;
+  }
+  return kept.join('\n');
+}
@@ -0,0 +1,345 @@
+import * as fs from 'fs';
+import * as path from 'path';
+import { HttpResponse } from 'uWebSockets.js';
+import { acceptsType } from './negotiation';
+import { countWritten } from './stream';
+import { etagMatches, parseETags } from './etag';
+
+// What static serving needs to know about a file
+export interface StaticFileStat {
+  size: number;
+  mtime: Date;
+  isFile(): boolean;
+  isDirectory(): boolean;
+}
+
+/**
+ * Read-only file system static files are served from. Paths are relative,
+ * slash separated and already normalized (no "..", no leading slash; "" is
+ * the root). Implementations should reject (throw) for missing entries.
+ */
+export interface StaticFileSystem {
+  stat(filePath: string): Promise<StaticFileStat>;
+  readFile(filePath: string): Promise<Buffer>;
+}
+
+export interface StaticServeOptions {
+  prefix: string;
+  cacheControl?: string;
+  index?: string;
+  spaFallback?: string;
+  spaExclude?: string[];
+  // Offer misses to the routes before answering them like unmatched requests
+  fallThrough?: boolean;
+  // Written on every response, e.g. the app's default headers
+  headers?: Array<[string, string]>;
+}
+
+// Request data copied off the uWS request before going async
+export interface StaticRequest {
+  url: string;
+  method: string;
+  accept: string;
+  ifNoneMatch: string;
+  range: string;
+}
+
+export function getMimeType(filePath: string): string {
+  const extension = filePath.split('.').pop()?.toLowerCase() || '';
+  const mimeTypes: Record<string, string> = {
+    html: 'text/html',
+    css: 'text/css',
+    js: 'application/javascript',
+    json: 'application/json',
+    png: 'image/png',
+    jpg: 'image/jpeg',
+    jpeg: 'image/jpeg',
+    gif: 'image/gif',
+    svg: 'image/svg+xml',
+    ico: 'image/x-icon',
+    txt: 'text/plain',
+  };
+
+  return mimeTypes[extension] || 'application/octet-stream';
+}
+
+/**
+ * Turn a request path into a normalized relative path ("css/app.css").
+ * ".." segments can't climb above the root. Returns null for paths that
+ * can't be decoded or contain null bytes.
+ */
+export function normalizeStaticPath(requestPath: string): string | null {
+  let decoded: string;
+  try {
+    decoded = decodeURIComponent(requestPath);
+  } catch {
+    return null;
+  }
+
+  if (decoded.includes('\0')) {
+    return null;
+  }
+
+  return path.posix.normalize('/' + decoded.replace(/\\/g, '/')).slice(1).replace(/\/$/, '');
+}
+
+/**
+ * Map a request path onto a file below root. Returns null for paths that
+ * would escape the root directory (e.g. "/../etc/passwd") or can't be decoded.
+ */
+export function resolveStaticPath(root: string, requestPath: string): string | null {
+  const relative = normalizeStaticPath(requestPath);
+  if (relative === null) {
+    return null;
+  }
+
+  const filePath = path.resolve(root, relative);
+  if (filePath !== root && !filePath.startsWith(root + path.sep)) {
+    return null;
+  }
+
+  return filePath;
+}
+
+// Files below a directory on disk
+export function diskFileSystem(root: string): StaticFileSystem {
+  const rootDir = path.resolve(root);
+
+  const resolve = (filePath: string) => {
+    const resolved = resolveStaticPath(rootDir, filePath);
+    if (resolved === null) {
+      throw new Error(`Invalid path: ${filePath}`);
+    }
+    return resolved;
+  };
+
+  return {
+    stat: async (filePath) => fs.promises.stat(resolve(filePath)),
+    readFile: async (filePath) => fs.promises.readFile(resolve(filePath))
+  };
+}
+
+/**
+ * In-memory file system, e.g. for assets bundled into a single-file build
+ * or for tests. Directories are implied by the file paths.
+ */
+export function memoryFileSystem(files: Record<string, string | Buffer>): StaticFileSystem {
+  const mtime = new Date();
+  const entries = new Map<string, Buffer>();
+
+  for (const [name, content] of Object.entries(files)) {
+    const normalized = normalizeStaticPath(name);
+    if (normalized) {
+      entries.set(normalized, Buffer.isBuffer(content) ? content : Buffer.from(content));
+    }
+  }
+
+  const isDirectory = (filePath: string) =>
+    filePath === '' || [...entries.keys()].some(name => name.startsWith(`${filePath}/`));
+
+  return {
+    stat: async (filePath) => {
+      const content = entries.get(filePath);
+      if (content) {
+        return { size: content.length, mtime, isFile: () => true, isDirectory: () => false };
+      }
+      if (isDirectory(filePath)) {
+        return { size: 0, mtime, isFile: () => false, isDirectory: () => true };
+      }
+      throw new Error(`File not found: ${filePath}`);
+    },
+    readFile: async (filePath) => {
+      const content = entries.get(filePath);
+      if (!content) {
+        throw new Error(`File not found: ${filePath}`);
+      }
+      return content;
+    }
+  };
+}
+
+/**
+ * Decide whether a request that matched no file should get the SPA entry
+ * point instead of a 404. Only browser navigations qualify: GET requests
+ * that accept HTML, don't look like asset requests (no file extension) and
+ * aren't under one of the excluded (API) prefixes.
+ */
+export function shouldServeSpaFallback(
+  url: string,
+  method: string,
+  accept: string,
+  exclude: string[]
+): boolean {
+  if (method !== 'get') {
+    return false;
+  }
+
+  if (exclude.some(prefix => url === prefix || url.startsWith(prefix.endsWith('/') ? prefix : `${prefix}/`))) {
+    return false;
+  }
+
+  const lastSegment = url.slice(url.lastIndexOf('/') + 1);
+  if (lastSegment.includes('.')) {
+    return false;
+  }
+
+  return acceptsType(accept, ['html']) !== '';
+}
+
+// Weak validator derived from size and modification time
+export function createETag(stat: StaticFileStat): string {
+  return `W/"${stat.size.toString(16)}-${Math.floor(stat.mtime.getTime()).toString(16)}"`;
+}
+
+/**
+ * Parse a single "bytes=" range against a file size. Returns null when the
+ * header should be ignored (missing, malformed or multiple ranges, which are
+ * answered with the full file) and 'unsatisfiable' for ranges outside the file.
+ */
+export function parseRange(header: string, size: number): { start: number; end: number } | 'unsatisfiable' | null {
+  const match = /^bytes=(\d*)-(\d*)$/.exec(header.trim());
+  if (!match || (match[1] === '' && match[2] === '')) {
+    return null;
+  }
+
+  let start: number;
+  let end: number;
+
+  if (match[1] === '') {
+    // Suffix range: the last N bytes
+    const length = parseInt(match[2], 10);
+    if (length === 0) return 'unsatisfiable';
+    start = Math.max(size - length, 0);
+    end = size - 1;
+  } else {
+    start = parseInt(match[1], 10);
+    end = match[2] === '' ? size - 1 : Math.min(parseInt(match[2], 10), size - 1);
+  }
+
+  if (start >= size || start > end) {
+    return 'unsatisfiable';
+  }
+
+  return { start, end };
+}
+
+/**
+ * Send a file. Resolves to false (without writing anything) when the path
+ * doesn't point at a readable file, so callers can fall back. Handles
+ * directory index files, ETag revalidation and single byte ranges.
+ */
+export async function sendFile(
+  res: HttpResponse,
+  fsys: StaticFileSystem,
+  filePath: string,
+  request: Pick<StaticRequest, 'ifNoneMatch' | 'range'>,
+  options: { cacheControl?: string; index?: string; headers?: Array<[string, string]> } = {}
+): Promise<boolean> {
+  let target = filePath;
+  let stat: StaticFileStat;
+  let data: Buffer;
+
+  try {
+    stat = await fsys.stat(target);
+    if (stat.isDirectory()) {
+      if (!options.index) return false;
+      target = target ? `${target}/${options.index}` : options.index;
+      stat = await fsys.stat(target);
+    }
+    if (!stat.isFile()) return false;
+
+    data = await fsys.readFile(target);
+  } catch {
+    return false;
+  }
+
+  if (res.aborted) {
+    return true;
+  }
+
+  const etag = createETag(stat);
+
+  res.cork(() => {
+    const writeStatus = (status: string) => {
+      res.writeStatus(status);
+      for (const [key, value] of options.headers || []) {
+        res.writeHeader(key, value);
+      }
+    };
+
+    const writeCommonHeaders = (status: string) => {
+      writeStatus(status);
+      res.writeHeader('ETag', etag);
+      res.writeHeader('Last-Modified', stat.mtime.toUTCString());
+      res.writeHeader('Accept-Ranges', 'bytes');
+      if (options.cacheControl) {
+        res.writeHeader('Cache-Control', options.cacheControl);
+      }
+    };
+
+    // If-None-Match uses weak comparison, so the W/ prefix is ignored
+    if (request.ifNoneMatch && etagMatches(parseETags(request.ifNoneMatch), etag, true)) {
+      writeCommonHeaders('304 Not Modified');
+      res.end(undefined, res.closeConnection === true);
+      return;
+    }
+
+    const range = request.range ? parseRange(request.range, data.length) : null;
+
+    if (range === 'unsatisfiable') {
+      writeStatus('416 Range Not Satisfiable');
+      res.writeHeader('Content-Range', `bytes */${data.length}`);
+      res.end(undefined, res.closeConnection === true);
+      return;
+    }
+
+    if (range) {
+      writeCommonHeaders('206 Partial Content');
+      res.writeHeader('Content-Type', getMimeType(target));
+      res.writeHeader('Content-Range', `bytes ${range.start}-${range.end}/${data.length}`);
+      const slice = data.subarray(range.start, range.end + 1);
+      countWritten(res, slice);
+      res.end(slice, res.closeConnection === true);
+      return;
+    }
+
+    writeCommonHeaders('200 OK');
+    res.writeHeader('Content-Type', getMimeType(target));
+    countWritten(res, data);
+    res.end(data, res.closeConnection === true);
+  });
+
+  return true;
+}
+
+/**
+ * Serve a static request: real files first, then the SPA entry point for
+ * browser navigations (when configured). Resolves false when nothing was
+ * sent, leaving the miss to the caller.
+ */
+export async function serveStatic(
+  res: HttpResponse,
+  fsys: StaticFileSystem,
+  request: StaticRequest,
+  options: StaticServeOptions
+): Promise<boolean> {
+  const { prefix, cacheControl, index, spaFallback, spaExclude = ['/api'], headers = [] } = options;
+  const { url } = request;
+
+  if (prefix === '' || url === prefix || url.startsWith(`${prefix}/`)) {
+    const filePath = normalizeStaticPath(url.slice(prefix.length));
+    if (filePath !== null && await sendFile(res, fsys, filePath, request, { cacheControl, index, headers })) {
+      return true;
+    }
+  }
+
+  if (spaFallback && shouldServeSpaFallback(url, request.method, request.accept, spaExclude)) {
+    // The entry point changes on every deploy, so don't let it be cached
+    const fallbackPath = normalizeStaticPath(spaFallback);
+    if (fallbackPath !== null && await sendFile(res, fsys, fallbackPath, request, { cacheControl: 'no-cache', headers })) {
+      return true;
+    }
+  }
+
+  return false;
+}
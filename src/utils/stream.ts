@@ -0,0 +1,351 @@
+import { Duplex } from 'stream';
+import { HttpResponse } from 'uWebSockets.js';
+import { Logger } from './logger';
+import { onAborted } from './abort';
+
+// A source of items for a streamed JSON array: any (async) iterable, such as a
+// database cursor, or a pull function following the iterator protocol
+export type JSONArraySource<T = any> =
+  | Iterable<T>
+  | AsyncIterable<T>
+  | (() => IteratorResult<T> | Promise<IteratorResult<T>>);
+
+// Raised by streaming writes once the client has disconnected, so loops
+// producing output can stop instead of writing into the void
+export class ConnectionClosedError extends Error {
+  constructor() {
+    super('Client disconnected');
+    this.name = 'ConnectionClosedError';
+  }
+}
+
+// Flush buffered output once it grows past this many bytes
+const FLUSH_THRESHOLD = 16 * 1024;
+
+// Flush buffered output at least this often (ms), so a slow source doesn't
+// hold back the items it has produced
+const FLUSH_INTERVAL = 100;
+
+// Largest single write when copying a body source, so backpressure is
+// checked at least this often
+const MAX_WRITE_SIZE = 64 * 1024;
+
+// Anything a response body can be copied from: Node readable streams, web
+// ReadableStreams (e.g. a fetch() body), async generators or plain arrays
+export type BodySource =
+  | AsyncIterable<Uint8Array | string>
+  | Iterable<Uint8Array | string>;
+
+/**
+ * Add a body chunk to res.bytesWritten, the count behind ctx.bytesWritten.
+ * Every write and end of a response body goes through here; strings are
+ * counted in UTF-8 bytes, as sent.
+ */
+export function countWritten(res: HttpResponse, chunk?: string | Buffer | ArrayBuffer) {
+  if (chunk !== undefined) {
+    res.bytesWritten = (res.bytesWritten || 0) + (typeof chunk === 'string' ? Buffer.byteLength(chunk) : chunk.byteLength);
+  }
+}
+
+// The iterator behind any JSONArraySource
+export function toIterator<T>(source: JSONArraySource<T>): Iterator<T> | AsyncIterator<T> {
+  if (typeof source === 'function') {
+    return { next: source } as AsyncIterator<T>;
+  }
+  if (Symbol.asyncIterator in (source as any)) {
+    return (source as AsyncIterable<T>)[Symbol.asyncIterator]();
+  }
+  return (source as Iterable<T>)[Symbol.iterator]();
+}
+
+// Resolves once uWS can take more output, rejects if the client leaves first.
+// A client that takes nothing for res.writeTimeout ms (the writeTimeout
+// setting) is disconnected, which rejects the same way
+function waitForDrain(res: HttpResponse): Promise<void> {
+  return new Promise((resolve, reject) => {
+    let settled = false;
+    const timeout: number | undefined = res.writeTimeout;
+    const timer = timeout ? setTimeout(() => {
+      Logger.debug(`Closing a connection whose client read nothing for ${timeout}ms`);
+      res.close();
+    }, timeout) : undefined;
+
+    // Streams wait many times per response, so each wait removes its listener
+    const unsubscribe = onAborted(res, () => {
+      if (settled) return;
+      settled = true;
+      clearTimeout(timer);
+      reject(new ConnectionClosedError());
+    });
+    res.onWritable(() => {
+      if (!settled) {
+        settled = true;
+        clearTimeout(timer);
+        unsubscribe();
+        resolve();
+      }
+      return true;
+    });
+  });
+}
+
+/**
+ * Write one chunk of a streamed response whose status and headers have
+ * already been written. Waits for the socket to drain under backpressure and
+ * rejects with ConnectionClosedError once the client has disconnected.
+ */
+export async function writeChunk(res: HttpResponse, chunk: string | Buffer): Promise<void> {
+  if (res.aborted) {
+    throw new ConnectionClosedError();
+  }
+
+  let accepted = true;
+  countWritten(res, chunk);
+  res.cork(() => {
+    accepted = res.write(chunk);
+  });
+
+  if (!accepted) {
+    await waitForDrain(res);
+  }
+}
+
+/**
+ * Copy a body source to the response chunk by chunk (chunked encoding).
+ * writeHead writes the status and headers and runs before the first chunk.
+ * Chunks are passed to uWS without copying, in writes of at most 64KB, and
+ * each write waits out backpressure; uWS copies whatever it can't send
+ * right away, so no buffers are held between writes.
+ *
+ * The source is closed when copying stops early, and errors are propagated:
+ * ConnectionClosedError once the client disconnects, or whatever the source
+ * threw. A source failing after output started also closes the connection,
+ * since the status has been sent already.
+ */
+export async function streamBody(res: HttpResponse, source: BodySource, writeHead: () => void): Promise<void> {
+  let started = false;
+  const start = () => {
+    if (started) return;
+    started = true;
+    if (!res.aborted) {
+      res.cork(writeHead);
+    }
+  };
+
+  try {
+    for await (const chunk of source) {
+      start();
+      const bytes = typeof chunk === 'string' ? Buffer.from(chunk) : chunk;
+      for (let offset = 0; offset < bytes.byteLength; offset += MAX_WRITE_SIZE) {
+        const piece = bytes.subarray(offset, offset + MAX_WRITE_SIZE);
+        await writeChunk(res, Buffer.from(piece.buffer, piece.byteOffset, piece.byteLength));
+      }
+    }
+
+    start();
+    if (res.aborted) {
+      throw new ConnectionClosedError();
+    }
+    res.cork(() => res.end(undefined, res.closeConnection === true));
+  } catch (error) {
+    if (started && !res.aborted && !(error instanceof ConnectionClosedError)) {
+      res.aborted = true;
+      res.close();
+    }
+    throw error;
+  } finally {
+    closeSource(source);
+  }
+}
+
+export interface PipeOptions {
+  // Content-Type of the upstream's output, unless the handler set one
+  contentType?: string;
+  // End the upstream's writable side once the request body is written,
+  // leaving its readable side open for the answer (default true). Turn it
+  // off for upstreams that close completely on end
+  halfClose?: boolean;
+}
+
+/**
+ * Proxy an exchange through a duplex upstream, such as a net.Socket: write
+ * body to it, half-close it, then stream whatever it sends back as the
+ * response (see streamBody), without buffering it. The upstream is
+ * destroyed when the client disconnects, which rejects with
+ * ConnectionClosedError even while the upstream is idle, and once piping
+ * ends either way. Upstream errors reject as they are.
+ */
+export async function pipeUpstream(
+  res: HttpResponse,
+  upstream: Duplex,
+  body: Buffer,
+  options: PipeOptions,
+  writeHead: () => void
+): Promise<void> {
+  // Errors surface through the write callback and the read loop; without a
+  // listener, one emitted in between would crash the process
+  const ignore = () => undefined;
+  upstream.on('error', ignore);
+  // A failed upstream is already destroyed when streamBody() cuts the
+  // connection over it, which isn't the client leaving
+  let clientLeft = false;
+  onAborted(res, () => {
+    if (!upstream.destroyed) {
+      clientLeft = true;
+      upstream.destroy();
+    }
+  });
+
+  try {
+    if (body.length > 0) {
+      await new Promise<void>((resolve, reject) => {
+        upstream.write(body, error => (error ? reject(error) : resolve()));
+      });
+    }
+    if (options.halfClose !== false) {
+      upstream.end();
+    }
+    await streamBody(res, upstream, writeHead);
+  } catch (error) {
+    // Destroying the upstream on disconnect can fail the read loop with its own error
+    throw clientLeft ? new ConnectionClosedError() : error;
+  } finally {
+    upstream.off('error', ignore);
+    upstream.destroy();
+  }
+}
+
+// for await closes iterators it abandons; sources with a close() (file
+// handles and the like) are closed here too
+export function closeSource(source: BodySource) {
+  const closable = source as { close?: unknown };
+  if (typeof closable.close === 'function') {
+    try {
+      closable.close();
+    } catch {
+      // Already closed
+    }
+  }
+}
+
+/**
+ * Stream items from a source as a JSON array without buffering the whole
+ * payload. Output is flushed in chunks, and at least every 100ms while
+ * there is any, each write waiting out backpressure; if the source throws
+ * after the headers went out, the error is logged and the connection is
+ * closed so the client sees a truncated (invalid) body rather than a
+ * silently short array. Headers are written together with the status when
+ * output starts. Rejects with ConnectionClosedError if the client
+ * disconnects before the end.
+ */
+export async function streamJSONArray<T>(
+  res: HttpResponse,
+  status: number,
+  source: JSONArraySource<T>,
+  headers: Array<[string, string]> = []
+): Promise<void> {
+  const iterator = toIterator(source);
+  let buffer = '[';
+  let first = true;
+  let started = false;
+
+  const writeHead = () => {
+    res.writeStatus(status.toString());
+    for (const [key, value] of headers) {
+      res.writeHeader(key, value);
+    }
+    if (!headers.some(([key]) => key.toLowerCase() === 'content-type')) {
+      res.writeHeader('Content-Type', 'application/json');
+    }
+  };
+
+  // Armed while output is buffered; due once it has waited FLUSH_INTERVAL
+  let timer: NodeJS.Timeout | undefined;
+  let tick: Promise<void> | undefined;
+  let due = false;
+  const schedule = () => {
+    tick = new Promise(resolve => {
+      timer = setTimeout(() => {
+        due = true;
+        resolve();
+      }, FLUSH_INTERVAL);
+    });
+  };
+
+  const flush = async () => {
+    clearTimeout(timer);
+    tick = undefined;
+    due = false;
+    const chunk = buffer;
+    buffer = '';
+    if (!started && !res.aborted) {
+      started = true;
+      res.cork(writeHead);
+    }
+    await writeChunk(res, chunk);
+  };
+
+  try {
+    while (!res.aborted) {
+      const pending = Promise.resolve(iterator.next());
+      if (tick) {
+        // Send what is buffered if the source takes too long with the next item
+        await Promise.race([pending.catch(() => undefined), tick]);
+        if (due) {
+          await flush();
+        }
+      }
+      const result = await pending;
+      if (result.done) break;
+
+      buffer += (first ? '' : ',') + JSON.stringify(result.value === undefined ? null : result.value);
+      first = false;
+
+      if (buffer.length >= FLUSH_THRESHOLD || due) {
+        await flush();
+      } else if (!tick) {
+        schedule();
+      }
+    }
+
+    if (res.aborted) {
+      throw new ConnectionClosedError();
+    }
+
+    buffer += ']';
+    res.cork(() => {
+      if (!started) {
+        writeHead();
+      }
+      countWritten(res, buffer);
+      res.end(buffer, res.closeConnection === true);
+    });
+  } catch (error) {
+    // Give the source a chance to release resources (e.g. close a cursor)
+    await Promise.resolve(iterator.return?.()).catch(() => undefined);
+
+    if (error instanceof ConnectionClosedError) {
+      throw error;
+    }
+
+    Logger.error(`Error streaming JSON array: ${error}`);
+
+    if (res.aborted) return;
+
+    if (!started) {
+      // Nothing has been sent yet, so a proper error response is still possible
+      res.cork(() => {
+        res.writeStatus('500');
+        res.writeHeader('Content-Type', 'application/json');
+        const body = JSON.stringify({ error: 'Internal Server Error' });
+        countWritten(res, body);
+        res.end(body, res.closeConnection === true);
+      });
+    } else {
+      res.aborted = true;
+      res.close();
+    }
+  } finally {
+    clearTimeout(timer);
+  }
+}
@@ -11,33 +11,93 @@ export function parseUrl(url: string, queryString: string): {
 export function parseQuery(queryString: string): Record<string, string | string[]> {
   const result: Record<string, string | string[]> = {};
   
-  if (!queryString) {
-    return result;
-  }
-  
-  const pairs = queryString.split('&');
-  
-  for (const pair of pairs) {
-    const [key, value] = pair.split('=').map(decodeURIComponent);
-    
+  for (const [key, value] of parseQueryEntries(queryString)) {
     if (result[key] === undefined) {
-      result[key] = value || '';
+      result[key] = value;
     } else if (Array.isArray(result[key])) {
-      (result[key] as string[]).push(value || '');
+      (result[key] as string[]).push(value);
     } else {
-      result[key] = [result[key] as string, value || ''];
+      result[key] = [result[key] as string, value];
     }
   }
   
   return result;
 }
 
-// Simple route pattern matcher that extracts params
-export function matchRoute(routePattern: string, path: string): { match: boolean, params: Record<string, string> } {
+// Decode a query component, treating "+" as a space and keeping malformed escapes as-is
+function decodeQueryComponent(value: string): string {
+  const spaced = value.replace(/\+/g, ' ');
+  try {
+    return decodeURIComponent(spaced);
+  } catch {
+    return spaced;
+  }
+}
+
+// Query parameters in the order they appear, duplicates included; "flag" yields ["flag", ""]
+export function parseQueryEntries(queryString: string): Array<[string, string]> {
+  const entries: Array<[string, string]> = [];
+
+  if (!queryString) {
+    return entries;
+  }
+
+  for (const pair of queryString.split('&')) {
+    if (!pair) continue;
+
+    const separator = pair.indexOf('=');
+    const key = separator === -1 ? pair : pair.slice(0, separator);
+    const value = separator === -1 ? '' : pair.slice(separator + 1);
+    entries.push([decodeQueryComponent(key), decodeQueryComponent(value)]);
+  }
+
+  return entries;
+}
+
+// Named constraints available in every app, e.g. "/items/:id{uuid}"
+export const BUILTIN_PARAM_PATTERNS: Record<string, RegExp> = {
+  int: /-?\d+/,
+  uuid: /[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}/,
+  slug: /[a-z0-9]+(?:-[a-z0-9]+)*/
+};
+
+// Anchor a constraint so it has to match the whole segment
+export function compileParamPattern(pattern: RegExp | string): RegExp {
+  const source = typeof pattern === 'string' ? pattern : pattern.source;
+  const flags = typeof pattern === 'string' ? '' : pattern.flags.replace(/[gy]/g, '');
+  return new RegExp(`^(?:${source})$`, flags);
+}
+
+// A route pattern's parameters in declaration order, with their named
+// constraint if any: "/items/:id{uuid}" gives [{ name: 'id', pattern: 'uuid' }]
+export function routeParams(routePattern: string): Array<{ name: string; pattern?: string }> {
+  return routePattern
+    .split('/')
+    .filter(segment => segment.startsWith(':'))
+    .map(segment => {
+      const match = /^:([^{]+)(?:\{([^}]*)\})?$/.exec(segment);
+      return match && match[2] !== undefined
+        ? { name: match[1], pattern: match[2] }
+        : { name: segment.slice(1) };
+    });
+}
+
+// The pattern without constraints, as uWS understands it: "/items/:id"
+export function stripParamPatterns(routePattern: string): string {
+  return routePattern.replace(/(\/:[^/{]+)\{[^}]*\}/g, '$1');
+}
+
+// Simple route pattern matcher that extracts params. Constrained params
+// (":id{uuid}") must also match their entry in paramPatterns
+export function matchRoute(
+  routePattern: string,
+  path: string,
+  paramPatterns?: Map<string, RegExp>
+): { match: boolean, params: Record<string, string> } {
   const params: Record<string, string> = {};
   
   // Convert route pattern to regex pattern
-  let regexPattern = '^' + routePattern
+  let regexPattern = '^' + stripParamPatterns(routePattern)
     .replace(/:[a-zA-Z0-9_]+/g, match => {
       const paramName = match.substring(1);
       return `(?<${paramName}>[^/]+)`;
@@ -59,6 +119,13 @@ export function matchRoute(routePattern: string, path: string): { match: boolean
       params[key] = value;
     }
   }
+
+  for (const { name, pattern } of routeParams(routePattern)) {
+    const constraint = pattern === undefined ? undefined : paramPatterns?.get(pattern);
+    if (constraint && !constraint.test(params[name])) {
+      return { match: false, params: {} };
+    }
+  }
   
   return { match: true, params };
 }
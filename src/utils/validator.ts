@@ -15,9 +15,22 @@ export interface ValidationResult<T> {
   };
 }
 
+// JSON Schema (draft 2020-12) describing a QeraSchema, e.g. for OpenAPI docs
+export type JSONSchema = Record<string, any>;
+
 export abstract class QeraSchema<T = any> {
   abstract _parse(data: any, path: string[]): ValidationResult<T>;
 
+  // The JSON Schema the data must match; {} (anything) unless a subclass knows better
+  toJSONSchema(): JSONSchema {
+    return {};
+  }
+
+  // Whether an object property with this schema may be missing
+  isOptional(): boolean {
+    return false;
+  }
+
   parse(data: any): T {
     const result = this._parse(data, []);
     if (!result.success) {
@@ -72,6 +85,8 @@ export abstract class QeraSchema<T = any> {
 
 export class QeraValidationError extends Error {
   public issues: ValidationError[];
+  // Well-formed but invalid input
+  public statusCode = 422;
 
   constructor(issues: ValidationError[]) {
     super('Validation failed');
@@ -116,6 +131,17 @@ export class QeraStringSchema extends QeraSchema<string> {
   private _url = false;
   private _uuid = false;
 
+  toJSONSchema(): JSONSchema {
+    const schema: JSONSchema = { type: 'string' };
+    if (this._min !== undefined) schema.minLength = this._min;
+    if (this._max !== undefined) schema.maxLength = this._max;
+    if (this._pattern) schema.pattern = this._pattern.source;
+    if (this._email) schema.format = 'email';
+    if (this._url) schema.format = 'uri';
+    if (this._uuid) schema.format = 'uuid';
+    return schema;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<string> {
     if (typeof data !== 'string') {
       return {
@@ -293,6 +319,16 @@ export class QeraNumberSchema extends QeraSchema<number> {
   private _negative = false;
   private _nonnegative = false;
 
+  toJSONSchema(): JSONSchema {
+    const schema: JSONSchema = { type: this._int ? 'integer' : 'number' };
+    if (this._min !== undefined) schema.minimum = this._min;
+    if (this._max !== undefined) schema.maximum = this._max;
+    if (this._positive) schema.exclusiveMinimum = 0;
+    if (this._negative) schema.exclusiveMaximum = 0;
+    if (this._nonnegative) schema.minimum = Math.max(schema.minimum ?? 0, 0);
+    return schema;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<number> {
     if (typeof data !== 'number' || isNaN(data)) {
       return {
@@ -438,6 +474,10 @@ export class QeraNumberSchema extends QeraSchema<number> {
 
 // Boolean Schema
 export class QeraBooleanSchema extends QeraSchema<boolean> {
+  toJSONSchema(): JSONSchema {
+    return { type: 'boolean' };
+  }
+
   _parse(data: any, path: string[]): ValidationResult<boolean> {
     if (typeof data !== 'boolean') {
       return {
@@ -483,6 +523,13 @@ export class QeraArraySchema<T> extends QeraSchema<T[]> {
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    const schema: JSONSchema = { type: 'array', items: this.element.toJSONSchema() };
+    if (this._min !== undefined) schema.minItems = this._min;
+    if (this._max !== undefined) schema.maxItems = this._max;
+    return schema;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T[]> {
     if (!Array.isArray(data)) {
       return {
@@ -617,6 +664,19 @@ export class QeraObjectSchema<T extends Record<string, any>> extends QeraSchema<
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    const properties: Record<string, JSONSchema> = {};
+    const required: string[] = [];
+    for (const [key, schema] of Object.entries(this.shape) as Array<[string, QeraSchema]>) {
+      properties[key] = schema.toJSONSchema();
+      if (!schema.isOptional()) required.push(key);
+    }
+    const schema: JSONSchema = { type: 'object', properties };
+    if (required.length > 0) schema.required = required;
+    if (this._strict) schema.additionalProperties = false;
+    return schema;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T> {
     if (typeof data !== 'object' || data === null || Array.isArray(data)) {
       return {
@@ -764,6 +824,14 @@ export class QeraOptionalSchema<T> extends QeraSchema<T | undefined> {
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    return this.innerSchema.toJSONSchema();
+  }
+
+  isOptional(): boolean {
+    return true;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T | undefined> {
     if (data === undefined) {
       return { success: true, data: undefined };
@@ -779,6 +847,10 @@ export class QeraNullableSchema<T> extends QeraSchema<T | null> {
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    return { anyOf: [this.innerSchema.toJSONSchema(), { type: 'null' }] };
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T | null> {
     if (data === null) {
       return { success: true, data: null };
@@ -797,6 +869,14 @@ export class QeraDefaultSchema<T> extends QeraSchema<T> {
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    return { ...this.innerSchema.toJSONSchema(), default: this.defaultValue };
+  }
+
+  isOptional(): boolean {
+    return true;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T> {
     if (data === undefined) {
       return { success: true, data: this.defaultValue };
@@ -816,6 +896,10 @@ export class QeraRefinementSchema<T> extends QeraSchema<T> {
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    return this.innerSchema.toJSONSchema();
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T> {
     const result = this.innerSchema._parse(data, path);
     if (!result.success) {
@@ -863,6 +947,10 @@ export class QeraUnionSchema<T extends readonly [QeraSchema<any>, ...QeraSchema<
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    return { anyOf: this.schemas.map(schema => schema.toJSONSchema()) };
+  }
+
   _parse(data: any, path: string[]): ValidationResult<any> {
     const issues: ValidationError[] = [];
 
@@ -909,6 +997,10 @@ export class QeraLiteralSchema<T extends string | number | boolean> extends Qera
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    return { const: this.value };
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T> {
     if (data !== this.value) {
       return {
@@ -951,6 +1043,10 @@ export class QeraEnumSchema<T extends readonly [string, ...string[]]> extends Qe
     super();
   }
 
+  toJSONSchema(): JSONSchema {
+    return { type: 'string', enum: [...this.values] };
+  }
+
   _parse(data: any, path: string[]): ValidationResult<T[number]> {
     if (!this.values.includes(data)) {
       return {
@@ -996,6 +1092,11 @@ export class QeraTransformSchema<T, U> extends QeraSchema<U> {
     super();
   }
 
+  // Describes the input; the transformed output has no schema
+  toJSONSchema(): JSONSchema {
+    return this.innerSchema.toJSONSchema();
+  }
+
   _parse(data: any, path: string[]): ValidationResult<U> {
     const result = this.innerSchema._parse(data, path);
     if (!result.success) {
@@ -1071,6 +1172,14 @@ export class QeraUnknownSchema extends QeraSchema<unknown> {
 }
 
 export class QeraVoidSchema extends QeraSchema<void> {
+  toJSONSchema(): JSONSchema {
+    return { not: {} };
+  }
+
+  isOptional(): boolean {
+    return true;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<void> {
     if (data !== undefined) {
       return {
@@ -1108,6 +1217,10 @@ export class QeraVoidSchema extends QeraSchema<void> {
 }
 
 export class QeraNullSchema extends QeraSchema<null> {
+  toJSONSchema(): JSONSchema {
+    return { type: 'null' };
+  }
+
   _parse(data: any, path: string[]): ValidationResult<null> {
     if (data !== null) {
       return {
@@ -1145,6 +1258,14 @@ export class QeraNullSchema extends QeraSchema<null> {
 }
 
 export class QeraUndefinedSchema extends QeraSchema<undefined> {
+  toJSONSchema(): JSONSchema {
+    return { not: {} };
+  }
+
+  isOptional(): boolean {
+    return true;
+  }
+
   _parse(data: any, path: string[]): ValidationResult<undefined> {
     if (data !== undefined) {
       return {
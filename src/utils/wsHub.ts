@@ -0,0 +1,142 @@
+import { WebSocket } from 'uWebSockets.js';
+import { QeraWebSocketContext } from '../types';
+import { Logger } from './logger';
+
+export type HubMessage = string | ArrayBuffer | Buffer;
+
+export interface HubOptions {
+  // Bytes a client may have queued before it counts as a slow consumer (default 1MB)
+  maxBackpressure?: number;
+  // Skip messages for slow consumers ('drop', the default) or close them ('disconnect')
+  onSlowConsumer?: 'drop' | 'disconnect';
+}
+
+// Close code sent to disconnected slow consumers ("try again later")
+const CLOSE_SLOW_CONSUMER = 1013;
+
+/**
+ * Tracks a set of WebSocket connections, optionally grouped into rooms, and
+ * broadcasts to them. Clients are identified by their underlying uWS socket,
+ * so the context passed to open() and close() may differ.
+ *
+ * Unlike uWS publish(), which only reaches sockets of the same uWS app, a hub
+ * spans every listener the app serves on.
+ */
+export class WebSocketHub {
+  private clients: Map<WebSocket<any>, Set<string>> = new Map();
+  private rooms: Map<string, Set<WebSocket<any>>> = new Map();
+  private maxBackpressure: number;
+  private onSlowConsumer: 'drop' | 'disconnect';
+
+  constructor(options: HubOptions = {}) {
+    this.maxBackpressure = options.maxBackpressure ?? 1024 * 1024;
+    this.onSlowConsumer = options.onSlowConsumer || 'drop';
+  }
+
+  // Number of registered clients
+  get size(): number {
+    return this.clients.size;
+  }
+
+  register(client: QeraWebSocketContext, ...rooms: string[]): void {
+    if (!this.clients.has(client.ws)) {
+      this.clients.set(client.ws, new Set());
+    }
+    for (const room of rooms) {
+      this.join(client, room);
+    }
+  }
+
+  // Remove a client from the hub and all of its rooms
+  unregister(client: QeraWebSocketContext): void {
+    this.remove(client.ws);
+  }
+
+  join(client: QeraWebSocketContext, room: string): void {
+    this.register(client);
+    this.clients.get(client.ws)!.add(room);
+
+    let members = this.rooms.get(room);
+    if (!members) {
+      members = new Set();
+      this.rooms.set(room, members);
+    }
+    members.add(client.ws);
+  }
+
+  leave(client: QeraWebSocketContext, room: string): void {
+    this.clients.get(client.ws)?.delete(room);
+    this.leaveRoom(client.ws, room);
+  }
+
+  // Number of clients in a room
+  roomSize(room: string): number {
+    return this.rooms.get(room)?.size || 0;
+  }
+
+  // Send to every registered client; returns how many were sent the message
+  broadcast(message: HubMessage, except?: QeraWebSocketContext): number {
+    return this.sendAll(this.clients.keys(), message, except?.ws);
+  }
+
+  // Send to the clients in a room; returns how many were sent the message
+  broadcastTo(room: string, message: HubMessage, except?: QeraWebSocketContext): number {
+    const members = this.rooms.get(room);
+    return members ? this.sendAll(members, message, except?.ws) : 0;
+  }
+
+  private sendAll(targets: Iterable<WebSocket<any>>, message: HubMessage, except?: WebSocket<any>): number {
+    const isBinary = typeof message !== 'string';
+    let delivered = 0;
+
+    // Copy first, since slow or closed clients are removed while iterating
+    for (const ws of [...targets]) {
+      if (ws === except) continue;
+
+      try {
+        if (ws.getBufferedAmount() > this.maxBackpressure) {
+          this.slowConsumer(ws);
+          continue;
+        }
+
+        // 2 means uWS dropped the message because its own backpressure limit was hit
+        if (ws.send(message, isBinary) === 2) {
+          this.slowConsumer(ws);
+          continue;
+        }
+
+        delivered++;
+      } catch {
+        // uWS throws when using a socket that has already closed
+        this.remove(ws);
+      }
+    }
+
+    return delivered;
+  }
+
+  private slowConsumer(ws: WebSocket<any>) {
+    if (this.onSlowConsumer === 'disconnect') {
+      Logger.warn('Disconnecting slow WebSocket consumer');
+      this.remove(ws);
+      ws.end(CLOSE_SLOW_CONSUMER, 'Slow consumer');
+    }
+  }
+
+  private remove(ws: WebSocket<any>) {
+    for (const room of this.clients.get(ws) || []) {
+      this.leaveRoom(ws, room);
+    }
+    this.clients.delete(ws);
+  }
+
+  private leaveRoom(ws: WebSocket<any>, room: string) {
+    const members = this.rooms.get(room);
+    if (!members) return;
+
+    members.delete(ws);
+    if (members.size === 0) {
+      this.rooms.delete(room);
+    }
+  }
+}
@@ -0,0 +1,268 @@
+/*
+ * XML bodies as plain objects, the shape parseXML() returns and toXML()
+ * takes. The document is an object with one key, the root element:
+ *
+ *   <order id="7"><item sku="A-1">2</item><item sku="B-2">1</item><note>gift</note></order>
+ *   { order: { '@id': '7', item: [{ '@sku': 'A-1', '#text': '2' }, { '@sku': 'B-2', '#text': '1' }], note: 'gift' } }
+ *
+ * Attributes are keys starting with "@", and an element's text is "#text",
+ * or the element itself when it has neither attributes nor children.
+ * Repeated child elements become an array. Values are strings, as in forms.
+ */
+
+// Name of an element or attribute, prefixes included (e.g. "soap:Envelope")
+const NAME = /[\p{L}_:][\p{L}\p{N}_.:-]*/uy;
+const XML_NAME = /^[\p{L}_:][\p{L}\p{N}_.:-]*$/u;
+const REFERENCE = /&(?:#x([0-9a-fA-F]+)|#([0-9]+)|(lt|gt|amp|quot|apos));/g;
+const NAMED_REFERENCES: Record<string, string> = { lt: '<', gt: '>', amp: '&', quot: '"', apos: "'" };
+
+// Deeper documents are refused rather than risking the stack
+const MAX_DEPTH = 1000;
+
+// Rejected for a body that isn't well-formed XML, with where it went wrong
+export class XMLSyntaxError extends Error {
+  line: number;
+  column: number;
+
+  constructor(message: string, line: number, column: number) {
+    super(`${message} at line ${line}, column ${column}`);
+    this.line = line;
+    this.column = column;
+    this.name = 'XMLSyntaxError';
+  }
+}
+
+/**
+ * Parse an XML document into the object form above. DOCTYPE declarations
+ * are refused, so neither external entities nor entity expansion bombs can
+ * be smuggled in; only the five predefined and numeric character
+ * references are decoded. Comments and processing instructions are skipped.
+ */
+export function parseXML(text: string): Record<string, any> {
+  return new XMLParser(text).document();
+}
+
+class XMLParser {
+  private pos = 0;
+
+  constructor(private text: string) {}
+
+  document(): Record<string, any> {
+    if (this.text.startsWith('\uFEFF')) this.pos = 1;
+    this.skipMisc();
+    if (this.text[this.pos] !== '<') this.fail('Expected the root element');
+    const [name, value] = this.element(1);
+    this.skipMisc();
+    if (this.pos < this.text.length) this.fail('Unexpected content after the root element');
+    return define({}, name, value);
+  }
+
+  private element(depth: number): [string, any] {
+    if (depth > MAX_DEPTH) this.fail(`Elements nested more than ${MAX_DEPTH} deep`);
+    this.pos++;
+    const name = this.name();
+    const attributes: Record<string, string> = {};
+
+    for (;;) {
+      const spaced = this.skipSpace();
+      if (this.text.startsWith('/>', this.pos)) {
+        this.pos += 2;
+        return [name, content(attributes, {}, '')];
+      }
+      if (this.text[this.pos] === '>') {
+        this.pos++;
+        break;
+      }
+      if (this.pos >= this.text.length) this.fail(`Unclosed start tag <${name}>`);
+      if (!spaced) this.fail(`Expected whitespace, ">" or "/>" in <${name}>`);
+      const start = this.pos;
+      const attribute = this.name();
+      this.skipSpace();
+      this.expect('=', `Expected "=" after attribute ${attribute}`);
+      this.skipSpace();
+      const quote = this.text[this.pos];
+      if (quote !== '"' && quote !== "'") this.fail(`Expected a quoted value for attribute ${attribute}`);
+      const end = this.text.indexOf(quote, this.pos + 1);
+      if (end === -1) this.fail(`Unterminated value of attribute ${attribute}`);
+      const raw = this.text.slice(this.pos + 1, end);
+      if (raw.includes('<')) this.fail(`"<" in the value of attribute ${attribute}`);
+      if (`@${attribute}` in attributes) {
+        this.pos = start;
+        this.fail(`Duplicate attribute ${attribute} in <${name}>`);
+      }
+      attributes[`@${attribute}`] = this.decode(raw);
+      this.pos = end + 1;
+    }
+
+    const children: Record<string, any> = {};
+    let text = '';
+    for (;;) {
+      if (this.pos >= this.text.length) this.fail(`Unclosed element <${name}>`);
+      if (this.text.startsWith('</', this.pos)) {
+        this.pos += 2;
+        const closing = this.name();
+        if (closing !== name) this.fail(`Expected </${name}>, found </${closing}>`);
+        this.skipSpace();
+        this.expect('>', `Expected ">" to end </${name}>`);
+        return [name, content(attributes, children, text)];
+      }
+      if (this.text.startsWith('<![CDATA[', this.pos)) {
+        const end = this.skipTo(']]>', 'Unterminated CDATA section');
+        text += this.text.slice(this.pos + 9, end);
+        this.pos = end + 3;
+      } else if (this.text.startsWith('<!--', this.pos) || this.text.startsWith('<?', this.pos)) {
+        this.skipMarkup();
+      } else if (this.text[this.pos] === '<') {
+        const [child, value] = this.element(depth + 1);
+        const existing = Object.prototype.hasOwnProperty.call(children, child) ? children[child] : undefined;
+        if (existing === undefined) {
+          define(children, child, value);
+        } else if (Array.isArray(existing)) {
+          existing.push(value);
+        } else {
+          define(children, child, [existing, value]);
+        }
+      } else {
+        const end = this.text.indexOf('<', this.pos);
+        const stop = end === -1 ? this.text.length : end;
+        text += this.decode(this.text.slice(this.pos, stop));
+        this.pos = stop;
+      }
+    }
+  }
+
+  // Whitespace, comments, processing instructions and the XML declaration
+  // outside the root element
+  private skipMisc() {
+    for (;;) {
+      this.skipSpace();
+      if (this.text.startsWith('<!DOCTYPE', this.pos)) this.fail('DOCTYPE declarations are not allowed');
+      if (!this.text.startsWith('<!--', this.pos) && !this.text.startsWith('<?', this.pos)) return;
+      this.skipMarkup();
+    }
+  }
+
+  // A comment or processing instruction at pos
+  private skipMarkup() {
+    const comment = this.text.startsWith('<!--', this.pos);
+    const close = comment ? '-->' : '?>';
+    this.pos = this.skipTo(close, comment ? 'Unterminated comment' : 'Unterminated processing instruction') + close.length;
+  }
+
+  private skipTo(close: string, problem: string): number {
+    const end = this.text.indexOf(close, this.pos);
+    if (end === -1) this.fail(problem);
+    return end;
+  }
+
+  private skipSpace(): boolean {
+    const start = this.pos;
+    while (/[ \t\r\n]/.test(this.text[this.pos] ?? '')) this.pos++;
+    return this.pos > start;
+  }
+
+  private name(): string {
+    NAME.lastIndex = this.pos;
+    const match = NAME.exec(this.text);
+    if (!match) this.fail('Expected a name');
+    this.pos += match[0].length;
+    return match[0];
+  }
+
+  private expect(char: string, problem: string) {
+    if (this.text[this.pos] !== char) this.fail(problem);
+    this.pos++;
+  }
+
+  private decode(raw: string): string {
+    if (raw.replace(REFERENCE, '').includes('&')) this.fail('Unknown entity or stray "&"');
+    return raw.replace(REFERENCE, (_, hex: string, decimal: string, named: string) => {
+      if (named) return NAMED_REFERENCES[named];
+      const code = hex ? parseInt(hex, 16) : parseInt(decimal, 10);
+      if (code > 0x10ffff) this.fail(`Invalid character reference &#${hex ? `x${hex}` : decimal};`);
+      return String.fromCodePoint(code);
+    });
+  }
+
+  private fail(message: string): never {
+    const before = this.text.slice(0, this.pos).split('\n');
+    throw new XMLSyntaxError(message, before.length, before[before.length - 1].length + 1);
+  }
+}
+
+// An element's value: its text alone when it has nothing else, otherwise an
+// object of attributes, children and any text that isn't just whitespace
+function content(attributes: Record<string, string>, children: Record<string, any>, text: string): any {
+  if (Object.keys(attributes).length === 0 && Object.keys(children).length === 0) {
+    return text;
+  }
+  const value = { ...attributes, ...children };
+  if (text.trim() !== '') value['#text'] = text;
+  return value;
+}
+
+// Assign without __proto__ reaching the prototype, as JSON.parse does
+function define(target: Record<string, any>, key: string, value: any): Record<string, any> {
+  Object.defineProperty(target, key, { value, enumerable: true, writable: true, configurable: true });
+  return target;
+}
+
+const escapeText = (text: string) => text.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+const escapeAttribute = (text: string) => escapeText(text).replace(/"/g, '&quot;');
+
+/**
+ * Serialize the object form above, with an XML declaration. Numbers and
+ * booleans are written as text and dates in ISO 8601; null writes an empty
+ * element and undefined nothing. Throws a TypeError for a value that isn't
+ * an object with exactly one key, invalid names and circular structures.
+ */
+export function toXML(document: Record<string, any>): string {
+  const roots = document !== null && typeof document === 'object' && !Array.isArray(document)
+    ? Object.keys(document).filter(key => document[key] !== undefined)
+    : [];
+  if (roots.length !== 1 || Array.isArray(document[roots[0]])) {
+    throw new TypeError('XML needs an object with one key, the root element, e.g. { order: { ... } }');
+  }
+  return `<?xml version="1.0" encoding="UTF-8"?>\n${element(roots[0], document[roots[0]], new Set())}`;
+}
+
+function element(name: string, value: any, seen: Set<object>): string {
+  if (!XML_NAME.test(name)) {
+    throw new TypeError(`Invalid XML element name: ${JSON.stringify(name)}`);
+  }
+  if (value === undefined) return '';
+  if (value === null) return `<${name}/>`;
+  if (Array.isArray(value)) {
+    return value.map(item => element(name, item, seen)).join('');
+  }
+  if (value instanceof Date) {
+    return `<${name}>${value.toISOString()}</${name}>`;
+  }
+  if (typeof value !== 'object') {
+    return `<${name}>${escapeText(String(value))}</${name}>`;
+  }
+
+  if (seen.has(value)) {
+    throw new TypeError('Converting circular structure to XML');
+  }
+  seen.add(value);
+  let attributes = '';
+  let inner = '';
+  for (const [key, item] of Object.entries(value)) {
+    if (item === undefined) continue;
+    if (key.startsWith('@')) {
+      if (item === null) continue;
+      const attribute = key.slice(1);
+      if (!XML_NAME.test(attribute)) {
+        throw new TypeError(`Invalid XML attribute name: ${JSON.stringify(attribute)}`);
+      }
+      attributes += ` ${attribute}="${escapeAttribute(item instanceof Date ? item.toISOString() : String(item))}"`;
+    } else if (key === '#text') {
+      if (item !== null) inner = escapeText(String(item)) + inner;
+    } else {
+      inner += element(key, item, seen);
+    }
+  }
+  seen.delete(value);
+  return inner === '' ? `<${name}${attributes}/>` : `<${name}${attributes}>${inner}</${name}>`;
+}
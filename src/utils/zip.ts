@@ -0,0 +1,166 @@
+import { Readable, pipeline } from 'stream';
+import { createDeflateRaw } from 'zlib';
+import { BodySource } from './stream';
+
+export interface ZipEntry {
+  // Path inside the archive, "/" separated, e.g. "reports/2024.csv"
+  name: string;
+  // Contents, given whole or as a source read while the archive is sent
+  data: string | Uint8Array | BodySource;
+  // Modification time shown by archive tools (default now)
+  modified?: Date;
+  // Store the entry as-is, e.g. for already compressed images (default false)
+  store?: boolean;
+}
+
+// Entries for an archive, produced as it is written
+export type ZipSource = Iterable<ZipEntry> | AsyncIterable<ZipEntry>;
+
+// Raised when an archive would need ZIP64, which isn't supported
+export class ZipTooLargeError extends Error {
+  constructor() {
+    super('ZIP archive too large: entries and the archive must stay under 4GB and 65535 entries');
+    this.name = 'ZipTooLargeError';
+  }
+}
+
+const CRC_TABLE = Array.from({ length: 256 }, (_, n) => {
+  let c = n;
+  for (let k = 0; k < 8; k++) {
+    c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+  }
+  return c >>> 0;
+});
+
+// CRC-32 as used by ZIP and gzip; pass the previous result to continue it
+export function crc32(data: Uint8Array, previous = 0): number {
+  let crc = ~previous;
+  for (let i = 0; i < data.length; i++) {
+    crc = CRC_TABLE[(crc ^ data[i]) & 0xff] ^ (crc >>> 8);
+  }
+  return ~crc >>> 0;
+}
+
+// MS-DOS date and time fields, in local time as archive tools expect
+function dosDateTime(date: Date): { time: number; date: number } {
+  const year = Math.min(Math.max(date.getFullYear(), 1980), 2107);
+  return {
+    time: (date.getHours() << 11) | (date.getMinutes() << 5) | (date.getSeconds() >> 1),
+    date: ((year - 1980) << 9) | ((date.getMonth() + 1) << 5) | date.getDate()
+  };
+}
+
+const LIMIT_32 = 0xffffffff;
+
+// General purpose flags: sizes and CRC follow the data (bit 3), names are UTF-8 (bit 11)
+const FLAGS = 0x0808;
+
+async function* chunksOf(data: ZipEntry['data']): AsyncGenerator<Buffer> {
+  if (typeof data === 'string') {
+    yield Buffer.from(data);
+  } else if (data instanceof Uint8Array) {
+    yield Buffer.from(data.buffer, data.byteOffset, data.byteLength);
+  } else {
+    for await (const chunk of data) {
+      yield typeof chunk === 'string' ? Buffer.from(chunk) : Buffer.from(chunk.buffer, chunk.byteOffset, chunk.byteLength);
+    }
+  }
+}
+
+// Raw deflate of chunks as they come; a failing source fails the output
+async function* deflate(chunks: AsyncIterable<Buffer>): AsyncGenerator<Buffer> {
+  const deflater = createDeflateRaw();
+  // Errors reach the loop below, which reads from the destroyed deflater
+  pipeline(Readable.from(chunks), deflater, () => undefined);
+  for await (const chunk of deflater) {
+    yield chunk;
+  }
+}
+
+/**
+ * A ZIP archive of entries as a stream of chunks, holding at most one
+ * chunk of an entry at a time. Sizes and CRCs aren't known before an
+ * entry's data has gone out, so each entry is followed by a data
+ * descriptor and the central directory at the end carries the final
+ * values. Throws ZipTooLargeError where ZIP64 would be needed.
+ */
+export async function* zipChunks(entries: ZipSource): AsyncGenerator<Buffer> {
+  const directory: Buffer[] = [];
+  let offset = 0;
+
+  for await (const entry of entries) {
+    const name = Buffer.from(entry.name.replace(/\\/g, '/').replace(/^\/+/, ''));
+    const method = entry.store ? 0 : 8;
+    const { time, date } = dosDateTime(entry.modified ?? new Date());
+
+    const local = Buffer.alloc(30);
+    local.writeUInt32LE(0x04034b50, 0);
+    local.writeUInt16LE(20, 4); // version needed: 2.0
+    local.writeUInt16LE(FLAGS, 6);
+    local.writeUInt16LE(method, 8);
+    local.writeUInt16LE(time, 10);
+    local.writeUInt16LE(date, 12);
+    // CRC and sizes (14-25) stay zero, they are in the data descriptor
+    local.writeUInt16LE(name.length, 26);
+    yield Buffer.concat([local, name]);
+
+    let crc = 0;
+    let size = 0;
+    let compressedSize = 0;
+    const input = async function* () {
+      for await (const chunk of chunksOf(entry.data)) {
+        crc = crc32(chunk, crc);
+        size += chunk.length;
+        yield chunk;
+      }
+    };
+    for await (const chunk of method === 8 ? deflate(input()) : input()) {
+      compressedSize += chunk.length;
+      yield chunk;
+    }
+    if (size > LIMIT_32 || compressedSize > LIMIT_32) {
+      throw new ZipTooLargeError();
+    }
+
+    const descriptor = Buffer.alloc(16);
+    descriptor.writeUInt32LE(0x08074b50, 0);
+    descriptor.writeUInt32LE(crc, 4);
+    descriptor.writeUInt32LE(compressedSize, 8);
+    descriptor.writeUInt32LE(size, 12);
+    yield descriptor;
+
+    const central = Buffer.alloc(46);
+    central.writeUInt32LE(0x02014b50, 0);
+    central.writeUInt16LE(20, 4); // version made by: 2.0, MS-DOS attributes
+    central.writeUInt16LE(20, 6);
+    central.writeUInt16LE(FLAGS, 8);
+    central.writeUInt16LE(method, 10);
+    central.writeUInt16LE(time, 12);
+    central.writeUInt16LE(date, 14);
+    central.writeUInt32LE(crc, 16);
+    central.writeUInt32LE(compressedSize, 20);
+    central.writeUInt32LE(size, 24);
+    central.writeUInt16LE(name.length, 28);
+    // Extra field, comment, disk number and attributes (30-41) stay zero
+    central.writeUInt32LE(offset, 42);
+    directory.push(central, name);
+
+    offset += local.length + name.length + compressedSize + descriptor.length;
+    if (offset > LIMIT_32 || directory.length / 2 > 0xffff) {
+      throw new ZipTooLargeError();
+    }
+  }
+
+  const directorySize = directory.reduce((total, part) => total + part.length, 0);
+  if (offset + directorySize > LIMIT_32) {
+    throw new ZipTooLargeError();
+  }
+  const end = Buffer.alloc(22);
+  end.writeUInt32LE(0x06054b50, 0);
+  // Disk numbers (4-7) stay zero
+  end.writeUInt16LE(directory.length / 2, 8);
+  end.writeUInt16LE(directory.length / 2, 10);
+  end.writeUInt32LE(directorySize, 12);
+  end.writeUInt32LE(offset, 16);
+  yield Buffer.concat([...directory, end]);
+}
@@ -0,0 +1,279 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { brotliCompressSync, deflateSync, gzipSync } from 'zlib';
+import { Qera } from '../../src/core/app';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('Per-route body size limits', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, bodyLimit: 100 });
+    const echoLength = (ctx: any) => ctx.json({ length: ctx.body.length });
+
+    app.post('/small', echoLength, { maxBodySize: 10 });
+    app.post('/upload', echoLength, { maxBodySize: '1kb' });
+    app.post('/default', echoLength);
+    app.post('/bytes', echoLength, { maxBodySize: '8' });
+    app.group('/api').post('/tiny', echoLength, { maxBodySize: 4 });
+
+    app.listen(3458, 'localhost');
+    server = lastApp();
+  });
+
+  it('should reject a declared Content-Length above the route limit', async () => {
+    const body = 'x'.repeat(20);
+    const res = await request(server, 'POST', '/small', {
+      headers: { 'content-type': 'text/plain', 'content-length': String(body.length) },
+      body
+    });
+
+    expect(res.status).toBe(413);
+    expect(JSON.parse(res.body)).toEqual({ error: 'Payload Too Large' });
+  });
+
+  it('should reject chunked bodies that grow past the limit', async () => {
+    const res = await request(server, 'POST', '/small', {
+      headers: { 'content-type': 'text/plain' },
+      chunks: ['123456', '789012']
+    });
+
+    expect(res.status).toBe(413);
+  });
+
+  it('should allow a route to raise the global limit', async () => {
+    const res = await request(server, 'POST', '/upload', {
+      headers: { 'content-type': 'text/plain' },
+      body: 'x'.repeat(500)
+    });
+
+    expect(res.status).toBe(200);
+    expect(JSON.parse(res.body)).toEqual({ length: 500 });
+  });
+
+  it('should read a limit without a unit as bytes', async () => {
+    const send = (body: string) => request(server, 'POST', '/bytes', { headers: { 'content-type': 'text/plain' }, body });
+
+    expect((await send('x'.repeat(8))).status).toBe(200);
+    expect((await send('x'.repeat(9))).status).toBe(413);
+  });
+
+  it('should apply the global limit to other routes', async () => {
+    const res = await request(server, 'POST', '/default', {
+      headers: { 'content-type': 'text/plain' },
+      body: 'x'.repeat(500)
+    });
+
+    expect(res.status).toBe(413);
+  });
+
+  it('should accept bodies within the limit', async () => {
+    const res = await request(server, 'POST', '/small', {
+      headers: { 'content-type': 'text/plain', 'content-length': '5' },
+      body: 'hello'
+    });
+
+    expect(JSON.parse(res.body)).toEqual({ length: 5 });
+  });
+
+  it('should pass route options through groups', async () => {
+    const res = await request(server, 'POST', '/api/tiny', {
+      headers: { 'content-type': 'text/plain' },
+      body: 'hello'
+    });
+
+    expect(res.status).toBe(413);
+  });
+});
+
+describe('Body timeouts', () => {
+  let server: MockApp;
+  const handled: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, bodyTimeout: 50 });
+    const record = (ctx: any) => {
+      handled.push(ctx.route.path);
+      ctx.json({ length: ctx.body.length });
+    };
+
+    app.post('/upload', record);
+    app.post('/patient', record, { bodyTimeout: 0 });
+
+    app.listen(3478, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    handled.length = 0;
+  });
+
+  it('should answer 408 when the body arrives too slowly', async () => {
+    const res = await request(server, 'POST', '/upload', {
+      headers: { 'content-type': 'text/plain' },
+      chunks: ['a', 'b', 'c', 'd'],
+      chunkDelay: 30
+    });
+
+    expect(res.status).toBe(408);
+    expect(JSON.parse(res.body)).toEqual({ error: 'Request Timeout' });
+    expect(handled).toEqual([]);
+  });
+
+  it('should accept bodies that arrive in time', async () => {
+    const res = await request(server, 'POST', '/upload', {
+      headers: { 'content-type': 'text/plain' },
+      chunks: ['ab', 'cd'],
+      chunkDelay: 5
+    });
+
+    expect(JSON.parse(res.body)).toEqual({ length: 4 });
+  });
+
+  it('should let routes disable the timeout', async () => {
+    const res = await request(server, 'POST', '/patient', {
+      headers: { 'content-type': 'text/plain' },
+      chunks: ['a', 'b', 'c'],
+      chunkDelay: 30
+    });
+
+    expect(JSON.parse(res.body)).toEqual({ length: 3 });
+  });
+
+  it('should stop reading when the client disconnects', async () => {
+    const res = await request(server, 'POST', '/patient', {
+      headers: { 'content-type': 'text/plain' },
+      chunks: ['a', 'b', 'c'],
+      chunkDelay: 30,
+      abortAfter: 40
+    });
+    await new Promise(resolve => setTimeout(resolve, 60));
+
+    expect(res.closed).toBe(true);
+    expect(handled).toEqual([]);
+  });
+});
+
+describe('Multipart limits', () => {
+  let server: MockApp;
+  const boundary = 'XyZ';
+  const headers = { 'content-type': `multipart/form-data; boundary=${boundary}` };
+  const part = (name: string, content: string, filename?: string) =>
+    `--${boundary}\r\nContent-Disposition: form-data; name="${name}"${filename ? `; filename="${filename}"` : ''}\r\n\r\n${content}\r\n`;
+  const form = (...parts: string[]) => `${parts.join('')}--${boundary}--\r\n`;
+
+  beforeAll(() => {
+    const app = new Qera({
+      logging: { level: 'error' },
+      bodyLimit: 100,
+      multipart: { maxParts: 5, maxFileSize: 200, maxTotalSize: 1000 }
+    });
+    const names = (ctx: any) => ctx.json({ fields: Object.keys(ctx.body) });
+
+    app.post('/form', names);
+    app.post('/avatar', names, { multipart: { maxFileSize: 50 } });
+    app.post('/small', names, { maxBodySize: 150 });
+
+    app.listen(3489, 'localhost');
+    server = lastApp();
+  });
+
+  const post = (url: string, body: string) =>
+    request(server, 'POST', url, { headers, chunks: body.match(/[^]{1,64}/g)! });
+
+  it('should accept forms above bodyLimit but within maxTotalSize', async () => {
+    const res = await post('/form', form(part('title', 'x'.repeat(300)), part('file', 'y'.repeat(150), 'a.txt')));
+
+    expect(res.status).toBe(200);
+    expect(JSON.parse(res.body)).toEqual({ fields: ['title', 'file'] });
+  });
+
+  it('should answer 413 for too many parts', async () => {
+    const res = await post('/form', form(...Array.from({ length: 6 }, (_, i) => part(`f${i}`, 'x'))));
+
+    expect(res.status).toBe(413);
+    expect(JSON.parse(res.body)).toEqual({ error: 'Payload Too Large' });
+  });
+
+  it('should answer 413 for an oversized file', async () => {
+    expect((await post('/form', form(part('file', 'y'.repeat(250), 'a.txt')))).status).toBe(413);
+  });
+
+  it('should answer 413 for forms above maxTotalSize', async () => {
+    const fields = Array.from({ length: 4 }, (_, i) => part(`f${i}`, 'x'.repeat(300)));
+
+    expect((await post('/form', form(...fields))).status).toBe(413);
+  });
+
+  it('should merge route limits over the global ones', async () => {
+    expect((await post('/avatar', form(part('file', 'y'.repeat(60), 'a.png')))).status).toBe(413);
+    expect((await post('/avatar', form(part('file', 'y'.repeat(40), 'a.png')))).status).toBe(200);
+  });
+
+  it('should let a route maxBodySize beat the global maxTotalSize', async () => {
+    expect((await post('/small', form(part('title', 'x'.repeat(200))))).status).toBe(413);
+  });
+});
+
+describe('Request decompression', () => {
+  let server: MockApp;
+  const order = JSON.stringify({ sku: 'A-1', qty: 2, note: 'gift wrap, please' });
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, bodyLimit: '1mb', decompressRequests: { maxRatio: 20 } });
+    const echo = (ctx: any) => ctx.json({ body: ctx.body, ratio: ctx.compressionRatio ?? null });
+
+    app.post('/orders', echo);
+    app.post('/small', echo, { maxBodySize: 1000 });
+    app.post('/raw', (ctx) => ctx.json({ length: ctx.peekBody().length, ratio: ctx.compressionRatio ?? null }), {
+      decompressRequests: false
+    });
+
+    app.listen(3509, 'localhost');
+    server = lastApp();
+  });
+
+  const post = (url: string, encoding: string, body: Buffer | string) =>
+    request(server, 'POST', url, { headers: { 'content-type': 'application/json', 'content-encoding': encoding }, body });
+
+  it('should inflate gzip, deflate and br bodies and report the ratio', async () => {
+    for (const [encoding, compress] of [['gzip', gzipSync], ['deflate', deflateSync], ['br', brotliCompressSync]] as const) {
+      const compressed = compress(order);
+      const response = await post('/orders', encoding, compressed);
+
+      expect(response.status).toBe(200);
+      expect(JSON.parse(response.body)).toEqual({ body: JSON.parse(order), ratio: order.length / compressed.length });
+    }
+  });
+
+  it('should refuse a body that inflates past the ratio limit', async () => {
+    // 1mb of zeros compresses about a thousandfold
+    const bomb = gzipSync(Buffer.alloc(1024 * 1024, '0'));
+    const response = await post('/orders', 'gzip', bomb);
+
+    expect(response.status).toBe(400);
+    expect(JSON.parse(response.body)).toEqual({ error: 'Request body expands more than 20 times when decompressed' });
+  });
+
+  it('should hold the inflated body to the body limit', async () => {
+    const text = JSON.stringify({ words: Array.from({ length: 200 }, (_, i) => `word${i}`) });
+    const response = await post('/small', 'gzip', gzipSync(text));
+
+    expect(response.status).toBe(413);
+  });
+
+  it('should refuse unknown encodings and corrupt data', async () => {
+    expect((await post('/orders', 'compress', 'abc')).status).toBe(415);
+
+    const corrupt = await post('/orders', 'gzip', Buffer.from('not gzip at all'));
+    expect(corrupt.status).toBe(400);
+    expect(JSON.parse(corrupt.body)).toEqual({ error: 'Malformed request body: invalid gzip data' });
+  });
+
+  it('should leave bodies alone on routes that opt out', async () => {
+    const compressed = gzipSync(order);
+    const response = await post('/raw', 'gzip', compressed);
+
+    expect(JSON.parse(response.body)).toEqual({ length: compressed.length, ratio: null });
+  });
+});
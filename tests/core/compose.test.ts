@@ -0,0 +1,190 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { chain } from '../../src/core/compose';
+import { Middleware } from '../../src/types';
+import { lastApp, request } from '../helpers/mockUws';
+
+// Middleware that records when it runs before and after the rest of the chain
+function trace(calls: string[], name: string): Middleware {
+  return async (ctx, next) => {
+    calls.push(`${name}:before`);
+    await next();
+    calls.push(`${name}:after`);
+  };
+}
+
+describe('chain', () => {
+  const ctx: any = {};
+
+  it('should run like the same middleware applied one after another', async () => {
+    const chained: string[] = [];
+    const sequential: string[] = [];
+
+    await chain(trace(chained, 'a'), trace(chained, 'b'))(ctx, async () => {
+      chained.push('next');
+    });
+
+    const a = trace(sequential, 'a');
+    const b = trace(sequential, 'b');
+    await a(ctx, () => Promise.resolve(b(ctx, async () => {
+      sequential.push('next');
+    })));
+
+    expect(chained).toEqual(sequential);
+    expect(chained).toEqual(['a:before', 'b:before', 'next', 'b:after', 'a:after']);
+  });
+
+  it('should stop when a middleware does not call next', async () => {
+    const calls: string[] = [];
+    const next = jest.fn(async () => undefined);
+
+    await chain(trace(calls, 'a'), () => undefined, trace(calls, 'b'))(ctx, next);
+
+    expect(calls).toEqual(['a:before', 'a:after']);
+    expect(next).not.toHaveBeenCalled();
+  });
+
+  it('should append without changing the original chain', async () => {
+    const calls: string[] = [];
+    const base = chain(trace(calls, 'a'));
+    const extended = base.append(trace(calls, 'b'), trace(calls, 'c'));
+
+    await base(ctx, async () => undefined);
+    expect(calls).toEqual(['a:before', 'a:after']);
+
+    calls.length = 0;
+    await extended(ctx, async () => undefined);
+    expect(calls).toEqual(['a:before', 'b:before', 'c:before', 'c:after', 'b:after', 'a:after']);
+    expect(base.middlewares).toHaveLength(1);
+  });
+
+  it('should nest inside other chains', async () => {
+    const calls: string[] = [];
+    const inner = chain(trace(calls, 'b'));
+
+    await chain(trace(calls, 'a'), inner, trace(calls, 'c'))(ctx, async () => undefined);
+
+    expect(calls).toEqual(['a:before', 'b:before', 'c:before', 'c:after', 'b:after', 'a:after']);
+  });
+
+  it('should build route handlers and work as app middleware', async () => {
+    const calls: string[] = [];
+    const authenticated = chain(
+      async (ctx, next) => {
+        if (ctx.headers.authorization !== 'secret') {
+          return ctx.status(401).json({ error: 'Unauthorized' });
+        }
+        await next();
+      },
+      trace(calls, 'audit')
+    );
+
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/me', authenticated.handle((ctx) => ctx.json({ user: 'ada' })));
+    app.group('/admin', authenticated).get('/stats', (ctx) => ctx.json({ ok: true }));
+    app.listen(3466, 'localhost');
+    const server = lastApp();
+
+    const denied = await request(server, 'GET', '/me');
+    const me = await request(server, 'GET', '/me', { headers: { authorization: 'secret' } });
+    const stats = await request(server, 'GET', '/admin/stats', { headers: { authorization: 'secret' } });
+
+    expect(denied.status).toBe(401);
+    expect(JSON.parse(me.body)).toEqual({ user: 'ada' });
+    expect(JSON.parse(stats.body)).toEqual({ ok: true });
+    expect(calls).toEqual(['audit:before', 'audit:after', 'audit:before', 'audit:after']);
+  });
+});
+
+describe('abort', () => {
+  it('should stop the chain so later middleware and the handler never run', async () => {
+    const calls: string[] = [];
+    let handled = 0;
+
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(trace(calls, 'logger'));
+    app.use(async (ctx, next) => {
+      if (ctx.headers.authorization !== 'secret') {
+        ctx.abortWithStatus(401);
+      }
+      // A no-op once aborted
+      await next();
+    });
+    app.use(trace(calls, 'audit'));
+    app.group('/admin', async (ctx, next) => {
+      if (ctx.query.role !== 'admin') {
+        ctx.status(403).json({ error: 'Admins only' });
+        ctx.abort();
+      }
+      await next();
+      calls.push(`group:aborted=${ctx.isAborted()}`);
+    }).get('/stats', (ctx) => {
+      handled++;
+      ctx.json({ ok: true });
+    });
+    app.listen(3486, 'localhost');
+    const server = lastApp();
+
+    const denied = await request(server, 'GET', '/admin/stats');
+    expect(denied.status).toBe(401);
+    expect(denied.body).toBe('Unauthorized');
+    expect(calls).toEqual(['logger:before', 'logger:after']);
+
+    calls.length = 0;
+    const forbidden = await request(server, 'GET', '/admin/stats', { headers: { authorization: 'secret' } });
+    expect(calls).toEqual(['logger:before', 'audit:before', 'group:aborted=true', 'audit:after', 'logger:after']);
+    expect(handled).toBe(0);
+    expect(forbidden.status).toBe(403);
+
+    const allowed = await request(server, 'GET', '/admin/stats?role=admin', { headers: { authorization: 'secret' } });
+    expect(JSON.parse(allowed.body)).toEqual({ ok: true });
+    expect(handled).toBe(1);
+  });
+});
+
+describe('ctx.next()', () => {
+  it('should run the rest of the chain so middleware can act after the handler', async () => {
+    const calls: string[] = [];
+    const errors: string[] = [];
+    let handlerRuns = 0;
+
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(async (ctx) => {
+      calls.push('timer:start');
+      try {
+        await ctx.next();
+      } catch (error) {
+        errors.push((error as Error).message);
+        ctx.status(502).json({ error: 'Upstream failed' });
+      }
+      calls.push(`timer:stop ${ctx.statusCode}`);
+    });
+    app.use(trace(calls, 'audit'));
+    app.group('/api', async (ctx) => {
+      calls.push('group');
+      await ctx.next();
+    }).get('/items', async (ctx) => {
+      handlerRuns++;
+      // Nothing left to run from the handler
+      await ctx.next();
+      ctx.json([1, 2]);
+    });
+    app.get('/broken', () => {
+      throw new Error('upstream timeout');
+    });
+    app.listen(3487, 'localhost');
+    const server = lastApp();
+
+    const items = await request(server, 'GET', '/api/items');
+    expect(JSON.parse(items.body)).toEqual([1, 2]);
+    expect(handlerRuns).toBe(1);
+    expect(calls).toEqual(['timer:start', 'audit:before', 'group', 'audit:after', 'timer:stop 200']);
+
+    calls.length = 0;
+    const broken = await request(server, 'GET', '/broken');
+    expect(broken.status).toBe(502);
+    expect(errors).toEqual(['upstream timeout']);
+    expect(calls).toEqual(['timer:start', 'audit:before', 'timer:stop 502']);
+  });
+});
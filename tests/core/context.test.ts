@@ -0,0 +1,2209 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { Logger } from '../../src/utils/logger';
+import { v } from '../../src/utils/validator';
+import { errorHandler, safe, HttpError } from '../../src/middlewares';
+import { memoryFileSystem } from '../../src/utils/staticFiles';
+import { ConnectionClosedError } from '../../src/utils/stream';
+import { etagMatches } from '../../src/utils/etag';
+import { EventBroker } from '../../src/utils/eventBroker';
+import { ParamBindError } from '../../src/utils/bindUri';
+import { Duplex, Readable } from 'stream';
+import { createHmac } from 'crypto';
+import { brotliDecompressSync, gunzipSync, gzipSync, inflateSync } from 'zlib';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('Qera Context', () => {
+  let app: Qera;
+  let server: MockApp;
+
+  beforeAll(() => {
+    app = new Qera({ logging: { level: 'error' } });
+  });
+
+  // Routes are registered on listen, so each suite registers first and then starts
+  function start() {
+    app.listen(3456, 'localhost');
+    server = lastApp();
+  }
+
+  describe('signal', () => {
+    let abortedDuringHandler: Promise<boolean>;
+    let batchesDone: number;
+    let disconnectedBefore: boolean;
+    let batchFinished: () => void;
+
+    beforeAll(() => {
+      app.get('/slow', async (ctx) => {
+        abortedDuringHandler = new Promise(resolve => {
+          const timer = setTimeout(() => resolve(false), 1000);
+          ctx.signal.addEventListener('abort', () => {
+            clearTimeout(timer);
+            resolve(true);
+          });
+        });
+
+        if (await abortedDuringHandler) return;
+        ctx.json({ done: true });
+      });
+
+      app.get('/fast', (ctx) => {
+        ctx.json({ aborted: ctx.signal.aborted, disconnected: ctx.clientDisconnected() });
+      });
+
+      app.get('/batch', async (ctx) => {
+        batchesDone = 0;
+        disconnectedBefore = ctx.clientDisconnected();
+        for (let batch = 0; batch < 100 && !ctx.clientDisconnected(); batch++) {
+          await new Promise(resolve => setTimeout(resolve, 5));
+          batchesDone++;
+        }
+        batchFinished();
+        if (!ctx.clientDisconnected()) ctx.json({ batchesDone });
+      });
+
+      start();
+    });
+
+    it('should abort when the client disconnects mid-request', async () => {
+      await request(server, 'GET', '/slow', { abortAfter: 20 });
+
+      expect(await abortedDuringHandler).toBe(true);
+    });
+
+    it('should not be aborted for connected clients', async () => {
+      const response = await request(server, 'GET', '/fast');
+
+      expect(JSON.parse(response.body)).toEqual({ aborted: false, disconnected: false });
+    });
+
+    it('should let handlers poll for a client that went away mid-request', async () => {
+      const finished = new Promise<void>(resolve => {
+        batchFinished = resolve;
+      });
+      await request(server, 'GET', '/batch', { abortAfter: 30 });
+      await finished;
+
+      expect(disconnectedBefore).toBe(false);
+      expect(batchesDone).toBeGreaterThan(0);
+      expect(batchesDone).toBeLessThan(20);
+    });
+  });
+
+  describe('status and committed', () => {
+    let committedBefore: boolean;
+    let committedAfter: boolean;
+    let statusAfter: number;
+
+    beforeAll(() => {
+      app.get('/late-status', (ctx) => {
+        committedBefore = ctx.committed;
+        ctx.json({ ok: true });
+        committedAfter = ctx.committed;
+        ctx.status(500);
+        statusAfter = ctx.statusCode;
+      });
+
+      app.get('/status-after-header', (ctx) => {
+        ctx.header('X-Trace', 'abc');
+        ctx.status(201);
+        ctx.json({ created: true });
+      });
+
+      app.get('/double-write', (ctx) => {
+        ctx.send('first');
+        ctx.send('second');
+      });
+
+      app.get('/throw-after-write', (ctx) => {
+        ctx.json({ ok: true });
+        throw new Error('too late');
+      });
+
+      app.get('/problem', (ctx) => {
+        ctx.status(400).header('Content-Type', 'application/problem+json').json({ title: 'Bad' });
+      });
+
+      start();
+    });
+
+    it('should ignore and warn about status changes after the body is written', async () => {
+      const warn = jest.spyOn(Logger, 'warn');
+
+      const response = await request(server, 'GET', '/late-status');
+
+      expect(response.status).toBe(200);
+      expect(committedBefore).toBe(false);
+      expect(committedAfter).toBe(true);
+      expect(statusAfter).toBe(200);
+      expect(warn).toHaveBeenCalled();
+      warn.mockRestore();
+    });
+
+    it('should allow the status to change until the body is written', async () => {
+      const response = await request(server, 'GET', '/status-after-header');
+
+      expect(response.status).toBe(201);
+      expect(response.header('X-Trace')).toBe('abc');
+    });
+
+    it('should ignore a second body write', async () => {
+      const response = await request(server, 'GET', '/double-write');
+
+      expect(response.body).toBe('first');
+    });
+
+    it('should keep the sent response when the handler throws afterwards', async () => {
+      const response = await request(server, 'GET', '/throw-after-write');
+
+      expect(response.status).toBe(200);
+      expect(JSON.parse(response.body)).toEqual({ ok: true });
+    });
+
+    it('should not override an explicit Content-Type', async () => {
+      const response = await request(server, 'GET', '/problem');
+
+      expect(response.status).toBe(400);
+      expect(response.header('Content-Type')).toBe('application/problem+json');
+    });
+  });
+
+  describe('params', () => {
+    beforeAll(() => {
+      app.get('/orgs/:org/repos/:repo', (ctx) => {
+        ctx.json({ params: ctx.params, all: ctx.allParams() });
+      });
+
+      app.get('/items/:id', (ctx) => {
+        ctx.json({ id: ctx.paramInt('id') ?? null, missing: ctx.paramInt('other') ?? null });
+      });
+
+      app.get('/documents/:id', (ctx) => ctx.json({ id: ctx.paramUUID('id') }));
+      app.get('/documents/:id/owner', (ctx) => ctx.json({ owner: ctx.paramUUID('owner') }));
+
+      start();
+    });
+
+    it('should expose params as a map and in declaration order', async () => {
+      const response = await request(server, 'GET', '/orgs/acme/repos/api');
+
+      expect(JSON.parse(response.body)).toEqual({
+        params: { org: 'acme', repo: 'api' },
+        all: [{ name: 'org', value: 'acme' }, { name: 'repo', value: 'api' }]
+      });
+    });
+
+    it('should parse integer params', async () => {
+      const response = await request(server, 'GET', '/items/42');
+
+      expect(JSON.parse(response.body)).toEqual({ id: 42, missing: null });
+    });
+
+    it('should reject non-integer params', async () => {
+      const response = await request(server, 'GET', '/items/4x2');
+
+      expect(JSON.parse(response.body)).toEqual({ id: null, missing: null });
+    });
+
+    it('should parse UUID params into canonical form', async () => {
+      const response = await request(server, 'GET', '/documents/3F2504E0-4F89-11D3-9A0C-0305E82C3301');
+
+      expect(response.status).toBe(200);
+      expect(JSON.parse(response.body)).toEqual({ id: '3f2504e0-4f89-11d3-9a0c-0305e82c3301' });
+    });
+
+    it('should answer 400 for params that are not UUIDs', async () => {
+      for (const id of ['3f2504e0-4f89-11d3-9a0c', '3f2504e04f8911d39a0c0305e82c3301', 'zf2504e0-4f89-11d3-9a0c-0305e82c3301']) {
+        const response = await request(server, 'GET', `/documents/${id}`);
+
+        expect(response.status).toBe(400);
+        expect(JSON.parse(response.body)).toEqual({
+          error: `Invalid path parameter "id": expected a UUID, received "${id}"`
+        });
+      }
+    });
+
+    it('should answer 400 for a missing UUID param', async () => {
+      const response = await request(server, 'GET', '/documents/3f2504e0-4f89-11d3-9a0c-0305e82c3301/owner');
+
+      expect(response.status).toBe(400);
+      expect(JSON.parse(response.body)).toEqual({ error: 'Invalid path parameter "owner": expected a UUID, received ""' });
+    });
+  });
+
+  describe('query', () => {
+    let validated: any;
+
+    beforeAll(() => {
+      app.get('/search', (ctx) => {
+        validated = ctx.validateQuery({ safeParse: (data: any) => ({ success: true, data }) } as any);
+        ctx.json({ query: ctx.query, tags: ctx.queryArray('tag'), missing: ctx.queryArray('none') });
+      });
+
+      start();
+    });
+
+    it('should use the first value of repeated params', async () => {
+      const response = await request(server, 'GET', '/search?tag=a&tag=b&empty=&empty=x');
+
+      expect(JSON.parse(response.body)).toEqual({
+        query: { tag: 'a', empty: '' },
+        tags: ['a', 'b'],
+        missing: []
+      });
+    });
+
+    it('should validate the same values the handler sees', async () => {
+      await request(server, 'GET', '/search?tag=a&tag=b');
+
+      expect(validated).toEqual({ tag: 'a' });
+    });
+
+    it('should not let params shadow object properties', async () => {
+      const response = await request(server, 'GET', '/search?toString=1&__proto__=x');
+
+      expect(JSON.parse(response.body).query).toEqual({ toString: '1' });
+    });
+  });
+
+  describe('fingerprint', () => {
+    beforeAll(() => {
+      app.get('/catalog', (ctx) => ctx.json({ key: ctx.fingerprint() }));
+      app.get('/catalog/filtered', (ctx) => ctx.json({
+        key: ctx.fingerprint({ query: ['q'], headers: ['Accept-Language'] }),
+        parts: ctx.fingerprintParts({ query: ['q'], headers: ['Accept-Language'] })
+      }));
+      app.post('/catalog', (ctx) => ctx.json({ key: ctx.fingerprint({ body: true }) }));
+
+      start();
+    });
+
+    const key = async (method: string, url: string, opts: Record<string, any> = {}) =>
+      JSON.parse((await request(server, method, url, opts)).body).key;
+
+    it('should not depend on query order', async () => {
+      const first = await key('GET', '/catalog?b=2&a=1');
+
+      expect(await key('GET', '/catalog?a=1&b=2')).toBe(first);
+      expect(await key('GET', '/catalog?a=1&b=3')).not.toBe(first);
+      expect(await key('GET', '/catalog')).not.toBe(first);
+    });
+
+    it('should only include the selected query params and headers', async () => {
+      const response = await request(server, 'GET', '/catalog/filtered?utm_source=mail&q=shoes', {
+        headers: { 'accept-language': 'de' }
+      });
+      const { key: first, parts } = JSON.parse(response.body);
+
+      expect(parts).toEqual({
+        method: 'GET',
+        path: '/catalog/filtered',
+        query: [['q', 'shoes']],
+        headers: [['accept-language', 'de']]
+      });
+      expect(await key('GET', '/catalog/filtered?q=shoes', { headers: { 'accept-language': 'de' } })).toBe(first);
+      expect(await key('GET', '/catalog/filtered?q=shoes', { headers: { 'accept-language': 'fr' } })).not.toBe(first);
+    });
+
+    it('should include the body when asked', async () => {
+      const post = (body: string) => key('POST', '/catalog', { headers: { 'content-type': 'application/json' }, body });
+      const first = await post('{"sku":"A-1","qty":2}');
+
+      expect(await post('{"qty":2,"sku":"A-1"}')).toBe(first);
+      expect(await post('{"sku":"A-1","qty":3}')).not.toBe(first);
+      expect(await key('GET', '/catalog')).not.toBe(first);
+    });
+  });
+
+  describe('conditional requests', () => {
+    let version = 1;
+    const etag = () => `"v${version}"`;
+
+    beforeAll(() => {
+      app.put('/documents/1', (ctx) => {
+        const tags = ctx.ifMatch();
+        if (tags.length === 0) {
+          return ctx.status(428).json({ error: 'If-Match required' });
+        }
+        if (!etagMatches(tags, etag())) {
+          return ctx.preconditionFailed();
+        }
+        version++;
+        ctx.header('ETag', etag()).json({ version });
+      });
+      app.get('/documents/1/tags', (ctx) => ctx.json({ ifMatch: ctx.ifMatch(), ifNoneMatch: ctx.ifNoneMatch() }));
+
+      start();
+    });
+
+    const update = (ifMatch?: string) =>
+      request(server, 'PUT', '/documents/1', { headers: ifMatch ? { 'if-match': ifMatch } : {} });
+
+    const updatedAt = new Date(Date.UTC(2024, 0, 2, 3, 4, 5, 678));
+
+    it('should answer GET with 304 when not modified since', async () => {
+      let handlerFinished = false;
+      app.get('/reports/1', (ctx) => {
+        ctx.lastModified(updatedAt);
+        // Ignored quietly after a 304
+        ctx.json({ report: 1 });
+        handlerFinished = true;
+      });
+      start();
+      const warn = jest.spyOn(Logger, 'warn');
+
+      const fresh = await request(server, 'GET', '/reports/1');
+      const cached = await request(server, 'GET', '/reports/1', {
+        headers: { 'if-modified-since': 'Tue, 02 Jan 2024 03:04:05 GMT' }
+      });
+      const stale = await request(server, 'GET', '/reports/1', {
+        headers: { 'if-modified-since': 'Tue, 02 Jan 2024 03:04:04 GMT' }
+      });
+      const etagWins = await request(server, 'GET', '/reports/1', {
+        headers: { 'if-modified-since': 'Tue, 02 Jan 2024 03:04:05 GMT', 'if-none-match': '"x"' }
+      });
+
+      expect(fresh.status).toBe(200);
+      expect(JSON.parse(fresh.body)).toEqual({ report: 1 });
+      expect(fresh.header('Last-Modified')).toBe('Tue, 02 Jan 2024 03:04:05 GMT');
+      expect(cached.status).toBe(304);
+      expect(cached.withoutBody).toBe(true);
+      expect(cached.header('Last-Modified')).toBe('Tue, 02 Jan 2024 03:04:05 GMT');
+      expect(stale.status).toBe(200);
+      expect(etagWins.status).toBe(200);
+      expect(handlerFinished).toBe(true);
+      expect(warn).not.toHaveBeenCalledWith(expect.stringContaining('/reports/1'));
+      warn.mockRestore();
+    });
+
+    it('should parse both headers', async () => {
+      const response = await request(server, 'GET', '/documents/1/tags', {
+        headers: { 'if-match': '"a", W/"b"', 'if-none-match': '*' }
+      });
+
+      expect(JSON.parse(response.body)).toEqual({ ifMatch: ['"a"', 'W/"b"'], ifNoneMatch: ['*'] });
+    });
+
+    it('should let only one of two writers with the same ETag win', async () => {
+      const seen = etag();
+
+      const first = await update(seen);
+      const second = await update(seen);
+
+      expect(first.status).toBe(200);
+      expect(first.header('ETag')).toBe(etag());
+      expect(second.status).toBe(412);
+      expect(JSON.parse(second.body)).toEqual({ error: 'Precondition Failed' });
+      expect((await update()).status).toBe(428);
+    });
+  });
+
+  describe('bindAndValidate', () => {
+    const userSchema = v.object({ name: v.string().min(2) });
+
+    beforeAll(() => {
+      app.post('/users', (ctx) => {
+        const user = ctx.bindAndValidate(userSchema);
+        ctx.status(201).json(user);
+      });
+
+      app.post('/lenient', (ctx) => {
+        // Routes that never read the body aren't affected by malformed input
+        ctx.json({ ok: true });
+      });
+
+      start();
+    });
+
+    const post = (path: string, body: string, contentType = 'application/json') =>
+      request(server, 'POST', path, { headers: { 'content-type': contentType }, body });
+
+    it('should return the validated body', async () => {
+      const response = await post('/users', '{"name":"Ada"}');
+
+      expect(response.status).toBe(201);
+      expect(JSON.parse(response.body)).toEqual({ name: 'Ada' });
+    });
+
+    it('should answer malformed bodies with 400', async () => {
+      const response = await post('/users', '{"name":');
+
+      expect(response.status).toBe(400);
+      expect(JSON.parse(response.body).error).toMatch(/^Malformed request body/);
+    });
+
+    it('should answer non-object bodies with 400', async () => {
+      const response = await post('/users', 'just text', 'text/plain');
+
+      expect(response.status).toBe(400);
+      expect(JSON.parse(response.body)).toEqual({ error: 'Expected a JSON or form body' });
+    });
+
+    it('should answer invalid bodies with 422', async () => {
+      const response = await post('/users', '{"name":"A"}');
+
+      expect(response.status).toBe(422);
+      expect(JSON.parse(response.body).error).toBe('Validation failed');
+    });
+
+    it('should not fail routes that ignore the body', async () => {
+      const response = await post('/lenient', '{"broken');
+
+      expect(response.status).toBe(200);
+    });
+  });
+
+  describe('mustBind', () => {
+    const userSchema = v.object({ name: v.string().min(2) });
+    let created = 0;
+
+    beforeAll(() => {
+      app.post('/guarded', (ctx) => {
+        const user = ctx.mustBind(userSchema);
+        if (!user) return;
+        created++;
+        ctx.status(201).json(user);
+      });
+
+      app.post('/unguarded', (ctx) => {
+        ctx.mustBind();
+        ctx.json({ ok: true });
+      });
+
+      start();
+    });
+
+    const post = (path: string, body: string) =>
+      request(server, 'POST', path, { headers: { 'content-type': 'application/json' }, body });
+
+    it('should return the validated body', async () => {
+      const response = await post('/guarded', '{"name":"Ada"}');
+
+      expect(response.status).toBe(201);
+      expect(JSON.parse(response.body)).toEqual({ name: 'Ada' });
+    });
+
+    it('should answer bad bodies itself and return undefined', async () => {
+      created = 0;
+      const invalid = await post('/guarded', '{"name":"A"}');
+      const malformed = await post('/guarded', '{"name":');
+
+      expect(invalid.status).toBe(422);
+      expect(JSON.parse(invalid.body).error).toBe('Validation failed');
+      expect(malformed.status).toBe(400);
+      expect(JSON.parse(malformed.body).error).toMatch(/^Malformed request body/);
+      expect(created).toBe(0);
+    });
+
+    it('should still throw errors that are not about the body', async () => {
+      expect((await post('/unguarded', '{}')).status).toBe(500);
+    });
+  });
+
+  describe('bindURI', () => {
+    const postsQuery = v.object({
+      id: v.number().int(),
+      page: v.number().int().min(1).default(1),
+      tag: v.array(v.string()).optional(),
+      draft: v.boolean().optional()
+    });
+
+    beforeAll(() => {
+      app.get('/authors/:id/posts', (ctx) => ctx.json(ctx.bindURI(postsQuery)));
+      start();
+    });
+
+    const get = async (url: string) => {
+      const response = await request(server, 'GET', url);
+      return { status: response.status, body: JSON.parse(response.body) };
+    };
+
+    it('should combine and convert path and query params', async () => {
+      expect(await get('/authors/7/posts?page=2&tag=go&tag=ts&draft=false&utm=x')).toEqual({
+        status: 200,
+        body: { id: 7, page: 2, tag: ['go', 'ts'], draft: false }
+      });
+      expect((await get('/authors/7/posts')).body).toEqual({ id: 7, page: 1 });
+    });
+
+    it('should let the path param win over a query param of the same name', async () => {
+      expect((await get('/authors/7/posts?id=9')).body.id).toBe(7);
+    });
+
+    it('should answer values that do not convert with 400', async () => {
+      expect(await get('/authors/7/posts?page=two')).toEqual({
+        status: 400,
+        body: { error: 'Invalid query parameter "page": expected an integer, received "two"' }
+      });
+      expect((await get('/authors/abc/posts')).body.error).toBe(
+        'Invalid path parameter "id": expected an integer, received "abc"'
+      );
+    });
+
+    it('should answer converted but invalid values with 422', async () => {
+      const response = await get('/authors/7/posts?page=0');
+
+      expect(response.status).toBe(422);
+      expect(response.body.details.page).toBeDefined();
+    });
+
+    it('should throw a ParamBindError naming the field', async () => {
+      let caught: unknown;
+      app.get('/bind-error/:n', (ctx) => {
+        try {
+          ctx.bindURI(v.object({ n: v.number() }));
+        } catch (error) {
+          caught = error;
+        }
+        ctx.sendStatus(204);
+      });
+      start();
+
+      await request(server, 'GET', '/bind-error/x');
+
+      expect(caught).toBeInstanceOf(ParamBindError);
+      expect(caught).toMatchObject({ field: 'n', source: 'path', value: 'x', expected: 'a number', statusCode: 400 });
+    });
+  });
+
+  describe('bindForm', () => {
+    const signup = v.object({
+      name: v.string(),
+      address: v.object({ city: v.string() }),
+      tags: v.array(v.string()).optional()
+    });
+
+    beforeAll(() => {
+      app.post('/signup', (ctx) => ctx.json(ctx.bindForm(signup)));
+      start();
+    });
+
+    const post = async (body: string, type = 'application/x-www-form-urlencoded') => {
+      const response = await request(server, 'POST', '/signup', { headers: { 'content-type': type }, body });
+      return { status: response.status, body: JSON.parse(response.body) };
+    };
+
+    it('should bind nested and list fields of a urlencoded body', async () => {
+      expect(await post('name=Ada&address%5Bcity%5D=Paris&tags%5B%5D=a&tags%5B%5D=b&submit=Save')).toEqual({
+        status: 200,
+        body: { name: 'Ada', address: { city: 'Paris' }, tags: ['a', 'b'] }
+      });
+    });
+
+    it('should answer unmappable fields with 400', async () => {
+      expect(await post('name=Ada&address=Paris')).toEqual({
+        status: 400,
+        body: { error: 'Unmappable form fields: address: "address" is an object, name its fields' }
+      });
+    });
+
+    it('should answer bodies that are not forms with 400', async () => {
+      expect(await post('plain text', 'text/plain')).toEqual({ status: 400, body: { error: 'Expected a form body' } });
+    });
+  });
+
+  describe('peekBody', () => {
+    const sign = (body: string) => createHmac('sha256', 'webhook-secret').update(body).digest('hex');
+
+    beforeAll(() => {
+      const webhooks = app.group('/webhooks', async (ctx, next) => {
+        if (ctx.headers['x-signature'] !== sign(ctx.peekBody().toString())) {
+          return ctx.status(401).json({ error: 'Bad signature' });
+        }
+        await next();
+      });
+      webhooks.post('/payments', (ctx) => ctx.json({ event: ctx.body.event, bytes: ctx.peekBody().length }));
+
+      app.get('/no-body', (ctx) => ctx.json({ bytes: ctx.peekBody().length }));
+
+      start();
+    });
+
+    const deliver = (body: string, signature = sign(body)) => request(server, 'POST', '/webhooks/payments', {
+      headers: { 'content-type': 'application/json', 'x-signature': signature },
+      body
+    });
+
+    it('should let middleware and the handler both read the raw body', async () => {
+      const response = await deliver('{"event":"paid"}');
+
+      expect(JSON.parse(response.body)).toEqual({ event: 'paid', bytes: 16 });
+    });
+
+    it('should return the bytes of bodies that did not parse', async () => {
+      expect((await deliver('{"event":', sign('{"event":'))).status).toBe(400);
+      expect((await deliver('{"event":', 'forged')).status).toBe(401);
+    });
+
+    it('should be empty without a body', async () => {
+      const response = await request(server, 'GET', '/no-body');
+
+      expect(JSON.parse(response.body)).toEqual({ bytes: 0 });
+    });
+  });
+
+  describe('trailer', () => {
+    beforeAll(() => {
+      app.post('/uploads/checked', (ctx) => ctx.json({
+        bytes: ctx.peekBody().length,
+        digest: ctx.trailer('Content-Digest')
+      }));
+
+      start();
+    });
+
+    it('should be empty under uWS, which drops trailers', async () => {
+      const response = await request(server, 'POST', '/uploads/checked', {
+        headers: { 'content-type': 'application/octet-stream', 'transfer-encoding': 'chunked', trailer: 'Content-Digest' },
+        chunks: ['part one,', 'part two']
+      });
+
+      expect(JSON.parse(response.body)).toEqual({ bytes: 17, digest: '' });
+    });
+  });
+
+  describe('header helpers', () => {
+    beforeAll(() => {
+      app.get('/headers', (ctx) => {
+        ctx.header('X-Trace', 'a')
+           .header('X-Trace', 'b')
+           .header('X-Drop', 'gone')
+           .setHeaders({ 'x-trace': 'c', 'Cache-Control': 'no-store' })
+           .appendHeader('Link', '</a.css>; rel=preload')
+           .appendHeader('Link', '</b.js>; rel=preload')
+           .deleteHeader('x-drop')
+           .cookie('a', '1')
+           .cookie('b', '2')
+           .send('ok');
+      });
+
+      app.get('/no-server', (ctx) => ctx.deleteHeader('Server').send('ok'));
+      app.get('/close', (ctx) => ctx.setConnectionClose().json({ bye: true }));
+      app.get('/timing', (ctx) => {
+        ctx.serverTiming('db', 53.21, 'Orders "recent" query')
+           .serverTiming('cache', 0.1234567)
+           .serverTiming('miss')
+           .json({ ok: true });
+      });
+      app.get('/timing-invalid', (ctx) => {
+        try {
+          ctx.serverTiming('db query', 1);
+        } catch (error) {
+          ctx.json({ error: (error as Error).message });
+        }
+      });
+      app.get('/timing-stream', async (ctx) => {
+        ctx.serverTiming('render', 4);
+        await ctx.write('a');
+        ctx.serverTiming('late', 1);
+        ctx.end();
+      });
+      app.get('/close-stream', async (ctx) => {
+        ctx.setConnectionClose();
+        await ctx.stream('text/plain', ['a', 'b']);
+      });
+      app.get('/cache/directives', (ctx) => {
+        ctx.header('Cache-Control', 'no-store').cacheControl('public', ' max-age=60', 'no-cache="Set-Cookie"').send('ok');
+      });
+      app.get('/cache/none', (ctx) => ctx.noCache().send('ok'));
+      app.get('/cache/public', (ctx) => ctx.cachePublic(3600).send('ok'));
+      app.get('/cache/private', (ctx) => ctx.noCache().cachePrivate('1.5m').send('ok'));
+      app.get('/cache/invalid', (ctx) => {
+        const errors = [() => ctx.cacheControl(), () => ctx.cacheControl('max-age=1, public'), () => ctx.cachePublic(-1)]
+          .map(attempt => {
+            try {
+              attempt();
+            } catch (error) {
+              return (error as Error).message;
+            }
+          });
+        ctx.json({ errors });
+      });
+
+      start();
+    });
+
+    const lines = (headers: Array<[string, string]>, name: string) =>
+      headers.filter(([key]) => key.toLowerCase() === name.toLowerCase()).map(([, value]) => value);
+
+    it('should replace, append and delete headers', async () => {
+      const response = await request(server, 'GET', '/headers');
+
+      expect(lines(response.headers, 'X-Trace')).toEqual(['c']);
+      expect(lines(response.headers, 'Cache-Control')).toEqual(['no-store']);
+      expect(lines(response.headers, 'X-Drop')).toEqual([]);
+    });
+
+    it('should send appended values as separate lines', async () => {
+      const response = await request(server, 'GET', '/headers');
+
+      expect(lines(response.headers, 'Link')).toEqual(['</a.css>; rel=preload', '</b.js>; rel=preload']);
+      expect(lines(response.headers, 'Set-Cookie')).toEqual(['a=1', 'b=2']);
+    });
+
+    it('should close the connection after the response when asked', async () => {
+      const closing = await request(server, 'GET', '/close');
+      const streamed = await request(server, 'GET', '/close-stream');
+      const keepAlive = await request(server, 'GET', '/no-server');
+
+      expect(closing.connectionClosed).toBe(true);
+      expect(closing.header('Connection')).toBe('close');
+      expect(JSON.parse(closing.body)).toEqual({ bye: true });
+      expect(streamed.connectionClosed).toBe(true);
+      expect(streamed.body).toBe('ab');
+      expect(keepAlive.connectionClosed).toBe(false);
+      expect(keepAlive.header('Connection')).toBeUndefined();
+    });
+
+    it('should send server timings in one header', async () => {
+      const response = await request(server, 'GET', '/timing');
+      const streamed = await request(server, 'GET', '/timing-stream');
+      const untimed = await request(server, 'GET', '/no-server');
+
+      expect(lines(response.headers, 'Server-Timing')).toEqual([
+        'db;dur=53.21;desc="Orders \\"recent\\" query", cache;dur=0.123, miss'
+      ]);
+      expect(lines(streamed.headers, 'Server-Timing')).toEqual(['render;dur=4']);
+      expect(untimed.header('Server-Timing')).toBeUndefined();
+    });
+
+    it('should reject metric names that are not tokens', async () => {
+      const response = await request(server, 'GET', '/timing-invalid');
+
+      expect(JSON.parse(response.body)).toEqual({ error: 'Invalid Server-Timing metric name: "db query"' });
+    });
+
+    it('should delete default headers', async () => {
+      const response = await request(server, 'GET', '/no-server');
+
+      expect(response.header('Server')).toBeUndefined();
+    });
+
+    it('should replace Cache-Control with the given directives', async () => {
+      const response = await request(server, 'GET', '/cache/directives');
+
+      expect(lines(response.headers, 'Cache-Control')).toEqual(['public, max-age=60, no-cache="Set-Cookie"']);
+    });
+
+    it('should forbid caching for current and legacy caches', async () => {
+      const response = await request(server, 'GET', '/cache/none');
+
+      expect(lines(response.headers, 'Cache-Control')).toEqual(['no-cache, no-store, must-revalidate']);
+      expect(lines(response.headers, 'Pragma')).toEqual(['no-cache']);
+      expect(lines(response.headers, 'Expires')).toEqual(['0']);
+    });
+
+    it('should allow public and private caching for max-age seconds', async () => {
+      const shared = await request(server, 'GET', '/cache/public');
+      const browser = await request(server, 'GET', '/cache/private');
+
+      expect(lines(shared.headers, 'Cache-Control')).toEqual(['public, max-age=3600']);
+      expect(lines(browser.headers, 'Cache-Control')).toEqual(['private, max-age=90']);
+      expect(browser.header('Pragma')).toBeUndefined();
+      expect(browser.header('Expires')).toBeUndefined();
+    });
+
+    it('should reject invalid cache directives', async () => {
+      const response = await request(server, 'GET', '/cache/invalid');
+
+      expect(JSON.parse(response.body)).toEqual({
+        errors: [
+          'cacheControl() needs at least one directive',
+          'Invalid Cache-Control directive: "max-age=1, public"',
+          'Invalid max-age: -1'
+        ]
+      });
+    });
+  });
+
+  describe('write', () => {
+    let streamError: unknown;
+    let signalAborted: boolean;
+    let writesAfterDisconnect: number;
+
+    beforeAll(() => {
+      app.get('/chunks', async (ctx) => {
+        ctx.status(201).header('Content-Type', 'text/plain');
+        await ctx.write('a');
+        await ctx.write(Buffer.from('b'));
+        ctx.end('c');
+      });
+
+      app.get('/csv', async (ctx) => {
+        await ctx.stream('text/csv', Readable.from(['id,name\n', '1,ada\n']));
+      });
+
+      app.get('/csv-failing', async (ctx) => {
+        async function* rows() {
+          throw new Error('query failed');
+        }
+        await ctx.stream('text/csv', rows());
+      });
+
+      app.get('/ticker', async (ctx) => {
+        writesAfterDisconnect = 0;
+        try {
+          for (;;) {
+            await ctx.write('tick\n');
+            if (ctx.signal.aborted) writesAfterDisconnect++;
+            await new Promise(resolve => setTimeout(resolve, 5));
+          }
+        } catch (error) {
+          streamError = error;
+          signalAborted = ctx.signal.aborted;
+        }
+      });
+
+      app.get('/ticker-unhandled', async (ctx) => {
+        for (;;) {
+          await ctx.write('tick\n');
+          await new Promise(resolve => setTimeout(resolve, 5));
+        }
+      });
+
+      start();
+    });
+
+    it('should send the head with the first chunk and finish on end', async () => {
+      const response = await request(server, 'GET', '/chunks');
+
+      expect(response.status).toBe(201);
+      expect(response.header('Content-Type')).toBe('text/plain');
+      expect(response.body).toBe('abc');
+    });
+
+    it('should copy streams to the response', async () => {
+      const response = await request(server, 'GET', '/csv');
+
+      expect(response.header('Content-Type')).toBe('text/csv');
+      expect(response.body).toBe('id,name\n1,ada\n');
+    });
+
+    it('should turn source errors before any output into a 500', async () => {
+      const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+      const response = await request(server, 'GET', '/csv-failing');
+
+      expect(response.status).toBe(500);
+      error.mockRestore();
+    });
+
+    it('should reject writes once the client disconnects', async () => {
+      const response = await request(server, 'GET', '/ticker', { abortAfter: 20 });
+      await new Promise(resolve => setTimeout(resolve, 20));
+
+      expect(response.closed).toBe(true);
+      expect(streamError).toBeInstanceOf(ConnectionClosedError);
+      expect(signalAborted).toBe(true);
+      expect(writesAfterDisconnect).toBe(0);
+    });
+
+    it('should not report a disconnect that escapes the handler as a server error', async () => {
+      const error = jest.spyOn(Logger, 'error');
+
+      await request(server, 'GET', '/ticker-unhandled', { abortAfter: 20 });
+      await new Promise(resolve => setTimeout(resolve, 20));
+
+      expect(error).not.toHaveBeenCalled();
+      error.mockRestore();
+    });
+  });
+
+  describe('compressResponse', () => {
+    const report = JSON.stringify({ rows: Array.from({ length: 200 }, (_, i) => ({ id: i, name: 'row' })) });
+    let flushedAfterFirstWrite = 0;
+
+    beforeAll(() => {
+      app.get('/compressed/json', (ctx) => ctx.compressResponse().header('Content-Type', 'application/json').send(report));
+      app.get('/compressed/off', (ctx) => ctx.compressResponse().compressResponse(false).send(report));
+      app.get('/compressed/encoded', (ctx) => {
+        ctx.compressResponse().header('Content-Encoding', 'gzip').send(gzipSync('already'));
+      });
+      app.get('/compressed/write', async (ctx) => {
+        ctx.compressResponse().header('Content-Length', '3');
+        await ctx.write('a');
+        flushedAfterFirstWrite = ctx.bytesWritten;
+        await ctx.write('b');
+        ctx.end('c');
+      });
+      app.get('/compressed/stream', async (ctx) => {
+        await ctx.compressResponse().stream('text/csv', Readable.from(['id,name\n', '1,ada\n']));
+      });
+      app.get('/compressed/failing', async (ctx) => {
+        async function* rows() {
+          throw new Error('query failed');
+        }
+        await ctx.compressResponse().stream('text/csv', rows());
+      });
+      start();
+    });
+
+    const get = (path: string, encoding?: string) =>
+      request(server, 'GET', path, { headers: encoding ? { 'accept-encoding': encoding } : {} });
+
+    it('should compress whole bodies with the encoding the client prefers', async () => {
+      const gzip = await get('/compressed/json', 'gzip, deflate');
+      const br = await get('/compressed/json', 'gzip;q=0.5, br');
+
+      expect(gzip.header('content-encoding')).toBe('gzip');
+      expect(gzip.header('vary')).toBe('Accept-Encoding');
+      expect(gunzipSync(gzip.bytes).toString()).toBe(report);
+      expect(gzip.bytes.length).toBeLessThan(report.length / 4);
+      expect(br.header('content-encoding')).toBe('br');
+      expect(brotliDecompressSync(br.bytes).toString()).toBe(report);
+    });
+
+    it('should send the body as is to clients that accept no compression', async () => {
+      const none = await get('/compressed/json');
+      const identity = await get('/compressed/json', 'identity');
+
+      expect(none.header('content-encoding')).toBeUndefined();
+      expect(none.header('vary')).toBe('Accept-Encoding');
+      expect(none.body).toBe(report);
+      expect(identity.body).toBe(report);
+    });
+
+    it('should not compress when opted out or already encoded', async () => {
+      const off = await get('/compressed/off', 'gzip');
+      const encoded = await get('/compressed/encoded', 'gzip, br');
+
+      expect(off.header('content-encoding')).toBeUndefined();
+      expect(off.header('vary')).toBeUndefined();
+      expect(off.body).toBe(report);
+      expect(encoded.header('content-encoding')).toBe('gzip');
+      expect(gunzipSync(encoded.bytes).toString()).toBe('already');
+    });
+
+    it('should compress written chunks, flushing each one', async () => {
+      const response = await get('/compressed/write', 'gzip');
+
+      expect(response.header('content-encoding')).toBe('gzip');
+      expect(response.header('content-length')).toBeUndefined();
+      expect(gunzipSync(response.bytes).toString()).toBe('abc');
+      expect(flushedAfterFirstWrite).toBeGreaterThan(0);
+    });
+
+    it('should compress streamed sources', async () => {
+      const response = await get('/compressed/stream', 'deflate');
+
+      expect(response.header('content-encoding')).toBe('deflate');
+      expect(inflateSync(response.bytes).toString()).toBe('id,name\n1,ada\n');
+    });
+
+    it('should answer a source failing before the first chunk with a compressed error', async () => {
+      const response = await get('/compressed/failing', 'gzip');
+
+      expect(response.status).toBe(500);
+      expect(response.headers.filter(([key]) => key.toLowerCase() === 'content-encoding')).toEqual([['Content-Encoding', 'gzip']]);
+      expect(JSON.parse(gunzipSync(response.bytes).toString())).toEqual({ error: 'Internal Server Error' });
+    });
+  });
+
+  describe('downloads', () => {
+    async function* failingRows() {
+      for (let i = 0; i < 2000; i++) yield [i, 'x'.repeat(20)];
+      throw new Error('cursor lost');
+    }
+
+    beforeAll(() => {
+      app.get('/export.csv', (ctx) => ctx.csv('users.csv', ['id', 'name'], [[1, 'Ada'], [2, 'Lovelace, A.']]));
+      app.get('/export-failing.csv', (ctx) => ctx.csv('users.csv', ['id', 'name'], failingRows()));
+      app.get('/export-empty.csv', (ctx) => ctx.csv('users.csv', ['id'], (async function* () {
+        throw new Error('query failed');
+      })()));
+      app.get('/export.zip', (ctx) => ctx.zip('export.zip', [
+        { name: 'users.csv', data: 'id\r\n1\r\n' },
+        { name: 'notes.txt', data: Readable.from(['a', 'b']) }
+      ]));
+      app.get('/file', (ctx) => ctx.attachment('data.json').json({ ok: true }));
+      start();
+    });
+
+    it('should stream CSV as an attachment', async () => {
+      const response = await request(server, 'GET', '/export.csv');
+
+      expect(response.status).toBe(200);
+      expect(response.header('Content-Type')).toBe('text/csv; charset=utf-8');
+      expect(response.header('Content-Disposition')).toBe('attachment; filename="users.csv"');
+      expect(response.body).toBe('id,name\r\n1,Ada\r\n2,"Lovelace, A."\r\n');
+    });
+
+    it('should stream a ZIP archive', async () => {
+      const response = await request(server, 'GET', '/export.zip');
+      const archive = response.bytes;
+
+      expect(response.header('Content-Type')).toBe('application/zip');
+      expect(response.header('Content-Disposition')).toBe('attachment; filename="export.zip"');
+      expect(archive.readUInt32LE(0)).toBe(0x04034b50);
+      // End of central directory: two entries
+      expect(archive.readUInt32LE(archive.length - 22)).toBe(0x06054b50);
+      expect(archive.readUInt16LE(archive.length - 12)).toBe(2);
+    });
+
+    it('should log a source failing mid-stream and cut the response', async () => {
+      const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+      const response = await request(server, 'GET', '/export-failing.csv');
+
+      expect(response.status).toBe(200);
+      expect(response.closed).toBe(true);
+      expect(error).toHaveBeenCalledWith('Error streaming users.csv: Error: cursor lost');
+      expect(error).toHaveBeenCalledTimes(1);
+      error.mockRestore();
+    });
+
+    it('should answer a source failing before any output with a 500', async () => {
+      const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+      const response = await request(server, 'GET', '/export-empty.csv');
+
+      expect(response.status).toBe(500);
+      expect(response.header('Content-Disposition')).toBeUndefined();
+      error.mockRestore();
+    });
+
+    it('should mark other responses as attachments', async () => {
+      const response = await request(server, 'GET', '/file');
+
+      expect(response.header('Content-Disposition')).toBe('attachment; filename="data.json"');
+      expect(JSON.parse(response.body)).toEqual({ ok: true });
+    });
+  });
+
+  describe('pagination', () => {
+    beforeAll(() => {
+      app.get('/people', (ctx) => {
+        const page = ctx.pagination({ size: 5, maxSize: 10 });
+        ctx.pageLinks(page, 12).json(page);
+      });
+
+      start();
+    });
+
+    it('should parse the page and set Link headers', async () => {
+      const response = await request(server, 'GET', '/people?page=2&size=50&sort=name');
+
+      expect(JSON.parse(response.body)).toEqual({ page: 2, size: 10, offset: 10, limit: 10, style: 'page' });
+      expect(response.header('Link')).toBe(
+        '</people?sort=name&page=1&size=10>; rel="first", </people?sort=name&page=1&size=10>; rel="prev"' +
+        ', </people?sort=name&page=2&size=10>; rel="last"'
+      );
+    });
+
+    it('should use the route defaults without params', async () => {
+      const response = await request(server, 'GET', '/people');
+
+      expect(JSON.parse(response.body)).toMatchObject({ page: 1, size: 5 });
+    });
+  });
+
+  describe('paginated', () => {
+    const people = Array.from({ length: 12 }, (_, i) => ({ id: i + 1 }));
+
+    beforeAll(() => {
+      app.get('/people/paged', (ctx) => {
+        const page = ctx.pagination({ size: 5 });
+        ctx.paginated(people.slice(page.offset, page.offset + page.limit), page, people.length);
+      });
+      app.get('/people/feed', (ctx) => {
+        ctx.paginated([], ctx.pagination());
+      });
+
+      start();
+    });
+
+    it('should send the page in an envelope with links and the total', async () => {
+      const response = await request(server, 'GET', '/people/paged?page=3');
+
+      expect(JSON.parse(response.body)).toEqual({
+        data: [{ id: 11 }, { id: 12 }],
+        meta: { total: 12, page: 3, size: 5, totalPages: 3 }
+      });
+      expect(response.header('X-Total-Count')).toBe('12');
+      expect(response.header('Link')).toContain('</people/paged?page=2&size=5>; rel="prev"');
+    });
+
+    it('should leave out the total when it is unknown', async () => {
+      const response = await request(server, 'GET', '/people/feed');
+
+      expect(JSON.parse(response.body)).toEqual({ data: [], meta: { page: 1, size: 20 } });
+      expect(response.header('X-Total-Count')).toBeUndefined();
+      expect(response.header('Link')).toContain('rel="next"');
+    });
+  });
+
+  describe('sse', () => {
+    const broker = new EventBroker();
+    let streamError: unknown;
+
+    beforeAll(() => {
+      app.get('/events/list', (ctx) => ctx.sse([{ id: 1, data: { n: 1 } }, 'done']));
+
+      app.get('/events/live', async (ctx) => {
+        try {
+          await ctx.sse(broker.subscribe());
+        } catch (error) {
+          streamError = error;
+        }
+      });
+
+      start();
+    });
+
+    it('should send events as text/event-stream', async () => {
+      const response = await request(server, 'GET', '/events/list');
+
+      expect(response.header('Content-Type')).toBe('text/event-stream');
+      expect(response.header('Cache-Control')).toBe('no-cache');
+      expect(response.body).toBe(': connected\n\nid: 1\ndata: {"n":1}\n\ndata: done\n\n');
+    });
+
+    it('should fan broker events out and unsubscribe on disconnect', async () => {
+      const first = request(server, 'GET', '/events/live', { abortAfter: 30 });
+      const second = request(server, 'GET', '/events/live', { abortAfter: 30 });
+      await new Promise(resolve => setTimeout(resolve, 10));
+
+      expect(broker.size).toBe(2);
+      broker.publish({ event: 'tick', data: 1 });
+
+      const responses = await Promise.all([first, second]);
+      await new Promise(resolve => setTimeout(resolve, 10));
+
+      for (const response of responses) {
+        expect(response.body).toBe(': connected\n\nevent: tick\ndata: 1\n\n');
+      }
+      expect(broker.size).toBe(0);
+      expect(streamError).toBeInstanceOf(ConnectionClosedError);
+    });
+  });
+
+  describe('jsonp', () => {
+    beforeAll(() => {
+      app.get('/jsonp', (ctx) => ctx.jsonp({ user: 'ada', note: 'a\u2028b' }));
+      app.get('/jsonp-named', (ctx) => ctx.jsonp([1, 2], 'handlers.loaded'));
+
+      start();
+    });
+
+    it('should wrap the JSON in the callback from the query', async () => {
+      const response = await request(server, 'GET', '/jsonp?callback=jQuery_123');
+
+      expect(response.status).toBe(200);
+      expect(response.header('Content-Type')).toBe('application/javascript');
+      expect(response.header('X-Content-Type-Options')).toBe('nosniff');
+      expect(response.body).toBe(
+        `/**/ typeof jQuery_123 === 'function' && jQuery_123({"user":"ada","note":"a\\u2028b"});`
+      );
+    });
+
+    it('should accept an explicit callback name', async () => {
+      const response = await request(server, 'GET', '/jsonp-named');
+
+      expect(response.body).toBe(`/**/ typeof handlers.loaded === 'function' && handlers.loaded([1,2]);`);
+    });
+
+    it('should fall back to plain JSON without a callback', async () => {
+      const response = await request(server, 'GET', '/jsonp');
+
+      expect(response.header('Content-Type')).toBe('application/json');
+      expect(JSON.parse(response.body)).toEqual({ user: 'ada', note: 'a\u2028b' });
+    });
+
+    it('should reject callbacks that are not identifiers', async () => {
+      const malicious = encodeURIComponent('alert(document.cookie);//');
+      const response = await request(server, 'GET', `/jsonp?callback=${malicious}`);
+
+      expect(response.status).toBe(400);
+      expect(response.header('Content-Type')).toBe('application/json');
+      expect(response.body).not.toContain('alert');
+    });
+  });
+
+  describe('sendStatus', () => {
+    beforeAll(() => {
+      app.delete('/status/deleted', (ctx) => ctx.sendStatus(204));
+      app.get('/status/missing', (ctx) => ctx.sendStatus(404));
+      app.get('/status/unchanged', (ctx) => ctx.sendStatus(304));
+      app.get('/status/custom', (ctx) => ctx.sendStatus(599));
+      app.get('/status/empty', (ctx) => ctx.status(204).json({ ignored: true }));
+
+      start();
+    });
+
+    it('should send the reason phrase as plain text', async () => {
+      const response = await request(server, 'GET', '/status/missing');
+
+      expect(response.status).toBe(404);
+      expect(response.body).toBe('Not Found');
+      expect(response.header('Content-Type')).toBe('text/plain; charset=utf-8');
+    });
+
+    it('should send 204 and 304 without a body or Content-Type', async () => {
+      for (const path of ['/status/deleted', '/status/unchanged']) {
+        const response = await request(server, path === '/status/deleted' ? 'DELETE' : 'GET', path);
+
+        expect(response.withoutBody).toBe(true);
+        expect(response.body).toBe('');
+        expect(response.header('Content-Type')).toBeUndefined();
+      }
+    });
+
+    it('should fall back to the code for unknown statuses', async () => {
+      const response = await request(server, 'GET', '/status/custom');
+
+      expect(response.status).toBe(599);
+      expect(response.body).toBe('599');
+    });
+
+    it('should drop bodies sent with a 204 status', async () => {
+      const response = await request(server, 'GET', '/status/empty');
+
+      expect(response.status).toBe(204);
+      expect(response.withoutBody).toBe(true);
+      expect(response.body).toBe('');
+    });
+  });
+
+  describe('sendString and sendBytes', () => {
+    const bytes = new Uint8Array([0x00, 0x3c, 0x68, 0x74, 0x6d, 0x6c, 0x3e, 0xff]);
+
+    beforeAll(() => {
+      app.get('/string', (ctx) => ctx.sendString('<!DOCTYPE html> is text here'));
+      app.get('/string/typed', (ctx) => ctx.header('Content-Type', 'text/csv').sendString('a,b'));
+      app.get('/bytes', (ctx) => ctx.sendBytes(bytes.subarray(1, 7)));
+      app.get('/bytes/buffer', (ctx) => ctx.sendBytes(bytes.buffer));
+
+      start();
+    });
+
+    it('should send strings as plain text without sniffing', async () => {
+      const response = await request(server, 'GET', '/string');
+
+      expect(response.body).toBe('<!DOCTYPE html> is text here');
+      expect(response.header('Content-Type')).toBe('text/plain; charset=utf-8');
+      expect((await request(server, 'GET', '/string/typed')).header('Content-Type')).toBe('text/csv');
+    });
+
+    it('should send exactly the viewed bytes as octet-stream', async () => {
+      const view = await request(server, 'GET', '/bytes');
+      const whole = await request(server, 'GET', '/bytes/buffer');
+
+      expect(view.bytes).toEqual(Buffer.from('<html>'));
+      expect(view.header('Content-Type')).toBe('application/octet-stream');
+      expect(whole.bytes).toEqual(Buffer.from(bytes));
+    });
+  });
+});
+
+describe('JSON options', () => {
+  const user = { userId: 7, displayName: 'Ada', deletedAt: null, nickname: undefined };
+
+  function serve(config: Record<string, any>) {
+    const app = new Qera({ logging: { level: 'error' }, ...config });
+    app.get('/default', (ctx) => ctx.json(user));
+    app.get('/options', (ctx) => ctx.jsonWithOptions(user, { nulls: 'emit', keys: 'snake_case' }));
+    app.listen(3479, 'localhost');
+    return lastApp();
+  }
+
+  it('should emit nulls and rename keys for one response', async () => {
+    const response = await request(serve({}), 'GET', '/options');
+
+    expect(response.header('Content-Type')).toBe('application/json');
+    expect(response.body).toBe('{"user_id":7,"display_name":"Ada","deleted_at":null,"nickname":null}');
+  });
+
+  it('should leave qera.json() alone without a json config', async () => {
+    const response = await request(serve({}), 'GET', '/default');
+
+    expect(response.body).toBe('{"userId":7,"displayName":"Ada","deletedAt":null}');
+  });
+
+  it('should apply the json config to qera.json()', async () => {
+    const response = await request(serve({ json: { nulls: 'omit', keys: 'snake_case' } }), 'GET', '/default');
+
+    expect(response.body).toBe('{"user_id":7,"display_name":"Ada"}');
+  });
+});
+
+describe('Page envelope', () => {
+  it('should shape qera.paginated() bodies', async () => {
+    const app = new Qera({
+      logging: { level: 'error' },
+      pageEnvelope: (items, meta) => ({ items, pagination: { count: meta.total, pages: meta.totalPages } })
+    });
+    app.get('/things', (ctx) => ctx.paginated(['a'], ctx.pagination(), 1));
+    app.listen(3506, 'localhost');
+
+    const response = await request(lastApp(), 'GET', '/things');
+
+    expect(JSON.parse(response.body)).toEqual({ items: ['a'], pagination: { count: 1, pages: 1 } });
+  });
+});
+
+describe('Response transformer', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({
+      logging: { level: 'error' },
+      json: { keys: 'snake_case' },
+      responseTransformer: (data, ctx) => ({ data, meta: { requestId: ctx.headers['x-request-id'] || null } })
+    });
+    app.get('/users', (ctx) => ctx.json([{ userId: 1 }]));
+    app.post('/users', (ctx) => ctx.status(422).json({ error: 'Name is required' }));
+    app.get('/broken', () => {
+      throw new Error('database down');
+    });
+    app.get('/options', (ctx) => ctx.jsonWithOptions({ userId: 1 }, {}));
+    app.get('/raw', (ctx) => ctx.send('{"plain":true}'));
+    app.get('/health', (ctx) => ctx.json({ ok: true }), { transformResponse: false });
+    app.listen(3501, 'localhost');
+    server = lastApp();
+  });
+
+  it('should wrap qera.json() bodies before serializing them', async () => {
+    const response = await request(server, 'GET', '/users', { headers: { 'x-request-id': 'r1' } });
+
+    expect(JSON.parse(response.body)).toEqual({ data: [{ user_id: 1 }], meta: { request_id: 'r1' } });
+    expect(JSON.parse((await request(server, 'GET', '/options')).body)).toEqual({ data: { userId: 1 }, meta: { requestId: null } });
+  });
+
+  it('should leave other responses alone', async () => {
+    expect((await request(server, 'GET', '/raw')).body).toBe('{"plain":true}');
+  });
+
+  it('should not wrap error responses', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+
+    expect(JSON.parse((await request(server, 'GET', '/nowhere')).body)).toEqual({ error: 'Not Found' });
+    expect(JSON.parse((await request(server, 'PUT', '/users')).body)).toEqual({ error: 'Method Not Allowed' });
+    expect(JSON.parse((await request(server, 'GET', '/broken')).body)).toEqual({ error: 'Internal Server Error' });
+    expect(JSON.parse((await request(server, 'POST', '/users')).body)).toEqual({ error: 'Name is required' });
+    error.mockRestore();
+  });
+
+  it('should be skipped by routes that opt out', async () => {
+    expect(JSON.parse((await request(server, 'GET', '/health')).body)).toEqual({ ok: true });
+  });
+});
+
+describe('Route schemas', () => {
+  const user = v.object({ id: v.number(), name: v.string() });
+  const options = {
+    request: v.object({ name: v.string().min(2) }),
+    responses: { 201: user, 404: v.object({ error: v.string() }) }
+  };
+
+  function serve(config: Record<string, any>) {
+    const app = new Qera({ logging: { level: 'error' }, ...config });
+    app.post('/users', (ctx) => ctx.status(201).json({ id: 1, ...ctx.bindAndValidate() }), options);
+    app.post('/broken', (ctx) => ctx.status(201).json({ id: 'one', name: 'Ada' }), options);
+    app.get('/plain', (ctx) => ctx.json({ ok: true }));
+    app.listen(3482, 'localhost');
+    return { app, server: lastApp() };
+  }
+
+  const post = (server: MockApp, path: string, body: string) =>
+    request(server, 'POST', path, { headers: { 'content-type': 'application/json' }, body });
+
+  afterEach(() => jest.restoreAllMocks());
+
+  it('should validate the body against the route request schema', async () => {
+    const { server } = serve({});
+
+    expect((await post(server, '/users', '{"name":"Ada"}')).status).toBe(201);
+    expect((await post(server, '/users', '{"name":"A"}')).status).toBe(422);
+  });
+
+  it('should warn about responses that do not match their schema', async () => {
+    const warn = jest.spyOn(Logger, 'warn').mockImplementation(() => undefined);
+    const { server } = serve({ validateResponses: true });
+
+    const matching = await post(server, '/users', '{"name":"Ada"}');
+    expect(warn).not.toHaveBeenCalled();
+
+    const response = await post(server, '/broken', '{"name":"Ada"}');
+    expect(matching.status).toBe(201);
+    expect(response.status).toBe(201);
+    expect(JSON.parse(response.body)).toEqual({ id: 'one', name: 'Ada' });
+    expect(warn).toHaveBeenCalledWith(
+      "Response 201 of POST /broken doesn't match its schema",
+      { issues: [expect.stringMatching(/^id: /)] }
+    );
+  });
+
+  it('should skip response checks when validateResponses is off', async () => {
+    const warn = jest.spyOn(Logger, 'warn').mockImplementation(() => undefined);
+    const { server } = serve({ validateResponses: false });
+
+    await post(server, '/broken', '{"name":"Ada"}');
+
+    expect(warn).not.toHaveBeenCalled();
+  });
+
+  it('should list routes with their schemas as JSON Schema', () => {
+    const { app } = serve({});
+    const docs = app.routeDocs();
+
+    expect(docs).toContainEqual({
+      method: 'POST',
+      path: '/users',
+      request: { type: 'object', properties: { name: { type: 'string', minLength: 2 } }, required: ['name'] },
+      responses: {
+        201: { type: 'object', properties: { id: { type: 'number' }, name: { type: 'string' } }, required: ['id', 'name'] },
+        404: { type: 'object', properties: { error: { type: 'string' } }, required: ['error'] }
+      }
+    });
+    expect(docs).toContainEqual({ method: 'GET', path: '/plain' });
+  });
+});
+
+describe('errorHandler with bind errors', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(errorHandler({ log: false }));
+    app.post('/items', (ctx) => {
+      ctx.json(ctx.bindAndValidate(v.object({ id: v.number() })));
+    });
+    app.listen(3461, 'localhost');
+    server = lastApp();
+  });
+
+  it('should map bind and validation errors to their status codes', async () => {
+    const malformed = await request(server, 'POST', '/items', { headers: { 'content-type': 'application/json' }, body: '{' });
+    const invalid = await request(server, 'POST', '/items', { headers: { 'content-type': 'application/json' }, body: '{"id":"x"}' });
+
+    expect(malformed.status).toBe(400);
+    expect(invalid.status).toBe(422);
+  });
+});
+
+describe('pipe()', () => {
+  let server: MockApp;
+  let upstreams: Duplex[] = [];
+  let received: string[] = [];
+  let pipeError: unknown;
+
+  // An in-memory upstream: answer(push) runs once the request has been
+  // written, after the half-close unless it waits for data
+  function upstream(answer: (push: (chunk: string | null) => void, input: string) => void, onData = false) {
+    let input = '';
+    const duplex: Duplex = new Duplex({
+      read() {},
+      write(chunk, _encoding, callback) {
+        input += chunk;
+        callback();
+        if (onData) answer(chunk => duplex.push(chunk), input);
+      },
+      final(callback) {
+        received.push(input);
+        callback();
+        if (!onData) answer(chunk => duplex.push(chunk), input);
+      }
+    });
+    upstreams.push(duplex);
+    return duplex;
+  }
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, compression: true });
+
+    app.post('/echo', (ctx) => ctx.pipe(upstream((push, input) => {
+      push(input.toUpperCase());
+      push(null);
+    }), { contentType: 'text/plain' }));
+    app.get('/events', (ctx) => ctx.pipe(upstream((push) => {
+      let n = 0;
+      const timer = setInterval(() => {
+        push(`data: ${++n}\n\n`);
+        if (n === 3) {
+          clearInterval(timer);
+          push(null);
+        }
+      }, 5);
+    }), { contentType: 'text/event-stream' }));
+    app.post('/open', (ctx) => ctx.pipe(upstream((push, input) => {
+      if (input === 'ping') {
+        push('pong');
+        push(null);
+      }
+    }, true), { halfClose: false }));
+    app.get('/idle', async (ctx) => {
+      try {
+        await ctx.pipe(upstream(() => undefined));
+      } catch (error) {
+        pipeError = error;
+      }
+    });
+    app.get('/refused', (ctx) => ctx.pipe(upstream(() => upstreams[upstreams.length - 1].destroy(new Error('ECONNREFUSED')))));
+    app.get('/dropped', (ctx) => ctx.pipe(upstream((push) => {
+      push('partial');
+      setTimeout(() => upstreams[upstreams.length - 1].destroy(new Error('upstream reset')), 5);
+    })));
+
+    app.listen(3511, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    upstreams = [];
+    received = [];
+    pipeError = undefined;
+  });
+
+  it('should send the request body upstream and its answer back', async () => {
+    const response = await request(server, 'POST', '/echo', {
+      headers: { 'content-type': 'text/plain', 'accept-encoding': 'gzip' },
+      body: 'hello upstream'
+    });
+
+    expect(received).toEqual(['hello upstream']);
+    expect(response.body).toBe('HELLO UPSTREAM');
+    expect(response.header('Content-Type')).toBe('text/plain');
+    // Passed through as it comes, not compressed
+    expect(response.header('Content-Encoding')).toBeUndefined();
+    expect(upstreams[0].destroyed).toBe(true);
+  });
+
+  it('should stream chunks as the upstream sends them', async () => {
+    const response = await request(server, 'GET', '/events');
+
+    expect(response.body).toBe('data: 1\n\ndata: 2\n\ndata: 3\n\n');
+    expect(response.header('Content-Type')).toBe('text/event-stream');
+  });
+
+  it('should leave the upstream open for writing without halfClose', async () => {
+    const response = await request(server, 'POST', '/open', { headers: { 'content-type': 'text/plain' }, body: 'ping' });
+
+    expect(response.body).toBe('pong');
+    expect(received).toEqual([]);
+  });
+
+  it('should destroy an idle upstream when the client disconnects', async () => {
+    await request(server, 'GET', '/idle', { abortAfter: 10 });
+    await new Promise(resolve => setTimeout(resolve, 5));
+
+    expect(upstreams[0].destroyed).toBe(true);
+    expect(pipeError).toBeInstanceOf(ConnectionClosedError);
+  });
+
+  it('should propagate upstream errors', async () => {
+    const refused = await request(server, 'GET', '/refused');
+    expect(refused.status).toBe(500);
+
+    // After output started, the connection is cut instead
+    const dropped = await request(server, 'GET', '/dropped');
+    expect(dropped.body).toBe('partial');
+    expect(dropped.closed).toBe(true);
+  });
+});
+
+describe('fail()', () => {
+  let server: MockApp;
+  const errors: unknown[] = [];
+  const after: string[] = [];
+
+  // A helper deep in the call tree that ends the request itself
+  function parseQuantity(ctx: any, raw: string | undefined): number {
+    const quantity = Number(raw);
+    if (!Number.isInteger(quantity) || quantity < 1) {
+      ctx.fail(400, `Invalid quantity: ${raw}`);
+    }
+    return quantity;
+  }
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(async (ctx, next) => {
+      try {
+        await next();
+      } finally {
+        after.push(ctx.path());
+      }
+    });
+    app.get('/cart', (ctx) => {
+      const quantity = parseQuantity(ctx, ctx.query.qty);
+      ctx.json({ quantity });
+    });
+    app.get('/gone', (ctx) => ctx.fail(410));
+    app.get('/handled', safe((ctx) => ctx.fail(409, 'Already paid')));
+    app.get('/bad-status', (ctx) => ctx.fail(302));
+    app.onError((_ctx, error) => errors.push(error));
+    app.listen(3516, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    errors.length = 0;
+    after.length = 0;
+  });
+
+  const get = async (path: string) => {
+    const response = await request(server, 'GET', path);
+    return { status: response.status, body: JSON.parse(response.body) };
+  };
+
+  it('should end the request from a nested helper without logging it', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+
+    expect(await get('/cart?qty=0')).toEqual({ status: 400, body: { error: 'Invalid quantity: 0' } });
+    expect(await get('/cart?qty=3')).toEqual({ status: 200, body: { quantity: 3 } });
+    expect(errors).toEqual([]);
+    expect(error).not.toHaveBeenCalled();
+    // Middleware still unwinds through its finally blocks
+    expect(after).toEqual(['/cart', '/cart']);
+    error.mockRestore();
+  });
+
+  it('should default the message to the reason phrase', async () => {
+    expect(await get('/gone')).toEqual({ status: 410, body: { error: 'Gone' } });
+  });
+
+  it('should be answered the same way by safe() and errorHandler()', async () => {
+    expect(await get('/handled')).toEqual({ status: 409, body: { error: 'Already paid' } });
+  });
+
+  it('should only fail with error statuses', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+
+    expect((await get('/bad-status')).status).toBe(500);
+    expect(String(errors[0])).toContain('qera.fail() needs a 4xx or 5xx status, got 302');
+    error.mockRestore();
+  });
+});
+
+describe('Error mappings', () => {
+  class DomainError extends Error {}
+  class NotFoundError extends DomainError {}
+  class ConflictError extends DomainError {}
+  const NoRows = new Error('no rows in result set');
+
+  let server: MockApp;
+  const errors: unknown[] = [];
+  // new Error(message, { cause }), which the ES2020 typings don't know
+  const wrap = (message: string, cause: unknown) => Object.assign(new Error(message), { cause });
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    // The parent class first, so only specificity can make the subclasses win
+    app.mapError(DomainError, 422, 'Invalid operation');
+    app.mapError(NotFoundError, 404);
+    app.mapError(NoRows, 404, 'No such record');
+    app.mapError(ConflictError, 503, 'Try again later');
+
+    app.get('/missing', () => { throw new NotFoundError('user 7'); });
+    app.get('/domain', () => { throw new DomainError('bad transition'); });
+    app.get('/sentinel', () => { throw NoRows; });
+    app.get('/wrapped', () => { throw wrap('loading user', wrap('querying users', NoRows)); });
+    app.get('/outer', () => { throw Object.assign(new NotFoundError('order'), { cause: NoRows }); });
+    app.get('/unmapped', () => { throw wrap('loading user', new TypeError('boom')); });
+    app.get('/busy', () => { throw new ConflictError('locked'); });
+    app.get('/handled', safe(() => { throw wrap('loading user', NoRows); }));
+    app.onError((_ctx, error) => errors.push(error));
+
+    app.listen(3510, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    errors.length = 0;
+  });
+
+  const get = async (path: string) => {
+    const response = await request(server, 'GET', path);
+    return { status: response.status, body: JSON.parse(response.body) };
+  };
+
+  it('should answer mapped classes, most derived first', async () => {
+    expect(await get('/missing')).toEqual({ status: 404, body: { error: 'Not Found' } });
+    expect(await get('/domain')).toEqual({ status: 422, body: { error: 'Invalid operation' } });
+    expect(errors).toEqual([]);
+  });
+
+  it('should match sentinels and errors wrapped around them', async () => {
+    expect(await get('/sentinel')).toEqual({ status: 404, body: { error: 'No such record' } });
+    expect(await get('/wrapped')).toEqual({ status: 404, body: { error: 'No such record' } });
+  });
+
+  it('should let the outermost mapped error decide', async () => {
+    expect(await get('/outer')).toEqual({ status: 404, body: { error: 'Not Found' } });
+  });
+
+  it('should answer unmapped errors with 500', async () => {
+    expect((await get('/unmapped')).status).toBe(500);
+    expect(errors).toHaveLength(1);
+  });
+
+  it('should still report mapped server errors', async () => {
+    expect(await get('/busy')).toEqual({ status: 503, body: { error: 'Try again later' } });
+    expect(errors).toHaveLength(1);
+  });
+
+  it('should apply to errorHandler() and safe() too', async () => {
+    expect(await get('/handled')).toEqual({ status: 404, body: { error: 'No such record' } });
+  });
+
+  it('should only map to error statuses', () => {
+    expect(() => new Qera().mapError(DomainError, 302)).toThrow('mapError() needs a 4xx or 5xx status, got 302');
+  });
+});
+
+describe('safe()', () => {
+  let server: MockApp;
+  const errors: unknown[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/risky', safe(() => {
+      throw new Error('parser exploded');
+    }));
+    app.get('/forbidden', safe(() => {
+      throw new HttpError(403, 'Not yours');
+    }));
+    app.get('/late', safe((ctx) => {
+      ctx.json({ ok: true });
+      throw new Error('after the fact');
+    }));
+    app.get('/healthy', (ctx) => ctx.json({ ok: true }));
+    app.onError((_ctx, error) => errors.push(error));
+    app.listen(3484, 'localhost');
+    server = lastApp();
+  });
+
+  it('should answer errors in the wrapped handler with 500 and keep serving', async () => {
+    const failed = await request(server, 'GET', '/risky');
+    const healthy = await request(server, 'GET', '/healthy');
+
+    expect(failed.status).toBe(500);
+    expect(JSON.parse(failed.body)).toEqual({ error: 'parser exploded' });
+    expect(healthy.status).toBe(200);
+    expect(errors).toEqual([]);
+  });
+
+  it('should use the status of HTTP errors', async () => {
+    const response = await request(server, 'GET', '/forbidden');
+
+    expect(response.status).toBe(403);
+    expect(JSON.parse(response.body)).toEqual({ error: 'Not yours' });
+  });
+
+  it('should only log errors thrown after the response was sent', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+    const response = await request(server, 'GET', '/late');
+
+    expect(JSON.parse(response.body)).toEqual({ ok: true });
+    expect(error).toHaveBeenCalledWith(expect.stringContaining('after the fact'));
+    error.mockRestore();
+  });
+});
+
+describe('Header shortcuts', () => {
+  const describeRequest = (ctx: any) => ctx.json({
+    userAgent: ctx.userAgent(),
+    referer: ctx.referer(),
+    host: ctx.host(),
+    protocol: ctx.protocol(),
+    secure: ctx.secure()
+  });
+
+  function serve(config: Record<string, any>) {
+    const app = new Qera({ logging: { level: 'error' }, ...config });
+    app.get('/info', describeRequest);
+    app.listen(3462, 'localhost');
+    return lastApp();
+  }
+
+  const forwardedHeaders = {
+    host: 'internal:3000',
+    'x-forwarded-host': 'example.com',
+    'x-forwarded-proto': 'https, http'
+  };
+
+  it('should read common headers directly', async () => {
+    const server = serve({});
+    const response = await request(server, 'GET', '/info', {
+      headers: { 'user-agent': 'curl/8.0', referer: 'https://example.com/', host: 'api.local' }
+    });
+
+    expect(JSON.parse(response.body)).toEqual({
+      userAgent: 'curl/8.0',
+      referer: 'https://example.com/',
+      host: 'api.local',
+      protocol: 'http',
+      secure: false
+    });
+  });
+
+  it('should ignore forwarded headers from untrusted peers', async () => {
+    const server = serve({ trustProxy: ['10.0.0.1'] });
+    const response = await request(server, 'GET', '/info', { ip: '203.0.113.5', headers: forwardedHeaders });
+
+    expect(JSON.parse(response.body)).toMatchObject({ host: 'internal:3000', protocol: 'http', secure: false });
+  });
+
+  it('should honour forwarded headers from trusted proxies', async () => {
+    const server = serve({ trustProxy: ['10.0.0.1'] });
+    const response = await request(server, 'GET', '/info', { ip: '10.0.0.1', headers: forwardedHeaders });
+
+    expect(JSON.parse(response.body)).toMatchObject({ host: 'example.com', protocol: 'https', secure: true });
+  });
+
+  it('should report https for TLS listeners', async () => {
+    const server = serve({ ssl: { key_file_name: 'key.pem', cert_file_name: 'cert.pem' } });
+    const response = await request(server, 'GET', '/info');
+
+    expect(JSON.parse(response.body)).toMatchObject({ protocol: 'https', secure: true });
+  });
+});
+
+describe('Content type sniffing', () => {
+  const png = Buffer.from([0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0, 0, 0, 0x0d]);
+
+  function serve(config: Record<string, any>) {
+    const app = new Qera({ logging: { level: 'error' }, ...config });
+    app.get('/page', (ctx) => ctx.send('<!DOCTYPE html><title>Hi</title>'));
+    app.get('/image', (ctx) => ctx.send(png));
+    app.get('/typed', (ctx) => ctx.header('Content-Type', 'text/csv').send('a,b'));
+    app.get('/download', (ctx) => ctx.send('<html>'), { sniffContentType: false });
+    app.get('/empty', (ctx) => ctx.send(''));
+    app.listen(3485, 'localhost');
+    return lastApp();
+  }
+
+  it('should detect the type of untyped bodies', async () => {
+    const server = serve({});
+
+    expect((await request(server, 'GET', '/page')).header('Content-Type')).toBe('text/html; charset=utf-8');
+    expect((await request(server, 'GET', '/image')).header('Content-Type')).toBe('image/png');
+    expect((await request(server, 'GET', '/empty')).header('Content-Type')).toBeUndefined();
+  });
+
+  it('should keep a type set by the handler', async () => {
+    const response = await request(serve({}), 'GET', '/typed');
+
+    expect(response.header('Content-Type')).toBe('text/csv');
+  });
+
+  it('should send application/octet-stream when sniffing is off', async () => {
+    const route = await request(serve({}), 'GET', '/download');
+    const app = await request(serve({ sniffContentType: false }), 'GET', '/page');
+
+    expect(route.header('Content-Type')).toBe('application/octet-stream');
+    expect(app.header('Content-Type')).toBe('application/octet-stream');
+  });
+});
+
+describe('Default headers', () => {
+  function serve(config: Record<string, any>) {
+    const app = new Qera({ logging: { level: 'error' }, ...config });
+    app.get('/plain', (ctx) => ctx.send('ok'));
+    app.get('/override', (ctx) => ctx.header('x-frame-options', 'SAMEORIGIN').header('Server', 'edge').send('ok'));
+    app.staticFS('/assets', memoryFileSystem({ 'app.css': 'body {}' }));
+    app.listen(3465, 'localhost');
+    return lastApp();
+  }
+
+  it('should send a Server header by default', async () => {
+    const response = await request(serve({}), 'GET', '/plain');
+
+    expect(response.header('Server')).toBe('Qera');
+  });
+
+  it('should send configured headers on every response', async () => {
+    const server = serve({ defaultHeaders: { 'X-Frame-Options': 'DENY', 'X-Powered-By': 'Qera' } });
+
+    for (const path of ['/plain', '/missing', '/assets/app.css', '/assets/missing.css']) {
+      const response = await request(server, 'GET', path);
+      expect(response.header('X-Frame-Options')).toBe('DENY');
+      expect(response.header('X-Powered-By')).toBe('Qera');
+    }
+  });
+
+  it('should let handlers override defaults', async () => {
+    const server = serve({ defaultHeaders: { 'X-Frame-Options': 'DENY' } });
+    const response = await request(server, 'GET', '/override');
+
+    expect(response.header('X-Frame-Options')).toBe('SAMEORIGIN');
+    expect(response.header('Server')).toBe('edge');
+  });
+
+  it('should omit the Server header when disabled', async () => {
+    const server = serve({ disableServerHeader: true, defaultHeaders: { Server: 'Qera/1.0' } });
+    const response = await request(server, 'GET', '/plain');
+
+    expect(response.header('Server')).toBeUndefined();
+  });
+});
+
+describe('Feature flags', () => {
+  let server: MockApp;
+  const asked: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({
+      logging: { level: 'error' },
+      featureProvider: async (name, ctx) => {
+        asked.push(name);
+        if (name === 'broken') throw new Error('flag service down');
+        // A user-based flag: on for beta testers only
+        return name === 'new-checkout' ? ctx.headers['x-user'] === 'beta' : name === 'dark-mode';
+      }
+    });
+    app.get('/checkout', async (ctx) => {
+      const [first, again, parallel] = await Promise.all([
+        ctx.feature('new-checkout'),
+        ctx.feature('new-checkout'),
+        ctx.feature('new-checkout')
+      ]);
+      ctx.json({ first, again, parallel, dark: await ctx.feature('dark-mode'), broken: await ctx.feature('broken') });
+    });
+    app.listen(3513, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    asked.length = 0;
+  });
+
+  it('should decide flags per request, asking the provider once per flag', async () => {
+    const beta = JSON.parse((await request(server, 'GET', '/checkout', { headers: { 'x-user': 'beta' } })).body);
+    expect(beta).toEqual({ first: true, again: true, parallel: true, dark: true, broken: false });
+    expect(asked).toEqual(['new-checkout', 'dark-mode', 'broken']);
+
+    const other = JSON.parse((await request(server, 'GET', '/checkout', { headers: { 'x-user': 'ada' } })).body);
+    expect(other.first).toBe(false);
+    expect(asked.filter(name => name === 'new-checkout')).toHaveLength(2);
+  });
+
+  it('should need a provider', async () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    let error: unknown;
+    app.get('/flag', (ctx) => {
+      try {
+        ctx.feature('x');
+      } catch (caught) {
+        error = caught;
+      }
+      ctx.send('ok');
+    });
+    app.listen(3514, 'localhost');
+    await request(lastApp(), 'GET', '/flag');
+
+    expect(String(error)).toContain('qera.feature() needs a provider');
+  });
+});
+
+describe('Response header limits', () => {
+  function serve(config: Record<string, any>) {
+    const app = new Qera({ logging: { level: 'error' }, ...config });
+    app.get('/cookies/:n', (ctx) => {
+      for (let i = 0; i < Number(ctx.params.n); i++) ctx.cookie(`c${i}`, 'x');
+      ctx.send('ok');
+    });
+    app.get('/large', (ctx) => ctx.setHeaders({ 'X-Blob': 'x'.repeat(300) }).send('ok'));
+    app.listen(3512, 'localhost');
+    return lastApp();
+  }
+  const cookies = (response: { headers: Array<[string, string]> }) =>
+    response.headers.filter(([key]) => key === 'Set-Cookie').length;
+
+  it('should allow responses within the limits', async () => {
+    const server = serve({ maxResponseHeaders: 5 });
+
+    // The Server default header is one of the 5
+    expect((await request(server, 'GET', '/cookies/4')).status).toBe(200);
+    expect((await request(serve({}), 'GET', '/cookies/99')).status).toBe(200);
+  });
+
+  it('should fail responses over the limits outside production', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+    const server = serve({ maxResponseHeaders: 5, maxResponseHeaderSize: 200 });
+
+    expect((await request(server, 'GET', '/cookies/5')).status).toBe(500);
+    expect((await request(server, 'GET', '/large')).status).toBe(500);
+    expect(error).toHaveBeenCalledWith(expect.stringContaining('ResponseHeaderLimitError: Response for /cookies/5 would have more than 5 headers with Set-Cookie'));
+    error.mockRestore();
+  });
+
+  it('should drop headers over the limits in production, with one warning', async () => {
+    const env = process.env.NODE_ENV;
+    process.env.NODE_ENV = 'production';
+    const warn = jest.spyOn(Logger, 'warn').mockImplementation(() => undefined);
+    try {
+      const response = await request(serve({ maxResponseHeaders: 5 }), 'GET', '/cookies/1000');
+
+      expect(response.status).toBe(200);
+      expect(cookies(response)).toBe(4);
+      expect(warn).toHaveBeenCalledTimes(1);
+    } finally {
+      process.env.NODE_ENV = env;
+      warn.mockRestore();
+    }
+  });
+
+  it('should not limit headers with 0', async () => {
+    const response = await request(serve({ maxResponseHeaders: 0 }), 'GET', '/cookies/500');
+
+    expect(cookies(response)).toBe(500);
+  });
+});
+
+describe('XML', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.post('/orders', (ctx) => {
+      const { order } = ctx.bindAndValidate(v.object({
+        order: v.object({ '@id': v.string(), item: v.array(v.object({ '@sku': v.string(), '#text': v.string() })) })
+      }));
+      ctx.status(201).xml({
+        receipt: { '@order': order['@id'], line: order.item.map(item => ({ '@sku': item['@sku'], qty: Number(item['#text']) })) }
+      });
+    });
+    app.post('/echo', (ctx) => ctx.xml(ctx.body));
+    app.listen(3515, 'localhost');
+    server = lastApp();
+  });
+
+  const post = (path: string, body: string, contentType = 'application/xml') =>
+    request(server, 'POST', path, { headers: { 'content-type': contentType }, body });
+
+  it('should bind XML bodies and answer in XML', async () => {
+    const response = await post('/orders', '<order id="7"><item sku="A-1">2</item><item sku="B-2">1</item></order>');
+
+    expect(response.status).toBe(201);
+    expect(response.header('Content-Type')).toBe('application/xml; charset=utf-8');
+    expect(response.body).toBe(
+      '<?xml version="1.0" encoding="UTF-8"?>\n' +
+      '<receipt order="7"><line sku="A-1"><qty>2</qty></line><line sku="B-2"><qty>1</qty></line></receipt>'
+    );
+  });
+
+  it('should accept text/xml and +xml media types', async () => {
+    const envelope = '<soap:Envelope xmlns:soap="urn:x"><soap:Body><ping>1</ping></soap:Body></soap:Envelope>';
+
+    expect((await post('/echo', '<a b="1">x</a>', 'text/xml; charset=utf-8')).body).toContain('<a b="1">x</a>');
+    expect((await post('/echo', envelope, 'application/soap+xml')).body).toContain(envelope);
+  });
+
+  it('should answer malformed XML with a 400 saying where it broke', async () => {
+    const response = await post('/orders', '<order id="7">\n  <item sku="A-1">2</order>');
+
+    expect(response.status).toBe(400);
+    expect(JSON.parse(response.body)).toEqual({
+      error: 'Malformed request body: Expected </item>, found </order> at line 2, column 27'
+    });
+  });
+});
+
+describe('msgpack', () => {
+  // Stand-in codec; real apps plug in a msgpack library with the same shape
+  const codec = {
+    encode: (value: any) => new Uint8Array(Buffer.from(`MP${JSON.stringify(value)}`)),
+    decode: (data: Uint8Array) => {
+      const text = Buffer.from(data).toString();
+      if (!text.startsWith('MP')) throw new Error('not msgpack');
+      return JSON.parse(text.slice(2));
+    }
+  };
+
+  const order = {
+    id: 7,
+    customer: { name: 'Ada', tags: ['vip', 'beta'] },
+    lines: [{ sku: 'A-1', qty: 2, options: { gift: true } }, { sku: 'B-2', qty: 1, options: null }]
+  };
+
+  function serve(config: Record<string, any>) {
+    const app = new Qera({ logging: { level: 'error' }, ...config });
+    app.post('/echo', (ctx) => ctx.msgpack(ctx.body));
+    app.listen(3468, 'localhost');
+    return lastApp();
+  }
+
+  const post = (server: MockApp, contentType: string, body: Uint8Array | string) =>
+    request(server, 'POST', '/echo', { headers: { 'content-type': contentType }, body: Buffer.from(body as any) });
+
+  it('should round-trip nested structures', async () => {
+    const server = serve({ msgpack: codec });
+    const response = await post(server, 'application/msgpack', codec.encode(order));
+
+    expect(response.status).toBe(200);
+    expect(response.header('Content-Type')).toBe('application/msgpack');
+    expect(codec.decode(Buffer.from(response.body))).toEqual(order);
+  });
+
+  it('should accept the legacy x-msgpack media type', async () => {
+    const server = serve({ msgpack: codec });
+    const response = await post(server, 'application/x-msgpack', codec.encode(order));
+
+    expect(codec.decode(Buffer.from(response.body))).toEqual(order);
+  });
+
+  it('should answer undecodable bodies with 400', async () => {
+    const server = serve({ msgpack: codec });
+    const response = await post(server, 'application/msgpack', 'garbage');
+
+    expect(response.status).toBe(400);
+    expect(JSON.parse(response.body).error).toBe('Malformed request body: not msgpack');
+  });
+
+  it('should fail loudly without a codec', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+    const server = serve({});
+    const response = await post(server, 'application/json', JSON.stringify(order));
+
+    expect(response.status).toBe(500);
+    expect(String(error.mock.calls[0][0])).toContain('needs a codec');
+    error.mockRestore();
+  });
+});
+
+describe('Write timeouts', () => {
+  let server: MockApp;
+  let writeError: unknown;
+  let written = 0;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, writeTimeout: 30 });
+
+    app.get('/feed', async (ctx) => {
+      ctx.header('Content-Type', 'text/plain');
+      try {
+        for (let i = 0; i < 3; i++) {
+          await ctx.write(`line ${i}\n`);
+          written++;
+        }
+        ctx.end();
+      } catch (error) {
+        writeError = error;
+      }
+    });
+
+    app.listen(3520, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    writeError = undefined;
+    written = 0;
+  });
+
+  it('should close the connection when the client stops reading', async () => {
+    const startedAt = Date.now();
+    const response = await request(server, 'GET', '/feed', { stopReading: true });
+
+    expect(response.closed).toBe(true);
+    expect(Date.now() - startedAt).toBeGreaterThanOrEqual(25);
+    expect(written).toBe(0);
+    expect(writeError).toBeInstanceOf(ConnectionClosedError);
+  });
+
+  it('should leave clients that keep reading alone', async () => {
+    const response = await request(server, 'GET', '/feed');
+
+    expect(response.body).toBe('line 0\nline 1\nline 2\n');
+    expect(written).toBe(3);
+  });
+});
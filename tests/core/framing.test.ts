@@ -0,0 +1,120 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { memoryFileSystem } from '../../src/utils/staticFiles';
+import { lastApp, request, MockApp, RequestOptions } from '../helpers/mockUws';
+
+describe('Ambiguous body framing', () => {
+  let server: MockApp;
+  const seen: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(async (ctx, next) => {
+      seen.push(ctx.path());
+      await next();
+    });
+    app.post('/orders', (ctx) => ctx.json({ received: ctx.body }));
+    app.staticFS('/assets', memoryFileSystem({ 'app.js': 'console.log(1)' }));
+
+    app.listen(3503, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    seen.length = 0;
+  });
+
+  const post = (headers: RequestOptions['headers'], url = '/orders') =>
+    request(server, 'POST', url, { headers: { 'content-type': 'text/plain', ...headers }, body: 'hello' });
+
+  it('should accept a single Content-Length or chunked encoding', async () => {
+    expect((await post({ 'content-length': '5' })).status).toBe(200);
+    expect((await post({ 'transfer-encoding': 'chunked' })).status).toBe(200);
+    expect((await post({ 'transfer-encoding': 'gzip, chunked' })).status).toBe(200);
+  });
+
+  it('should refuse Content-Length together with Transfer-Encoding', async () => {
+    const response = await post({ 'content-length': '5', 'transfer-encoding': 'chunked' });
+
+    expect(response.status).toBe(400);
+    expect(JSON.parse(response.body)).toEqual({ error: 'Bad Request' });
+    expect(response.connectionClosed).toBe(true);
+    expect(seen).toEqual([]);
+  });
+
+  it('should refuse repeated or malformed Content-Length', async () => {
+    expect((await post({ 'content-length': ['5', '5'] })).status).toBe(400);
+    expect((await post({ 'content-length': ['5', '12'] })).status).toBe(400);
+    expect((await post({ 'content-length': '5, 5' })).status).toBe(400);
+    expect((await post({ 'content-length': '+5' })).status).toBe(400);
+  });
+
+  it('should refuse Transfer-Encoding that does not end in chunked once', async () => {
+    expect((await post({ 'transfer-encoding': 'chunked, gzip' })).status).toBe(400);
+    expect((await post({ 'transfer-encoding': ['chunked', 'chunked'] })).status).toBe(400);
+    expect((await post({ 'transfer-encoding': 'xchunked' })).status).toBe(400);
+  });
+
+  it('should refuse folded header lines', async () => {
+    expect((await post({ 'x-note': 'first\r\n second' })).status).toBe(400);
+    expect((await post({ ' x-continued': 'second' })).status).toBe(400);
+  });
+
+  it('should refuse them before static files and 404s too', async () => {
+    const framing = { 'content-length': '0', 'transfer-encoding': 'chunked' };
+
+    expect((await request(server, 'GET', '/assets/app.js', { headers: framing })).status).toBe(400);
+    expect((await post(framing, '/nowhere')).status).toBe(400);
+    expect(seen).toEqual([]);
+  });
+});
+
+describe('URI length limit', () => {
+  const seen: string[] = [];
+
+  const serve = (maxURILength?: number) => {
+    const app = new Qera({ logging: { level: 'error' }, maxURILength });
+    app.use(async (ctx, next) => {
+      seen.push(ctx.path());
+      await next();
+    });
+    app.get('/search', (ctx) => ctx.json({ length: ctx.query.q.length }));
+    app.staticFS('/assets', memoryFileSystem({ 'app.js': 'console.log(1)' }));
+    app.listen(3521, 'localhost');
+    return lastApp();
+  };
+
+  beforeEach(() => {
+    seen.length = 0;
+  });
+
+  it('should answer 414 for a query string past the default limit, before any middleware', async () => {
+    const server = serve();
+    const response = await request(server, 'GET', `/search?q=${'a'.repeat(9000)}`);
+
+    expect(response.status).toBe(414);
+    expect(JSON.parse(response.body)).toEqual({ error: 'URI Too Long' });
+    expect(response.connectionClosed).toBe(true);
+    expect(seen).toEqual([]);
+  });
+
+  it('should count the path and query against a configured limit', async () => {
+    const server = serve(100);
+    // "/search?q=" is 10 bytes
+    const fits = await request(server, 'GET', `/search?q=${'a'.repeat(90)}`);
+    const over = await request(server, 'GET', `/search?q=${'a'.repeat(91)}`);
+
+    expect(JSON.parse(fits.body)).toEqual({ length: 90 });
+    expect(over.status).toBe(414);
+    expect((await request(server, 'GET', `/assets/${'a'.repeat(100)}.js`)).status).toBe(414);
+    expect((await request(server, 'POST', `/${'a'.repeat(100)}`, { body: 'x' })).status).toBe(414);
+  });
+
+  it('should not limit URIs when set to 0', async () => {
+    const server = serve(0);
+    const response = await request(server, 'GET', `/search?q=${'a'.repeat(9000)}`);
+
+    expect(JSON.parse(response.body)).toEqual({ length: 9000 });
+  });
+});
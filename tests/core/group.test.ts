@@ -0,0 +1,116 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { joinPaths } from '../../src/core/group';
+import { memoryFileSystem } from '../../src/utils/staticFiles';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('Router Groups', () => {
+  let server: MockApp;
+  const calls: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    const api = app.group('/api', async (ctx, next) => {
+      calls.push('api');
+      await next();
+    });
+    api.get('/users', (ctx) => ctx.json({ users: [] }));
+    api.notFound((ctx) => ctx.json({ error: 'No such endpoint' }));
+
+    const v2 = api.group('/v2');
+    v2.get('/users', (ctx) => ctx.json({ version: 2 }));
+    v2.notFound((ctx) => ctx.json({ error: 'No such v2 endpoint' }));
+
+    const web = app.group('/web');
+    web.get('/', (ctx) => ctx.send('home'));
+    web.notFound((ctx) => {
+      ctx.header('Content-Type', 'text/html');
+      ctx.send('<h1>Page not found</h1>');
+    });
+
+    app.get('/health', (ctx) => ctx.json({ ok: true }));
+    app.notFound((ctx) => ctx.json({ error: 'Nothing here' }));
+
+    app.listen(3457, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    calls.length = 0;
+  });
+
+  it('should mount routes under the group prefix', async () => {
+    const res = await request(server, 'GET', '/api/users');
+    expect(res.status).toBe(200);
+    expect(JSON.parse(res.body)).toEqual({ users: [] });
+  });
+
+  it('should run group middleware only for group routes', async () => {
+    await request(server, 'GET', '/api/v2/users');
+    expect(calls).toEqual(['api']);
+
+    calls.length = 0;
+    await request(server, 'GET', '/health');
+    expect(calls).toEqual([]);
+  });
+
+  it('should use the group not-found handler', async () => {
+    const res = await request(server, 'GET', '/api/missing');
+    expect(res.status).toBe(404);
+    expect(JSON.parse(res.body)).toEqual({ error: 'No such endpoint' });
+  });
+
+  it('should prefer the most specific group', async () => {
+    const res = await request(server, 'GET', '/api/v2/missing');
+    expect(res.status).toBe(404);
+    expect(JSON.parse(res.body)).toEqual({ error: 'No such v2 endpoint' });
+  });
+
+  it('should serve HTML 404s for the web group', async () => {
+    const res = await request(server, 'GET', '/web/missing');
+    expect(res.status).toBe(404);
+    expect(res.header('Content-Type')).toBe('text/html');
+    expect(res.body).toBe('<h1>Page not found</h1>');
+  });
+
+  it('should not treat a shared name as a prefix match', async () => {
+    const res = await request(server, 'GET', '/apiary');
+    expect(JSON.parse(res.body)).toEqual({ error: 'Nothing here' });
+  });
+
+  it('should fall back to the app-level handler', async () => {
+    const res = await request(server, 'GET', '/elsewhere');
+    expect(res.status).toBe(404);
+    expect(JSON.parse(res.body)).toEqual({ error: 'Nothing here' });
+  });
+
+  it('should still answer 405 for known paths', async () => {
+    const res = await request(server, 'POST', '/api/users');
+    expect(res.status).toBe(405);
+    expect(res.header('Allow')).toBe('GET');
+  });
+
+  it('should use the group not-found handler for GETs a static mount misses', async () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.staticFS('', memoryFileSystem({ 'docs/index.html': '<h1>docs</h1>' }));
+    app.group('/docs').notFound((ctx) => ctx.send('No such page'));
+    app.listen(3525, 'localhost');
+    const withStatic = lastApp();
+
+    expect((await request(withStatic, 'GET', '/docs/')).body).toBe('<h1>docs</h1>');
+    const res = await request(withStatic, 'GET', '/docs/missing');
+    expect(res.status).toBe(404);
+    expect(res.body).toBe('No such page');
+  });
+});
+
+describe('joinPaths', () => {
+  it('should join prefixes and paths with a single slash', () => {
+    expect(joinPaths('/api', '/users')).toBe('/api/users');
+    expect(joinPaths('/api/', 'users')).toBe('/api/users');
+    expect(joinPaths('/api', '/')).toBe('/api');
+    expect(joinPaths('/', '/')).toBe('/');
+  });
+});
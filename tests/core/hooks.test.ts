@@ -0,0 +1,79 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('Lifecycle Hooks', () => {
+  let app: Qera;
+  let server: MockApp;
+  const events: string[] = [];
+
+  beforeAll(() => {
+    app = new Qera({ logging: { level: 'error' } });
+
+    app.onRequest((ctx) => {
+      events.push(`request ${ctx.req.getUrl()}`);
+    });
+    app.onResponse((ctx, route) => {
+      events.push(`response ${ctx.statusCode} ${route ? `${route.method} ${route.path}` : 'unmatched'}`);
+    });
+    app.onError((ctx, error) => {
+      events.push(`error ${(error as Error).message}`);
+    });
+
+    app.get('/users/:id', (ctx) => {
+      ctx.json({ id: ctx.params.id });
+    });
+
+    app.get('/fail', () => {
+      throw new Error('boom');
+    });
+
+    app.listen(3456, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    events.length = 0;
+  });
+
+  it('should report the matched route pattern', async () => {
+    const response = await request(server, 'GET', '/users/42');
+
+    expect(response.status).toBe(200);
+    expect(events).toEqual(['request /users/42', 'response 200 GET /users/:id']);
+  });
+
+  it('should run for 404 responses', async () => {
+    const response = await request(server, 'GET', '/missing');
+
+    expect(response.status).toBe(404);
+    expect(events).toEqual(['request /missing', 'response 404 unmatched']);
+  });
+
+  it('should run for 405 responses', async () => {
+    const response = await request(server, 'POST', '/users/42');
+
+    expect(response.status).toBe(405);
+    expect(response.header('Allow')).toBe('GET');
+    expect(events).toEqual(['request /users/42', 'response 405 unmatched']);
+  });
+
+  it('should report handler errors', async () => {
+    const response = await request(server, 'GET', '/fail');
+
+    expect(response.status).toBe(500);
+    expect(events).toEqual(['request /fail', 'error boom', 'response 500 GET /fail']);
+  });
+
+  it('should not let a failing hook affect the response', async () => {
+    app.onRequest(() => {
+      throw new Error('hook failure');
+    });
+
+    const response = await request(server, 'GET', '/users/1');
+
+    expect(response.status).toBe(200);
+    expect(JSON.parse(response.body)).toEqual({ id: '1' });
+  });
+});
@@ -0,0 +1,125 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { IncomingMessage, ServerResponse } from 'http';
+import { Readable } from 'stream';
+import { Qera } from '../../src/core/app';
+import { fromNodeHandler, fromNodeMiddleware } from '../../src/core/nodeAdapter';
+import { Logger } from '../../src/utils/logger';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+// Reads the request body the way plain Node handlers do
+function readBody(req: IncomingMessage): Promise<string> {
+  return new Promise((resolve, reject) => {
+    const chunks: Buffer[] = [];
+    req.on('data', chunk => chunks.push(chunk));
+    req.on('end', () => resolve(Buffer.concat(chunks).toString()));
+    req.on('error', reject);
+  });
+}
+
+describe('Node handler adapters', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    app.use(fromNodeMiddleware((req, res, next) => {
+      res.setHeader('X-Legacy-Middleware', 'yes');
+      if (req.headers['x-block'] === '1') {
+        res.statusCode = 403;
+        res.end('blocked');
+        return;
+      }
+      if (req.headers['x-fail'] === '1') {
+        return next(new Error('legacy failure'));
+      }
+      next();
+    }));
+
+    app.post('/echo', fromNodeHandler(async (req, res) => {
+      const body = await readBody(req);
+      res.setHeader('Set-Cookie', ['a=1', 'b=2']);
+      res.writeHead(201, { 'Content-Type': 'application/json', 'X-Method': req.method! });
+      res.end(JSON.stringify({ url: req.url, body, type: req.headers['content-type'] }));
+    }));
+    app.get('/chunks', fromNodeHandler((req, res) => {
+      res.setHeader('Content-Type', 'text/plain');
+      res.write('one,');
+      res.write(Buffer.from('two,'));
+      res.end('three');
+    }));
+    app.get('/piped', fromNodeHandler((req, res: ServerResponse) => {
+      Readable.from(['x', 'y', 'z']).pipe(res);
+    }));
+    app.get('/throws', fromNodeHandler(() => {
+      throw new Error('sync failure');
+    }));
+    app.get('/native', (ctx) => ctx.json({ native: true }));
+
+    app.listen(3477, 'localhost');
+    server = lastApp();
+  });
+
+  it('should pass the method, url, headers and body to the handler', async () => {
+    const res = await request(server, 'POST', '/echo?draft=1', {
+      headers: { 'content-type': 'application/json' },
+      body: '{"title":"Hello"}'
+    });
+
+    expect(res.status).toBe(201);
+    expect(res.header('X-Method')).toBe('POST');
+    expect(res.headers.filter(([key]) => key === 'Set-Cookie').map(([, value]) => value)).toEqual(['a=1', 'b=2']);
+    expect(JSON.parse(res.body)).toEqual({ url: '/echo?draft=1', body: '{"title":"Hello"}', type: 'application/json' });
+  });
+
+  it('should stream written and piped responses', async () => {
+    const chunks = await request(server, 'GET', '/chunks');
+    const piped = await request(server, 'GET', '/piped');
+
+    expect(chunks.body).toBe('one,two,three');
+    expect(chunks.header('Content-Type')).toBe('text/plain');
+    expect(piped.body).toBe('xyz');
+  });
+
+  it('should carry middleware headers into the Qera response', async () => {
+    const res = await request(server, 'GET', '/native');
+
+    expect(JSON.parse(res.body)).toEqual({ native: true });
+    expect(res.header('X-Legacy-Middleware')).toBe('yes');
+  });
+
+  it('should stop the chain when middleware ends the response', async () => {
+    const res = await request(server, 'GET', '/native', { headers: { 'x-block': '1' } });
+
+    expect(res.status).toBe(403);
+    expect(res.body).toBe('blocked');
+  });
+
+  it('should turn errors into 500 responses', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+
+    const failed = await request(server, 'GET', '/native', { headers: { 'x-fail': '1' } });
+    const thrown = await request(server, 'GET', '/throws');
+
+    expect(failed.status).toBe(500);
+    expect(thrown.status).toBe(500);
+    error.mockRestore();
+  });
+
+  it('should not report the client gone when the deadline passes', async () => {
+    const app = new Qera({ logging: { level: 'error' }, requestTimeout: 20 });
+    app.get('/late', fromNodeHandler((req, res) => {
+      let closed = false;
+      res.on('close', () => {
+        closed = true;
+      });
+      setTimeout(() => res.end(closed ? 'gone' : 'answered'), 40);
+    }));
+    app.listen(3527, 'localhost');
+
+    const res = await request(lastApp(), 'GET', '/late');
+
+    expect(res.status).toBe(200);
+    expect(res.body).toBe('answered');
+  });
+});
@@ -0,0 +1,129 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import http from 'http';
+import { AddressInfo } from 'net';
+import { Qera } from '../../src/core/app';
+
+interface NodeResult {
+  status: number;
+  headers: http.IncomingHttpHeaders;
+  body: string;
+}
+
+function send(port: number, method: string, path: string, body?: string, headers: http.OutgoingHttpHeaders = {}): Promise<NodeResult> {
+  return new Promise((resolve, reject) => {
+    const req = http.request({ host: '127.0.0.1', port, method, path, headers }, (res) => {
+      const chunks: Buffer[] = [];
+      res.on('data', chunk => chunks.push(chunk));
+      res.on('end', () => resolve({ status: res.statusCode!, headers: res.headers, body: Buffer.concat(chunks).toString() }));
+    });
+    req.on('error', reject);
+    req.end(body);
+  });
+}
+
+describe('app.handler()', () => {
+  let server: http.Server;
+  let port: number;
+
+  beforeAll(async () => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    app.use(async (ctx, next) => {
+      ctx.header('X-Middleware', 'ran');
+      await next();
+    });
+
+    app.get('/users/me', (ctx) => ctx.json({ me: true }));
+    app.get('/users/:id', (ctx) => ctx.json({ id: ctx.params.id, sort: ctx.query.sort }));
+    app.post('/users', async (ctx) => ctx.status(201).json({ created: ctx.body }));
+    app.post('/uploads', (ctx) => ctx.json({ bytes: ctx.peekBody().length, digest: ctx.trailer('Content-Digest') }));
+    app.get('/cookies', (ctx) => {
+      ctx.cookie('a', '1');
+      ctx.cookie('b', '2');
+      ctx.send('ok');
+    });
+    app.get('/files/*', (ctx) => ctx.send(`file ${ctx.req.getUrl()}`));
+
+    server = http.createServer(app.handler());
+    await new Promise<void>(resolve => server.listen(0, '127.0.0.1', () => resolve()));
+    port = (server.address() as AddressInfo).port;
+  });
+
+  afterAll(async () => {
+    await new Promise(resolve => server.close(resolve));
+  });
+
+  it('should route requests and extract params and query', async () => {
+    const res = await send(port, 'GET', '/users/42?sort=name');
+
+    expect(res.status).toBe(200);
+    expect(res.headers['content-type']).toContain('application/json');
+    expect(JSON.parse(res.body)).toEqual({ id: '42', sort: 'name' });
+  });
+
+  it('should prefer static segments over params', async () => {
+    const res = await send(port, 'GET', '/users/me');
+
+    expect(JSON.parse(res.body)).toEqual({ me: true });
+  });
+
+  it('should run middleware', async () => {
+    const res = await send(port, 'GET', '/users/1');
+
+    expect(res.headers['x-middleware']).toBe('ran');
+  });
+
+  it('should parse request bodies', async () => {
+    const res = await send(port, 'POST', '/users', '{"name":"Ada"}', { 'Content-Type': 'application/json' });
+
+    expect(res.status).toBe(201);
+    expect(JSON.parse(res.body)).toEqual({ created: { name: 'Ada' } });
+  });
+
+  it('should read request trailers once the body has been read', async () => {
+    const res = await new Promise<NodeResult>((resolve, reject) => {
+      const req = http.request({
+        host: '127.0.0.1',
+        port,
+        method: 'POST',
+        path: '/uploads',
+        headers: { 'Content-Type': 'application/octet-stream', 'Transfer-Encoding': 'chunked', Trailer: 'Content-Digest' }
+      }, (response) => {
+        const chunks: Buffer[] = [];
+        response.on('data', chunk => chunks.push(chunk));
+        response.on('end', () => resolve({ status: response.statusCode!, headers: response.headers, body: Buffer.concat(chunks).toString() }));
+      });
+      req.on('error', reject);
+      req.write('part one,');
+      req.write('part two');
+      req.addTrailers({ 'Content-Digest': 'sha-256=:abc=:' });
+      req.end();
+    });
+
+    expect(JSON.parse(res.body)).toEqual({ bytes: 17, digest: 'sha-256=:abc=:' });
+  });
+
+  it('should keep repeated headers separate', async () => {
+    const res = await send(port, 'GET', '/cookies');
+
+    expect(res.headers['set-cookie']).toHaveLength(2);
+  });
+
+  it('should match wildcards below their prefix only', async () => {
+    const file = await send(port, 'GET', '/files/a/b.txt');
+    const bare = await send(port, 'GET', '/files');
+
+    expect(file.body).toBe('file /files/a/b.txt');
+    expect(bare.status).toBe(404);
+  });
+
+  it('should answer unmatched paths and methods like listen()', async () => {
+    const missing = await send(port, 'GET', '/nope');
+    const wrongMethod = await send(port, 'DELETE', '/users/me');
+
+    expect(missing.status).toBe(404);
+    expect(wrongMethod.status).toBe(405);
+    expect(wrongMethod.headers.allow).toContain('GET');
+  });
+});
@@ -0,0 +1,99 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { Logger } from '../../src/utils/logger';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('enableProfiling', () => {
+  let server: MockApp;
+  let warn: jest.SpyInstance;
+
+  beforeAll(() => {
+    warn = jest.spyOn(Logger, 'warn').mockImplementation(() => undefined);
+    const app = new Qera({ logging: { level: 'error' } });
+    app.enableProfiling('/debug/profile', {
+      maxSeconds: 5,
+      auth: async (ctx, next) => {
+        if (ctx.headers['x-debug-token'] !== 'secret') {
+          return ctx.status(401).json({ error: 'Unauthorized' });
+        }
+        await next();
+      }
+    });
+    app.listen(3488, 'localhost');
+    server = lastApp();
+  });
+
+  afterAll(() => warn.mockRestore());
+
+  const get = (url: string) => request(server, 'GET', url, { headers: { 'x-debug-token': 'secret' } });
+
+  it('should require the auth middleware', async () => {
+    expect((await request(server, 'GET', '/debug/profile/stats')).status).toBe(401);
+    expect(warn).not.toHaveBeenCalled();
+  });
+
+  it('should list the endpoints', async () => {
+    expect(JSON.parse((await get('/debug/profile')).body)).toEqual({
+      endpoints: ['/debug/profile/cpu', '/debug/profile/allocations', '/debug/profile/heap', '/debug/profile/stats']
+    });
+  });
+
+  it('should serve a CPU profile for the requested duration', async () => {
+    const response = await get('/debug/profile/cpu?seconds=0.1');
+
+    expect(response.status).toBe(200);
+    expect(response.header('content-disposition')).toMatch(/^attachment; filename="profile-\d+\.cpuprofile"$/);
+    const profile = JSON.parse(response.body);
+    expect(profile.nodes.length).toBeGreaterThan(0);
+    expect(profile.endTime).toBeGreaterThan(profile.startTime);
+  });
+
+  it('should serve a sampled allocation profile', async () => {
+    const response = await get('/debug/profile/allocations?seconds=0.1');
+
+    expect(response.status).toBe(200);
+    expect(JSON.parse(response.body).head).toBeDefined();
+  });
+
+  it('should serve a heap snapshot', async () => {
+    const response = await get('/debug/profile/heap');
+
+    expect(response.status).toBe(200);
+    expect(response.header('content-disposition')).toMatch(/\.heapsnapshot"$/);
+    expect(JSON.parse(response.body).snapshot.meta).toBeDefined();
+  }, 30000);
+
+  it('should reject durations outside the limit', async () => {
+    const response = await get('/debug/profile/cpu?seconds=60');
+
+    expect(response.status).toBe(400);
+    expect(JSON.parse(response.body)).toEqual({ error: 'seconds must be between 0 and 5' });
+    expect((await get('/debug/profile/cpu?seconds=abc')).status).toBe(400);
+  });
+
+  it('should run one capture at a time', async () => {
+    const first = get('/debug/profile/cpu?seconds=0.3');
+    const second = await get('/debug/profile/allocations?seconds=0.1');
+
+    expect(second.status).toBe(409);
+    expect(JSON.parse(second.body)).toEqual({ error: 'A profile is already being captured' });
+    expect((await first).status).toBe(200);
+  });
+
+  it('should report memory and event loop delay', async () => {
+    const stats = JSON.parse((await get('/debug/profile/stats')).body);
+
+    expect(stats.memory.heapUsed).toBeGreaterThan(0);
+    expect(stats.heap.heap_size_limit).toBeGreaterThan(0);
+    expect(stats.eventLoopDelay.p99).toBeGreaterThanOrEqual(0);
+  });
+
+  it('should warn when enabled without auth', () => {
+    new Qera({ logging: { level: 'error' } }).enableProfiling();
+
+    expect(warn).toHaveBeenCalledWith(
+      'Profiling endpoints under /debug/profile have no auth: anyone who can reach them can profile the process'
+    );
+  });
+});
@@ -0,0 +1,102 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { Qera } from '../../src/core/app';
+import { RecordedExchange } from '../../src/core/recorder';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+// Fixtures are written in the background once the response has ended
+async function fixtures(dir: string, count: number): Promise<string[]> {
+  for (let attempt = 0; attempt < 50; attempt++) {
+    const files = fs.existsSync(dir) ? fs.readdirSync(dir).filter(file => file.endsWith('.json')).sort() : [];
+    if (files.length >= count) {
+      return files.map(file => path.join(dir, file));
+    }
+    await new Promise(resolve => setTimeout(resolve, 10));
+  }
+  throw new Error(`Expected ${count} fixtures in ${dir}`);
+}
+
+const read = (file: string): RecordedExchange => JSON.parse(fs.readFileSync(file, 'utf8'));
+
+describe('Record mode', () => {
+  let dir: string;
+
+  function serve(record: Record<string, any> = {}) {
+    const app = new Qera({ logging: { level: 'error' }, record: { dir, ...record } });
+    app.post('/users', (ctx) => {
+      if (ctx.headers.authorization !== 'Bearer secret') {
+        return ctx.status(401).json({ error: 'Unauthorized' });
+      }
+      ctx.cookie('session', 'abc');
+      ctx.status(201).json({ created: ctx.body.name, invite: ctx.query.invite });
+    });
+    app.get('/empty', (ctx) => ctx.sendStatus(204));
+    app.listen(3483, 'localhost');
+    return { app, server: lastApp() };
+  }
+
+  const create = (server: MockApp, body = '{"name":"Ada"}') => request(server, 'POST', '/users?invite=1', {
+    headers: { 'content-type': 'application/json', authorization: 'Bearer secret' },
+    body
+  });
+
+  beforeEach(() => {
+    dir = path.join(fs.mkdtempSync(path.join(os.tmpdir(), 'qera-record-')), 'fixtures');
+  });
+
+  it('should write requests and responses with sensitive headers redacted', async () => {
+    const { server } = serve();
+    await create(server);
+
+    const [file] = await fixtures(dir, 1);
+    const exchange = read(file);
+
+    expect(path.basename(file)).toMatch(/^\d+-1-post-users\.json$/);
+    expect(exchange.request).toMatchObject({ method: 'POST', url: '/users', query: 'invite=1', body: '{"name":"Ada"}' });
+    expect(exchange.request.headers.authorization).toBe('[redacted]');
+    expect(exchange.request.headers['content-type']).toBe('application/json');
+    expect(exchange.response.status).toBe(201);
+    expect(exchange.response.body).toBe('{"created":"Ada","invite":"1"}');
+    expect(exchange.response.headers).toContainEqual(['set-cookie', '[redacted]']);
+  });
+
+  it('should replay fixtures through the app', async () => {
+    const { app, server } = serve();
+    await create(server);
+    const [file] = await fixtures(dir, 1);
+
+    const redacted = await app.replay(file);
+    const replayed = await app.replay(file, { Authorization: 'Bearer secret' });
+
+    expect(redacted.status).toBe(401);
+    expect(replayed.status).toBe(201);
+    expect(replayed.body.toString()).toBe(replayed.recorded.body);
+    expect(replayed.headers).toContainEqual(['content-type', 'application/json']);
+  });
+
+  it('should record bodyless responses', async () => {
+    const { server } = serve();
+    await request(server, 'GET', '/empty');
+
+    const [file] = await fixtures(dir, 1);
+
+    expect(read(file).response).toMatchObject({ status: 204, body: '' });
+  });
+
+  it('should stop after maxRequests and skip bodies over maxBodySize', async () => {
+    const { server } = serve({ maxRequests: 2, maxBodySize: 20 });
+    await create(server, JSON.stringify({ name: 'x'.repeat(50) }));
+    await create(server);
+    await create(server);
+
+    const files = await fixtures(dir, 1);
+    await new Promise(resolve => setTimeout(resolve, 50));
+
+    expect(fs.readdirSync(dir)).toHaveLength(1);
+    expect(path.basename(files[0])).toMatch(/-2-post-users\.json$/);
+    expect(read(files[0]).response).toMatchObject({ truncated: true, body: '{"created":"Ada","in' });
+  });
+});
@@ -0,0 +1,698 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera, RouteConfigError } from '../../src/core/app';
+import { Logger } from '../../src/utils/logger';
+import { memoryFileSystem } from '../../src/utils/staticFiles';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('Route conflicts', () => {
+  const handler = () => {};
+  let app: Qera;
+
+  beforeEach(() => {
+    app = new Qera({ logging: { level: 'error' } });
+  });
+
+  it('should reject a second handler for the same method and path', () => {
+    app.get('/users', handler);
+
+    expect(() => app.get('/users', handler)).toThrow('Route conflict: GET /users conflicts with GET /users');
+  });
+
+  it('should reject patterns that only differ in parameter names', () => {
+    app.delete('/users/:id', handler);
+
+    expect(() => app.delete('/users/:name', handler)).toThrow('Route conflict: DELETE /users/:name conflicts with DELETE /users/:id');
+  });
+
+  it('should name both registration sites', () => {
+    app.get('/items', handler);
+
+    let message = '';
+    try {
+      app.get('/items', handler);
+    } catch (error) {
+      message = (error as Error).message;
+    }
+
+    expect(message).toMatch(/registered at .*routes\.test\.ts/);
+    expect(message).toMatch(/previously registered at .*routes\.test\.ts/);
+  });
+
+  it('should detect conflicts between groups and the app', () => {
+    app.get('/api/users', handler);
+
+    expect(() => app.group('/api').get('/users', handler)).toThrow('Route conflict');
+  });
+
+  it('should allow the same path for different methods', () => {
+    expect(() => {
+      app.get('/users/:id', handler);
+      app.put('/users/:id', handler);
+      app.any('/users/:id', handler);
+    }).not.toThrow();
+  });
+
+  it('should allow static and parametric routes side by side', () => {
+    expect(() => {
+      app.get('/users/:id', handler);
+      app.get('/users/me', handler);
+      app.get('/users/:id/posts', handler);
+    }).not.toThrow();
+  });
+});
+
+describe('Param patterns', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.paramPattern('sku', /[A-Z]{3}-\d{4}/);
+
+    app.get('/items/:id{int}', (ctx) => ctx.json({ kind: 'int', id: ctx.paramInt('id') }));
+    app.get('/items/:id{uuid}', (ctx) => ctx.json({ kind: 'uuid', id: ctx.params.id }));
+    app.get('/items/:id{sku}', (ctx) => ctx.json({ kind: 'sku', id: ctx.params.id }));
+    app.get('/posts/:slug{slug}', (ctx) => ctx.json({ slug: ctx.params.slug }));
+    // Registered before the constrained route, still tried after it
+    app.get('/users/:name', (ctx) => ctx.json({ kind: 'name' }));
+    app.get('/users/:id{int}', (ctx) => ctx.json({ kind: 'id' }));
+
+    app.listen(3467, 'localhost');
+    server = lastApp();
+  });
+
+  const get = async (path: string) => {
+    const response = await request(server, 'GET', path);
+    return { status: response.status, body: JSON.parse(response.body) };
+  };
+
+  it('should route by the built-in patterns', async () => {
+    expect(await get('/items/42')).toEqual({ status: 200, body: { kind: 'int', id: 42 } });
+    expect((await get('/items/0b7f2a3c-9d1e-4f5a-8b6c-1d2e3f4a5b6c')).body.kind).toBe('uuid');
+    expect((await get('/posts/hello-world')).body).toEqual({ slug: 'hello-world' });
+  });
+
+  it('should route by custom patterns', async () => {
+    expect((await get('/items/ABC-1234')).body).toEqual({ kind: 'sku', id: 'ABC-1234' });
+  });
+
+  it('should answer 404 when no constraint matches', async () => {
+    expect((await get('/items/not-an-id')).status).toBe(404);
+    expect((await get('/posts/Not_A_Slug')).status).toBe(404);
+  });
+
+  it('should prefer constrained routes over unconstrained ones', async () => {
+    expect((await get('/users/7')).body).toEqual({ kind: 'id' });
+    expect((await get('/users/ada')).body).toEqual({ kind: 'name' });
+  });
+
+  it('should reject unknown pattern names at registration', () => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    expect(() => app.get('/items/:id{nope}', () => {})).toThrow('Unknown param pattern "nope" in route GET /items/:id{nope}');
+  });
+
+  it('should still detect conflicts between identically constrained routes', () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/items/:id{int}', () => {});
+
+    expect(() => app.get('/items/:key{int}', () => {})).toThrow('Route conflict');
+  });
+});
+
+describe('Encoded params', () => {
+  let server: MockApp;
+  let rawServer: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/users/:name', (ctx) => ctx.json({ name: ctx.params.name }));
+    app.get('/files/:dir/:file', (ctx) => ctx.json(ctx.params));
+    app.get('/items/:id{int}', (ctx) => ctx.json({ id: ctx.paramInt('id') }));
+    app.listen(3495, 'localhost');
+    server = lastApp();
+
+    const rawApp = new Qera({ logging: { level: 'error' }, unescapePath: false });
+    rawApp.get('/users/:name', (ctx) => ctx.json({ name: ctx.params.name }));
+    rawApp.listen(3496, 'localhost');
+    rawServer = lastApp();
+  });
+
+  const get = async (target: MockApp, path: string) => {
+    const response = await request(target, 'GET', path);
+    return response.status === 200 ? JSON.parse(response.body) : response.status;
+  };
+
+  it('should decode encoded spaces and unicode', async () => {
+    expect(await get(server, '/users/john%20doe')).toEqual({ name: 'john doe' });
+    expect(await get(server, '/users/Ren%C3%A9e%20%E6%9D%B1%E4%BA%AC')).toEqual({ name: 'Renée 東京' });
+  });
+
+  it('should keep plus signs, which only mean spaces in query strings', async () => {
+    expect(await get(server, '/users/a+b')).toEqual({ name: 'a+b' });
+    expect(await get(server, '/users/a%2Bb')).toEqual({ name: 'a+b' });
+  });
+
+  it('should match on the raw path, so encoded slashes stay inside a param', async () => {
+    expect(await get(server, '/users/a%2Fb')).toEqual({ name: 'a/b' });
+    expect(await get(server, '/files/docs%2F2024/a%20b.txt')).toEqual({ dir: 'docs/2024', file: 'a b.txt' });
+  });
+
+  it('should check param patterns against the decoded value', async () => {
+    expect(await get(server, '/items/%34%32')).toEqual({ id: 42 });
+  });
+
+  it('should leave malformed escapes as sent', async () => {
+    expect(await get(server, '/users/100%')).toEqual({ name: '100%' });
+    expect(await get(server, '/users/%E0%A4%A')).toEqual({ name: '%E0%A4%A' });
+  });
+
+  it('should pass params through undecoded when unescapePath is off', async () => {
+    expect(await get(rawServer, '/users/john%20doe')).toEqual({ name: 'john%20doe' });
+  });
+});
+
+describe('Mounts', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    const legacy = (ctx: any) => ctx.json({
+      handler: 'legacy',
+      method: ctx.method,
+      path: ctx.path(),
+      prefix: ctx.route.prefix,
+      rest: ctx.path().slice(ctx.route.prefix.length)
+    });
+
+    app.mount('/legacy/', legacy);
+    app.get('/legacy/users', (ctx) => ctx.json({ handler: 'users' }));
+    app.get('/legacy/users/:id', (ctx) => ctx.json({ handler: 'user', id: ctx.params.id }));
+    app.group('/admin').mount('/old', (ctx) => ctx.json({ handler: 'admin', prefix: ctx.route!.prefix }));
+    app.get('/legacyish', (ctx) => ctx.json({ handler: 'legacyish' }));
+
+    app.listen(3476, 'localhost');
+    server = lastApp();
+  });
+
+  const call = async (method: string, path: string) => JSON.parse((await request(server, method, path)).body);
+
+  it('should route the prefix and everything below it to the handler', async () => {
+    expect(await call('GET', '/legacy')).toEqual({ handler: 'legacy', method: 'GET', path: '/legacy', prefix: '/legacy', rest: '' });
+    expect(await call('DELETE', '/legacy/reports/2024/q1')).toEqual({
+      handler: 'legacy',
+      method: 'DELETE',
+      path: '/legacy/reports/2024/q1',
+      prefix: '/legacy',
+      rest: '/reports/2024/q1'
+    });
+  });
+
+  it('should let exact and parameter routes win', async () => {
+    expect(await call('GET', '/legacy/users')).toEqual({ handler: 'users' });
+    expect(await call('GET', '/legacy/users/7')).toEqual({ handler: 'user', id: '7' });
+    // Other methods and deeper paths aren't matched by those routes
+    expect((await call('POST', '/legacy/users')).handler).toBe('legacy');
+    expect((await call('GET', '/legacy/users/7/avatar')).handler).toBe('legacy');
+  });
+
+  it('should only match whole segments', async () => {
+    expect(await call('GET', '/legacyish')).toEqual({ handler: 'legacyish' });
+    expect((await request(server, 'GET', '/legacyx')).status).toBe(404);
+  });
+
+  it('should mount under group prefixes', async () => {
+    expect(await call('PUT', '/admin/old/settings')).toEqual({ handler: 'admin', prefix: '/admin/old' });
+  });
+
+  it('should conflict with an any() route on the prefix', () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.any('/legacy', () => {});
+
+    expect(() => app.mount('/legacy', () => {})).toThrow('Route conflict');
+  });
+});
+
+describe('Path prefixes', () => {
+  const describeRoute = (ctx: any) => ctx.json({ route: ctx.route.path, path: ctx.path(), id: ctx.params.id });
+
+  describe('stripPrefix', () => {
+    let server: MockApp;
+
+    beforeAll(() => {
+      const app = new Qera({ logging: { level: 'error' } });
+      app.stripPrefix('/service-a/');
+      app.get('/', describeRoute);
+      app.get('/users/:id', describeRoute);
+      app.post('/users', describeRoute);
+
+      app.listen(3480, 'localhost');
+      server = lastApp();
+    });
+
+    it('should route requests with the prefix to the unprefixed routes', async () => {
+      const res = await request(server, 'GET', '/service-a/users/7');
+
+      expect(JSON.parse(res.body)).toEqual({ route: '/users/:id', path: '/users/7', id: '7' });
+      expect(JSON.parse((await request(server, 'GET', '/service-a')).body).path).toBe('/');
+    });
+
+    it('should answer 404 without the prefix', async () => {
+      const res = await request(server, 'GET', '/users/7');
+
+      expect(res.status).toBe(404);
+      expect(JSON.parse(res.body)).toEqual({ error: 'Not Found' });
+    });
+
+    it('should answer 405 for other methods under the prefix only', async () => {
+      const under = await request(server, 'DELETE', '/service-a/users');
+      const outside = await request(server, 'DELETE', '/users');
+
+      expect(under.status).toBe(405);
+      expect(under.header('Allow')).toBe('POST');
+      expect(outside.status).toBe(404);
+    });
+  });
+
+  describe('addPrefix', () => {
+    let server: MockApp;
+    let warn: jest.SpyInstance;
+
+    beforeAll(() => {
+      warn = jest.spyOn(Logger, 'warn').mockImplementation(() => undefined);
+      const app = new Qera({ logging: { level: 'error' } });
+      app.addPrefix('/service-a');
+      app.get('/service-a', describeRoute);
+      app.get('/service-a/users/:id', describeRoute);
+      app.get('/health', describeRoute);
+
+      app.listen(3481, 'localhost');
+      server = lastApp();
+    });
+
+    afterAll(() => warn.mockRestore());
+
+    it('should route requests without the prefix to the prefixed routes', async () => {
+      const res = await request(server, 'GET', '/users/7');
+
+      expect(JSON.parse(res.body)).toEqual({ route: '/service-a/users/:id', path: '/service-a/users/7', id: '7' });
+      expect(JSON.parse((await request(server, 'GET', '/')).body).path).toBe('/service-a');
+    });
+
+    it('should not serve requests that already carry the prefix', async () => {
+      expect((await request(server, 'GET', '/service-a/users/7')).status).toBe(404);
+    });
+
+    it('should skip routes outside the prefix with a warning', async () => {
+      expect((await request(server, 'GET', '/health')).status).toBe(404);
+      expect(warn.mock.calls.map(([message]) => message)).toContain(
+        "Route GET /health is outside the added prefix /service-a and can't be reached"
+      );
+    });
+  });
+});
+
+describe('Host routing', () => {
+  let server: MockApp;
+  const reply = (name: string) => (ctx: any) => ctx.json({ name, id: ctx.params.id, host: ctx.route.host });
+  const get = (host: string, url: string, method = 'GET') => request(server, method, url, { headers: { host } });
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/', reply('web'));
+    app.get('/users/me', reply('web me'));
+    app.notFound((ctx) => ctx.json({ missing: 'web' }));
+
+    const api = app.host('API.example.com');
+    api.get('/', reply('api'));
+    api.get('/users/:id', reply('api user'));
+    api.post('/users', reply('api create'));
+    api.group('/admin', async (ctx, next) => {
+      ctx.header('X-Admin', '1');
+      await next();
+    }).get('/stats', reply('api stats'));
+
+    const tenants = app.host('*.example.com');
+    tenants.get('/', reply('tenant'));
+    tenants.notFound((ctx) => ctx.json({ missing: 'tenant' }));
+
+    app.listen(3500, 'localhost');
+    server = lastApp();
+  });
+
+  it('should route the same path by host', async () => {
+    expect(JSON.parse((await get('example.com', '/')).body)).toEqual({ name: 'web' });
+    expect(JSON.parse((await get('api.example.com', '/')).body)).toEqual({ name: 'api', host: 'api.example.com' });
+    expect(JSON.parse((await get('acme.example.com', '/')).body)).toEqual({ name: 'tenant', host: '*.example.com' });
+  });
+
+  it('should ignore ports and letter case', async () => {
+    expect(JSON.parse((await get('Api.Example.com:8443', '/')).body).name).toBe('api');
+  });
+
+  it('should match the host before the path', async () => {
+    // The app's static /users/me would otherwise beat the parameter route
+    expect(JSON.parse((await get('api.example.com', '/users/me')).body)).toMatchObject({ name: 'api user', id: 'me' });
+    expect(JSON.parse((await get('example.com', '/users/me')).body).name).toBe('web me');
+    expect((await get('api.example.com', '/nowhere')).status).toBe(404);
+  });
+
+  it('should match a single label for wildcards', async () => {
+    expect(JSON.parse((await get('a.b.example.com', '/')).body).name).toBe('web');
+    expect(JSON.parse((await get('other.org', '/')).body).name).toBe('web');
+  });
+
+  it('should answer 405 and 404 per host', async () => {
+    const notAllowed = await get('api.example.com', '/users', 'GET');
+    expect(notAllowed.status).toBe(405);
+    expect(notAllowed.header('Allow')).toBe('POST');
+    expect((await get('example.com', '/users')).status).toBe(404);
+
+    expect(JSON.parse((await get('acme.example.com', '/nowhere')).body)).toEqual({ missing: 'tenant' });
+    expect(JSON.parse((await get('api.example.com', '/nowhere')).body)).toEqual({ missing: 'web' });
+  });
+
+  it('should run group middleware for host routes', async () => {
+    const response = await get('api.example.com', '/admin/stats');
+
+    expect(JSON.parse(response.body).name).toBe('api stats');
+    expect(response.header('X-Admin')).toBe('1');
+  });
+
+  it('should only report conflicts within a host', () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/x', () => {});
+    app.host('api.example.com').get('/x', () => {});
+
+    expect(() => app.host('api.example.com').get('/x', () => {})).toThrow(
+      'Route conflict: GET api.example.com/x conflicts with GET api.example.com/x'
+    );
+  });
+
+  it('should reject invalid host patterns', () => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    expect(() => app.host('api.*.com')).toThrow('Invalid host pattern "api.*.com"');
+    expect(() => app.host('example.com:8080')).toThrow('Invalid host pattern');
+  });
+});
+
+describe('Matched route', () => {
+  let server: MockApp;
+  const describeMatch = (ctx: any) => ctx.json(ctx.route);
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/users/:id', describeMatch, { name: 'users.show' });
+    app.put('/users/:id', describeMatch);
+    app.any('/things', describeMatch);
+    app.notFound((ctx) => ctx.json({ route: ctx.route }));
+
+    app.listen(3490, 'localhost');
+    server = lastApp();
+  });
+
+  it('should report the pattern rather than the request path', async () => {
+    const route = JSON.parse((await request(server, 'GET', '/users/42')).body);
+
+    expect(route).toEqual({ method: 'GET', path: '/users/:id', methods: ['GET', 'PUT'], name: 'users.show', options: { name: 'users.show' } });
+  });
+
+  it('should list the methods registered for the pattern', async () => {
+    expect(JSON.parse((await request(server, 'PUT', '/users/42')).body).methods).toEqual(['GET', 'PUT']);
+    expect(JSON.parse((await request(server, 'DELETE', '/things')).body)).toMatchObject({ method: 'ANY', methods: ['ANY'] });
+  });
+
+  it('should be null for unmatched requests', async () => {
+    expect(JSON.parse((await request(server, 'GET', '/nowhere')).body)).toEqual({ route: null });
+  });
+});
+
+describe('Route metrics', () => {
+  let app: Qera;
+  let server: MockApp;
+
+  beforeAll(() => {
+    app = new Qera({ logging: { level: 'error' } });
+    app.get('/users/:id', (ctx) => {
+      if (ctx.params.id === 'missing') return ctx.status(404).json({ error: 'Not Found' });
+      if (ctx.params.id === 'broken') throw new Error('boom');
+      ctx.json(ctx.route!.metrics());
+    });
+    app.get('/stats', (ctx) => ctx.json(app.routeMetrics()));
+
+    app.listen(3502, 'localhost');
+    server = lastApp();
+  });
+
+  it('should count requests and errors per route', async () => {
+    await request(server, 'GET', '/users/missing');
+    await request(server, 'GET', '/users/broken');
+    await request(server, 'GET', '/nowhere');
+
+    // The current request is counted once it is answered
+    expect(JSON.parse((await request(server, 'GET', '/users/1')).body)).toEqual({ requests: 2, clientErrors: 1, errors: 1 });
+  });
+
+  it('should list every route and reset on demand', async () => {
+    const stats = JSON.parse((await request(server, 'GET', '/stats')).body);
+
+    expect(stats).toEqual([
+      { method: 'GET', path: '/users/:id', requests: 3, clientErrors: 1, errors: 1 },
+      { method: 'GET', path: '/stats', requests: 0, clientErrors: 0, errors: 0 }
+    ]);
+
+    app.resetRouteMetrics();
+    expect(app.routeMetrics().map(entry => entry.requests)).toEqual([0, 0]);
+  });
+
+  it('should hand out snapshots', () => {
+    const [entry] = app.routeMetrics();
+    entry.requests = 99;
+
+    expect(app.routeMetrics()[0].requests).toBe(0);
+  });
+});
+
+describe('addRoutes()', () => {
+  const handler = (ctx: any) => ctx.json({ route: ctx.route.path, name: ctx.route.name, tagged: ctx.state.tagged });
+  const tag = async (ctx: any, next: () => Promise<void>) => {
+    ctx.state.tagged = true;
+    await next();
+  };
+
+  it('should register every route in the list', async () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.addRoutes([
+      { method: 'GET', path: '/users', handler, name: 'users.list' },
+      { method: 'post', path: '/users', handler, middleware: [tag] },
+      { method: 'DELETE', path: '/users/:id', handler, options: { name: 'users.remove' } }
+    ]);
+    app.listen(3505, 'localhost');
+    const server = lastApp();
+
+    expect(JSON.parse((await request(server, 'GET', '/users')).body)).toEqual({ route: '/users', name: 'users.list' });
+    expect(JSON.parse((await request(server, 'POST', '/users')).body)).toEqual({ route: '/users', tagged: true });
+    expect(JSON.parse((await request(server, 'DELETE', '/users/7')).body).name).toBe('users.remove');
+  });
+
+  it('should report every invalid entry at once and register none', () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/health', handler, { name: 'health' });
+
+    let error: RouteConfigError | undefined;
+    try {
+      app.addRoutes([
+        { method: 'GET', path: '/orders', handler, name: 'orders.list' },
+        { method: 'FETCH', path: '/orders/:id', handler },
+        { method: 'POST', path: 'orders', handler: undefined as any },
+        { method: 'GET', path: '/orders/:orderId', handler },
+        { method: 'GET', path: '/orders/:key', handler, name: 'health' },
+        { method: 'GET', path: '/items/:id{sku}', handler }
+      ]);
+    } catch (caught) {
+      error = caught as RouteConfigError;
+    }
+
+    expect(error).toBeInstanceOf(RouteConfigError);
+    expect(error!.problems).toEqual([
+      'Route #2 (FETCH /orders/:id): unknown method "FETCH"',
+      'Route #3 (POST orders): the handler is not a function',
+      'Route #3 (POST orders): the pattern must start with "/"',
+      'Route #5 (GET /orders/:key): conflicts with GET /orders/:orderId',
+      'Route #5 (GET /orders/:key): the name "health" is already used by a registered route',
+      'Route #6 (GET /items/:id{sku}): unknown param pattern "sku"'
+    ]);
+    // Nothing from the list was registered, so it can be fixed and added again
+    expect(() => app.get('/orders', handler)).not.toThrow();
+  });
+
+  it('should report conflicts with routes already registered', () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/users/:id', handler);
+
+    expect(() => app.addRoutes([{ method: 'GET', path: '/users/:name', handler }])).toThrow(
+      'Route #1 (GET /users/:name): conflicts with GET /users/:id'
+    );
+  });
+});
+
+describe('build()', () => {
+  const handler = () => {};
+  let app: Qera;
+
+  beforeEach(() => {
+    app = new Qera({ logging: { level: 'error' } });
+  });
+
+  const problems = () => {
+    try {
+      app.build();
+    } catch (error) {
+      expect(error).toBeInstanceOf(RouteConfigError);
+      return (error as RouteConfigError).problems;
+    }
+    return [];
+  };
+
+  it('should accept a working route tree', () => {
+    app.get('/users/:id', handler);
+    app.mount('/files', handler);
+    app.group('/admin', async (ctx, next) => next()).get('/stats', handler);
+
+    expect(() => app.build()).not.toThrow();
+  });
+
+  it('should report malformed patterns', () => {
+    app.get('users', handler);
+    app.get('/files/*/raw', handler);
+    app.get('/a/:', handler);
+    app.post('/orders/:id/items/:id', handler);
+    app.ws('/live/:room-id', {});
+
+    expect(problems()).toEqual([
+      'Route GET users: the pattern must start with "/"',
+      'Route GET /files/*/raw: a wildcard can only be the whole last segment',
+      'Route GET /a/:: a parameter has no name',
+      'Route POST /orders/:id/items/:id: parameter "id" is used twice',
+      'WebSocket route /live/:room-id: invalid parameter name "room-id": use letters, digits and underscores'
+    ]);
+  });
+
+  it('should report route names used twice', () => {
+    app.get('/users/:id', handler, { name: 'users.show' });
+    app.get('/people/:id', handler, { name: 'users.show' });
+    app.get('/teams', handler, { name: 'teams.list' });
+
+    expect(problems()).toEqual(['Route name "users.show" is used by both GET /users/:id and GET /people/:id']);
+  });
+
+  it('should report group middleware that no route runs', () => {
+    const auth = async (_ctx: unknown, next: () => Promise<void>) => next();
+    const audit = async (_ctx: unknown, next: () => Promise<void>) => next();
+    app.group('/empty', auth);
+    const api = app.group('/api');
+    const v2 = api.group('/v2', auth);
+    api.get('/users', handler);
+    v2.get('/users', handler);
+    api.use(audit);
+
+    expect(problems()).toEqual([
+      'Group /empty: middleware auth is added after all of its routes, or the group has none, so it never runs',
+      'Group /api: middleware audit is added after all of its routes, or the group has none, so it never runs'
+    ]);
+  });
+
+  it('should count middleware run by a subgroup route as used', () => {
+    const auth = async (_ctx: unknown, next: () => Promise<void>) => next();
+    app.group('/api', auth).group('/v1').get('/users', handler);
+
+    expect(problems()).toEqual([]);
+  });
+
+  it('should run before listen() starts serving', () => {
+    app.get('/files/*/raw', handler);
+
+    expect(() => app.listen(3488, 'localhost')).toThrow(
+      'Invalid route configuration:\n  Route GET /files/*/raw: a wildcard can only be the whole last segment'
+    );
+    expect(() => app.handler()).toThrow(RouteConfigError);
+  });
+});
+
+describe('Error response formats', () => {
+  let text: MockApp;
+  let negotiated: MockApp;
+
+  beforeAll(() => {
+    const register = (app: Qera) => {
+      app.get('/users', (ctx) => ctx.json([]));
+      app.get('/boom', () => {
+        throw new Error('boom');
+      });
+      app.staticFS('/assets', memoryFileSystem({ 'app.js': 'x' }));
+    };
+    jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+
+    const textApp = new Qera({ logging: { level: 'error' }, errorContentType: 'text' });
+    register(textApp);
+    textApp.listen(3491, 'localhost');
+    text = lastApp();
+
+    const negotiatedApp = new Qera({ logging: { level: 'error' }, errorContentType: 'html', negotiateErrors: true });
+    register(negotiatedApp);
+    negotiatedApp.listen(3492, 'localhost');
+    negotiated = lastApp();
+  });
+
+  afterAll(() => jest.restoreAllMocks());
+
+  it('should use the configured format for 404, 405 and 500', async () => {
+    const notFound = await request(text, 'GET', '/nowhere');
+    expect(notFound.status).toBe(404);
+    expect(notFound.header('Content-Type')).toBe('text/plain; charset=utf-8');
+    expect(notFound.body).toBe('Not Found');
+
+    const notAllowed = await request(text, 'POST', '/users');
+    expect(notAllowed.status).toBe(405);
+    expect(notAllowed.header('Allow')).toBe('GET');
+    expect(notAllowed.body).toBe('Method Not Allowed');
+
+    expect((await request(text, 'GET', '/boom')).body).toBe('Internal Server Error');
+  });
+
+  it('should ignore the Accept header without negotiateErrors', async () => {
+    const response = await request(text, 'GET', '/nowhere', { headers: { accept: 'application/json' } });
+
+    expect(response.header('Content-Type')).toBe('text/plain; charset=utf-8');
+  });
+
+  it('should send HTML pages', async () => {
+    const response = await request(negotiated, 'GET', '/nowhere');
+
+    expect(response.header('Content-Type')).toBe('text/html; charset=utf-8');
+    expect(response.body).toContain('<title>404 Not Found</title>');
+  });
+
+  it('should let the Accept header pick the format', async () => {
+    const json = await request(negotiated, 'GET', '/nowhere', { headers: { accept: 'application/json' } });
+    expect(json.header('Content-Type')).toBe('application/json');
+    expect(JSON.parse(json.body)).toEqual({ error: 'Not Found' });
+
+    const plain = await request(negotiated, 'GET', '/boom', { headers: { accept: 'text/plain, application/json;q=0.5' } });
+    expect(plain.status).toBe(500);
+    expect(plain.body).toBe('Internal Server Error');
+
+    // Wildcards and types none of the formats match fall back to the config
+    const browser = await request(negotiated, 'POST', '/users', { headers: { accept: 'image/png, */*;q=0.1' } });
+    expect(browser.header('Content-Type')).toBe('text/html; charset=utf-8');
+    expect((await request(negotiated, 'GET', '/nowhere', { headers: { accept: 'image/png' } })).body).toContain('<h1>');
+  });
+
+  it('should format static file 404s too', async () => {
+    const response = await request(negotiated, 'GET', '/assets/missing.js', { headers: { accept: 'text/plain' } });
+
+    expect(response.status).toBe(404);
+    expect(response.body).toBe('Not Found');
+  });
+});
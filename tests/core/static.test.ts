@@ -0,0 +1,171 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { memoryFileSystem } from '../../src/utils/staticFiles';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('Static Files', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    app.staticFS('/assets', memoryFileSystem({
+      'index.html': '<h1>assets</h1>',
+      'app.js': 'console.log("hello world");'
+    }));
+
+    app.listen(3456, 'localhost');
+    server = lastApp();
+  });
+
+  it('should serve files from an in-memory file system', async () => {
+    const response = await request(server, 'GET', '/assets/app.js');
+
+    expect(response.status).toBe(200);
+    expect(response.header('Content-Type')).toBe('application/javascript');
+    expect(response.body).toBe('console.log("hello world");');
+  });
+
+  it('should serve the index file for directories', async () => {
+    const response = await request(server, 'GET', '/assets/');
+
+    expect(response.status).toBe(200);
+    expect(response.body).toBe('<h1>assets</h1>');
+  });
+
+  it('should answer conditional requests with 304', async () => {
+    const first = await request(server, 'GET', '/assets/app.js');
+    const etag = first.header('ETag')!;
+
+    const response = await request(server, 'GET', '/assets/app.js', {
+      headers: { 'If-None-Match': etag }
+    });
+
+    expect(response.status).toBe(304);
+    expect(response.body).toBe('');
+  });
+
+  it('should serve byte ranges', async () => {
+    const response = await request(server, 'GET', '/assets/app.js', {
+      headers: { Range: 'bytes=0-10' }
+    });
+
+    expect(response.status).toBe(206);
+    expect(response.header('Content-Range')).toBe('bytes 0-10/27');
+    expect(response.body).toBe('console.log');
+  });
+
+  it('should reject unsatisfiable ranges', async () => {
+    const response = await request(server, 'GET', '/assets/app.js', {
+      headers: { Range: 'bytes=100-' }
+    });
+
+    expect(response.status).toBe(416);
+  });
+
+  it('should return 404 for missing files', async () => {
+    const response = await request(server, 'GET', '/assets/missing.css');
+
+    expect(response.status).toBe(404);
+  });
+});
+
+describe('Static Files missing a file', () => {
+  let server: MockApp;
+  const events: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    // Mounted at the root, so the static mount claims every GET
+    app.staticFS('', memoryFileSystem({ 'app.js': '' }));
+    app.post('/orders', (ctx) => ctx.sendStatus(201));
+    app.onRequest((ctx) => {
+      events.push(`request ${ctx.method} ${ctx.path()}`);
+    });
+    app.onResponse((ctx, route) => {
+      events.push(`response ${ctx.statusCode} ${route ? route.path : 'unmatched'}`);
+    });
+
+    app.listen(3524, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    events.length = 0;
+  });
+
+  it('should answer like an unmatched request, hooks included', async () => {
+    const response = await request(server, 'GET', '/missing.css');
+
+    expect(response.status).toBe(404);
+    expect(JSON.parse(response.body)).toEqual({ error: 'Not Found' });
+    expect(events).toEqual(['request GET /missing.css', 'response 404 unmatched']);
+  });
+
+  it('should answer 405 for a path only other methods have', async () => {
+    const response = await request(server, 'GET', '/orders');
+
+    expect(response.status).toBe(405);
+    expect(response.header('Allow')).toBe('POST');
+  });
+
+  it('should not run the hooks for files it serves', async () => {
+    expect((await request(server, 'GET', '/app.js')).status).toBe(200);
+    expect(events).toEqual([]);
+  });
+});
+
+describe('Static Files falling through', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    app.staticFS('/assets', memoryFileSystem({
+      'app.js': 'console.log("hello world");'
+    }), { fallThrough: true });
+
+    app.get('/assets/*', (ctx) => ctx.json({ generated: ctx.path() }));
+    app.get('/assets/:version/app.js', (ctx) => ctx.send(`version ${ctx.params.version}`));
+    app.paramPattern('hashed', /[a-f0-9]{8}\.js/);
+    app.get('/assets/:file{hashed}', (ctx) => ctx.json({ hashed: ctx.params.file }));
+    app.post('/assets/upload', (ctx) => ctx.sendStatus(201));
+
+    app.listen(3518, 'localhost');
+    server = lastApp();
+  });
+
+  it('should still serve files that exist', async () => {
+    const response = await request(server, 'GET', '/assets/app.js');
+
+    expect(response.status).toBe(200);
+    expect(response.body).toBe('console.log("hello world");');
+  });
+
+  it('should hand missing files to a dynamic route', async () => {
+    const response = await request(server, 'GET', '/assets/theme.css?v=2', { headers: { Accept: 'text/css' } });
+
+    expect(response.status).toBe(200);
+    expect(JSON.parse(response.body)).toEqual({ generated: '/assets/theme.css' });
+  });
+
+  it('should leave routes that beat the static mount untouched', async () => {
+    expect((await request(server, 'GET', '/assets/v2/app.js')).body).toBe('version v2');
+    expect(JSON.parse((await request(server, 'GET', '/assets/0123abcd.js')).body)).toEqual({ hashed: '0123abcd.js' });
+  });
+
+  it('should answer 404 and 405 as usual when no route takes the miss', async () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.staticFS('/assets', memoryFileSystem({ 'app.js': '' }), { fallThrough: true });
+    app.post('/assets/upload', (ctx) => ctx.sendStatus(201));
+    app.listen(3519, 'localhost');
+    const bare = lastApp();
+
+    expect((await request(bare, 'GET', '/assets/missing.css')).status).toBe(404);
+    const upload = await request(bare, 'GET', '/assets/upload');
+    expect(upload.status).toBe(405);
+    expect(upload.header('Allow')).toBe('POST');
+  });
+});
@@ -0,0 +1,162 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+// Fake uWS WebSocket recording what the server does with it
+function createSocket(data: any = { params: {}, query: {}, state: {} }) {
+  const ws: any = {
+    getUserData: () => data,
+    sent: [] as any[],
+    ended: null as null | { code?: number; reason?: string },
+    send: jest.fn((message: any) => { ws.sent.push(Buffer.from(message).toString()); return 1; }),
+    end: jest.fn((code?: number, reason?: string) => { ws.ended = { code, reason }; }),
+    subscribe: jest.fn(),
+    unsubscribe: jest.fn(),
+    publish: jest.fn()
+  };
+  return ws;
+}
+
+const flush = () => new Promise(resolve => setImmediate(resolve));
+
+describe('WebSocket error isolation', () => {
+  let server: MockApp;
+  const reported: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    app.ws('/echo', {
+      message: (ctx, message) => {
+        const text = Buffer.from(message).toString();
+        if (text === 'crash') throw new Error('handler crashed');
+        ctx.send(text);
+      }
+    });
+
+    app.ws('/async', {
+      open: async () => {
+        throw new Error('async failure');
+      },
+      close: () => {
+        throw new Error('close failure');
+      }
+    });
+
+    app.onWebSocketError((ctx, error) => {
+      reported.push((error as Error).message);
+    });
+
+    app.listen(3460, 'localhost');
+    server = lastApp();
+  });
+
+  const behavior = (pattern: string) => server.wsRoutes.find(route => route.pattern === pattern)!.behavior;
+
+  beforeEach(() => {
+    reported.length = 0;
+  });
+
+  it('should close only the failing connection and keep others echoing', () => {
+    const broken = createSocket();
+    const healthy = createSocket();
+    const echo = behavior('/echo');
+
+    echo.message(broken, Buffer.from('crash'), false);
+    echo.message(healthy, Buffer.from('hello'), false);
+
+    expect(broken.ended).toEqual({ code: 1011, reason: 'Internal error' });
+    expect(healthy.ended).toBeNull();
+    expect(healthy.sent).toEqual(['hello']);
+    expect(reported).toEqual(['handler crashed']);
+  });
+
+  it('should catch rejected async handlers', async () => {
+    const ws = createSocket();
+
+    behavior('/async').open(ws);
+    await flush();
+
+    expect(ws.ended).toEqual({ code: 1011, reason: 'Internal error' });
+    expect(reported).toEqual(['async failure']);
+  });
+
+  it('should report errors in close handlers without ending the socket again', () => {
+    const ws = createSocket();
+
+    behavior('/async').close(ws, 1000, new ArrayBuffer(0));
+
+    expect(ws.end).not.toHaveBeenCalled();
+    expect(reported).toEqual(['close failure']);
+  });
+});
+
+describe('WebSocket upgrades', () => {
+  let server: MockApp;
+  const seen: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+
+    app.use(async (ctx, next) => {
+      seen.push(ctx.path());
+      await next();
+    });
+
+    const auth = async (ctx: any, next: () => Promise<void>) => {
+      if (ctx.headers.authorization !== 'Bearer secret') {
+        return ctx.status(401).json({ error: 'Unauthorized' });
+      }
+      ctx.user = { id: 'u1' };
+      ctx.state.tenant = 'acme';
+      await next();
+    };
+
+    app.ws('/rooms/:room', {
+      open: (ctx) => {
+        ctx.state.joined = ctx.params.room;
+        ctx.send(JSON.stringify({ user: ctx.user.id, tenant: ctx.state.tenant, room: ctx.params.room, lang: ctx.query.lang }));
+      },
+      message: (ctx) => {
+        ctx.send(`${ctx.user.id} in ${ctx.state.joined}`);
+      }
+    }, auth);
+
+    app.listen(3504, 'localhost');
+    server = lastApp();
+  });
+
+  const upgrade = (url: string, headers: Record<string, string> = {}) =>
+    request(server, 'GET', url, { headers: { upgrade: 'websocket', 'sec-websocket-key': 'dGhlIHNhbXBsZSBub25jZQ==', ...headers } });
+
+  it('should run middleware before upgrading and refuse when it answers', async () => {
+    seen.length = 0;
+    const response = await upgrade('/rooms/lobby');
+
+    expect(response.status).toBe(401);
+    expect(response.upgraded).toBeUndefined();
+    expect(seen).toEqual(['/rooms/lobby']);
+  });
+
+  it('should hand the user and state to the socket handlers', async () => {
+    const response = await upgrade('/rooms/lobby?lang=en', { authorization: 'Bearer secret' });
+
+    expect(response.status).toBe(101);
+    expect(response.upgraded).toEqual({
+      params: { room: 'lobby' },
+      query: { lang: 'en' },
+      state: { tenant: 'acme' },
+      user: { id: 'u1' }
+    });
+
+    const ws = createSocket(response.upgraded);
+    const behavior = server.wsRoutes.find(route => route.pattern === '/rooms/:room')!.behavior;
+    behavior.open(ws);
+    behavior.message(ws, Buffer.from('hi'), false);
+
+    expect(JSON.parse(ws.sent[0])).toEqual({ user: 'u1', tenant: 'acme', room: 'lobby', lang: 'en' });
+    // State set by one handler is there for the next
+    expect(ws.sent[1]).toBe('u1 in lobby');
+  });
+});
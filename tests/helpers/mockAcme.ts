@@ -0,0 +1,141 @@
+import http from 'http';
+import { AddressInfo } from 'net';
+import { createHash, createPublicKey, JsonWebKey, verify } from 'crypto';
+
+// Issued for every order: self-signed, CN example.test, SANs example.test and www.example.test
+export const ISSUED_CERT = `-----BEGIN CERTIFICATE-----
+MIIBsTCCAVagAwIBAgIUXHXfQpM6KoLd+SWM4BpbYN1TYwEwCgYIKoZIzj0EAwIw
+FzEVMBMGA1UEAwwMZXhhbXBsZS50ZXN0MCAXDTI2MTAxNjA4NTgwN1oYDzIxMjYw
+OTIyMDg1ODA3WjAXMRUwEwYDVQQDDAxleGFtcGxlLnRlc3QwWTATBgcqhkjOPQIB
+BggqhkjOPQMBBwNCAATGJEqnTl6QEuC7fFBeojpQxmUhStHwrWgekq65v4BnJ+Je
+RJDHaHyoQOp5fDbeTKI2TGRYFGV6TqUxnlpBoXX6o34wfDAdBgNVHQ4EFgQUuCvl
+Le0R0lerau78wbfWXGkvxoowHwYDVR0jBBgwFoAUuCvlLe0R0lerau78wbfWXGkv
+xoowDwYDVR0TAQH/BAUwAwEB/zApBgNVHREEIjAgggxleGFtcGxlLnRlc3SCEHd3
+dy5leGFtcGxlLnRlc3QwCgYIKoZIzj0EAwIDSQAwRgIhAIpxCgtW6NbL60tdxgF1
+4JmMKto+irDtQTzNFqUfoYpVAiEAs63T+oJrKXOvhyndFJpFgHRL+JINPW9A1/L5
+eHmDM3A=
+-----END CERTIFICATE-----`;
+
+export interface MockAcmeServer {
+  directoryUrl: string;
+  // Payloads of the signed requests, by path
+  requests: Array<{ path: string; payload: any }>;
+  // CSR sent to finalize, DER
+  csr?: Buffer;
+  // Reject the next signed request with badNonce once
+  failNextNonce: boolean;
+  close(): Promise<void>;
+}
+
+const thumbprint = ({ crv, kty, x, y }: JsonWebKey) =>
+  createHash('sha256').update(JSON.stringify({ crv, kty, x, y })).digest('base64url');
+
+/**
+ * A tiny ACME server that checks JWS signatures and nonces like a real CA.
+ * fetchChallenge(token) plays the CA's HTTP-01 check and should resolve to
+ * what the client serves at /.well-known/acme-challenge/<token>.
+ */
+export async function startMockAcme(fetchChallenge: (token: string) => Promise<string | undefined>): Promise<MockAcmeServer> {
+  const nonces = new Set<string>();
+  const accounts = new Map<string, JsonWebKey>();
+  let nonceCounter = 0;
+  let base = '';
+  let orderStatus = 'pending';
+  let authorizationStatus = 'pending';
+
+  const server: MockAcmeServer = {
+    directoryUrl: '',
+    requests: [],
+    failNextNonce: false,
+    close: () => new Promise(resolve => httpServer.close(() => resolve()))
+  };
+
+  const newNonce = () => {
+    const nonce = `nonce-${++nonceCounter}`;
+    nonces.add(nonce);
+    return nonce;
+  };
+
+  const reply = (res: http.ServerResponse, status: number, body: unknown, headers: Record<string, string> = {}) => {
+    res.writeHead(status, { 'Replay-Nonce': newNonce(), 'Content-Type': 'application/json', ...headers });
+    res.end(typeof body === 'string' ? body : JSON.stringify(body));
+  };
+
+  const httpServer = http.createServer((req, res) => {
+    const path = req.url || '/';
+    if (req.method === 'GET' && path === '/directory') {
+      return reply(res, 200, { newNonce: `${base}/nonce`, newAccount: `${base}/account`, newOrder: `${base}/order` });
+    }
+    if (req.method === 'HEAD' && path === '/nonce') {
+      return reply(res, 200, '');
+    }
+
+    const chunks: Buffer[] = [];
+    req.on('data', chunk => chunks.push(chunk));
+    req.on('end', async () => {
+      const jws = JSON.parse(Buffer.concat(chunks).toString());
+      const header = JSON.parse(Buffer.from(jws.protected, 'base64url').toString());
+      const jwk: JsonWebKey | undefined = header.jwk || accounts.get(header.kid);
+
+      if (!nonces.delete(header.nonce) || server.failNextNonce) {
+        server.failNextNonce = false;
+        return reply(res, 400, { type: 'urn:ietf:params:acme:error:badNonce', detail: 'bad nonce' });
+      }
+      const valid = jwk && header.url === `${base}${path}` && verify(
+        'sha256',
+        Buffer.from(`${jws.protected}.${jws.payload}`),
+        { key: createPublicKey({ key: jwk, format: 'jwk' }), dsaEncoding: 'ieee-p1363' },
+        Buffer.from(jws.signature, 'base64url')
+      );
+      if (!valid) {
+        return reply(res, 401, { type: 'urn:ietf:params:acme:error:unauthorized', detail: 'bad signature' });
+      }
+
+      const payload = jws.payload ? JSON.parse(Buffer.from(jws.payload, 'base64url').toString()) : undefined;
+      server.requests.push({ path, payload });
+
+      switch (path) {
+        case '/account':
+          accounts.set(`${base}/acct/1`, jwk!);
+          return reply(res, 201, { status: 'valid' }, { Location: `${base}/acct/1` });
+        case '/order':
+          orderStatus = 'pending';
+          authorizationStatus = 'pending';
+          return reply(res, 201, {
+            status: orderStatus,
+            authorizations: [`${base}/authz/1`],
+            finalize: `${base}/finalize/1`
+          }, { Location: `${base}/order/1` });
+        case '/authz/1':
+          return reply(res, 200, {
+            status: authorizationStatus,
+            identifier: { type: 'dns', value: 'example.test' },
+            challenges: [
+              { type: 'dns-01', url: `${base}/chall/2`, token: 'dns-token' },
+              { type: 'http-01', url: `${base}/chall/1`, token: 'http-token' }
+            ]
+          });
+        case '/chall/1': {
+          const served = await fetchChallenge('http-token');
+          authorizationStatus = served === `http-token.${thumbprint(jwk!)}` ? 'valid' : 'invalid';
+          return reply(res, 200, { status: 'processing' });
+        }
+        case '/finalize/1':
+          server.csr = Buffer.from(payload.csr, 'base64url');
+          orderStatus = 'valid';
+          return reply(res, 200, { status: 'processing' });
+        case '/order/1':
+          return reply(res, 200, { status: orderStatus, certificate: `${base}/cert/1` });
+        case '/cert/1':
+          return reply(res, 200, ISSUED_CERT, { 'Content-Type': 'application/pem-certificate-chain' });
+        default:
+          return reply(res, 404, { type: 'urn:ietf:params:acme:error:malformed', detail: 'not found' });
+      }
+    });
+  });
+
+  await new Promise<void>(resolve => httpServer.listen(0, '127.0.0.1', () => resolve()));
+  base = `http://127.0.0.1:${(httpServer.address() as AddressInfo).port}`;
+  server.directoryUrl = `${base}/directory`;
+  return server;
+}
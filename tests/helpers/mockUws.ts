@@ -0,0 +1,367 @@
+// In-memory stand-in for uWebSockets.js so the request pipeline can be tested
+// without opening sockets. Use it with:
+//   jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+type UwsHandler = (res: any, req: any) => void;
+
+interface MockRoute {
+  method: string;
+  pattern: string;
+  handler: UwsHandler;
+}
+
+export interface MockResponse {
+  status: number;
+  statusLine: string;
+  headers: Array<[string, string]>;
+  body: string;
+  // The body as sent, for binary responses
+  bytes: Buffer;
+  closed: boolean;
+  // Ended with uWS's closeConnection flag, so the connection isn't reused
+  connectionClosed: boolean;
+  // Ended with endWithoutBody(), which sends no body and no Content-Length
+  withoutBody: boolean;
+  // For WebSocket upgrades, the user data the socket was opened with
+  upgraded?: any;
+  header(name: string): string | undefined;
+}
+
+export interface RequestOptions {
+  // An array sends the header once per value, as separate lines
+  headers?: Record<string, string | string[]>;
+  body?: string | Buffer;
+  chunks?: Array<string | Buffer>;
+  abortAfter?: number;
+  // Delay between body chunks in ms, for slow uploads
+  chunkDelay?: number;
+  // The client stops reading: streamed writes report backpressure that never drains
+  stopReading?: boolean;
+  ip?: string;
+  // Client source port; requests sharing ip and port share a connection.
+  // Defaults to a fresh port, i.e. a new connection per request
+  port?: number;
+}
+
+let nextPort = 40000;
+
+export interface MockApp {
+  routes: MockRoute[];
+  wsRoutes: Array<{ pattern: string; behavior: any }>;
+  listening: boolean;
+  addresses: Array<{ host: string; port: number }>;
+  ssl?: any;
+  [key: string]: any;
+}
+
+export const apps: MockApp[] = [];
+
+// Ports that fail to bind, for testing startup errors
+export const unavailablePorts = new Set<number>();
+
+// Listen sockets passed to us_listen_socket_close
+export const closedSockets: any[] = [];
+
+export function App(ssl?: any): MockApp {
+  const app: MockApp = { routes: [], wsRoutes: [], listening: false, addresses: [], ssl };
+
+  for (const method of ['get', 'post', 'put', 'patch', 'del', 'options', 'head', 'any']) {
+    app[method] = (pattern: string, handler: UwsHandler) => {
+      app.routes.push({ method: method === 'del' ? 'delete' : method, pattern, handler });
+      return app;
+    };
+  }
+
+  app.ws = (pattern: string, behavior: any) => {
+    app.wsRoutes.push({ pattern, behavior });
+    return app;
+  };
+
+  app.listen = (...args: any[]) => {
+    const callback = args[args.length - 1];
+    const [host, port] = args.length === 3 ? args : ['0.0.0.0', args[0]];
+    if (unavailablePorts.has(port)) {
+      callback(false);
+      return app;
+    }
+    app.listening = true;
+    app.addresses.push({ host, port });
+    callback({ host, port });
+    return app;
+  };
+
+  app.close = () => {
+    app.listening = false;
+  };
+
+  apps.push(app);
+  return app;
+}
+
+export const SSLApp = App;
+
+export function us_listen_socket_close(socket: any): void {
+  closedSockets.push(socket);
+}
+
+// The most recently created app, i.e. the one behind the Qera under test
+export function lastApp(): MockApp {
+  return apps[apps.length - 1];
+}
+
+// uWS prefers static segments over parameters over wildcards
+function specificity(pattern: string): number[] {
+  return pattern.split('/').map(segment => (segment === '*' ? 0 : segment.startsWith(':') ? 1 : 2));
+}
+
+function compareRoutes(a: MockRoute, b: MockRoute): number {
+  const x = specificity(a.pattern);
+  const y = specificity(b.pattern);
+  for (let i = 0; i < Math.max(x.length, y.length); i++) {
+    const diff = (y[i] ?? -1) - (x[i] ?? -1);
+    if (diff !== 0) return diff;
+  }
+  return 0;
+}
+
+function matchPattern(pattern: string, url: string): string[] | null {
+  const patternSegments = pattern.split('/');
+  const urlSegments = url.split('/');
+  const params: string[] = [];
+
+  for (let i = 0; i < patternSegments.length; i++) {
+    const segment = patternSegments[i];
+    if (segment === '*') return params;
+    if (i >= urlSegments.length) return null;
+    if (segment.startsWith(':')) {
+      params.push(urlSegments[i]);
+    } else if (segment !== urlSegments[i]) {
+      return null;
+    }
+  }
+
+  return patternSegments.length === urlSegments.length ? params : null;
+}
+
+/**
+ * Dispatch a request through the routes registered on a mock app and resolve
+ * with what the handler wrote once the response is ended or closed.
+ */
+export function request(
+  app: MockApp,
+  method: string,
+  fullUrl: string,
+  options: RequestOptions = {}
+): Promise<MockResponse> {
+  const [url, query = ''] = fullUrl.split('?');
+  const headers: Record<string, string[]> = {};
+  for (const [key, value] of Object.entries(options.headers || {})) {
+    headers[key.toLowerCase()] = Array.isArray(value) ? value : [value];
+  }
+  const chunks = options.chunks || [options.body === undefined ? '' : options.body];
+  const lowerMethod = method.toLowerCase();
+  const port = options.port ?? nextPort++;
+
+  return new Promise(resolve => {
+    let statusLine = '200 OK';
+    let statusWritten = false;
+    let done = false;
+    let connectionClosed = false;
+    let withoutBody = false;
+    let upgraded: any;
+    let abortHandler: (() => void) | undefined;
+    const written: Buffer[] = [];
+    const responseHeaders: Array<[string, string]> = [];
+
+    const finish = (closed: boolean) => {
+      if (done) return;
+      done = true;
+      setImmediate(() => resolve({
+        status: parseInt(statusLine, 10),
+        statusLine,
+        headers: responseHeaders,
+        body: Buffer.concat(written).toString(),
+        bytes: Buffer.concat(written),
+        closed,
+        connectionClosed,
+        withoutBody,
+        upgraded,
+        header(name: string) {
+          const values = responseHeaders.filter(([key]) => key.toLowerCase() === name.toLowerCase());
+          return values.length ? values.map(([, value]) => value).join(', ') : undefined;
+        }
+      }));
+    };
+
+    const toBuffer = (chunk: any) =>
+      typeof chunk === 'string' ? Buffer.from(chunk) : Buffer.from(chunk instanceof ArrayBuffer ? new Uint8Array(chunk) : chunk);
+
+    const res: any = {
+      writeStatus(status: string) {
+        if (!statusWritten && written.length === 0) {
+          statusLine = String(status);
+          statusWritten = true;
+        }
+        return res;
+      },
+      writeHeader(key: string, value: string) {
+        statusWritten = true;
+        responseHeaders.push([String(key), String(value)]);
+        return res;
+      },
+      write(chunk: any) {
+        written.push(toBuffer(chunk));
+        return !options.stopReading;
+      },
+      end(chunk?: any, closeConnection?: boolean) {
+        if (done) throw new Error('uWS: response already ended');
+        if (closeConnection) {
+          responseHeaders.push(['Connection', 'close']);
+          connectionClosed = true;
+        }
+        if (chunk !== undefined && chunk !== null) written.push(toBuffer(chunk));
+        finish(false);
+        return res;
+      },
+      endWithoutBody(reportedContentLength?: number, closeConnection?: boolean) {
+        if (done) throw new Error('uWS: response already ended');
+        if (closeConnection) {
+          responseHeaders.push(['Connection', 'close']);
+          connectionClosed = true;
+        }
+        withoutBody = true;
+        finish(false);
+        return res;
+      },
+      tryEnd(chunk: any) {
+        written.push(toBuffer(chunk));
+        finish(false);
+        return [true, true];
+      },
+      close() {
+        // Like uWS, closing a response that is still in progress counts as an abort
+        if (!done && abortHandler) abortHandler();
+        finish(true);
+        return res;
+      },
+      upgrade(userData: any) {
+        if (done) throw new Error('uWS: response already ended');
+        statusLine = '101 Switching Protocols';
+        upgraded = userData;
+        finish(false);
+      },
+      cork(fn: () => void) {
+        fn();
+        return res;
+      },
+      onAborted(handler: () => void) {
+        abortHandler = handler;
+        return res;
+      },
+      onData(handler: (chunk: ArrayBuffer, isLast: boolean) => void) {
+        chunks.forEach((chunk, i) => {
+          const deliver = () => {
+            // A client that went away sends nothing more
+            if (done) return;
+            const buffer = toBuffer(chunk);
+            handler(buffer.buffer.slice(buffer.byteOffset, buffer.byteOffset + buffer.length), i === chunks.length - 1);
+          };
+          if (options.chunkDelay) {
+            setTimeout(deliver, i * options.chunkDelay);
+          } else {
+            setImmediate(deliver);
+          }
+        });
+        return res;
+      },
+      onWritable() {
+        return res;
+      },
+      getWriteOffset() {
+        return written.reduce((total, chunk) => total + chunk.length, 0);
+      },
+      getRemoteAddressAsText() {
+        return Buffer.from(options.ip || '127.0.0.1');
+      },
+      getRemotePort() {
+        return port;
+      },
+      getProxiedRemoteAddressAsText() {
+        return Buffer.from('');
+      }
+    };
+
+    if (options.abortAfter !== undefined) {
+      setTimeout(() => {
+        if (done) return;
+        if (abortHandler) abortHandler();
+        finish(true);
+      }, options.abortAfter);
+    }
+
+    // Like uWS, a request is only valid until the handler it was passed to
+    // returns; reading it afterwards throws
+    const createRequest = (params: string[]) => {
+      let valid = true;
+      const live = <T extends (...args: any[]) => any>(read: T) => ((...args: Parameters<T>) => {
+        if (!valid) throw new Error('uWS.HttpRequest must not be accessed after await or route handler return');
+        return read(...args);
+      }) as T;
+      const req = {
+        getMethod: live(() => lowerMethod),
+        getCaseSensitiveMethod: live(() => method.toUpperCase()),
+        getUrl: live(() => url),
+        getQuery: live((key?: string) => (key === undefined ? query : new URLSearchParams(query).get(key) ?? undefined)),
+        // Like uWS, the first of repeated headers
+        getHeader: live((key: string) => headers[key.toLowerCase()]?.[0] || ''),
+        getParameter: live((index: number) => params[index]),
+        forEach: live((callback: (key: string, value: string) => void) => {
+          for (const [key, values] of Object.entries(headers)) values.forEach(value => callback(key, value));
+        }),
+        yielded: false,
+        setYield: (value: boolean) => {
+          req.yielded = value;
+          return req;
+        },
+        invalidate: () => {
+          valid = false;
+        }
+      };
+      return req;
+    };
+
+    // Upgrade requests go to WebSocket routes, which upgrade by default
+    if (lowerMethod === 'get' && headers.upgrade?.[0].toLowerCase() === 'websocket') {
+      for (const { pattern, behavior } of app.wsRoutes) {
+        const params = matchPattern(pattern, url);
+        if (!params) continue;
+
+        if (behavior.upgrade) {
+          const req = createRequest(params);
+          behavior.upgrade(res, req, {});
+          req.invalidate();
+        } else {
+          res.upgrade({});
+        }
+        return;
+      }
+    }
+
+    const candidates = app.routes
+      .filter(route => route.method === lowerMethod || route.method === 'any')
+      .sort(compareRoutes);
+
+    for (const route of candidates) {
+      const params = matchPattern(route.pattern, url);
+      if (!params) continue;
+
+      const req = createRequest(params);
+      route.handler(res, req);
+      req.invalidate();
+      if (!req.yielded) return;
+    }
+
+    statusLine = '404 File Not Found';
+    finish(false);
+  });
+}
@@ -0,0 +1,110 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { chain } from '../../src/core/compose';
+import { apiKey } from '../../src/middlewares/apiKey';
+import { QeraContext } from '../../src/types';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('apiKey middleware', () => {
+  let server: MockApp;
+  const validated: string[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    const whoami = (ctx: QeraContext) => {
+      ctx.json({ user: ctx.user });
+    };
+
+    app.get('/default', chain(apiKey({ keys: ['k1', 'k2'] })).handle(whoami));
+    app.get('/mapped', chain(apiKey({ keys: { secret: { client: 'billing' } } })).handle(whoami));
+    const ordered = apiKey({ lookup: ['header:X-Token', 'query:api_key', 'bearer'], keys: ['a', 'b', 'c'] });
+    app.get('/lookup', chain(ordered).handle(whoami));
+    const validate = apiKey({
+      keys: ['static'],
+      validate: async (key) => {
+        validated.push(key);
+        if (key === 'broken') throw new Error('store down');
+        return key === 'dynamic' ? { id: 7 } : null;
+      }
+    });
+    app.get('/validated', chain(validate).handle(whoami));
+
+    app.listen(3493, 'localhost');
+    server = lastApp();
+  });
+
+  it('should read the key from X-API-Key by default', async () => {
+    const res = await request(server, 'GET', '/default', { headers: { 'x-api-key': 'k2' } });
+
+    expect(res.status).toBe(200);
+    expect(JSON.parse(res.body)).toEqual({ user: 'k2' });
+  });
+
+  it('should answer 401 without a key', async () => {
+    const res = await request(server, 'GET', '/default');
+
+    expect(res.status).toBe(401);
+    expect(JSON.parse(res.body)).toEqual({ error: 'API key required' });
+    expect(res.header('www-authenticate')).toBeUndefined();
+  });
+
+  it('should answer 401 for an unknown key', async () => {
+    const res = await request(server, 'GET', '/default', { headers: { 'x-api-key': 'k3' } });
+
+    expect(res.status).toBe(401);
+    expect(JSON.parse(res.body)).toEqual({ error: 'Invalid API key' });
+  });
+
+  it('should store the identity a static key maps to', async () => {
+    const res = await request(server, 'GET', '/mapped', { headers: { 'x-api-key': 'secret' } });
+
+    expect(JSON.parse(res.body)).toEqual({ user: { client: 'billing' } });
+  });
+
+  it('should try lookup locations in order', async () => {
+    const both = await request(server, 'GET', '/lookup?api_key=b', { headers: { 'x-token': 'a', authorization: 'Bearer c' } });
+    const queryAndBearer = await request(server, 'GET', '/lookup?api_key=b', { headers: { authorization: 'Bearer c' } });
+    const bearer = await request(server, 'GET', '/lookup', { headers: { authorization: 'bearer c' } });
+
+    expect(JSON.parse(both.body)).toEqual({ user: 'a' });
+    expect(JSON.parse(queryAndBearer.body)).toEqual({ user: 'b' });
+    expect(JSON.parse(bearer.body)).toEqual({ user: 'c' });
+  });
+
+  it('should not fall back to later locations when the first key is wrong', async () => {
+    const res = await request(server, 'GET', '/lookup?api_key=b', { headers: { 'x-token': 'wrong' } });
+
+    expect(res.status).toBe(401);
+  });
+
+  it('should challenge with Bearer when bearer tokens are accepted', async () => {
+    const res = await request(server, 'GET', '/lookup', { headers: { authorization: 'Basic YTpi' } });
+
+    expect(res.status).toBe(401);
+    expect(res.header('www-authenticate')).toBe('Bearer');
+  });
+
+  it('should only ask the validator about keys that are not static', async () => {
+    validated.length = 0;
+    const staticKey = await request(server, 'GET', '/validated', { headers: { 'x-api-key': 'static' } });
+    const dynamic = await request(server, 'GET', '/validated', { headers: { 'x-api-key': 'dynamic' } });
+    const unknown = await request(server, 'GET', '/validated', { headers: { 'x-api-key': 'nope' } });
+
+    expect(JSON.parse(staticKey.body)).toEqual({ user: 'static' });
+    expect(JSON.parse(dynamic.body)).toEqual({ user: { id: 7 } });
+    expect(unknown.status).toBe(401);
+    expect(validated).toEqual(['dynamic', 'nope']);
+  });
+
+  it('should pass validator errors on to error handling', async () => {
+    const res = await request(server, 'GET', '/validated', { headers: { 'x-api-key': 'broken' } });
+
+    expect(res.status).toBe(500);
+  });
+
+  it('should reject invalid options', () => {
+    expect(() => apiKey({})).toThrow('needs keys or a validate function');
+    expect(() => apiKey({ keys: ['a'], lookup: ['cookie:key' as any] })).toThrow('Invalid API key lookup');
+  });
+});
@@ -0,0 +1,138 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { circuitBreaker, CircuitState } from '../../src/middlewares/circuitBreaker';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+const sleep = (ms: number) => new Promise(resolve => setTimeout(resolve, ms));
+
+describe('circuitBreaker middleware', () => {
+  let server: MockApp;
+  let upstreamHealthy: boolean;
+  let upstreamCalls: number;
+  let transitions: Array<[CircuitState, CircuitState]>;
+
+  beforeEach(() => {
+    upstreamHealthy = false;
+    upstreamCalls = 0;
+    transitions = [];
+
+    const app = new Qera({ logging: { level: 'error' } });
+    const breaker = circuitBreaker({
+      failureRatio: 0.5,
+      minRequests: 4,
+      openMs: 40,
+      onStateChange: (state, previous) => transitions.push([state, previous])
+    });
+
+    app.get('/upstream', async (ctx) => {
+      await breaker(ctx, async () => {
+        upstreamCalls++;
+        if (upstreamHealthy) {
+          ctx.json({ ok: true });
+        } else {
+          ctx.status(502).json({ error: 'Bad Gateway' });
+        }
+      });
+    });
+    app.get('/throws', async (ctx) => {
+      await breaker(ctx, async () => {
+        upstreamCalls++;
+        throw new Error('connection refused');
+      });
+    });
+    app.get('/client-error', async (ctx) => {
+      await breaker(ctx, async () => {
+        upstreamCalls++;
+        throw Object.assign(new Error('bad input'), { statusCode: 400 });
+      });
+    });
+
+    app.listen(3473, 'localhost');
+    server = lastApp();
+  });
+
+  const hit = (path = '/upstream') => request(server, 'GET', path);
+
+  async function trip() {
+    for (let i = 0; i < 4; i++) {
+      await hit();
+    }
+  }
+
+  it('should pass requests through while closed', async () => {
+    upstreamHealthy = true;
+
+    const res = await hit();
+
+    expect(res.status).toBe(200);
+    expect(transitions).toEqual([]);
+  });
+
+  it('should open after the failure ratio and fail fast', async () => {
+    await trip();
+    const res = await hit();
+
+    expect(transitions).toEqual([['open', 'closed']]);
+    expect(res.status).toBe(503);
+    expect(res.header('Retry-After')).toBe('1');
+    expect(upstreamCalls).toBe(4);
+  });
+
+  it('should not open before enough requests were seen', async () => {
+    await hit();
+    await hit();
+    await hit();
+
+    expect(transitions).toEqual([]);
+  });
+
+  it('should count thrown errors but not client errors', async () => {
+    for (let i = 0; i < 4; i++) {
+      await hit('/client-error');
+    }
+    expect(transitions).toEqual([]);
+
+    for (let i = 0; i < 4; i++) {
+      await hit('/throws');
+    }
+    expect(transitions).toEqual([['open', 'closed']]);
+  });
+
+  it('should close again after a successful trial request', async () => {
+    await trip();
+    await sleep(50);
+    upstreamHealthy = true;
+
+    const trial = await hit();
+    const after = await hit();
+
+    expect(trial.status).toBe(200);
+    expect(after.status).toBe(200);
+    expect(transitions).toEqual([['open', 'closed'], ['half-open', 'open'], ['closed', 'half-open']]);
+  });
+
+  it('should re-open when the trial request fails', async () => {
+    await trip();
+    await sleep(50);
+
+    const trial = await hit();
+    const rejected = await hit();
+
+    expect(trial.status).toBe(502);
+    expect(rejected.status).toBe(503);
+    expect(transitions).toEqual([['open', 'closed'], ['half-open', 'open'], ['open', 'half-open']]);
+  });
+
+  it('should only let the allowed number of trials through while half-open', async () => {
+    await trip();
+    await sleep(50);
+    upstreamHealthy = true;
+    const calls = upstreamCalls;
+
+    const responses = await Promise.all([hit(), hit(), hit()]);
+
+    expect(responses.map(res => res.status).sort()).toEqual([200, 503, 503]);
+    expect(upstreamCalls - calls).toBe(1);
+  });
+});
@@ -0,0 +1,58 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { connLimit } from '../../src/middlewares/connLimit';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('connLimit middleware', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, trustProxy: ['10.0.0.1'] });
+    app.use(connLimit(2));
+
+    app.get('/slow', async (ctx) => {
+      await new Promise(resolve => setTimeout(resolve, 30));
+      ctx.send('ok');
+    });
+    app.get('/fail', async () => {
+      throw new Error('boom');
+    });
+
+    app.listen(3459, 'localhost');
+    server = lastApp();
+  });
+
+  it('should reject requests beyond the per-IP limit with 429', async () => {
+    const responses = await Promise.all([1, 2, 3].map(() => request(server, 'GET', '/slow', { ip: '1.1.1.1' })));
+
+    expect(responses.map(res => res.status).sort()).toEqual([200, 200, 429]);
+  });
+
+  it('should count each client separately', async () => {
+    const responses = await Promise.all([
+      request(server, 'GET', '/slow', { ip: '1.1.1.1' }),
+      request(server, 'GET', '/slow', { ip: '1.1.1.1' }),
+      request(server, 'GET', '/slow', { ip: '2.2.2.2' })
+    ]);
+
+    expect(responses.map(res => res.status)).toEqual([200, 200, 200]);
+  });
+
+  it('should identify clients behind a trusted proxy by X-Forwarded-For', async () => {
+    const viaProxy = (client: string) =>
+      request(server, 'GET', '/slow', { ip: '10.0.0.1', headers: { 'x-forwarded-for': client } });
+
+    const responses = await Promise.all([viaProxy('3.3.3.3'), viaProxy('3.3.3.3'), viaProxy('4.4.4.4')]);
+
+    expect(responses.map(res => res.status)).toEqual([200, 200, 200]);
+  });
+
+  it('should release slots when the handler throws', async () => {
+    await request(server, 'GET', '/fail', { ip: '5.5.5.5' });
+    await request(server, 'GET', '/fail', { ip: '5.5.5.5' });
+
+    const res = await request(server, 'GET', '/slow', { ip: '5.5.5.5' });
+    expect(res.status).toBe(200);
+  });
+});
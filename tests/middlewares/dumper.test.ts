@@ -0,0 +1,82 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { dumper } from '../../src/middlewares/dumper';
+import { Logger } from '../../src/utils/logger';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('dumper middleware', () => {
+  let server: MockApp;
+  let dumps: string[];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(dumper(dump => dumps.push(dump), { maxBodyLength: 20 }));
+
+    app.post('/users', (ctx) => ctx.json({ ok: true }));
+    app.get('/crash', () => {
+      throw new Error('boom');
+    });
+    app.get('/dump', (ctx) => ctx.send(ctx.dump({ redact: ['x-secret'] })));
+
+    app.listen(3475, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    dumps = [];
+  });
+
+  it('should dump matched requests with credentials redacted', async () => {
+    await request(server, 'POST', '/users', {
+      headers: {
+        'content-type': 'application/json',
+        authorization: 'Bearer secret-token',
+        cookie: 'sid=abc'
+      },
+      body: JSON.stringify({ name: 'Ada Lovelace', email: 'ada@example.com' })
+    });
+
+    expect(dumps).toEqual([[
+      'POST /users',
+      'authorization: [redacted]',
+      'content-type: application/json',
+      'cookie: [redacted]',
+      '',
+      '{"name":"Ada Lovelac... (29 more characters)'
+    ].join('\n')]);
+  });
+
+  it('should dump before the handler runs', async () => {
+    const error = jest.spyOn(Logger, 'error').mockImplementation(() => undefined);
+
+    const res = await request(server, 'GET', '/crash?id=7');
+
+    expect(res.status).toBe(500);
+    expect(dumps).toEqual(['GET /crash?id=7']);
+    error.mockRestore();
+  });
+
+  it('should skip unmatched requests', async () => {
+    await request(server, 'GET', '/missing');
+
+    expect(dumps).toEqual([]);
+  });
+
+  it('should let ctx.dump() choose which headers to redact', async () => {
+    const res = await request(server, 'GET', '/dump', {
+      headers: { authorization: 'Basic YTpi', 'x-secret': 'hunter2' }
+    });
+
+    expect(res.body).toBe('GET /dump\nauthorization: Basic YTpi\nx-secret: [redacted]');
+  });
+
+  it('should report bodies that failed to parse', async () => {
+    await request(server, 'POST', '/users', {
+      headers: { 'content-type': 'application/json' },
+      body: '{"name":'
+    });
+
+    expect(dumps[0]).toContain('\n\n<unreadable body: Malformed request body:');
+  });
+});
@@ -0,0 +1,108 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { Qera } from '../../src/core/app';
+import { favicon } from '../../src/middlewares/favicon';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('favicon middleware', () => {
+  const icon = Buffer.from([0, 0, 1, 0, 1, 0]);
+  let routeHits = 0;
+
+  function serve(middleware: ReturnType<typeof favicon>): MockApp {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(middleware);
+    app.get('/:page', (ctx) => {
+      routeHits++;
+      ctx.send('page');
+    });
+    app.post('/:page', (ctx) => {
+      routeHits++;
+      ctx.json({ saved: ctx.body });
+    });
+    app.listen(3463, 'localhost');
+    return lastApp();
+  }
+
+  beforeEach(() => {
+    routeHits = 0;
+  });
+
+  it('should serve the icon with long cache headers without reaching routes', async () => {
+    const server = serve(favicon(icon));
+    const res = await request(server, 'GET', '/favicon.ico');
+
+    expect(res.status).toBe(200);
+    expect(res.header('Content-Type')).toBe('image/x-icon');
+    expect(res.header('Cache-Control')).toBe('public, max-age=31536000');
+    expect(Buffer.from(res.body, 'binary').length).toBe(icon.length);
+    expect(routeHits).toBe(0);
+  });
+
+  it('should serve the icon even when no route matches', async () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(favicon(icon));
+    app.listen(3464, 'localhost');
+
+    const res = await request(lastApp(), 'GET', '/favicon.ico');
+    expect(res.status).toBe(200);
+  });
+
+  it('should read the icon from disk once at startup', async () => {
+    const file = path.join(fs.mkdtempSync(path.join(os.tmpdir(), 'qera-favicon-')), 'favicon.png');
+    fs.writeFileSync(file, icon);
+    const server = serve(favicon(file, { maxAge: 60 }));
+    fs.unlinkSync(file);
+
+    const res = await request(server, 'GET', '/favicon.ico');
+    expect(res.status).toBe(200);
+    expect(res.header('Content-Type')).toBe('image/png');
+    expect(res.header('Cache-Control')).toBe('public, max-age=60');
+  });
+
+  it('should fail at startup for a missing file', () => {
+    expect(() => favicon('/no/such/favicon.ico')).toThrow();
+  });
+
+  it('should revalidate with the ETag', async () => {
+    const server = serve(favicon(icon));
+    const first = await request(server, 'GET', '/favicon.ico');
+    const second = await request(server, 'GET', '/favicon.ico', {
+      headers: { 'if-none-match': first.header('ETag')! }
+    });
+
+    expect(second.status).toBe(304);
+    expect(second.body).toBe('');
+  });
+
+  it('should answer 404 without an icon', async () => {
+    const server = serve(favicon());
+    const res = await request(server, 'GET', '/favicon.ico');
+
+    expect(res.status).toBe(404);
+    expect(routeHits).toBe(0);
+  });
+
+  it('should leave other paths alone', async () => {
+    const server = serve(favicon(icon));
+    const res = await request(server, 'GET', '/about');
+
+    expect(res.body).toBe('page');
+    expect(routeHits).toBe(1);
+  });
+
+  it('should pass requests with a body through, and refuse them for the icon', async () => {
+    const server = serve(favicon(icon));
+    const body = { headers: { 'content-type': 'application/json' }, body: '{"title":"draft"}' };
+    const saved = await request(server, 'POST', '/notes', body);
+    const refused = await request(server, 'POST', '/favicon.ico', body);
+
+    expect(saved.status).toBe(200);
+    expect(JSON.parse(saved.body)).toEqual({ saved: { title: 'draft' } });
+    expect(refused.status).toBe(405);
+    expect(refused.header('Allow')).toBe('GET, HEAD');
+    expect(routeHits).toBe(1);
+  });
+});
@@ -1,5 +1,7 @@
 import { jwtAuth, errorHandler, HttpError } from '../../src/middlewares';
 import { QeraContext } from '../../src/types';
+import { chain } from '../../src/core/compose';
+import { isFrameworkFrame } from '../../src/utils/stack';
 
 // Helper function to create a mock QeraContext
 function createMockContext(overrides: Partial<QeraContext> = {}): QeraContext {
@@ -141,9 +143,9 @@ describe('Middlewares', () => {
       // Mock context and next function
       const ctx = {
         req: {
-          getUrl: () => '/test',
           log: { error: jest.fn() }
         } as any,
+        path: () => '/test',
         res: {} as any,
         headers: {},
         params: {},
@@ -179,11 +181,11 @@ describe('Middlewares', () => {
       // Mock context and next function
       const ctx = {
         req: {
-          getUrl: () => '/test',
           log: {
             error: jest.fn()
           }
         } as any,
+        path: () => '/test',
         res: {} as any,
         headers: {},
         params: {},
@@ -225,11 +227,11 @@ describe('Middlewares', () => {
       // Mock context and next function
       const ctx = {
         req: {
-          getUrl: () => '/test',
           log: {
             error: jest.fn()
           }
         } as any,
+        path: () => '/test',
         res: {} as any,
         headers: {},
         params: {},
@@ -276,11 +278,11 @@ describe('Middlewares', () => {
       const jsonMock = jest.fn();
       const ctx = {
         req: {
-          getUrl: () => '/test',
           log: {
             error: jest.fn()
           }
         } as any,
+        path: () => '/test',
         res: {} as any,
         headers: {},
         params: {},
@@ -319,6 +321,54 @@ describe('Middlewares', () => {
       // Restore NODE_ENV
       process.env.NODE_ENV = originalNodeEnv;
     });
+
+    it('should pass stacks through the stack formatter', async () => {
+      const originalNodeEnv = process.env.NODE_ENV;
+      process.env.NODE_ENV = 'development';
+
+      const jsonMock = jest.fn();
+      const logError = jest.fn();
+      const ctx = createMockContext({
+        req: { log: { error: logError } } as any,
+        path: () => '/test',
+        json: jsonMock
+      });
+      const error = new Error('Formatted error');
+      const next = jest.fn().mockImplementation(() => {
+        throw error;
+      });
+      const stackFormatter = jest.fn((stack: string) => JSON.stringify(stack.split('\n').slice(0, 2)));
+
+      await errorHandler({ log: true, includeErrorDetails: true, stackFormatter })(ctx, next);
+
+      const expected = JSON.stringify(error.stack!.split('\n').slice(0, 2));
+      expect(stackFormatter).toHaveBeenCalledWith(error.stack, error);
+      expect(jsonMock.mock.calls[0][0].stack).toBe(expected);
+      expect(logError.mock.calls[0][1].stack).toBe(expected);
+
+      process.env.NODE_ENV = originalNodeEnv;
+    });
+
+    it('should start default stacks at the code that threw', async () => {
+      const originalNodeEnv = process.env.NODE_ENV;
+      process.env.NODE_ENV = 'development';
+
+      const jsonMock = jest.fn();
+      const ctx = createMockContext({ path: () => '/test', json: jsonMock });
+      const failingHandler = () => {
+        throw new Error('Trimmed error');
+      };
+      // Route through the chain so there are framework frames to trim
+      const next = () => chain(async (_ctx, next) => next())(ctx, async () => failingHandler());
+
+      await errorHandler({ includeErrorDetails: true })(ctx, next);
+
+      const stack: string = jsonMock.mock.calls[0][0].stack;
+      expect(stack.split('\n')[1]).toContain('failingHandler');
+      expect(stack.split('\n').some(isFrameworkFrame)).toBe(false);
+
+      process.env.NODE_ENV = originalNodeEnv;
+    });
   });
 
   describe('HttpError', () => {
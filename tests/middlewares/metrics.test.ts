@@ -0,0 +1,73 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { metrics } from '../../src/middlewares/metrics';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('metrics middleware', () => {
+  let server: MockApp;
+  const traffic = metrics();
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(traffic);
+
+    app.post('/upload', (ctx) => ctx.json({ received: ctx.bytesRead }));
+    app.get('/items/:id', (ctx) => ctx.send('é'.repeat(ctx.paramInt('id')!)));
+    app.get('/export', async (ctx) => {
+      await ctx.write('id,name\n');
+      await ctx.write('1,Ada\n');
+      ctx.end('2,Grace\n');
+    });
+    app.get('/feed', (ctx) => ctx.streamJSONArray([{ id: 1 }, { id: 2 }]));
+    app.get('/metrics', traffic.handler);
+
+    app.listen(3474, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => traffic.reset());
+
+  it('should count request and response body bytes per route', async () => {
+    await request(server, 'POST', '/upload', { headers: { 'content-type': 'text/plain' }, chunks: ['abc', 'defgh'] });
+    await request(server, 'GET', '/items/3');
+    await request(server, 'GET', '/items/4');
+
+    expect(traffic.snapshot()).toEqual({
+      'POST /upload': { requests: 1, requestBytes: 8, responseBytes: '{"received":8}'.length },
+      // "é" is two bytes in UTF-8
+      'GET /items/:id': { requests: 2, requestBytes: 0, responseBytes: 14 }
+    });
+  });
+
+  it('should count streamed responses and unmatched requests', async () => {
+    await request(server, 'GET', '/export');
+    await request(server, 'GET', '/feed');
+    const missing = await request(server, 'GET', '/nowhere');
+
+    expect(traffic.snapshot()).toEqual({
+      'GET /export': { requests: 1, requestBytes: 0, responseBytes: 'id,name\n1,Ada\n2,Grace\n'.length },
+      'GET /feed': { requests: 1, requestBytes: 0, responseBytes: '[{"id":1},{"id":2}]'.length },
+      unmatched: { requests: 1, requestBytes: 0, responseBytes: missing.body.length }
+    });
+  });
+
+  it('should return copies from snapshot()', async () => {
+    await request(server, 'GET', '/items/1');
+    const before = traffic.snapshot();
+    await request(server, 'GET', '/items/1');
+
+    expect(before['GET /items/:id'].requests).toBe(1);
+    expect(traffic.snapshot()['GET /items/:id'].requests).toBe(2);
+  });
+
+  it('should serve the counters in the Prometheus text format', async () => {
+    await request(server, 'GET', '/items/2');
+    const res = await request(server, 'GET', '/metrics');
+
+    expect(res.header('Content-Type')).toBe('text/plain; version=0.0.4');
+    expect(res.body).toContain('# TYPE qera_http_response_bytes_total counter\n');
+    expect(res.body).toContain('qera_http_requests_total{method="GET",route="/items/:id"} 1\n');
+    expect(res.body).toContain('qera_http_response_bytes_total{method="GET",route="/items/:id"} 4\n');
+  });
+});
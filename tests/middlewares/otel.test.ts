@@ -0,0 +1,162 @@
+jest.mock('@opentelemetry/api', () => ({
+  context: {
+    active: () => ({ root: true }),
+    with: (context: any, fn: () => any) => fn()
+  },
+  trace: {
+    setSpanContext: (context: any, spanContext: any) => ({ ...context, remote: spanContext }),
+    setSpan: (context: any, span: any) => ({ ...context, span })
+  }
+}), { virtual: true });
+
+import { otel, parseTraceparent } from '../../src/middlewares/otel';
+import { QeraContext } from '../../src/types';
+
+function createFakeTracer() {
+  const spans: any[] = [];
+  const provider = {
+    getTracer: jest.fn(() => ({
+      startSpan: jest.fn((name: string, options: any, parent: any) => {
+        const span = {
+          name,
+          options,
+          parent,
+          attributes: {} as Record<string, any>,
+          status: undefined as any,
+          exceptions: [] as any[],
+          ended: false,
+          setAttribute(key: string, value: any) { span.attributes[key] = value; return span; },
+          setStatus(status: any) { span.status = status; return span; },
+          recordException(error: any) { span.exceptions.push(error); },
+          end() { span.ended = true; }
+        };
+        spans.push(span);
+        return span;
+      })
+    }))
+  };
+  return { provider, spans };
+}
+
+// A context whose finish() runs the onFinish() callbacks, as Qera does once
+// the request is done
+function createContext(overrides: Partial<QeraContext> = {}): QeraContext & { finish(): void } {
+  let statusCode = 200;
+  const finishCallbacks: Array<() => void> = [];
+  const ctx: any = {
+    method: 'GET',
+    path: () => '/users/42',
+    headers: {},
+    state: {},
+    route: { method: 'GET', path: '/users/:id', methods: ['GET'] },
+    get statusCode() { return statusCode; },
+    status: jest.fn((code: number) => { statusCode = code; return ctx; }),
+    onFinish: (callback: () => void) => finishCallbacks.push(callback),
+    finish: () => finishCallbacks.forEach(callback => callback()),
+    ...overrides
+  };
+  return ctx;
+}
+
+describe('OpenTelemetry Middleware', () => {
+  describe('parseTraceparent', () => {
+    it('should parse a valid header', () => {
+      expect(parseTraceparent('00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01')).toEqual({
+        traceId: '4bf92f3577b34da6a3ce929d0e0e4736',
+        spanId: '00f067aa0ba902b7',
+        traceFlags: 1
+      });
+    });
+
+    it('should reject malformed and all-zero values', () => {
+      expect(parseTraceparent(undefined)).toBeNull();
+      expect(parseTraceparent('garbage')).toBeNull();
+      expect(parseTraceparent('00-00000000000000000000000000000000-00f067aa0ba902b7-01')).toBeNull();
+      expect(parseTraceparent('ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01')).toBeNull();
+    });
+  });
+
+  it('should be a no-op without a tracer provider', async () => {
+    const ctx = createContext();
+    const next = jest.fn().mockResolvedValue(undefined);
+
+    await otel()(ctx, next);
+
+    expect(next).toHaveBeenCalled();
+    expect(ctx.state.span).toBeUndefined();
+  });
+
+  it('should name the span after the route pattern and record the status', async () => {
+    const { provider, spans } = createFakeTracer();
+    const ctx = createContext();
+
+    await otel({ tracerProvider: provider })(ctx, async () => {
+      ctx.status(201);
+    });
+    expect(spans[0].ended).toBe(false);
+    ctx.finish();
+
+    expect(spans).toHaveLength(1);
+    expect(spans[0].name).toBe('GET /users/:id');
+    expect(spans[0].options.attributes['http.route']).toBe('/users/:id');
+    expect(spans[0].attributes['http.response.status_code']).toBe(201);
+    expect(spans[0].status).toBeUndefined();
+    expect(spans[0].ended).toBe(true);
+    expect(ctx.state.span).toBe(spans[0]);
+  });
+
+  it('should continue the trace from an incoming traceparent header', async () => {
+    const { provider, spans } = createFakeTracer();
+    const ctx = createContext({
+      headers: { traceparent: '00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01' }
+    });
+
+    await otel({ tracerProvider: provider })(ctx, async () => {});
+
+    expect(spans[0].parent.remote).toEqual({
+      traceId: '4bf92f3577b34da6a3ce929d0e0e4736',
+      spanId: '00f067aa0ba902b7',
+      traceFlags: 1,
+      isRemote: true
+    });
+  });
+
+  it('should record thrown errors and rethrow them', async () => {
+    const { provider, spans } = createFakeTracer();
+    const ctx = createContext();
+    const error = new Error('database down');
+
+    await expect(otel({ tracerProvider: provider })(ctx, async () => {
+      throw error;
+    })).rejects.toThrow('database down');
+    expect(spans[0].ended).toBe(false);
+
+    // The framework answers the error after the chain has unwound
+    ctx.status(500);
+    ctx.finish();
+
+    expect(spans[0].exceptions).toEqual([error]);
+    expect(spans[0].status).toEqual({ code: 2, message: 'database down' });
+    expect(spans[0].attributes['http.response.status_code']).toBe(500);
+    expect(spans[0].ended).toBe(true);
+  });
+
+  it('should record the status a thrown error is answered with', async () => {
+    const { provider, spans } = createFakeTracer();
+    const ctx = createContext();
+    const error = new Error('invalid body');
+
+    await expect(otel({ tracerProvider: provider })(ctx, async () => {
+      throw error;
+    })).rejects.toThrow('invalid body');
+    // e.g. by an errorHandler() further out, after an await
+    await new Promise(resolve => setTimeout(resolve, 5));
+    ctx.status(422);
+    ctx.finish();
+
+    expect(spans[0].exceptions).toEqual([error]);
+    expect(spans[0].status).toBeUndefined();
+    expect(spans[0].attributes['http.response.status_code']).toBe(422);
+    expect(spans[0].ended).toBe(true);
+  });
+});
@@ -0,0 +1,60 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { requireHeaders } from '../../src/middlewares/requireHeaders';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('requireHeaders middleware', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    const tenants = app.group('/tenants', requireHeaders('X-Tenant-ID', {
+      'X-API-Version': /[12]/,
+      'X-Request-ID': (value) => value.length === 8
+    }));
+    tenants.get('/me', (ctx) => ctx.json({ tenant: ctx.headers['x-tenant-id'] }));
+    app.get('/open', (ctx) => ctx.send('ok'));
+
+    app.listen(3497, 'localhost');
+    server = lastApp();
+  });
+
+  const valid = { 'x-tenant-id': 'acme', 'x-api-version': '2', 'x-request-id': 'abcd1234' };
+  const get = async (headers: Record<string, string>) => {
+    const response = await request(server, 'GET', '/tenants/me', { headers });
+    return { status: response.status, body: JSON.parse(response.body) };
+  };
+
+  it('should pass requests carrying every required header', async () => {
+    expect(await get(valid)).toEqual({ status: 200, body: { tenant: 'acme' } });
+  });
+
+  it('should answer 400 naming the missing header', async () => {
+    const { 'x-tenant-id': _, ...withoutTenant } = valid;
+
+    expect(await get(withoutTenant)).toEqual({ status: 400, body: { error: 'Missing required header X-Tenant-ID' } });
+    expect(await get({ ...valid, 'x-api-version': '' })).toEqual({
+      status: 400,
+      body: { error: 'Missing required header X-API-Version' }
+    });
+  });
+
+  it('should answer 400 for values that fail their check', async () => {
+    expect(await get({ ...valid, 'x-api-version': '12' })).toEqual({
+      status: 400,
+      body: { error: 'Invalid value for header X-API-Version' }
+    });
+    expect((await get({ ...valid, 'x-request-id': 'short' })).body.error).toBe('Invalid value for header X-Request-ID');
+  });
+
+  it('should leave routes outside the group alone', async () => {
+    const response = await request(server, 'GET', '/open');
+
+    expect(response.status).toBe(200);
+  });
+
+  it('should need at least one header', () => {
+    expect(() => requireHeaders()).toThrow('needs at least one header name');
+  });
+});
@@ -0,0 +1,87 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { requireJSON } from '../../src/middlewares/requireJSON';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('requireJSON middleware', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    const api = app.group('/api', requireJSON({ exclude: ['/api/uploads', /^\/api\/hooks\//] }));
+    api.post('/orders', (ctx) => ctx.status(201).json({ order: ctx.body }));
+    api.patch('/orders/:id', (ctx) => ctx.json({ patch: ctx.body }));
+    api.get('/orders', (ctx) => ctx.json([]));
+    api.post('/uploads/avatar', (ctx) => ctx.send('stored'));
+    api.post('/uploads-old', (ctx) => ctx.send('stored'));
+    api.post('/hooks/github', (ctx) => ctx.send('received'));
+
+    const strict = app.group('/strict', requireJSON({ methods: ['delete'] }));
+    strict.delete('/items', (ctx) => ctx.sendStatus(204));
+    strict.post('/items', (ctx) => ctx.send('created'));
+
+    const lenient = app.group('/lenient', requireJSON({ allowEmpty: true }));
+    lenient.post('/logout', (ctx) => ctx.sendStatus(204));
+
+    app.listen(3517, 'localhost');
+    server = lastApp();
+  });
+
+  const send = async (method: string, url: string, body: string, contentType?: string) => {
+    const headers: Record<string, string> = contentType ? { 'content-type': contentType } : {};
+    const response = await request(server, method, url, { headers, body });
+    return { status: response.status, body: response.body };
+  };
+
+  it('should pass JSON bodies, with parameters or a +json type', async () => {
+    expect(await send('POST', '/api/orders', '{"sku":"A-1"}', 'application/json')).toEqual({
+      status: 201,
+      body: '{"order":{"sku":"A-1"}}'
+    });
+    expect((await send('POST', '/api/orders', '{}', 'Application/JSON; charset=utf-8')).status).toBe(201);
+    expect((await send('PATCH', '/api/orders/7', '{"qty":2}', 'application/merge-patch+json')).status).toBe(200);
+  });
+
+  it('should answer 415 without a Content-Type', async () => {
+    expect(await send('POST', '/api/orders', '{"sku":"A-1"}')).toEqual({
+      status: 415,
+      body: '{"error":"Content-Type must be application/json"}'
+    });
+  });
+
+  it('should answer 415 for other types', async () => {
+    expect((await send('POST', '/api/orders', 'sku=A-1', 'application/x-www-form-urlencoded')).status).toBe(415);
+    expect((await send('PATCH', '/api/orders/7', '{"qty":2}', 'text/plain')).status).toBe(415);
+    expect((await send('POST', '/api/orders', '{}', 'application/jsonp')).status).toBe(415);
+  });
+
+  it('should answer 400 for an empty body', async () => {
+    expect(await send('POST', '/api/orders', '', 'application/json')).toEqual({
+      status: 400,
+      body: '{"error":"Request body must not be empty"}'
+    });
+  });
+
+  it('should leave other methods and excluded paths alone', async () => {
+    expect((await send('GET', '/api/orders', '')).status).toBe(200);
+    expect(await send('POST', '/api/uploads/avatar', 'binary', 'image/png')).toEqual({ status: 200, body: 'stored' });
+    expect(await send('POST', '/api/hooks/github', 'payload', 'text/plain')).toEqual({ status: 200, body: 'received' });
+    expect((await send('POST', '/api/uploads-old', 'binary', 'image/png')).status).toBe(415);
+  });
+
+  it('should check only the configured methods', async () => {
+    expect((await send('DELETE', '/strict/items', '')).status).toBe(415);
+    expect((await send('DELETE', '/strict/items', '', 'application/json')).status).toBe(400);
+    expect((await send('POST', '/strict/items', 'x', 'text/plain')).status).toBe(200);
+  });
+
+  it('should let empty bodies through when allowed', async () => {
+    expect((await send('POST', '/lenient/logout', '')).status).toBe(204);
+    expect((await send('POST', '/lenient/logout', 'bye', 'text/plain')).status).toBe(415);
+  });
+
+  it('should refuse an empty list of methods', () => {
+    expect(() => requireJSON({ methods: [] })).toThrow('requireJSON() needs at least one method');
+  });
+});
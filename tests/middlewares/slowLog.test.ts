@@ -0,0 +1,138 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { slowLog, SlowRequest } from '../../src/middlewares/slowLog';
+import { Logger } from '../../src/utils/logger';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+class LockedError extends Error {}
+
+const sleep = (ms: number) => new Promise(resolve => setTimeout(resolve, ms));
+
+describe('slowLog middleware', () => {
+  let server: MockApp;
+  const lines: string[] = [];
+  const entries: SlowRequest[] = [];
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(slowLog(40, (line, entry) => {
+      lines.push(line);
+      entries.push(entry);
+    }));
+    app.mapError(LockedError, 423, 'Locked');
+
+    app.get('/reports/:id', async (ctx) => {
+      await sleep(60);
+      ctx.status(202).json({ id: ctx.params.id });
+    });
+    app.post('/reports', async (ctx) => {
+      await sleep(60);
+      ctx.status(201).json(ctx.body);
+    });
+    app.get('/health', (ctx) => ctx.send('ok'));
+    app.get('/locked', async () => {
+      await sleep(60);
+      throw new LockedError('report is being generated');
+    });
+    app.get('/broken', async () => {
+      await sleep(60);
+      throw new Error('database down');
+    });
+
+    app.listen(3522, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    lines.length = 0;
+    entries.length = 0;
+  });
+
+  it('should log a request slower than the threshold with its route pattern', async () => {
+    const response = await request(server, 'GET', '/reports/7?format=csv');
+
+    expect(response.status).toBe(202);
+    expect(entries).toEqual([{
+      time: expect.any(String),
+      method: 'GET',
+      path: '/reports/7',
+      route: '/reports/:id',
+      status: 202,
+      latencyMs: expect.any(Number)
+    }]);
+    expect(entries[0].latencyMs).toBeGreaterThanOrEqual(55);
+    expect(lines).toEqual([`${JSON.stringify(entries[0])}\n`]);
+  });
+
+  it('should log requests with a body', async () => {
+    const response = await request(server, 'POST', '/reports', {
+      headers: { 'content-type': 'application/json' },
+      body: '{"name":"weekly"}'
+    });
+
+    expect(response.status).toBe(201);
+    expect(entries.map(entry => [entry.method, entry.route, entry.status])).toEqual([['POST', '/reports', 201]]);
+  });
+
+  it('should not log fast requests', async () => {
+    await request(server, 'GET', '/health');
+
+    expect(lines).toEqual([]);
+  });
+
+  it('should log requests that fail with the status they are answered with', async () => {
+    await request(server, 'GET', '/locked');
+    await request(server, 'GET', '/broken');
+    await sleep(5);
+
+    expect(entries.map(entry => [entry.route, entry.status])).toEqual([['/locked', 423], ['/broken', 500]]);
+  });
+
+  it('should log the status an async error handler answers with', async () => {
+    const app = new Qera({ logging: { level: 'error' } });
+    const logged: SlowRequest[] = [];
+    app.use(async (ctx, next) => {
+      try {
+        await next();
+      } catch {
+        // e.g. looking up a fallback before answering
+        await sleep(10);
+        ctx.status(503).json({ error: 'Try again later' });
+      }
+    });
+    app.use(slowLog(0, (_line, entry) => logged.push(entry)));
+    app.get('/flaky', async () => {
+      await sleep(5);
+      throw new Error('upstream down');
+    });
+    app.listen(3528, 'localhost');
+
+    const response = await request(lastApp(), 'GET', '/flaky');
+    await sleep(5);
+
+    expect(response.status).toBe(503);
+    expect(logged.map(entry => entry.status)).toEqual([503]);
+  });
+
+  it('should log through Logger.warn without a writer', async () => {
+    const warn = jest.spyOn(Logger, 'warn').mockImplementation(() => undefined);
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(slowLog(0));
+    app.get('/slow', async (ctx) => {
+      await sleep(5);
+      ctx.send('done');
+    });
+    app.listen(3523, 'localhost');
+
+    await request(lastApp(), 'GET', '/slow');
+
+    expect(String(warn.mock.calls[0][0])).toMatch(/^Slow request: GET \/slow took \d+ms$/);
+    expect(warn.mock.calls[0][1]).toMatchObject({ route: '/slow', status: 200 });
+    warn.mockRestore();
+  });
+
+  it('should refuse a negative threshold', () => {
+    expect(() => slowLog(-1)).toThrow('slowLog() needs a threshold of 0 ms or more, got -1');
+  });
+});
@@ -0,0 +1,70 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { subdomain } from '../../src/middlewares/subdomain';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('subdomain middleware', () => {
+  let server: MockApp;
+  let resolvedServer: MockApp;
+  const tenants: Record<string, { id: number }> = { acme: { id: 1 }, globex: { id: 2 } };
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, trustProxy: ['10.0.0.1'] });
+    app.use(subdomain({ baseDomain: ['example.com', 'example.dev'] }));
+    app.get('/', (ctx) => ctx.json({ tenant: ctx.state.tenant ?? null }));
+    app.listen(3498, 'localhost');
+    server = lastApp();
+
+    const resolvedApp = new Qera({ logging: { level: 'error' } });
+    resolvedApp.use(subdomain({ baseDomain: 'example.com', resolve: (name) => tenants[name], key: 'account', ignore: ['www', 'app'] }));
+    resolvedApp.get('/', (ctx) => ctx.json({ account: ctx.state.account ?? null }));
+    resolvedApp.listen(3499, 'localhost');
+    resolvedServer = lastApp();
+  });
+
+  const get = async (target: MockApp, host: string, extra: Record<string, string> = {}, ip?: string) => {
+    const response = await request(target, 'GET', '/', { headers: { host, ...extra }, ip });
+    return { status: response.status, body: JSON.parse(response.body) };
+  };
+
+  it('should store the subdomain as the tenant', async () => {
+    expect((await get(server, 'acme.example.com')).body).toEqual({ tenant: 'acme' });
+    expect((await get(server, 'ACME.Example.dev:8080')).body).toEqual({ tenant: 'acme' });
+    expect((await get(server, 'acme.example.com.')).body).toEqual({ tenant: 'acme' });
+  });
+
+  it('should carry no tenant for the base domain and ignored subdomains', async () => {
+    expect((await get(server, 'example.com')).body).toEqual({ tenant: null });
+    expect((await get(server, 'www.example.com')).body).toEqual({ tenant: null });
+  });
+
+  it('should carry no tenant for invalid hosts without a resolver', async () => {
+    expect((await get(server, 'a.b.example.com')).body).toEqual({ tenant: null });
+    expect((await get(server, '-acme.example.com')).body).toEqual({ tenant: null });
+    expect((await get(server, 'acme.example.org')).body).toEqual({ tenant: null });
+    expect((await get(server, 'notexample.com')).body).toEqual({ tenant: null });
+  });
+
+  it('should read X-Forwarded-Host only from trusted proxies', async () => {
+    const forwarded = { 'x-forwarded-host': 'globex.example.com' };
+
+    expect((await get(server, 'internal:3000', forwarded, '10.0.0.1')).body).toEqual({ tenant: 'globex' });
+    expect((await get(server, 'acme.example.com', forwarded, '1.2.3.4')).body).toEqual({ tenant: 'acme' });
+  });
+
+  it('should store what the resolver returns', async () => {
+    expect(await get(resolvedServer, 'globex.example.com')).toEqual({ status: 200, body: { account: { id: 2 } } });
+    expect(await get(resolvedServer, 'app.example.com')).toEqual({ status: 200, body: { account: null } });
+  });
+
+  it('should answer unknown and invalid subdomains with 404 when resolving', async () => {
+    expect((await get(resolvedServer, 'initech.example.com')).status).toBe(404);
+    expect((await get(resolvedServer, 'a.acme.example.com')).status).toBe(404);
+    expect((await get(resolvedServer, 'acme.evil.com')).status).toBe(404);
+  });
+
+  it('should need a base domain', () => {
+    expect(() => subdomain({ baseDomain: '' })).toThrow('needs a base domain');
+  });
+});
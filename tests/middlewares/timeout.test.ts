@@ -0,0 +1,199 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { timeout } from '../../src/middlewares/timeout';
+import { Logger } from '../../src/utils/logger';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+const sleep = (ms: number) => new Promise(resolve => setTimeout(resolve, ms));
+
+describe('timeout middleware', () => {
+  let server: MockApp;
+  let branded: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.use(timeout(20));
+
+    app.get('/fast', (ctx) => ctx.send('ok'));
+    app.get('/slow', async (ctx) => {
+      await sleep(60);
+      ctx.send('late');
+    });
+    app.get('/report', async (ctx) => {
+      await sleep(60);
+      ctx.send('report');
+    }, { timeout: 200 });
+    app.get('/unlimited', async (ctx) => {
+      await sleep(40);
+      ctx.send('done');
+    }, { timeout: 0 });
+    app.get('/boundary/:delay', async (ctx) => {
+      await sleep(ctx.paramInt('delay')!);
+      ctx.send('ok');
+    });
+    app.get('/fail-late', async () => {
+      await sleep(40);
+      throw new Error('boom');
+    });
+
+    app.listen(3471, 'localhost');
+    server = lastApp();
+
+    const brandedApp = new Qera({ logging: { level: 'error' } });
+    brandedApp.use(timeout(20, {
+      response: (ctx) => ctx.status(503).json({ code: 'TIMEOUT', message: 'Try again later' })
+    }));
+    brandedApp.get('/slow', async (ctx) => {
+      await sleep(60);
+      ctx.send('late');
+    });
+    brandedApp.listen(3472, 'localhost');
+    branded = lastApp();
+  });
+
+  it('should pass through responses that finish in time', async () => {
+    const res = await request(server, 'GET', '/fast');
+
+    expect(res.status).toBe(200);
+    expect(res.body).toBe('ok');
+  });
+
+  it('should answer slow requests with a 503', async () => {
+    const res = await request(server, 'GET', '/slow');
+
+    expect(res.status).toBe(503);
+    expect(JSON.parse(res.body)).toEqual({ error: 'Request timed out' });
+  });
+
+  it('should send the custom timeout response', async () => {
+    const res = await request(branded, 'GET', '/slow');
+
+    expect(res.status).toBe(503);
+    expect(JSON.parse(res.body)).toEqual({ code: 'TIMEOUT', message: 'Try again later' });
+  });
+
+  it('should honour per-route overrides', async () => {
+    const report = await request(server, 'GET', '/report');
+    const unlimited = await request(server, 'GET', '/unlimited');
+
+    expect(report.status).toBe(200);
+    expect(report.body).toBe('report');
+    expect(unlimited.status).toBe(200);
+    expect(unlimited.body).toBe('done');
+  });
+
+  it('should not report handler errors after the deadline as failures', async () => {
+    const error = jest.spyOn(Logger, 'error');
+
+    const res = await request(server, 'GET', '/fail-late');
+    await sleep(40);
+
+    expect(res.status).toBe(503);
+    expect(error).not.toHaveBeenCalled();
+    error.mockRestore();
+  });
+
+  it('should write exactly one response when handlers finish at the deadline', async () => {
+    const error = jest.spyOn(Logger, 'error');
+    const delays = Array.from({ length: 200 }, (_, i) => 16 + (i % 9));
+
+    const responses = await Promise.all(delays.map(delay => request(server, 'GET', `/boundary/${delay}`)));
+    await sleep(40);
+
+    for (const res of responses) {
+      if (res.status === 200) {
+        expect(res.body).toBe('ok');
+      } else {
+        expect(res.status).toBe(503);
+        expect(JSON.parse(res.body)).toEqual({ error: 'Request timed out' });
+      }
+      expect(res.headers.filter(([key]) => key === 'Content-Type').length).toBeLessThan(2);
+    }
+    // A second end() on a response throws in the mock, which would surface here
+    expect(error).not.toHaveBeenCalled();
+    error.mockRestore();
+  });
+});
+
+describe('request deadline', () => {
+  let server: MockApp;
+  let seenInMiddleware: { deadline?: number; remaining?: number } = {};
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, requestTimeout: 50 });
+    app.use(async (ctx, next) => {
+      seenInMiddleware = { deadline: ctx.deadline()?.getTime(), remaining: ctx.remainingTime() };
+      await sleep(20);
+      await next();
+    });
+    app.use(timeout());
+
+    app.get('/budget', (ctx) => {
+      ctx.json({ deadline: ctx.deadline()!.getTime(), remaining: ctx.remainingTime() });
+    });
+    app.get('/slow', async (ctx) => {
+      await sleep(60);
+      ctx.send('late');
+    });
+    app.get('/exhausted', (ctx) => ctx.send('never'), { timeout: 10 });
+    app.get('/unbounded', (ctx) => ctx.json({ deadline: ctx.deadline() ?? null, remaining: String(ctx.remainingTime()) }), { timeout: 0 });
+
+    app.listen(3507, 'localhost');
+    server = lastApp();
+  });
+
+  it('should share one deadline between middleware and handler', async () => {
+    const before = Date.now();
+    const { deadline, remaining } = JSON.parse((await request(server, 'GET', '/budget')).body);
+
+    expect(deadline).toBe(seenInMiddleware.deadline);
+    expect(deadline).toBeGreaterThanOrEqual(before + 50);
+    expect(deadline).toBeLessThanOrEqual(Date.now() + 50);
+    // The middleware's sleep was taken out of the handler's budget
+    expect(remaining).toBeLessThanOrEqual(seenInMiddleware.remaining! - 15);
+  });
+
+  it('should answer at the deadline with timeout() and no limit of its own', async () => {
+    const started = Date.now();
+    const response = await request(server, 'GET', '/slow');
+
+    expect(response.status).toBe(503);
+    expect(Date.now() - started).toBeLessThan(58);
+  });
+
+  it('should use the route option as the budget', async () => {
+    // The 10ms budget runs out during the middleware, so timeout() answers at once
+    const response = await request(server, 'GET', '/exhausted');
+
+    expect(response.status).toBe(503);
+    expect(response.body).not.toBe('never');
+  });
+
+  it('should have no deadline on routes that turn it off', async () => {
+    const body = JSON.parse((await request(server, 'GET', '/unbounded')).body);
+
+    expect(body).toEqual({ deadline: null, remaining: 'Infinity' });
+  });
+});
+
+describe('request deadline signal', () => {
+  let server: MockApp;
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' }, requestTimeout: 15 });
+    app.get('/cancelled', async (ctx) => {
+      const reason = await new Promise(resolve => ctx.signal.addEventListener('abort', () => resolve(ctx.signal.reason)));
+      ctx.json({ error: (reason as Error).name, remaining: ctx.remainingTime() });
+    });
+
+    app.listen(3508, 'localhost');
+    server = lastApp();
+  });
+
+  it('should abort ctx.signal with DeadlineExceededError at the deadline', async () => {
+    const body = JSON.parse((await request(server, 'GET', '/cancelled')).body);
+
+    expect(body).toEqual({ error: 'DeadlineExceededError', remaining: 0 });
+  });
+});
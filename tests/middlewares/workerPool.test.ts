@@ -0,0 +1,106 @@
+jest.mock('uWebSockets.js', () => require('../helpers/mockUws'));
+
+import { Qera } from '../../src/core/app';
+import { chain } from '../../src/core/compose';
+import { workerPool } from '../../src/middlewares/workerPool';
+import { QeraContext } from '../../src/types';
+import { lastApp, request, MockApp } from '../helpers/mockUws';
+
+describe('workerPool middleware', () => {
+  let server: MockApp;
+  const pool = workerPool({ workers: 2, maxQueue: 1 });
+  const impatient = workerPool({ workers: 1, maxWait: 20 });
+  const started: string[] = [];
+
+  const slow = (ctx: QeraContext) => new Promise<void>(resolve => {
+    started.push(ctx.query.id);
+    setTimeout(() => {
+      ctx.send(ctx.query.id);
+      resolve();
+    }, 50);
+  });
+
+  beforeAll(() => {
+    const app = new Qera({ logging: { level: 'error' } });
+    app.get('/slow', chain(pool).handle(slow));
+    app.get('/impatient', chain(impatient).handle(slow));
+    app.get('/pool-metrics', pool.handler);
+
+    app.listen(3494, 'localhost');
+    server = lastApp();
+  });
+
+  beforeEach(() => {
+    started.length = 0;
+  });
+
+  it('should queue requests beyond the workers and reject beyond the queue', async () => {
+    const responses = await Promise.all(['1', '2', '3', '4'].map(id => request(server, 'GET', `/slow?id=${id}`)));
+
+    expect(responses.map(res => res.status)).toEqual([200, 200, 200, 503]);
+    expect(responses[2].body).toBe('3');
+    expect(responses[3].header('retry-after')).toBe('1');
+    expect(JSON.parse(responses[3].body)).toEqual({ error: 'Server busy, try again later' });
+    expect(started).toEqual(['1', '2', '3']);
+  });
+
+  it('should report queue depth and wait time', async () => {
+    const before = pool.stats();
+    const pending = Promise.all(['1', '2', '3'].map(id => request(server, 'GET', `/slow?id=${id}`)));
+    await new Promise(resolve => setTimeout(resolve, 10));
+    const during = pool.stats();
+    await pending;
+    const after = pool.stats();
+
+    expect(during).toMatchObject({ workers: 2, active: 2, queued: 1 });
+    expect(after).toMatchObject({ active: 0, queued: 0, admitted: before.admitted + 3 });
+    expect(after.totalWait - before.totalWait).toBeGreaterThanOrEqual(30);
+    expect(after.maxWait).toBeGreaterThanOrEqual(30);
+  });
+
+  it('should serve the stats in the Prometheus format', async () => {
+    const response = await request(server, 'GET', '/pool-metrics');
+
+    expect(response.header('content-type')).toBe('text/plain; version=0.0.4');
+    expect(response.body).toContain('qera_pool_queue_depth 0\n');
+    expect(response.body).toContain(`qera_pool_rejected_total ${pool.stats().rejected}\n`);
+    expect(response.body).toContain(`qera_pool_wait_seconds_count ${pool.stats().admitted}\n`);
+  });
+
+  it('should give up on requests that wait longer than maxWait', async () => {
+    const responses = await Promise.all(['1', '2'].map(id => request(server, 'GET', `/impatient?id=${id}`)));
+
+    expect(responses.map(res => res.status)).toEqual([200, 503]);
+    expect(started).toEqual(['1']);
+    expect(impatient.stats()).toMatchObject({ queued: 0, rejected: 1 });
+  });
+
+  it('should drop queued requests whose client disconnects', async () => {
+    await Promise.all([
+      request(server, 'GET', '/slow?id=1'),
+      request(server, 'GET', '/slow?id=2'),
+      request(server, 'GET', '/slow?id=3', { abortAfter: 10 })
+    ]);
+    await new Promise(resolve => setTimeout(resolve, 10));
+
+    expect(started).toEqual(['1', '2']);
+    expect(pool.stats()).toMatchObject({ active: 0, queued: 0 });
+  });
+
+  it('should answer queued requests that reach their deadline', async () => {
+    const app = new Qera({ logging: { level: 'error' }, requestTimeout: 30 });
+    const single = workerPool({ workers: 1 });
+    app.get('/slow', chain(single).handle(slow));
+    app.listen(3526, 'localhost');
+
+    const responses = await Promise.all(['1', '2'].map(id => request(lastApp(), 'GET', `/slow?id=${id}`)));
+
+    expect(responses.map(res => res.status)).toEqual([200, 503]);
+    expect(started).toEqual(['1']);
+    expect(single.stats()).toMatchObject({ queued: 0, rejected: 1 });
+  });
+
+  it('should need at least one worker', () => {
+    expect(() => workerPool({ workers: 0 })).toThrow('needs at least one worker');
+  });
+});
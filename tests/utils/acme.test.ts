@@ -0,0 +1,101 @@
+import { createPrivateKey, generateKeyPairSync, X509Certificate } from 'crypto';
+import { obtainCertificate, certificateNeedsRenewal, createCSR, AcmeOptions } from '../../src/utils/acme';
+import { startMockAcme, MockAcmeServer, ISSUED_CERT } from '../helpers/mockAcme';
+
+describe('ACME client', () => {
+  let acme: MockAcmeServer;
+  const published = new Map<string, string>();
+
+  const options = (extra: Partial<AcmeOptions> = {}): AcmeOptions => ({
+    directoryUrl: acme.directoryUrl,
+    publish: (token, keyAuthorization) => published.set(token, keyAuthorization),
+    unpublish: (token) => published.delete(token),
+    pollInterval: 10,
+    ...extra
+  });
+
+  beforeAll(async () => {
+    acme = await startMockAcme(async (token) => published.get(token));
+  });
+
+  afterAll(() => acme.close());
+
+  it('should obtain a certificate through an HTTP-01 challenge', async () => {
+    const issued = await obtainCertificate(['example.test', 'www.example.test'], options({ email: 'ops@example.test' }));
+
+    expect(issued.cert).toBe(ISSUED_CERT);
+    expect(createPrivateKey(issued.key).asymmetricKeyType).toBe('ec');
+    expect(acme.requests[0]).toEqual({
+      path: '/account',
+      payload: { termsOfServiceAgreed: true, contact: ['mailto:ops@example.test'] }
+    });
+    expect(acme.requests[1].payload.identifiers).toEqual([
+      { type: 'dns', value: 'example.test' },
+      { type: 'dns', value: 'www.example.test' }
+    ]);
+    expect(acme.csr!.includes(Buffer.from('www.example.test'))).toBe(true);
+    expect(published.size).toBe(0);
+  });
+
+  it('should reuse a given account key', async () => {
+    const accountKey = generateKeyPairSync('ec', { namedCurve: 'P-256' }).privateKey
+      .export({ format: 'pem', type: 'pkcs8' }) as string;
+
+    const issued = await obtainCertificate(['example.test'], options({ accountKey }));
+
+    expect(issued.accountKey).toBe(accountKey);
+  });
+
+  it('should retry once when the server rejects a nonce', async () => {
+    acme.failNextNonce = true;
+
+    const issued = await obtainCertificate(['example.test'], options());
+
+    expect(issued.cert).toBe(ISSUED_CERT);
+  });
+
+  it('should fail when the challenge answer is wrong', async () => {
+    await expect(obtainCertificate(['example.test'], options({
+      publish: (token) => published.set(token, `${token}.wrong`)
+    }))).rejects.toThrow(/became invalid/);
+    published.clear();
+  });
+
+  it('should require a domain', async () => {
+    await expect(obtainCertificate([], options())).rejects.toThrow(/At least one domain/);
+  });
+});
+
+describe('createCSR', () => {
+  it('should name every domain', () => {
+    const key = generateKeyPairSync('ec', { namedCurve: 'P-256' }).privateKey;
+    const csr = createCSR(['example.test', 'api.example.test'], key);
+
+    expect(csr[0]).toBe(0x30);
+    expect(csr.includes(Buffer.from('example.test'))).toBe(true);
+    expect(csr.includes(Buffer.from('api.example.test'))).toBe(true);
+  });
+});
+
+describe('certificateNeedsRenewal', () => {
+  const expires = new Date(new X509Certificate(ISSUED_CERT).validTo).getTime();
+  const thirtyDays = 30 * 24 * 60 * 60 * 1000;
+
+  it('should keep a certificate that covers the domains and is not expiring', () => {
+    expect(certificateNeedsRenewal(ISSUED_CERT, ['example.test', 'www.example.test'], thirtyDays)).toBe(false);
+  });
+
+  it('should renew a certificate that is missing a domain', () => {
+    expect(certificateNeedsRenewal(ISSUED_CERT, ['example.test', 'api.example.test'], thirtyDays)).toBe(true);
+  });
+
+  it('should renew a certificate close to expiry', () => {
+    expect(certificateNeedsRenewal(ISSUED_CERT, ['example.test'], thirtyDays, expires - thirtyDays + 1000)).toBe(true);
+    expect(certificateNeedsRenewal(ISSUED_CERT, ['example.test'], thirtyDays, expires - thirtyDays - 1000)).toBe(false);
+  });
+
+  it('should renew when there is no readable certificate', () => {
+    expect(certificateNeedsRenewal('', ['example.test'], thirtyDays)).toBe(true);
+    expect(certificateNeedsRenewal('not a certificate', ['example.test'], thirtyDays)).toBe(true);
+  });
+});
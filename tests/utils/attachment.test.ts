@@ -0,0 +1,75 @@
+import { contentDisposition, csvChunks, formatCSVRow } from '../../src/utils/attachment';
+
+describe('contentDisposition', () => {
+  it('should name ASCII files directly', () => {
+    expect(contentDisposition('report.csv')).toBe('attachment; filename="report.csv"');
+    expect(contentDisposition('say "hi".txt')).toBe('attachment; filename="say \\"hi\\".txt"');
+  });
+
+  it('should add filename* for other names', () => {
+    expect(contentDisposition('résumé (1).pdf')).toBe(
+      'attachment; filename="r_sum_ (1).pdf"; filename*=UTF-8\'\'r%C3%A9sum%C3%A9%20%281%29.pdf'
+    );
+  });
+
+  it('should drop directories and allow no name', () => {
+    expect(contentDisposition('../../etc/passwd')).toBe('attachment; filename="passwd"');
+    expect(contentDisposition()).toBe('attachment');
+  });
+});
+
+describe('formatCSVRow', () => {
+  it('should quote only fields that need it', () => {
+    expect(formatCSVRow(['plain', 'a,b', 'say "hi"', 'two\nlines', 3, null, undefined, true]))
+      .toBe('plain,"a,b","say ""hi""","two\nlines",3,,,true\r\n');
+  });
+
+  it('should write dates as ISO 8601', () => {
+    expect(formatCSVRow([new Date(Date.UTC(2024, 0, 2))])).toBe('2024-01-02T00:00:00.000Z\r\n');
+  });
+});
+
+describe('csvChunks', () => {
+  const collect = async (chunks: AsyncIterable<string>) => {
+    const parts: string[] = [];
+    for await (const chunk of chunks) parts.push(chunk);
+    return parts;
+  };
+
+  it('should write the header and rows', async () => {
+    expect((await collect(csvChunks(['id', 'name'], [[1, 'Ada'], [2, 'Linus']]))).join(''))
+      .toBe('id,name\r\n1,Ada\r\n2,Linus\r\n');
+  });
+
+  it('should read rows from a pull function', async () => {
+    let n = 0;
+    const rows = () => (n < 2 ? { value: [++n], done: false } : { value: undefined, done: true }) as IteratorResult<unknown[]>;
+
+    expect((await collect(csvChunks(null, rows))).join('')).toBe('1\r\n2\r\n');
+  });
+
+  it('should yield large output in several chunks', async () => {
+    const rows = Array.from({ length: 5000 }, (_, i) => [i, 'x'.repeat(20)]);
+    const parts = await collect(csvChunks(null, rows));
+
+    expect(parts.length).toBeGreaterThan(1);
+    expect(parts.join('').split('\r\n')).toHaveLength(5001);
+  });
+
+  it('should close the rows source when stopped early', async () => {
+    let closed = false;
+    async function* rows() {
+      try {
+        for (let i = 0; ; i++) yield [i, 'x'.repeat(100)];
+      } finally {
+        closed = true;
+      }
+    }
+
+    for await (const chunk of csvChunks(null, rows())) {
+      expect(chunk.length).toBeGreaterThan(0);
+      break;
+    }
+    expect(closed).toBe(true);
+  });
+});
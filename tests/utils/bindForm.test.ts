@@ -0,0 +1,100 @@
+import { bindForm, FormBindError } from '../../src/utils/bindForm';
+import { ParamBindError } from '../../src/utils/bindUri';
+import { v, QeraValidationError } from '../../src/utils/validator';
+
+describe('bindForm', () => {
+  const order = v.object({
+    name: v.string(),
+    address: v.object({ city: v.string(), zip: v.number().int() }).optional(),
+    tags: v.array(v.string()).optional(),
+    items: v.array(v.object({ sku: v.string(), qty: v.number().int().min(1), gift: v.boolean().optional() })).optional()
+  });
+
+  it('should nest dotted and bracketed names', () => {
+    expect(bindForm(order, { name: 'Ada', 'address.city': 'Paris', 'address[zip]': '75001' })).toEqual({
+      name: 'Ada',
+      address: { city: 'Paris', zip: 75001 }
+    });
+  });
+
+  it('should collect appended and repeated values into arrays', () => {
+    expect(bindForm(order, { name: 'Ada', 'tags[]': ['a', 'b'] }).tags).toEqual(['a', 'b']);
+    expect(bindForm(order, { name: 'Ada', tags: ['a', 'b'] }).tags).toEqual(['a', 'b']);
+    expect(bindForm(order, { name: 'Ada', tags: 'a' }).tags).toEqual(['a']);
+    expect(bindForm(order, { name: 'Ada', 'tags[1]': 'b', 'tags[0]': 'a' }).tags).toEqual(['a', 'b']);
+  });
+
+  it('should bind arrays of objects by index, closing gaps', () => {
+    const bound = bindForm(order, {
+      name: 'Ada',
+      'items[0][sku]': 'A-1',
+      'items[0][qty]': '2',
+      'items[3].sku': 'B-2',
+      'items[3].qty': '1',
+      'items[3].gift': 'true'
+    });
+
+    expect(bound.items).toEqual([{ sku: 'A-1', qty: 2 }, { sku: 'B-2', qty: 1, gift: true }]);
+  });
+
+  it('should leave out fields the schema does not declare', () => {
+    expect(bindForm(order, { name: 'Ada', _csrf: 'token', 'address.city': 'Oslo', 'address.zip': '1', 'address.floor': '3' }))
+      .toEqual({ name: 'Ada', address: { city: 'Oslo', zip: 1 } });
+  });
+
+  it('should infer nesting for schemas without declared properties', () => {
+    const loose = v.object({ meta: v.any() });
+
+    expect(bindForm(loose, { 'meta.a[]': ['1', '2'], 'meta.b.c': 'x' })).toEqual({ meta: { a: ['1', '2'], b: { c: 'x' } } });
+  });
+
+  it('should list every unmappable field in one error', () => {
+    let error: FormBindError | undefined;
+    try {
+      bindForm(order, {
+        name: 'Ada',
+        'name.first': 'A',
+        'address[city': 'Paris',
+        'tags[][x]': 'a',
+        'items[x]': 'a',
+        'items[5000].sku': 'a',
+        address: 'Paris'
+      });
+    } catch (caught) {
+      error = caught as FormBindError;
+    }
+
+    expect(error).toBeInstanceOf(FormBindError);
+    expect(error!.statusCode).toBe(400);
+    expect(error!.fields).toEqual([
+      'name.first: "name" is not an object',
+      'address[city: malformed name',
+      'tags[][x]: "[]" can only end a name',
+      'items[x]: "items" is a list, "x" is not an index',
+      'items[5000].sku: index 5000 is over 1000',
+      'address: "address" is an object, name its fields'
+    ]);
+    expect(error!.message).toBe(`Unmappable form fields: ${error!.fields.join('; ')}`);
+  });
+
+  it('should report fields that clash with each other', () => {
+    const loose = v.object({ meta: v.any() });
+
+    expect(() => bindForm(loose, { meta: 'x', 'meta.a': 'y' })).toThrow('meta.a: conflicts with another field for "meta"');
+  });
+
+  it('should refuse prototype keys', () => {
+    const loose = v.object({ meta: v.any() });
+
+    expect(() => bindForm(loose, { 'meta.__proto__.polluted': 'yes' })).toThrow('"__proto__" is not allowed');
+    expect(({} as any).polluted).toBeUndefined();
+  });
+
+  it('should reject values that do not convert, then invalid ones', () => {
+    expect(() => bindForm(order, { name: 'Ada', 'items[0].sku': 'A', 'items[0].qty': 'two' })).toThrow(ParamBindError);
+    expect(() => bindForm(order, { name: 'Ada', 'items[0].sku': 'A', 'items[0].qty': 'two' })).toThrow(
+      'Invalid form field "items[0].qty": expected an integer, received "two"'
+    );
+    expect(() => bindForm(order, { name: 'Ada', 'items[0].sku': 'A', 'items[0].qty': '0' })).toThrow(QeraValidationError);
+  });
+});
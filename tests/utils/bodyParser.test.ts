@@ -75,3 +75,123 @@ describe('Body Parser Utilities', () => {
     });
   });
 });
+
+describe('parseBody deadlines', () => {
+  // Just enough of a uWS response to feed parseBody a body in one piece
+  function send(body: string, contentType: string, timeout: number) {
+    let onData: (chunk: ArrayBuffer, isLast: boolean) => void = () => undefined;
+    const req: any = { getHeader: (name: string) => (name === 'content-type' ? contentType : '') };
+    const res: any = {
+      onAborted: () => res,
+      onData: (handler: typeof onData) => {
+        onData = handler;
+        return res;
+      }
+    };
+
+    const parsed = bodyParser.parseBody(req, res, '1mb', {}, timeout);
+    const buffer = Buffer.from(body);
+    onData(buffer.buffer.slice(buffer.byteOffset, buffer.byteOffset + buffer.length), true);
+    return parsed;
+  }
+
+  it('should stop parsing a multipart form once the deadline has passed', async () => {
+    const boundary = 'XyZ';
+    const parts = Array.from({ length: 5 }, (_, i) =>
+      `--${boundary}\r\nContent-Disposition: form-data; name="field${i}"\r\n\r\nvalue${i}\r\n`);
+    const body = `${parts.join('')}--${boundary}--\r\n`;
+
+    // Every clock read moves time on by 10ms, so the 25ms deadline passes mid-form
+    let clock = 0;
+    const now = jest.spyOn(Date, 'now').mockImplementation(() => (clock += 10));
+    try {
+      await expect(send(body, `multipart/form-data; boundary=${boundary}`, 25))
+        .rejects.toBeInstanceOf(bodyParser.BodyTimeoutError);
+    } finally {
+      now.mockRestore();
+    }
+  });
+
+  it('should parse the whole form within the deadline', async () => {
+    const body = '--XyZ\r\nContent-Disposition: form-data; name="a"\r\n\r\n1\r\n--XyZ--\r\n';
+
+    await expect(send(body, 'multipart/form-data; boundary=XyZ', 1000)).resolves.toEqual({ a: '1' });
+  });
+});
+
+describe('parseBody multipart limits', () => {
+  const boundary = 'XyZ';
+  const contentType = `multipart/form-data; boundary=${boundary}`;
+  const field = (name: string, value: string) =>
+    `--${boundary}\r\nContent-Disposition: form-data; name="${name}"\r\n\r\n${value}\r\n`;
+  const file = (name: string, content: string) =>
+    `--${boundary}\r\nContent-Disposition: form-data; name="${name}"; filename="${name}.txt"\r\n` +
+    `Content-Type: text/plain\r\n\r\n${content}\r\n`;
+  const form = (...parts: string[]) => `${parts.join('')}--${boundary}--\r\n`;
+
+  // Feed the body in chunks of chunkSize bytes, leaving out the last one when
+  // the body should stay incomplete
+  function send(body: string, limits: bodyParser.MultipartLimits, chunkSize = 7, limit: string | number = '1mb', complete = true) {
+    let onData: (chunk: ArrayBuffer, isLast: boolean) => void = () => undefined;
+    const req: any = { getHeader: (name: string) => (name === 'content-type' ? contentType : '') };
+    const res: any = {
+      onAborted: () => res,
+      onData: (handler: typeof onData) => {
+        onData = handler;
+        return res;
+      }
+    };
+
+    const parsed = bodyParser.parseBody(req, res, limit, {}, undefined, limits);
+    const buffer = Buffer.from(body);
+    for (let offset = 0; offset < buffer.length; offset += chunkSize) {
+      const isLast = offset + chunkSize >= buffer.length;
+      if (isLast && !complete) break;
+      const chunk = buffer.subarray(offset, offset + chunkSize);
+      onData(chunk.buffer.slice(chunk.byteOffset, chunk.byteOffset + chunk.length), isLast);
+    }
+    return parsed;
+  }
+
+  it('should accept forms within every limit', async () => {
+    const body = form(field('a', '1'), file('doc', 'hello'));
+
+    await expect(send(body, { maxParts: 2, maxFileSize: 5, maxTotalSize: body.length }))
+      .resolves.toEqual({ a: '1', doc: 'hello' });
+  });
+
+  it('should refuse forms with too many parts', async () => {
+    const body = form(...Array.from({ length: 50 }, (_, i) => field(`f${i}`, 'x')));
+    const parsed = send(body, { maxParts: 10 }, 7, '1mb', false);
+
+    await expect(parsed).rejects.toBeInstanceOf(bodyParser.PayloadTooLargeError);
+    await expect(parsed).rejects.toThrow('Too many multipart parts (limit 10)');
+  });
+
+  it('should refuse oversized files while they arrive', async () => {
+    const body = form(file('big', 'x'.repeat(200)), field('after', 'y'));
+    // The rest of the form never arrives, so only a check while streaming can fail it
+    await expect(send(body, { maxFileSize: 100 }, 7, '1mb', false)).rejects.toThrow('Multipart file larger than 100 bytes');
+  });
+
+  it('should not apply the file limit to plain fields', async () => {
+    const body = form(field('comment', 'x'.repeat(200)));
+
+    await expect(send(body, { maxFileSize: 100 })).resolves.toEqual({ comment: 'x'.repeat(200) });
+  });
+
+  it('should find delimiters split across chunks', async () => {
+    const parts = Array.from({ length: 4 }, (_, i) => field(`f${i}`, 'v'));
+    for (const chunkSize of [1, 2, 3, 5, 11]) {
+      await expect(send(form(...parts), { maxParts: 3 }, chunkSize)).rejects.toThrow('Too many multipart parts');
+      await expect(send(form(...parts), { maxParts: 4 }, chunkSize)).resolves.toEqual({ f0: 'v', f1: 'v', f2: 'v', f3: 'v' });
+    }
+  });
+
+  it('should use maxTotalSize instead of the body limit for forms', async () => {
+    const body = form(file('doc', 'x'.repeat(300)));
+
+    await expect(send(body, { maxTotalSize: 1000 }, 64, 100)).resolves.toEqual({ doc: 'x'.repeat(300) });
+    await expect(send(body, { maxTotalSize: 200 }, 64, 1000)).rejects.toThrow('Request body too large');
+  });
+});
@@ -0,0 +1,75 @@
+import { configFromEnv, parseDuration, parseSize } from '../../src/utils/config';
+
+describe('Config Utilities', () => {
+  describe('parseSize', () => {
+    it('should parse sizes with units', () => {
+      expect(parseSize('10MB')).toBe(10 * 1024 * 1024);
+      expect(parseSize('512kb')).toBe(512 * 1024);
+      expect(parseSize('1.5 kb')).toBe(1536);
+      expect(parseSize('2048')).toBe(2048);
+    });
+
+    it('should reject malformed sizes', () => {
+      expect(() => parseSize('ten megs')).toThrow('is not a valid size');
+      expect(() => parseSize('10tb')).toThrow('is not a valid size');
+    });
+  });
+
+  describe('parseDuration', () => {
+    it('should parse durations with units', () => {
+      expect(parseDuration('5s')).toBe(5000);
+      expect(parseDuration('250ms')).toBe(250);
+      expect(parseDuration('1m')).toBe(60000);
+      expect(parseDuration('1500')).toBe(1500);
+    });
+
+    it('should reject malformed durations', () => {
+      expect(() => parseDuration('soon')).toThrow('is not a valid duration');
+    });
+  });
+
+  describe('configFromEnv', () => {
+    it('should read prefixed variables', () => {
+      const config = configFromEnv('APP', {
+        APP_PORT: '8080',
+        APP_HOST: '0.0.0.0',
+        APP_BODY_LIMIT: '10MB',
+        APP_BODY_TIMEOUT: '30s',
+        APP_WRITE_TIMEOUT: '0',
+        APP_COMPRESSION: 'false',
+        APP_LOG_LEVEL: 'warn',
+        APP_TRUST_PROXY: '10.0.0.1, 10.0.0.2',
+        APP_RATE_LIMIT_WINDOW: '30s',
+        OTHER_PORT: '9999'
+      });
+
+      expect(config).toEqual({
+        port: 8080,
+        host: '0.0.0.0',
+        bodyLimit: 10 * 1024 * 1024,
+        bodyTimeout: 30000,
+        writeTimeout: 0,
+        compression: false,
+        logging: { level: 'warn' },
+        trustProxy: ['10.0.0.1', '10.0.0.2'],
+        rateLimit: { max: 100, windowMs: 30000 }
+      });
+    });
+
+    it('should leave unset variables out', () => {
+      expect(configFromEnv('APP', {})).toEqual({});
+    });
+
+    it('should parse boolean trustProxy values', () => {
+      expect(configFromEnv('APP', { APP_TRUST_PROXY: 'true' }).trustProxy).toBe(true);
+    });
+
+    it('should report every malformed variable', () => {
+      expect(() => configFromEnv('APP', {
+        APP_PORT: '70000',
+        APP_BODY_LIMIT: 'huge',
+        APP_HOST: 'fine'
+      })).toThrow('Invalid environment configuration:\n  APP_PORT: "70000" is not a valid port\n  APP_BODY_LIMIT: "huge" is not a valid size (e.g. 512kb, 10MB)');
+    });
+  });
+});
@@ -0,0 +1,34 @@
+import { errorContentType, renderError } from '../../src/utils/errorResponse';
+
+describe('errorContentType', () => {
+  it('should use the fallback unless negotiating', () => {
+    expect(errorContentType('text/html', 'json')).toBe('json');
+    expect(errorContentType(undefined, 'text', true)).toBe('text');
+  });
+
+  it('should pick the format the Accept header prefers', () => {
+    expect(errorContentType('text/html,application/xhtml+xml,*/*;q=0.8', 'json', true)).toBe('html');
+    expect(errorContentType('text/plain;q=0.9, application/json', 'html', true)).toBe('json');
+    expect(errorContentType('*/*', 'text', true)).toBe('text');
+    expect(errorContentType('image/webp', 'json', true)).toBe('json');
+  });
+});
+
+describe('renderError', () => {
+  it('should send JSON bodies whole', () => {
+    expect(renderError('json', 422, { error: 'Validation failed', details: { name: {} } })).toEqual({
+      contentType: 'application/json',
+      body: '{"error":"Validation failed","details":{"name":{}}}'
+    });
+  });
+
+  it('should send the message as text', () => {
+    expect(renderError('text', 404, { error: 'Not Found' })).toEqual({ contentType: 'text/plain; charset=utf-8', body: 'Not Found' });
+  });
+
+  it('should escape messages in HTML', () => {
+    const { body } = renderError('html', 400, { error: 'Bad <input> & "quotes"' });
+
+    expect(body).toContain('<h1>400 Bad &#60;input&#62; &#38; &#34;quotes&#34;</h1>');
+  });
+});
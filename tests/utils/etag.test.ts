@@ -0,0 +1,41 @@
+import { etagMatches, parseETags } from '../../src/utils/etag';
+
+describe('ETag Utilities', () => {
+  describe('parseETags', () => {
+    it('should return the tags as sent', () => {
+      expect(parseETags('"v1", W/"v2",  "v3"')).toEqual(['"v1"', 'W/"v2"', '"v3"']);
+      expect(parseETags(' * ')).toEqual(['*']);
+      expect(parseETags('')).toEqual([]);
+      expect(parseETags(undefined)).toEqual([]);
+    });
+
+    it('should keep commas inside tags', () => {
+      expect(parseETags('"a,b", "c"')).toEqual(['"a,b"', '"c"']);
+    });
+
+    it('should stop at malformed input', () => {
+      expect(parseETags('"v1", v2, "v3"')).toEqual(['"v1"']);
+      expect(parseETags('v1')).toEqual([]);
+    });
+  });
+
+  describe('etagMatches', () => {
+    it('should compare strongly by default', () => {
+      expect(etagMatches(['"v1"', '"v2"'], '"v2"')).toBe(true);
+      expect(etagMatches(['W/"v2"'], '"v2"')).toBe(false);
+      expect(etagMatches(['W/"v2"'], 'W/"v2"')).toBe(false);
+      expect(etagMatches([], '"v2"')).toBe(false);
+    });
+
+    it('should ignore the weak prefix in weak comparison', () => {
+      expect(etagMatches(['W/"v2"'], '"v2"', true)).toBe(true);
+      expect(etagMatches(['"v2"'], 'W/"v2"', true)).toBe(true);
+      expect(etagMatches(['"v1"'], '"v2"', true)).toBe(false);
+    });
+
+    it('should match anything for *', () => {
+      expect(etagMatches(['*'], '"v9"')).toBe(true);
+      expect(etagMatches(['*'], 'W/"v9"', true)).toBe(true);
+    });
+  });
+});
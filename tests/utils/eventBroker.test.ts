@@ -0,0 +1,77 @@
+import { EventBroker } from '../../src/utils/eventBroker';
+import { formatEvent } from '../../src/utils/sse';
+
+describe('EventBroker', () => {
+  it('should deliver every published event to every subscriber', async () => {
+    const broker = new EventBroker<string>();
+    const a = broker.subscribe();
+    const b = broker.subscribe();
+
+    broker.publish('one');
+    broker.publish('two');
+
+    expect(await a.next()).toEqual({ value: 'one', done: false });
+    expect(await a.next()).toEqual({ value: 'two', done: false });
+    expect(await b.next()).toEqual({ value: 'one', done: false });
+  });
+
+  it('should wake a subscriber waiting for the next event', async () => {
+    const broker = new EventBroker<string>();
+    const subscription = broker.subscribe();
+
+    const next = subscription.next();
+    broker.publish('late');
+
+    expect(await next).toEqual({ value: 'late', done: false });
+  });
+
+  it('should drop events for subscribers past the buffer size', async () => {
+    const broker = new EventBroker<number>({ bufferSize: 2 });
+    const slow = broker.subscribe();
+
+    for (let i = 1; i <= 5; i++) broker.publish(i);
+
+    expect(slow.pending).toBe(2);
+    expect(slow.dropped).toBe(3);
+    expect((await slow.next()).value).toBe(1);
+    broker.publish(6);
+    expect((await slow.next()).value).toBe(2);
+    expect((await slow.next()).value).toBe(6);
+  });
+
+  it('should unsubscribe when iteration stops early', async () => {
+    const broker = new EventBroker<string>();
+    const subscription = broker.subscribe();
+    broker.publish('only');
+
+    for await (const event of subscription) {
+      expect(event).toBe('only');
+      break;
+    }
+
+    expect(broker.size).toBe(0);
+  });
+
+  it('should end waiting subscribers on close', async () => {
+    const broker = new EventBroker<string>();
+    const next = broker.subscribe().next();
+
+    broker.close();
+
+    expect(await next).toEqual({ value: undefined, done: true });
+    expect(broker.size).toBe(0);
+  });
+});
+
+describe('formatEvent', () => {
+  it('should write the fields and JSON data', () => {
+    expect(formatEvent({ id: 7, event: 'user', data: { name: 'Ada' }, retry: 5000 }))
+      .toBe('id: 7\nevent: user\nretry: 5000\ndata: {"name":"Ada"}\n\n');
+  });
+
+  it('should split multi-line data and strip line breaks from fields', () => {
+    expect(formatEvent({ event: 'a\nb', data: 'line 1\nline 2' }))
+      .toBe('event: ab\ndata: line 1\ndata: line 2\n\n');
+    expect(formatEvent('ping')).toBe('data: ping\n\n');
+  });
+});
@@ -0,0 +1,38 @@
+import { canonicalQuery, hashFingerprint } from '../../src/utils/fingerprint';
+
+describe('Fingerprint Utilities', () => {
+  describe('canonicalQuery', () => {
+    it('should sort by name and keep the order of repeated values', () => {
+      expect(canonicalQuery([['b', '2'], ['a', 'x'], ['c', '3'], ['a', 'y']])).toEqual([
+        ['a', 'x'], ['a', 'y'], ['b', '2'], ['c', '3']
+      ]);
+    });
+
+    it('should keep only the listed names', () => {
+      expect(canonicalQuery([['page', '2'], ['utm_source', 'mail'], ['q', 'shoes']], ['q', 'page'])).toEqual([
+        ['page', '2'], ['q', 'shoes']
+      ]);
+    });
+  });
+
+  describe('hashFingerprint', () => {
+    it('should be a stable hex digest', () => {
+      const parts = { method: 'GET', path: '/items', query: [['a', '1']] as Array<[string, string]> };
+
+      expect(hashFingerprint(parts)).toMatch(/^[0-9a-f]{64}$/);
+      expect(hashFingerprint(parts)).toBe(hashFingerprint({ ...parts }));
+    });
+
+    it('should ignore key order in bodies', () => {
+      expect(hashFingerprint({ body: { a: 1, b: { c: [1, 2], d: null } } }))
+        .toBe(hashFingerprint({ body: { b: { d: null, c: [1, 2] }, a: 1 } }));
+      expect(hashFingerprint({ body: { a: [1, 2] } })).not.toBe(hashFingerprint({ body: { a: [2, 1] } }));
+    });
+
+    it('should not collide when characters move between fields', () => {
+      expect(hashFingerprint({ method: 'GET', path: '/ab' })).not.toBe(hashFingerprint({ method: 'GET/', path: 'ab' }));
+      expect(hashFingerprint({ query: [['a', 'b=c']] })).not.toBe(hashFingerprint({ query: [['a=b', 'c']] }));
+      expect(hashFingerprint({ path: '/' })).not.toBe(hashFingerprint({ query: [['/', '']] }));
+    });
+  });
+});
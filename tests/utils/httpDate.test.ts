@@ -0,0 +1,31 @@
+import { formatHTTPDate, parseHTTPDate, notModifiedSince } from '../../src/utils/httpDate';
+
+describe('HTTP dates', () => {
+  const time = Date.UTC(1994, 10, 6, 8, 49, 37);
+
+  it('should format IMF-fixdate', () => {
+    expect(formatHTTPDate(new Date(time + 250))).toBe('Sun, 06 Nov 1994 08:49:37 GMT');
+  });
+
+  it('should parse all three HTTP-date formats as UTC', () => {
+    expect(parseHTTPDate('Sun, 06 Nov 1994 08:49:37 GMT')).toBe(time);
+    expect(parseHTTPDate('Sunday, 06-Nov-94 08:49:37 GMT')).toBe(time);
+    expect(parseHTTPDate('Sun Nov  6 08:49:37 1994')).toBe(time);
+  });
+
+  it('should reject anything else', () => {
+    expect(parseHTTPDate(undefined)).toBeUndefined();
+    expect(parseHTTPDate('1994-11-06T08:49:37Z')).toBeUndefined();
+    expect(parseHTTPDate('Sun, 06 Nov 1994 08:49:37 PST')).toBeUndefined();
+    expect(parseHTTPDate('Mon, 31 Feb 2025 00:00:00 GMT')).toBeUndefined();
+  });
+
+  it('should compare at second precision', () => {
+    const header = 'Sun, 06 Nov 1994 08:49:37 GMT';
+
+    expect(notModifiedSince(header, time + 999)).toBe(true);
+    expect(notModifiedSince(header, time - 1000)).toBe(true);
+    expect(notModifiedSince(header, time + 1000)).toBe(false);
+    expect(notModifiedSince('garbage', time)).toBe(false);
+  });
+});
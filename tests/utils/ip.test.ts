@@ -0,0 +1,42 @@
+import { clientIp, isTrustedProxy, normalizeAddress } from '../../src/utils/ip';
+
+describe('IP Utilities', () => {
+  describe('normalizeAddress', () => {
+    it('should unwrap IPv4-mapped IPv6 addresses', () => {
+      expect(normalizeAddress('::ffff:10.0.0.1')).toBe('10.0.0.1');
+      expect(normalizeAddress(' 2001:db8::1 ')).toBe('2001:db8::1');
+    });
+  });
+
+  describe('isTrustedProxy', () => {
+    it('should trust nothing by default', () => {
+      expect(isTrustedProxy('10.0.0.1', undefined)).toBe(false);
+      expect(isTrustedProxy('10.0.0.1', false)).toBe(false);
+    });
+
+    it('should trust listed addresses only', () => {
+      expect(isTrustedProxy('::ffff:10.0.0.1', ['10.0.0.1'])).toBe(true);
+      expect(isTrustedProxy('10.0.0.2', ['10.0.0.1'])).toBe(false);
+      expect(isTrustedProxy('10.0.0.2', true)).toBe(true);
+    });
+  });
+
+  describe('clientIp', () => {
+    it('should ignore X-Forwarded-For from untrusted peers', () => {
+      expect(clientIp('203.0.113.9', '1.2.3.4', ['10.0.0.1'])).toBe('203.0.113.9');
+      expect(clientIp('203.0.113.9', '1.2.3.4', undefined)).toBe('203.0.113.9');
+    });
+
+    it('should use the forwarded address from a trusted proxy', () => {
+      expect(clientIp('10.0.0.1', '203.0.113.9', ['10.0.0.1'])).toBe('203.0.113.9');
+    });
+
+    it('should skip trusted proxies and ignore spoofed entries on the left', () => {
+      expect(clientIp('10.0.0.1', '6.6.6.6, 203.0.113.9, 10.0.0.2', ['10.0.0.1', '10.0.0.2'])).toBe('203.0.113.9');
+    });
+
+    it('should take the leftmost address when every hop is trusted', () => {
+      expect(clientIp('10.0.0.1', '203.0.113.9, 10.0.0.2', true)).toBe('203.0.113.9');
+    });
+  });
+});
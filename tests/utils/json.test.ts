@@ -0,0 +1,80 @@
+import { stringifyJSON, toSnakeCase, toCamelCase } from '../../src/utils/json';
+
+describe('stringifyJSON', () => {
+  const data = {
+    id: 1,
+    note: null,
+    missing: undefined,
+    callback: () => undefined,
+    tags: ['a', undefined],
+    profile: { avatarUrl: null, joinedAt: new Date('2024-01-02T03:04:05.000Z') }
+  };
+
+  it('should match JSON.stringify without options', () => {
+    expect(stringifyJSON(data)).toBe(JSON.stringify(data));
+  });
+
+  it('should write undefined fields as null in emit mode', () => {
+    expect(JSON.parse(stringifyJSON(data, { nulls: 'emit' }))).toEqual({
+      id: 1,
+      note: null,
+      missing: null,
+      callback: null,
+      tags: ['a', null],
+      profile: { avatarUrl: null, joinedAt: '2024-01-02T03:04:05.000Z' }
+    });
+  });
+
+  it('should drop null fields in omit mode but keep nulls in arrays', () => {
+    expect(JSON.parse(stringifyJSON({ ...data, list: [null, 1] }, { nulls: 'omit' }))).toEqual({
+      id: 1,
+      tags: ['a', null],
+      list: [null, 1],
+      profile: { joinedAt: '2024-01-02T03:04:05.000Z' }
+    });
+  });
+
+  it('should rename keys at every level', () => {
+    const value = { userId: 1, items: [{ unitPrice: 2 }], meta: { createdBy: 'x' } };
+
+    expect(stringifyJSON(value, { keys: 'snake_case' }))
+      .toBe('{"user_id":1,"items":[{"unit_price":2}],"meta":{"created_by":"x"}}');
+    expect(stringifyJSON({ user_id: 1, items: [{ unit_price: 2 }] }, { keys: 'camelCase' }))
+      .toBe('{"userId":1,"items":[{"unitPrice":2}]}');
+  });
+
+  it('should indent output', () => {
+    expect(stringifyJSON({ aB: 1 }, { keys: 'snake_case', indent: 2 })).toBe('{\n  "a_b": 1\n}');
+  });
+
+  it('should reject circular structures', () => {
+    const loop: any = { name: 'loop' };
+    loop.self = loop;
+
+    expect(() => stringifyJSON(loop, { nulls: 'emit' })).toThrow(/circular/);
+  });
+
+  it('should allow the same object twice when it is not circular', () => {
+    const shared = { v: 1 };
+
+    expect(stringifyJSON({ a: shared, b: shared }, { nulls: 'emit' })).toBe('{"a":{"v":1},"b":{"v":1}}');
+  });
+});
+
+describe('key casing', () => {
+  it('should convert to snake_case', () => {
+    expect(toSnakeCase('createdAt')).toBe('created_at');
+    expect(toSnakeCase('userID')).toBe('user_id');
+    expect(toSnakeCase('HTTPServer')).toBe('http_server');
+    expect(toSnakeCase('version2Name')).toBe('version2_name');
+    expect(toSnakeCase('already_snake')).toBe('already_snake');
+    expect(toSnakeCase('kebab-case')).toBe('kebab_case');
+  });
+
+  it('should convert to camelCase', () => {
+    expect(toCamelCase('created_at')).toBe('createdAt');
+    expect(toCamelCase('kebab-case-key')).toBe('kebabCaseKey');
+    expect(toCamelCase('_private_field')).toBe('_privateField');
+    expect(toCamelCase('alreadyCamel')).toBe('alreadyCamel');
+  });
+});
@@ -0,0 +1,80 @@
+import {
+  parseQualityValues,
+  acceptsType,
+  acceptsCharset,
+  acceptsEncoding,
+  acceptsLanguage
+} from '../../src/utils/negotiation';
+
+describe('Content Negotiation', () => {
+  describe('parseQualityValues', () => {
+    it('should order entries by quality', () => {
+      const values = parseQualityValues('text/html;q=0.5, application/json, text/plain;q=0.8');
+      expect(values.map(v => v.value)).toEqual(['application/json', 'text/plain', 'text/html']);
+    });
+
+    it('should keep client order for equal quality', () => {
+      const values = parseQualityValues('gzip, br, deflate');
+      expect(values.map(v => v.value)).toEqual(['gzip', 'br', 'deflate']);
+    });
+
+    it('should parse extra parameters', () => {
+      const [value] = parseQualityValues('text/html; level=1; q=0.7');
+      expect(value).toMatchObject({ value: 'text/html', q: 0.7, params: { level: '1' } });
+    });
+
+    it('should handle an empty header', () => {
+      expect(parseQualityValues('')).toEqual([]);
+    });
+  });
+
+  describe('acceptsType', () => {
+    it('should return the first offer when no Accept header is sent', () => {
+      expect(acceptsType(undefined, ['json', 'html'])).toBe('json');
+    });
+
+    it('should pick the offer the client prefers', () => {
+      expect(acceptsType('text/html, application/json;q=0.9', ['json', 'html'])).toBe('html');
+    });
+
+    it('should support wildcards', () => {
+      expect(acceptsType('text/*', ['application/json', 'text/plain'])).toBe('text/plain');
+      expect(acceptsType('*/*', ['json'])).toBe('json');
+    });
+
+    it('should prefer the more specific range', () => {
+      expect(acceptsType('text/*, text/html;q=0', ['html', 'text'])).toBe('text');
+    });
+
+    it('should return an empty string when nothing matches', () => {
+      expect(acceptsType('image/png', ['json', 'html'])).toBe('');
+    });
+  });
+
+  describe('acceptsCharset', () => {
+    it('should match charsets case-insensitively', () => {
+      expect(acceptsCharset('UTF-8, iso-8859-1;q=0.5', ['iso-8859-1', 'utf-8'])).toBe('utf-8');
+    });
+  });
+
+  describe('acceptsEncoding', () => {
+    it('should pick the preferred encoding', () => {
+      expect(acceptsEncoding('gzip;q=0.5, br', ['gzip', 'br'])).toBe('br');
+    });
+
+    it('should treat identity as implicitly acceptable', () => {
+      expect(acceptsEncoding('gzip', ['identity'])).toBe('identity');
+      expect(acceptsEncoding('gzip, *;q=0', ['identity'])).toBe('');
+    });
+  });
+
+  describe('acceptsLanguage', () => {
+    it('should match language prefixes', () => {
+      expect(acceptsLanguage('en;q=0.8, fr', ['en-US', 'de'])).toBe('en-US');
+    });
+
+    it('should prefer exact matches', () => {
+      expect(acceptsLanguage('id, en;q=0.5', ['en', 'id'])).toBe('id');
+    });
+  });
+});
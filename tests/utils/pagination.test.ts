@@ -0,0 +1,65 @@
+import { parsePagination, formatPageLinks } from '../../src/utils/pagination';
+
+describe('parsePagination', () => {
+  it('should use the defaults without params', () => {
+    expect(parsePagination({})).toEqual({ page: 1, size: 20, offset: 0, limit: 20, style: 'page' });
+    expect(parsePagination({}, { size: 50 }).size).toBe(50);
+  });
+
+  it('should read page and size', () => {
+    expect(parsePagination({ page: '3', size: '10' })).toEqual({ page: 3, size: 10, offset: 20, limit: 10, style: 'page' });
+  });
+
+  it('should read limit and offset', () => {
+    expect(parsePagination({ limit: '10', offset: '25' })).toEqual({ page: 3, size: 10, offset: 25, limit: 10, style: 'offset' });
+  });
+
+  it('should prefer page and size when both styles are sent', () => {
+    expect(parsePagination({ page: '2', offset: '100' })).toMatchObject({ page: 2, offset: 20, style: 'page' });
+  });
+
+  it('should clamp out-of-range values', () => {
+    expect(parsePagination({ page: '0', size: '1000' })).toMatchObject({ page: 1, size: 100 });
+    expect(parsePagination({ page: '-4', size: '0' })).toMatchObject({ page: 1, size: 1 });
+    expect(parsePagination({ offset: '-10', limit: '7' }, { minSize: 10, maxSize: 50 })).toMatchObject({ offset: 0, size: 10 });
+    expect(Number.isSafeInteger(parsePagination({ page: '999999999999999' }).offset)).toBe(true);
+  });
+
+  it('should fall back to the defaults for malformed values', () => {
+    expect(parsePagination({ page: 'two', size: '2.5' })).toMatchObject({ page: 1, size: 20 });
+    expect(parsePagination({ page: '1e3', size: '' })).toMatchObject({ page: 1, size: 20 });
+  });
+});
+
+describe('formatPageLinks', () => {
+  const query: Array<[string, string]> = [['q', 'ada lovelace'], ['page', '2'], ['size', '10']];
+
+  it('should link first, prev, next and last pages keeping other params', () => {
+    const page = parsePagination({ page: '2', size: '10' });
+
+    expect(formatPageLinks('/users', query, page, 35)).toBe([
+      '</users?q=ada+lovelace&page=1&size=10>; rel="first"',
+      '</users?q=ada+lovelace&page=1&size=10>; rel="prev"',
+      '</users?q=ada+lovelace&page=3&size=10>; rel="next"',
+      '</users?q=ada+lovelace&page=4&size=10>; rel="last"'
+    ].join(', '));
+  });
+
+  it('should omit next on the last page and last without a total', () => {
+    const last = parsePagination({ page: '4', size: '10' });
+    const unknown = parsePagination({ page: '1', size: '10' });
+
+    expect(formatPageLinks('/users', [], last, 35)).not.toContain('rel="next"');
+    expect(formatPageLinks('/users', [], unknown)).toBe(
+      '</users?page=1&size=10>; rel="first", </users?page=2&size=10>; rel="next"'
+    );
+  });
+
+  it('should keep the limit/offset style', () => {
+    const page = parsePagination({ limit: '10', offset: '10' });
+
+    expect(formatPageLinks('/users', [['limit', '10'], ['offset', '10']], page, 20)).toBe(
+      '</users?offset=0&limit=10>; rel="first", </users?offset=0&limit=10>; rel="prev", </users?offset=10&limit=10>; rel="last"'
+    );
+  });
+});
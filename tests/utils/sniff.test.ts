@@ -0,0 +1,37 @@
+import { detectContentType } from '../../src/utils/sniff';
+
+describe('detectContentType', () => {
+  it.each([
+    ['  <!doctype html><p>hi', 'text/html; charset=utf-8'],
+    ['<HTML>', 'text/html; charset=utf-8'],
+    ['<p class="x">', 'text/html; charset=utf-8'],
+    ['<?xml version="1.0"?><a/>', 'text/xml; charset=utf-8'],
+    ['%PDF-1.7', 'application/pdf'],
+    ['plain words\nand lines', 'text/plain; charset=utf-8'],
+    ['{"json":true}', 'text/plain; charset=utf-8']
+  ])('should detect %j as %s', (body, type) => {
+    expect(detectContentType(body)).toBe(type);
+  });
+
+  it('should not take tags without a terminator for HTML', () => {
+    expect(detectContentType('<pre>')).toBe('text/plain; charset=utf-8');
+  });
+
+  it.each([
+    [[0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0, 0, 0, 0x0d], 'image/png'],
+    [[0xff, 0xd8, 0xff, 0xe0], 'image/jpeg'],
+    [[0x47, 0x49, 0x46, 0x38, 0x39, 0x61], 'image/gif'],
+    [[0x1f, 0x8b, 0x08, 0x00], 'application/x-gzip'],
+    [[0x50, 0x4b, 0x03, 0x04], 'application/zip'],
+    [[0x00, 0x61, 0x73, 0x6d, 0x01], 'application/wasm'],
+    [[0xef, 0xbb, 0xbf, 0x68, 0x69], 'text/plain; charset=utf-8'],
+    [[0x00, 0x00, 0x00, 0x18, 0x66, 0x74, 0x79, 0x70, 0x6d, 0x70, 0x34, 0x32, 0, 0, 0, 0, 0x69, 0x73, 0x6f, 0x6d, 0x6d, 0x70, 0x34, 0x32], 'video/mp4'],
+    [[0x01, 0x02, 0x03], 'application/octet-stream']
+  ])('should detect binary signature %#', (data, type) => {
+    expect(detectContentType(Buffer.from(data))).toBe(type);
+  });
+
+  it('should only look at the first 512 bytes', () => {
+    expect(detectContentType(Buffer.concat([Buffer.alloc(512, 'a'), Buffer.from([0])]))).toBe('text/plain; charset=utf-8');
+  });
+});
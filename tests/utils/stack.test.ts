@@ -0,0 +1,41 @@
+import { chain } from '../../src/core/compose';
+import { isFrameworkFrame, trimFrameworkFrames } from '../../src/utils/stack';
+
+// A real stack that passes through the middleware chain on its way to the handler
+async function stackThroughChain(): Promise<string> {
+  let stack = '';
+  await chain(async (_ctx, next) => next())({} as any, async () => {
+    stack = new Error('card declined').stack!;
+  });
+  return stack;
+}
+
+describe('Stack Utilities', () => {
+  it('should recognise frames from the middleware chain', async () => {
+    const frames = (await stackThroughChain()).split('\n').slice(1);
+
+    expect(frames.some(isFrameworkFrame)).toBe(true);
+    expect(isFrameworkFrame('    at chargeCard (/srv/app/payments.ts:12:11)')).toBe(false);
+    expect(isFrameworkFrame('Error: card declined')).toBe(false);
+  });
+
+  it('should drop framework frames and keep the rest in order', async () => {
+    const stack = await stackThroughChain();
+    const lines = stack.split('\n');
+
+    const trimmed = trimFrameworkFrames(stack).split('\n');
+
+    expect(trimmed[0]).toBe('Error: card declined');
+    expect(trimmed.some(isFrameworkFrame)).toBe(false);
+    expect(trimmed).toEqual(lines.filter(line => !isFrameworkFrame(line)));
+    expect(trimmed.length).toBeLessThan(lines.length);
+  });
+
+  it('should leave stacks made only of framework frames alone', async () => {
+    const lines = (await stackThroughChain()).split('\n');
+    const internal = ['TypeError: x is undefined', ...lines.filter(isFrameworkFrame)].join('\n');
+
+    expect(trimFrameworkFrames(internal)).toBe(internal);
+    expect(trimFrameworkFrames('Error: no frames')).toBe('Error: no frames');
+  });
+});
@@ -0,0 +1,121 @@
+import * as path from 'path';
+import {
+  createETag,
+  getMimeType,
+  memoryFileSystem,
+  parseRange,
+  resolveStaticPath,
+  shouldServeSpaFallback
+} from '../../src/utils/staticFiles';
+
+describe('Static File Utilities', () => {
+  describe('resolveStaticPath', () => {
+    const root = path.resolve('/srv/public');
+
+    it('should resolve paths inside the root', () => {
+      expect(resolveStaticPath(root, '/css/app.css')).toBe(path.join(root, 'css', 'app.css'));
+    });
+
+    it('should decode URL encoded paths', () => {
+      expect(resolveStaticPath(root, '/my%20file.txt')).toBe(path.join(root, 'my file.txt'));
+    });
+
+    it('should keep traversal attempts inside the root', () => {
+      expect(resolveStaticPath(root, '/../../etc/passwd')).toBe(path.join(root, 'etc', 'passwd'));
+      expect(resolveStaticPath(root, '/%2e%2e/%2e%2e/etc/passwd')).toBe(path.join(root, 'etc', 'passwd'));
+    });
+
+    it('should reject malformed or null-byte paths', () => {
+      expect(resolveStaticPath(root, '/%E0%A4%A')).toBeNull();
+      expect(resolveStaticPath(root, '/index.html%00.png')).toBeNull();
+    });
+  });
+
+  describe('shouldServeSpaFallback', () => {
+    const html = 'text/html,application/xhtml+xml,*/*;q=0.8';
+
+    it('should fall back for browser navigations', () => {
+      expect(shouldServeSpaFallback('/dashboard/settings', 'get', html, ['/api'])).toBe(true);
+    });
+
+    it('should not fall back for API paths', () => {
+      expect(shouldServeSpaFallback('/api/users/42', 'get', html, ['/api'])).toBe(false);
+      expect(shouldServeSpaFallback('/api', 'get', html, ['/api'])).toBe(false);
+      expect(shouldServeSpaFallback('/apiary', 'get', html, ['/api'])).toBe(true);
+    });
+
+    it('should not fall back for non-GET requests', () => {
+      expect(shouldServeSpaFallback('/dashboard', 'post', html, ['/api'])).toBe(false);
+    });
+
+    it('should not fall back for missing assets', () => {
+      expect(shouldServeSpaFallback('/js/missing.js', 'get', html, ['/api'])).toBe(false);
+    });
+
+    it('should not fall back for clients that do not accept HTML', () => {
+      expect(shouldServeSpaFallback('/dashboard', 'get', 'application/json', ['/api'])).toBe(false);
+    });
+  });
+
+  describe('getMimeType', () => {
+    it('should map known extensions', () => {
+      expect(getMimeType('index.html')).toBe('text/html');
+      expect(getMimeType('unknown.bin')).toBe('application/octet-stream');
+    });
+  });
+});
+
+describe('Static File Systems', () => {
+  describe('memoryFileSystem', () => {
+    const fsys = memoryFileSystem({
+      'index.html': '<h1>home</h1>',
+      'css/app.css': 'body{}'
+    });
+
+    it('should stat and read files', async () => {
+      const stat = await fsys.stat('css/app.css');
+      expect(stat.isFile()).toBe(true);
+      expect(stat.size).toBe(6);
+      expect((await fsys.readFile('css/app.css')).toString()).toBe('body{}');
+    });
+
+    it('should report implied directories', async () => {
+      expect((await fsys.stat('css')).isDirectory()).toBe(true);
+      expect((await fsys.stat('')).isDirectory()).toBe(true);
+    });
+
+    it('should reject missing files', async () => {
+      await expect(fsys.stat('missing.txt')).rejects.toThrow();
+      await expect(fsys.readFile('css')).rejects.toThrow();
+    });
+  });
+
+  describe('parseRange', () => {
+    it('should parse bounded, open and suffix ranges', () => {
+      expect(parseRange('bytes=0-9', 100)).toEqual({ start: 0, end: 9 });
+      expect(parseRange('bytes=90-', 100)).toEqual({ start: 90, end: 99 });
+      expect(parseRange('bytes=-10', 100)).toEqual({ start: 90, end: 99 });
+      expect(parseRange('bytes=50-500', 100)).toEqual({ start: 50, end: 99 });
+    });
+
+    it('should flag ranges outside the file', () => {
+      expect(parseRange('bytes=100-', 100)).toBe('unsatisfiable');
+      expect(parseRange('bytes=9-1', 100)).toBe('unsatisfiable');
+    });
+
+    it('should ignore malformed and multi-part ranges', () => {
+      expect(parseRange('bytes=0-1,5-6', 100)).toBeNull();
+      expect(parseRange('items=0-1', 100)).toBeNull();
+    });
+  });
+
+  describe('createETag', () => {
+    it('should change when the file changes', () => {
+      const base = { isFile: () => true, isDirectory: () => false };
+      const a = createETag({ ...base, size: 10, mtime: new Date(1000) });
+      const b = createETag({ ...base, size: 11, mtime: new Date(1000) });
+      expect(a).toMatch(/^W\/".+"$/);
+      expect(a).not.toBe(b);
+    });
+  });
+});
@@ -0,0 +1,302 @@
+import { streamJSONArray, streamBody, writeChunk, ConnectionClosedError } from '../../src/utils/stream';
+
+// Minimal stand-in for a uWS HttpResponse that records what was written
+function createMockResponse() {
+  const res: any = {
+    aborted: false,
+    status: '',
+    headers: {} as Record<string, string>,
+    chunks: [] as string[],
+    ended: false,
+    closed: false,
+    cork: (fn: () => void) => fn(),
+    writeStatus: jest.fn((status: string) => { res.status = status; return res; }),
+    writeHeader: jest.fn((key: string, value: string) => { res.headers[key] = value; return res; }),
+    write: jest.fn((chunk: string) => { res.chunks.push(chunk); return !res.backpressure; }),
+    end: jest.fn((chunk?: string) => { if (chunk) res.chunks.push(chunk); res.ended = true; return res; }),
+    close: jest.fn(() => { res.closed = true; return res; }),
+    onWritable: jest.fn((handler: () => boolean) => { res.writable = handler; return res; }),
+    onAborted: jest.fn((handler: () => void) => { res.abort = handler; return res; })
+  };
+  return res;
+}
+
+describe('Stream Utilities', () => {
+  describe('streamJSONArray', () => {
+    it('should stream an iterable as a JSON array', async () => {
+      const res = createMockResponse();
+
+      await streamJSONArray(res, 200, [{ id: 1 }, { id: 2 }, { id: 3 }]);
+
+      expect(res.status).toBe('200');
+      expect(res.headers['Content-Type']).toBe('application/json');
+      expect(res.ended).toBe(true);
+      expect(JSON.parse(res.chunks.join(''))).toEqual([{ id: 1 }, { id: 2 }, { id: 3 }]);
+    });
+
+    it('should pull items from a next function until done', async () => {
+      const res = createMockResponse();
+      let i = 0;
+
+      await streamJSONArray(res, 200, async () => {
+        i++;
+        return i <= 2 ? { value: i, done: false } : { value: undefined, done: true };
+      });
+
+      expect(JSON.parse(res.chunks.join(''))).toEqual([1, 2]);
+    });
+
+    it('should write an empty array for an empty source', async () => {
+      const res = createMockResponse();
+
+      await streamJSONArray(res, 200, []);
+
+      expect(res.chunks.join('')).toBe('[]');
+    });
+
+    it('should flush large outputs in multiple chunks', async () => {
+      const res = createMockResponse();
+      const rows = Array.from({ length: 2000 }, (_, id) => ({ id, name: 'x'.repeat(20) }));
+
+      await streamJSONArray(res, 200, rows);
+
+      expect(res.write.mock.calls.length).toBeGreaterThan(1);
+      expect(JSON.parse(res.chunks.join(''))).toHaveLength(2000);
+    });
+
+    it('should wait for the socket to drain between flushes', async () => {
+      const res = createMockResponse();
+      res.backpressure = true;
+      const rows = Array.from({ length: 2000 }, (_, id) => ({ id, name: 'x'.repeat(20) }));
+
+      const streaming = streamJSONArray(res, 200, rows);
+      await new Promise(resolve => setImmediate(resolve));
+      expect(res.write).toHaveBeenCalledTimes(1);
+
+      res.backpressure = false;
+      res.writable(0);
+      await streaming;
+      expect(JSON.parse(res.chunks.join(''))).toHaveLength(2000);
+    });
+
+    it('should flush what it has while the source is slow', async () => {
+      const res = createMockResponse();
+      async function* rows() {
+        yield { id: 1 };
+        await new Promise(resolve => setTimeout(resolve, 300));
+        yield { id: 2 };
+      }
+
+      const streaming = streamJSONArray(res, 200, rows());
+      await new Promise(resolve => setTimeout(resolve, 200));
+      expect(res.status).toBe('200');
+      expect(res.chunks.join('')).toBe('[{"id":1}');
+
+      await streaming;
+      expect(JSON.parse(res.chunks.join(''))).toEqual([{ id: 1 }, { id: 2 }]);
+    });
+
+    it('should stop the source when the client leaves while a flush waits', async () => {
+      const res = createMockResponse();
+      res.backpressure = true;
+      let cleanedUp = false;
+      async function* rows() {
+        try {
+          for (let id = 0; ; id++) {
+            yield { id, name: 'x'.repeat(20) };
+          }
+        } finally {
+          cleanedUp = true;
+        }
+      }
+
+      const streaming = streamJSONArray(res, 200, rows());
+      await new Promise(resolve => setImmediate(resolve));
+      res.aborted = true;
+      res.abort();
+
+      await expect(streaming).rejects.toThrow(ConnectionClosedError);
+      expect(cleanedUp).toBe(true);
+    });
+
+    it('should close the connection when the source fails mid-stream', async () => {
+      const res = createMockResponse();
+      async function* rows() {
+        for (let id = 0; id < 2000; id++) {
+          yield { id, name: 'x'.repeat(20) };
+        }
+        throw new Error('cursor failed');
+      }
+
+      await streamJSONArray(res, 200, rows());
+
+      expect(res.closed).toBe(true);
+      expect(res.ended).toBe(false);
+    });
+
+    it('should send a 500 when the source fails before anything was written', async () => {
+      const res = createMockResponse();
+
+      await streamJSONArray(res, 200, () => {
+        throw new Error('query failed');
+      });
+
+      expect(res.status).toBe('500');
+      expect(res.ended).toBe(true);
+      expect(res.closed).toBe(false);
+    });
+
+    it('should stop pulling items once the client disconnects', async () => {
+      const res = createMockResponse();
+      const next = jest.fn(() => {
+        res.aborted = true;
+        return { value: 1, done: false };
+      });
+
+      await expect(streamJSONArray(res, 200, next)).rejects.toThrow(ConnectionClosedError);
+
+      expect(next).toHaveBeenCalledTimes(1);
+      expect(res.end).not.toHaveBeenCalled();
+    });
+  });
+
+  describe('writeChunk', () => {
+    it('should write the chunk', async () => {
+      const res = createMockResponse();
+
+      await writeChunk(res, 'hello');
+
+      expect(res.chunks).toEqual(['hello']);
+    });
+
+    it('should reject once the client has disconnected', async () => {
+      const res = createMockResponse();
+      res.aborted = true;
+
+      await expect(writeChunk(res, 'hello')).rejects.toThrow(ConnectionClosedError);
+      expect(res.write).not.toHaveBeenCalled();
+    });
+
+    it('should wait for the socket to drain under backpressure', async () => {
+      const res = createMockResponse();
+      res.backpressure = true;
+      let resolved = false;
+
+      const write = writeChunk(res, 'hello').then(() => { resolved = true; });
+      await Promise.resolve();
+      expect(resolved).toBe(false);
+
+      res.writable(5);
+      await write;
+      expect(resolved).toBe(true);
+    });
+
+    it('should not keep an abort listener for each wait', async () => {
+      const res = createMockResponse();
+      res.backpressure = true;
+
+      for (let i = 0; i < 3; i++) {
+        const write = writeChunk(res, 'hello');
+        res.writable(5);
+        await write;
+      }
+
+      expect(res.abortListeners).toEqual([]);
+    });
+
+    it('should reject when the client leaves while waiting to drain', async () => {
+      const res = createMockResponse();
+      res.backpressure = true;
+
+      const write = writeChunk(res, 'hello');
+      res.aborted = true;
+      res.abort();
+
+      await expect(write).rejects.toThrow('Client disconnected');
+    });
+  });
+
+  describe('streamBody', () => {
+    const writeHead = (res: any) => () => {
+      res.writeStatus('200');
+      res.writeHeader('Content-Type', 'text/csv');
+    };
+
+    it('should copy every chunk and end the response', async () => {
+      const res = createMockResponse();
+
+      await streamBody(res, ['id,name\n', Buffer.from('1,ada\n'), new Uint8Array(Buffer.from('2,bob\n'))], writeHead(res));
+
+      expect(res.headers['Content-Type']).toBe('text/csv');
+      expect(res.chunks.map((chunk: any) => chunk.toString()).join('')).toBe('id,name\n1,ada\n2,bob\n');
+      expect(res.ended).toBe(true);
+    });
+
+    it('should send the head for an empty source', async () => {
+      const res = createMockResponse();
+
+      await streamBody(res, [], writeHead(res));
+
+      expect(res.status).toBe('200');
+      expect(res.ended).toBe(true);
+    });
+
+    it('should split large chunks into bounded writes', async () => {
+      const res = createMockResponse();
+
+      await streamBody(res, [Buffer.alloc(150 * 1024, 'x')], writeHead(res));
+
+      expect(res.chunks.map((chunk: Buffer) => chunk.length)).toEqual([64 * 1024, 64 * 1024, 22 * 1024]);
+    });
+
+    it('should close sources that can be closed', async () => {
+      const res = createMockResponse();
+      const source = Object.assign(['a', 'b'], { close: jest.fn() });
+
+      await streamBody(res, source, writeHead(res));
+
+      expect(source.close).toHaveBeenCalled();
+    });
+
+    it('should stop the source and reject once the client disconnects', async () => {
+      const res = createMockResponse();
+      let cleanedUp = false;
+      async function* lines() {
+        try {
+          for (let i = 0; ; i++) {
+            if (i === 2) res.aborted = true;
+            yield `line ${i}\n`;
+          }
+        } finally {
+          cleanedUp = true;
+        }
+      }
+
+      await expect(streamBody(res, lines(), writeHead(res))).rejects.toThrow(ConnectionClosedError);
+      expect(cleanedUp).toBe(true);
+      expect(res.ended).toBe(false);
+    });
+
+    it('should propagate source errors and close the connection once output started', async () => {
+      const res = createMockResponse();
+      async function* failing() {
+        yield 'partial';
+        throw new Error('upstream reset');
+      }
+
+      await expect(streamBody(res, failing(), writeHead(res))).rejects.toThrow('upstream reset');
+      expect(res.closed).toBe(true);
+    });
+
+    it('should leave the response untouched when the source fails before any output', async () => {
+      const res = createMockResponse();
+      async function* failing(): AsyncGenerator<string> {
+        throw new Error('not found');
+      }
+
+      await expect(streamBody(res, failing(), writeHead(res))).rejects.toThrow('not found');
+      expect(res.writeStatus).not.toHaveBeenCalled();
+      expect(res.closed).toBe(false);
+    });
+  });
+});
@@ -1,4 +1,12 @@
-import { parseUrl, parseQuery, matchRoute } from '../../src/utils/urlParser';
+import {
+  parseUrl,
+  parseQuery,
+  parseQueryEntries,
+  matchRoute,
+  routeParams,
+  stripParamPatterns,
+  compileParamPattern
+} from '../../src/utils/urlParser';
 
 describe('URL Parser', () => {
   describe('parseQuery', () => {
@@ -39,6 +47,24 @@ describe('URL Parser', () => {
     });
   });
 
+  describe('parseQueryEntries', () => {
+    it('should keep duplicates in request order', () => {
+      expect(parseQueryEntries('a=1&b=2&a=3')).toEqual([['a', '1'], ['b', '2'], ['a', '3']]);
+    });
+
+    it('should handle empty and missing values', () => {
+      expect(parseQueryEntries('a=&b&&c=1')).toEqual([['a', ''], ['b', ''], ['c', '1']]);
+    });
+
+    it('should decode plus signs and keep "=" inside values', () => {
+      expect(parseQueryEntries('q=hello+world&token=abc==')).toEqual([['q', 'hello world'], ['token', 'abc==']]);
+    });
+
+    it('should keep malformed escapes instead of throwing', () => {
+      expect(parseQueryEntries('q=100%')).toEqual([['q', '100%']]);
+    });
+  });
+
   describe('parseUrl', () => {
     it('should parse URL with query string', () => {
       const result = parseUrl('/path', 'foo=bar&baz=qux');
@@ -99,4 +125,43 @@ describe('URL Parser', () => {
       expect(match).toBe(false);
     });
   });
+
+  describe('routeParams', () => {
+    it('should list parameters in declaration order', () => {
+      expect(routeParams('/users/:userId/posts/:postId')).toEqual([{ name: 'userId' }, { name: 'postId' }]);
+    });
+
+    it('should return an empty list for static and wildcard routes', () => {
+      expect(routeParams('/static/*')).toEqual([]);
+    });
+  });
+
+  describe('param patterns', () => {
+    it('should parse constraints off parameters', () => {
+      expect(routeParams('/items/:id{uuid}/rev/:rev')).toEqual([
+        { name: 'id', pattern: 'uuid' },
+        { name: 'rev' }
+      ]);
+    });
+
+    it('should strip constraints for uWS', () => {
+      expect(stripParamPatterns('/items/:id{uuid}/rev/:rev{int}')).toEqual('/items/:id/rev/:rev');
+      expect(stripParamPatterns('/items/:id')).toEqual('/items/:id');
+    });
+
+    it('should anchor compiled patterns to the whole value', () => {
+      const int = compileParamPattern(/\d+/);
+
+      expect(int.test('42')).toBe(true);
+      expect(int.test('42abc')).toBe(false);
+      expect(compileParamPattern('[a-z]+').test('abc')).toBe(true);
+    });
+
+    it('should only match when constrained params fit their pattern', () => {
+      const patterns = new Map([['int', compileParamPattern(/\d+/)]]);
+
+      expect(matchRoute('/items/:id{int}', '/items/42', patterns)).toEqual({ match: true, params: { id: '42' } });
+      expect(matchRoute('/items/:id{int}', '/items/abc', patterns).match).toBe(false);
+    });
+  });
 });
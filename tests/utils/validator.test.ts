@@ -0,0 +1,50 @@
+import { v } from '../../src/utils/validator';
+
+describe('toJSONSchema', () => {
+  it('should describe primitives with their constraints', () => {
+    expect(v.string().min(2).max(10).email().toJSONSchema()).toEqual({
+      type: 'string',
+      minLength: 2,
+      maxLength: 10,
+      format: 'email'
+    });
+    expect(v.number().int().min(1).toJSONSchema()).toEqual({ type: 'integer', minimum: 1 });
+    expect(v.boolean().toJSONSchema()).toEqual({ type: 'boolean' });
+  });
+
+  it('should mark optional and defaulted properties as not required', () => {
+    const schema = v.object({
+      id: v.number(),
+      name: v.string().optional(),
+      role: v.enum(['admin', 'user'] as const).default('user'),
+      tags: v.array(v.string()).max(5)
+    });
+
+    expect(schema.toJSONSchema()).toEqual({
+      type: 'object',
+      properties: {
+        id: { type: 'number' },
+        name: { type: 'string' },
+        role: { type: 'string', enum: ['admin', 'user'], default: 'user' },
+        tags: { type: 'array', items: { type: 'string' }, maxItems: 5 }
+      },
+      required: ['id', 'tags']
+    });
+  });
+
+  it('should describe nullable, union and literal schemas', () => {
+    expect(v.string().nullable().toJSONSchema()).toEqual({ anyOf: [{ type: 'string' }, { type: 'null' }] });
+    expect(v.union(v.literal('a'), v.number()).toJSONSchema()).toEqual({
+      anyOf: [{ const: 'a' }, { type: 'number' }]
+    });
+  });
+
+  it('should forbid extra properties on strict objects', () => {
+    expect(v.object({ a: v.any() }).strict().toJSONSchema()).toEqual({
+      type: 'object',
+      properties: { a: {} },
+      required: ['a'],
+      additionalProperties: false
+    });
+  });
+});
@@ -0,0 +1,118 @@
+import { WebSocketHub } from '../../src/utils/wsHub';
+
+// Fake uWS socket wrapped in the minimal context shape the hub uses
+function createClient(bufferedAmount = 0) {
+  const ws: any = {
+    sent: [] as any[],
+    bufferedAmount,
+    ended: null as null | { code: number; reason: string },
+    closed: false,
+    getBufferedAmount: () => ws.bufferedAmount,
+    send: jest.fn((message: any) => {
+      if (ws.closed) throw new Error('Invalid access of closed uWS.WebSocket/SSLWebSocket.');
+      ws.sent.push(message);
+      return 1;
+    }),
+    end: jest.fn((code: number, reason: string) => { ws.ended = { code, reason }; })
+  };
+  return { ws } as any;
+}
+
+describe('WebSocketHub', () => {
+  it('should broadcast to every registered client', () => {
+    const hub = new WebSocketHub();
+    const a = createClient();
+    const b = createClient();
+    hub.register(a);
+    hub.register(b);
+
+    expect(hub.broadcast('hello')).toBe(2);
+    expect(a.ws.sent).toEqual(['hello']);
+    expect(b.ws.sent).toEqual(['hello']);
+  });
+
+  it('should skip the excluded sender', () => {
+    const hub = new WebSocketHub();
+    const a = createClient();
+    const b = createClient();
+    hub.register(a);
+    hub.register(b);
+
+    hub.broadcast('hi', a);
+
+    expect(a.ws.sent).toEqual([]);
+    expect(b.ws.sent).toEqual(['hi']);
+  });
+
+  it('should identify clients by socket, not context object', () => {
+    const hub = new WebSocketHub();
+    const client = createClient();
+    hub.register(client, 'lobby');
+
+    hub.unregister({ ws: client.ws } as any);
+
+    expect(hub.size).toBe(0);
+    expect(hub.roomSize('lobby')).toBe(0);
+  });
+
+  it('should only send room broadcasts to members', () => {
+    const hub = new WebSocketHub();
+    const a = createClient();
+    const b = createClient();
+    hub.register(a, 'room-1');
+    hub.register(b);
+
+    expect(hub.broadcastTo('room-1', 'news')).toBe(1);
+    expect(a.ws.sent).toEqual(['news']);
+    expect(b.ws.sent).toEqual([]);
+
+    hub.leave(a, 'room-1');
+    expect(hub.broadcastTo('room-1', 'more')).toBe(0);
+    expect(hub.size).toBe(2);
+  });
+
+  it('should drop messages for slow consumers by default', () => {
+    const hub = new WebSocketHub({ maxBackpressure: 100 });
+    const slow = createClient(500);
+    const fast = createClient();
+    hub.register(slow);
+    hub.register(fast);
+
+    expect(hub.broadcast('tick')).toBe(1);
+    expect(slow.ws.sent).toEqual([]);
+    expect(slow.ws.ended).toBeNull();
+    expect(hub.size).toBe(2);
+  });
+
+  it('should disconnect slow consumers when configured', () => {
+    const hub = new WebSocketHub({ maxBackpressure: 100, onSlowConsumer: 'disconnect' });
+    const slow = createClient(500);
+    hub.register(slow, 'room');
+
+    hub.broadcast('tick');
+
+    expect(slow.ws.ended).toEqual({ code: 1013, reason: 'Slow consumer' });
+    expect(hub.size).toBe(0);
+    expect(hub.roomSize('room')).toBe(0);
+  });
+
+  it('should forget clients whose socket has closed', () => {
+    const hub = new WebSocketHub();
+    const client = createClient();
+    hub.register(client);
+    client.ws.closed = true;
+
+    expect(hub.broadcast('gone')).toBe(0);
+    expect(hub.size).toBe(0);
+  });
+
+  it('should send buffers as binary messages', () => {
+    const hub = new WebSocketHub();
+    const client = createClient();
+    hub.register(client);
+
+    hub.broadcast(Buffer.from('data'));
+
+    expect(client.ws.send).toHaveBeenCalledWith(Buffer.from('data'), true);
+  });
+});
@@ -0,0 +1,116 @@
+import { parseXML, toXML, XMLSyntaxError } from '../../src/utils/xml';
+
+describe('XML', () => {
+  const order = {
+    order: {
+      '@id': '7',
+      '@status': 'open',
+      customer: { name: 'Ada Lovelace', address: { '@type': 'billing', city: 'London', zip: 'N1 9GU' } },
+      item: [
+        { '@sku': 'A-1', '#text': '2' },
+        { '@sku': 'B-2', '#text': '1' }
+      ],
+      note: 'Gift wrap <please> & "quickly"'
+    }
+  };
+
+  describe('parseXML', () => {
+    it('should parse attributes, nested elements and repeated children', () => {
+      const xml = `<?xml version="1.0" encoding="UTF-8"?>
+        <!-- exported by the shop -->
+        <order id="7" status='open'>
+          <customer>
+            <name>Ada Lovelace</name>
+            <address type="billing"><city>London</city><zip>N1 9GU</zip></address>
+          </customer>
+          <item sku="A-1">2</item>
+          <item sku="B-2">1</item>
+          <note>Gift wrap &lt;please&gt; &amp; &quot;quickly&quot;</note>
+        </order>`;
+
+      expect(parseXML(xml)).toEqual(order);
+    });
+
+    it('should read empty elements, CDATA and character references', () => {
+      expect(parseXML('<a><b/><c></c><d><![CDATA[<raw> & ]]>&#65;&#x42;</d></a>')).toEqual({
+        a: { b: '', c: '', d: '<raw> & AB' }
+      });
+    });
+
+    it('should keep text next to children, without whitespace-only text', () => {
+      expect(parseXML('<p lang="en">Hello <b>world</b>!</p>')).toEqual({ p: { '@lang': 'en', b: 'world', '#text': 'Hello !' } });
+      expect(parseXML('<p>\n  <b>x</b>\n</p>')).toEqual({ p: { b: 'x' } });
+    });
+
+    it('should keep namespace prefixes in names', () => {
+      const envelope = parseXML('<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body/></soap:Envelope>');
+
+      expect(envelope).toEqual({
+        'soap:Envelope': { '@xmlns:soap': 'http://www.w3.org/2003/05/soap-envelope', 'soap:Body': '' }
+      });
+    });
+
+    it('should not let element names reach the prototype', () => {
+      const parsed = parseXML('<a><__proto__><polluted>yes</polluted></__proto__></a>');
+
+      expect(({} as any).polluted).toBeUndefined();
+      expect(Object.getPrototypeOf(parsed.a)).toBe(Object.prototype);
+      expect(parsed.a.__proto__).toEqual({ polluted: 'yes' });
+    });
+
+    it('should describe where malformed documents go wrong', () => {
+      const cases: Array<[string, string]> = [
+        ['<order><item></order>', 'Expected </item>, found </order> at line 1, column 21'],
+        ['<order>\n  <item>1</item>\n', 'Unclosed element <order> at line 3, column 1'],
+        ['<a b="1" b="2"/>', 'Duplicate attribute b in <a> at line 1, column 10'],
+        ['<a>&nbsp;</a>', 'Unknown entity or stray "&"'],
+        ['<a b=1/>', 'Expected a quoted value for attribute b'],
+        ['<a/><b/>', 'Unexpected content after the root element'],
+        ['', 'Expected the root element'],
+        ['<!DOCTYPE a [<!ENTITY x "xx">]><a>&x;</a>', 'DOCTYPE declarations are not allowed']
+      ];
+
+      for (const [xml, message] of cases) {
+        expect(() => parseXML(xml)).toThrow(XMLSyntaxError);
+        expect(() => parseXML(xml)).toThrow(message);
+      }
+    });
+
+    it('should refuse documents nested too deep', () => {
+      expect(() => parseXML('<a>'.repeat(2000))).toThrow('Elements nested more than 1000 deep');
+    });
+  });
+
+  describe('toXML', () => {
+    it('should write a declaration, attributes, nested and repeated elements', () => {
+      expect(toXML(order)).toBe(
+        '<?xml version="1.0" encoding="UTF-8"?>\n' +
+        '<order id="7" status="open">' +
+        '<customer><name>Ada Lovelace</name><address type="billing"><city>London</city><zip>N1 9GU</zip></address></customer>' +
+        '<item sku="A-1">2</item><item sku="B-2">1</item>' +
+        '<note>Gift wrap &lt;please&gt; &amp; "quickly"</note>' +
+        '</order>'
+      );
+    });
+
+    it('should round-trip through parseXML', () => {
+      expect(parseXML(toXML(order))).toEqual(order);
+    });
+
+    it('should write numbers, booleans, dates, null and skip undefined', () => {
+      const xml = toXML({ r: { n: 1.5, ok: true, at: new Date(Date.UTC(2024, 0, 2)), none: null, skipped: undefined, '@q': 'a"b' } });
+
+      expect(xml).toBe('<?xml version="1.0" encoding="UTF-8"?>\n<r q="a&quot;b"><n>1.5</n><ok>true</ok><at>2024-01-02T00:00:00.000Z</at><none/></r>');
+    });
+
+    it('should refuse documents without exactly one root, and invalid names', () => {
+      expect(() => toXML({ a: 1, b: 2 })).toThrow('XML needs an object with one key, the root element');
+      expect(() => toXML({ items: [1, 2] })).toThrow(TypeError);
+      expect(() => toXML({ 'two words': 1 })).toThrow('Invalid XML element name: "two words"');
+
+      const loop: any = { a: {} };
+      loop.a.self = loop.a;
+      expect(() => toXML(loop)).toThrow('Converting circular structure to XML');
+    });
+  });
+});
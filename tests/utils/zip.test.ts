@@ -0,0 +1,110 @@
+import { inflateRawSync } from 'zlib';
+import { crc32, zipChunks, ZipEntry } from '../../src/utils/zip';
+
+async function zip(entries: Iterable<ZipEntry> | AsyncIterable<ZipEntry>): Promise<Buffer> {
+  const chunks: Buffer[] = [];
+  for await (const chunk of zipChunks(entries)) {
+    chunks.push(chunk);
+  }
+  return Buffer.concat(chunks);
+}
+
+// Just enough of a ZIP reader to check archives through their central directory
+function unzip(archive: Buffer): Array<{ name: string; data: Buffer; crc: number; modified: number }> {
+  const end = archive.length - 22;
+  expect(archive.readUInt32LE(end)).toBe(0x06054b50);
+  const count = archive.readUInt16LE(end + 10);
+  const directorySize = archive.readUInt32LE(end + 12);
+  let position = archive.readUInt32LE(end + 16);
+  expect(position + directorySize).toBe(end);
+
+  const files = [];
+  for (let i = 0; i < count; i++) {
+    expect(archive.readUInt32LE(position)).toBe(0x02014b50);
+    const method = archive.readUInt16LE(position + 10);
+    const crc = archive.readUInt32LE(position + 16);
+    const compressedSize = archive.readUInt32LE(position + 20);
+    const size = archive.readUInt32LE(position + 24);
+    const nameLength = archive.readUInt16LE(position + 28);
+    const offset = archive.readUInt32LE(position + 42);
+    const name = archive.toString('utf8', position + 46, position + 46 + nameLength);
+
+    expect(archive.readUInt32LE(offset)).toBe(0x04034b50);
+    const start = offset + 30 + archive.readUInt16LE(offset + 26);
+    const stored = archive.subarray(start, start + compressedSize);
+    const data = method === 8 ? inflateRawSync(stored) : stored;
+    expect(data.length).toBe(size);
+    // The data descriptor repeats what the central directory says
+    expect(archive.readUInt32LE(start + compressedSize)).toBe(0x08074b50);
+    expect(archive.readUInt32LE(start + compressedSize + 4)).toBe(crc);
+
+    files.push({ name, data, crc, modified: archive.readUInt16LE(position + 14) });
+    position += 46 + nameLength;
+  }
+  return files;
+}
+
+describe('crc32', () => {
+  it('should match the standard check value', () => {
+    expect(crc32(Buffer.from('123456789'))).toBe(0xcbf43926);
+  });
+
+  it('should continue over several chunks', () => {
+    expect(crc32(Buffer.from('56789'), crc32(Buffer.from('1234')))).toBe(0xcbf43926);
+  });
+});
+
+describe('zipChunks', () => {
+  it('should write entries readable through the central directory', async () => {
+    const files = unzip(await zip([
+      { name: 'hello.txt', data: 'Hello, world' },
+      { name: 'raw.bin', data: Buffer.from([0, 1, 2, 255]), store: true },
+      { name: 'reports/ünïcode.csv', data: 'a,b\r\n'.repeat(1000) }
+    ]));
+
+    expect(files.map(file => file.name)).toEqual(['hello.txt', 'raw.bin', 'reports/ünïcode.csv']);
+    expect(files[0].data.toString()).toBe('Hello, world');
+    expect([...files[1].data]).toEqual([0, 1, 2, 255]);
+    expect(files[2].data.toString()).toBe('a,b\r\n'.repeat(1000));
+    for (const file of files) {
+      expect(file.crc).toBe(crc32(file.data));
+    }
+  });
+
+  it('should stream entry data from async sources', async () => {
+    async function* rows() {
+      for (let i = 0; i < 500; i++) yield `row ${i}\n`;
+    }
+    async function* entries() {
+      yield { name: 'rows.txt', data: rows() };
+      yield { name: 'empty.txt', data: '' };
+    }
+
+    const files = unzip(await zip(entries()));
+
+    expect(files[0].data.toString().split('\n')).toHaveLength(501);
+    expect(files[1].data.length).toBe(0);
+  });
+
+  it('should write an empty archive', async () => {
+    const archive = await zip([]);
+
+    expect(archive.length).toBe(22);
+    expect(unzip(archive)).toEqual([]);
+  });
+
+  it('should store modification times as DOS dates', async () => {
+    const [file] = unzip(await zip([{ name: 'a', data: 'x', modified: new Date(2024, 2, 15, 10, 30) }]));
+
+    expect(file.modified).toBe(((2024 - 1980) << 9) | (3 << 5) | 15);
+  });
+
+  it('should fail when an entry source fails', async () => {
+    async function* broken() {
+      yield 'partial';
+      throw new Error('disk gone');
+    }
+
+    await expect(zip([{ name: 'a', data: broken() }])).rejects.toThrow('disk gone');
+  });
+});